@@ -30,6 +30,11 @@ import (
 // @name Authorization
 // @description Type "Bearer" followed by a space and JWT token.
 
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @description Personal API key (gbk_...), limited to the scopes it was created with.
+
 func main() {
 	// Load .env
 	if err := godotenv.Load(); err != nil {
@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrImmutable is returned when code tries to modify or delete recorded events
+var ErrImmutable = errors.New("audit events are append-only")
+
+// Event represents a security-relevant or data-changing action recorded for
+// later review. Events are append-only and chained by hash: each event's
+// hash is keyed with the audit secret and covers its contents and the hash
+// of the event before it, so edits, deletions and reordering are detectable
+// with Verify. As events cannot be
+// redacted, they identify users by ID and hold no personal data: the IP is
+// recorded as a pseudonym, and callers pass other personal values, e.g.
+// email addresses, through Recorder.Pseudonym.
+type Event struct {
+	ID         uint      `gorm:"primaryKey" json:"id" example:"1"`
+	Action     string    `gorm:"size:64;index;not null" json:"action" example:"user.updated"`
+	ActorID    *uint     `gorm:"index" json:"actor_id,omitempty" example:"1"`
+	TargetType string    `gorm:"size:32;index:idx_audit_target" json:"target_type,omitempty" example:"user"`
+	TargetID   string    `gorm:"size:191;index:idx_audit_target" json:"target_id,omitempty" example:"42"`
+	IP         string    `gorm:"size:45" json:"ip,omitempty" example:"p:4f1c9a0e5b7d2c8e6a3f1b9d0c7e5a2f"` // Pseudonym of the client IP
+	RequestID  string    `gorm:"size:64;index" json:"request_id,omitempty" example:"9f0c2d4e6a8b4c1d"`
+	Metadata   string    `gorm:"type:text" json:"metadata,omitempty"`
+	Changes    string    `gorm:"type:text" json:"changes,omitempty" example:"{\"name\":{\"from\":\"John\",\"to\":\"Johnny\"}}"` // Field-level diff, see Diff
+	PrevHash   string    `gorm:"size:64;not null;default:''" json:"prev_hash" example:"5d41402abc4b2a76b9719d911017c592..."`
+	Hash       string    `gorm:"size:64;index;not null;default:''" json:"hash" example:"7c211433f02071597741e6ff5a8ea34..."`
+	CreatedAt  time.Time `gorm:"index" json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+// TableName overrides the table name
+func (Event) TableName() string {
+	return "audit_events"
+}
+
+// BeforeUpdate rejects updates to recorded events
+func (Event) BeforeUpdate(*gorm.DB) error {
+	return ErrImmutable
+}
+
+// BeforeDelete rejects deletion of recorded events
+func (Event) BeforeDelete(*gorm.DB) error {
+	return ErrImmutable
+}
+
+// Recorder writes audit events
+type Recorder interface {
+	Record(event *Event) error
+	// Pseudonym returns the keyed hash to record in place of a personal
+	// value, such as an email address. Equal values get equal pseudonyms.
+	Pseudonym(value string) string
+}
+
+// dbRecorder implements Recorder using GORM
+type dbRecorder struct {
+	db         *gorm.DB
+	pseudonyms pseudonymizer
+	chainKey   chainKey
+}
+
+// NewRecorder creates a new database-backed audit recorder. Pseudonyms and
+// the hash chain are keyed with secret, which must be the same for every
+// recorder and store.
+func NewRecorder(db *gorm.DB, secret string) Recorder {
+	return &dbRecorder{db: db, pseudonyms: newPseudonymizer(secret), chainKey: newChainKey(secret)}
+}
+
+func (r *dbRecorder) Pseudonym(value string) string {
+	return r.pseudonyms.pseudonym(value)
+}
+
+// Record appends an event to the audit log, linking it to the current head
+// of the hash chain. The chain head row is locked for the duration of the
+// insert so concurrent writers, including other instances, append in order.
+func (r *dbRecorder) Record(event *Event) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	// Stored with millisecond precision; hash what will be read back
+	event.CreatedAt = event.CreatedAt.Truncate(time.Millisecond)
+	event.IP = r.pseudonyms.pseudonym(event.IP)
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		head := ChainHead{ID: chainHeadID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&head).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&head, chainHeadID).Error; err != nil {
+			return err
+		}
+
+		event.PrevHash = head.Hash
+		event.Hash = event.computeHash(r.chainKey)
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&head).Updates(map[string]interface{}{
+			"event_id": event.ID,
+			"hash":     event.Hash,
+		}).Error
+	})
+}
+
+// WithMetadata encodes arbitrary details into the event's metadata field
+func (e *Event) WithMetadata(metadata interface{}) *Event {
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		log.Printf("⚠️  Failed to encode audit metadata for %s: %v", e.Action, err)
+		return e
+	}
+	e.Metadata = string(encoded)
+	return e
+}
+
+// WithChanges encodes a field-level diff into the event's changes field
+func (e *Event) WithChanges(changes Changes) *Event {
+	if len(changes) == 0 {
+		return e
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		log.Printf("⚠️  Failed to encode audit changes for %s: %v", e.Action, err)
+		return e
+	}
+	e.Changes = string(encoded)
+	return e
+}
@@ -0,0 +1,240 @@
+package audit
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/testdb"
+)
+
+// testSecret keys the pseudonyms and hash chain of the test log
+const testSecret = "test-secret"
+
+// logTable is an in-memory audit log
+type logTable struct {
+	head   *ChainHead
+	events []Event // In ID order
+}
+
+// query answers the queries Verify runs
+func (l *logTable) query(query string, args []driver.Value) (driver.Rows, error) {
+	switch {
+	case strings.Contains(query, "`audit_chain_heads`"):
+		result := testdb.NewRows("id", "event_id", "hash", "updated_at")
+		if l.head != nil {
+			result.Add(int64(l.head.ID), int64(l.head.EventID), l.head.Hash, l.head.UpdatedAt)
+		}
+		return result, nil
+	case strings.HasPrefix(query, "SELECT count(*) FROM `audit_events`"):
+		return testdb.NewRows("count(*)").Add(int64(len(l.events))), nil
+	case strings.HasPrefix(query, "SELECT * FROM `audit_events` WHERE id <= ?"):
+		// Batches are bounded by the head, start after the previous batch
+		// and end with the limit
+		maxID, after, limit := args[0].(int64), int64(0), args[len(args)-1].(int64)
+		if len(args) == 3 {
+			after = args[1].(int64)
+		}
+		result := testdb.NewRows("id", "action", "actor_id", "target_type", "target_id", "ip", "request_id", "metadata", "changes", "prev_hash", "hash", "created_at")
+		for _, e := range l.events {
+			if int64(e.ID) <= after || int64(e.ID) > maxID || int64(result.Len()) == limit {
+				continue
+			}
+			var actorID driver.Value
+			if e.ActorID != nil {
+				actorID = int64(*e.ActorID)
+			}
+			result.Add(int64(e.ID), e.Action, actorID, e.TargetType, e.TargetID, e.IP, e.RequestID, e.Metadata, e.Changes, e.PrevHash, e.Hash, e.CreatedAt)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unexpected query %s", query)
+}
+
+// store returns a Store reading the table
+func (l *logTable) store(t *testing.T) *Store {
+	t.Helper()
+	return NewStore(testdb.Open(t, &testdb.Conn{Query: l.query}), testSecret)
+}
+
+// newLog returns a log of legacy events recorded before hashing followed
+// by chained events, linked as Record links them
+func newLog(legacy, chained int) *logTable {
+	l := &logTable{}
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < legacy+chained; i++ {
+		actorID := uint(i % 3)
+		l.events = append(l.events, Event{
+			ID:         uint(i + 1),
+			Action:     "user.updated",
+			ActorID:    &actorID,
+			TargetType: "user",
+			TargetID:   fmt.Sprint(i),
+			CreatedAt:  created.Add(time.Duration(i) * time.Second),
+		})
+	}
+	if chained == 0 {
+		return l
+	}
+
+	l.head = &ChainHead{ID: chainHeadID}
+	l.rechain(legacy, newChainKey(testSecret))
+	return l
+}
+
+// rechain links the events from index from on to their predecessors and
+// hashes them with key, moving the head to the last one
+func (l *logTable) rechain(from int, key chainKey) {
+	l.head.Hash = ""
+	if from > 0 {
+		l.head.Hash = l.events[from-1].Hash
+	}
+	for i := from; i < len(l.events); i++ {
+		e := &l.events[i]
+		e.PrevHash = l.head.Hash
+		e.Hash = e.computeHash(key)
+		l.head.EventID, l.head.Hash = e.ID, e.Hash
+	}
+}
+
+// remove deletes the event with the given ID
+func (l *logTable) remove(id uint) {
+	l.events = slices.DeleteFunc(l.events, func(e Event) bool { return e.ID == id })
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name          string
+		log           *logTable
+		tamper        func(l *logTable)
+		wantChecked   int64
+		wantUnchained int64
+		wantBrokenAt  uint // 0 when the chain is valid
+		wantReason    string
+	}{
+		{name: "empty log", log: newLog(0, 0)},
+		{name: "only legacy events", log: newLog(3, 0), wantUnchained: 3},
+		{name: "intact chain", log: newLog(0, 5), wantChecked: 5},
+		{name: "legacy events before the chain", log: newLog(2, 5), wantChecked: 5, wantUnchained: 2},
+		{name: "intact chain across batches", log: newLog(0, 2*verifyBatchSize+1), wantChecked: 2*verifyBatchSize + 1},
+		{
+			name: "event appended after the head was read",
+			log:  newLog(0, 5),
+			tamper: func(l *logTable) {
+				l.events = append(l.events, Event{ID: 6, Action: "user.updated", PrevHash: l.head.Hash})
+			},
+			wantChecked: 5,
+		},
+		{
+			name:         "edited event",
+			log:          newLog(0, 5),
+			tamper:       func(l *logTable) { l.events[2].Action = "user.deleted" },
+			wantChecked:  3,
+			wantBrokenAt: 3,
+			wantReason:   "event contents do not match its hash",
+		},
+		{
+			name: "edited and rehashed event",
+			log:  newLog(0, 5),
+			tamper: func(l *logTable) {
+				l.events[2].Action = "user.deleted"
+				l.events[2].Hash = l.events[2].computeHash(newChainKey(testSecret))
+			},
+			wantChecked:  4,
+			wantBrokenAt: 4,
+			wantReason:   "not linked to the previous event",
+		},
+		{
+			name: "edited event with the chain rehashed without the secret",
+			log:  newLog(0, 5),
+			tamper: func(l *logTable) {
+				l.events[2].Action = "user.deleted"
+				l.rechain(2, newChainKey("guessed-secret"))
+			},
+			wantChecked:  3,
+			wantBrokenAt: 3,
+			wantReason:   "event contents do not match its hash",
+		},
+		{
+			name:         "deleted event",
+			log:          newLog(0, 5),
+			tamper:       func(l *logTable) { l.remove(3) },
+			wantChecked:  3,
+			wantBrokenAt: 4,
+			wantReason:   "not linked to the previous event",
+		},
+		{
+			name:         "deleted event in a later batch",
+			log:          newLog(0, 2*verifyBatchSize+1),
+			tamper:       func(l *logTable) { l.remove(verifyBatchSize + 200) },
+			wantChecked:  verifyBatchSize + 200,
+			wantBrokenAt: verifyBatchSize + 201,
+			wantReason:   "not linked to the previous event",
+		},
+		{
+			name: "reordered events",
+			log:  newLog(0, 5),
+			tamper: func(l *logTable) {
+				l.events[1], l.events[2] = l.events[2], l.events[1]
+				l.events[1].ID, l.events[2].ID = 2, 3
+			},
+			wantChecked:  2,
+			wantBrokenAt: 2,
+			wantReason:   "not linked to the previous event",
+		},
+		{
+			name:         "unhashed event inside the chain",
+			log:          newLog(0, 5),
+			tamper:       func(l *logTable) { l.events[2].PrevHash, l.events[2].Hash = "", "" },
+			wantChecked:  3,
+			wantBrokenAt: 3,
+			wantReason:   "not linked to the previous event",
+		},
+		{
+			name:         "deleted last event",
+			log:          newLog(0, 5),
+			tamper:       func(l *logTable) { l.remove(5) },
+			wantChecked:  4,
+			wantBrokenAt: 5,
+			wantReason:   "last event does not match the chain head",
+		},
+		{
+			name:         "edited chain head",
+			log:          newLog(0, 5),
+			tamper:       func(l *logTable) { l.head.Hash = strings.Repeat("0", 64) },
+			wantChecked:  5,
+			wantBrokenAt: 5,
+			wantReason:   "last event does not match the chain head",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.tamper != nil {
+				tt.tamper(tt.log)
+			}
+			got, err := tt.log.store(t).Verify()
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if got.Checked != tt.wantChecked || got.Unchained != tt.wantUnchained {
+				t.Errorf("checked %d and unchained %d events, want %d and %d", got.Checked, got.Unchained, tt.wantChecked, tt.wantUnchained)
+			}
+			if tt.wantBrokenAt == 0 {
+				if !got.Valid || got.BrokenAt != nil || got.Reason != "" {
+					t.Errorf("Verify() = %+v, want a valid chain", got)
+				}
+				return
+			}
+			if got.Valid || got.BrokenAt == nil || *got.BrokenAt != tt.wantBrokenAt {
+				t.Fatalf("Verify() = %+v, want broken at %d", got, tt.wantBrokenAt)
+			}
+			if !strings.Contains(got.Reason, tt.wantReason) {
+				t.Errorf("reason = %q, want it to contain %q", got.Reason, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Change is the before and after value of a single field
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Changes maps field names to their changes
+type Changes map[string]Change
+
+// Diff compares two values of the same struct type field by field and
+// returns the fields that differ, keyed by their JSON names. Fields tagged
+// json:"-" and the names in ignore are skipped, so callers should pass
+// response DTOs rather than models that carry secrets.
+func Diff(before, after interface{}, ignore ...string) Changes {
+	b := reflect.Indirect(reflect.ValueOf(before))
+	a := reflect.Indirect(reflect.ValueOf(after))
+	if b.Kind() != reflect.Struct || a.Type() != b.Type() {
+		return nil
+	}
+
+	changes := Changes{}
+	for i := 0; i < b.NumField(); i++ {
+		field := b.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := jsonName(field)
+		if name == "-" || slices.Contains(ignore, name) {
+			continue
+		}
+
+		from, to := b.Field(i).Interface(), a.Field(i).Interface()
+		if !reflect.DeepEqual(from, to) {
+			changes[name] = Change{From: from, To: to}
+		}
+	}
+	return changes
+}
+
+// jsonName returns the name a field is encoded under
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
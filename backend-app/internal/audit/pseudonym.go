@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// pseudonymPrefix marks pseudonyms, telling them apart from values
+// recorded before personal data was pseudonymized
+const pseudonymPrefix = "p:"
+
+// pseudonymizer replaces personal data, such as client IPs and email
+// addresses, with keyed hashes. Equal values get equal pseudonyms, so events
+// can still be correlated and searched by them, while the append-only log
+// never holds the values themselves and needs no redaction on erasure.
+type pseudonymizer struct {
+	key []byte
+}
+
+// newPseudonymizer derives the pseudonym key from secret, so the key used
+// elsewhere, e.g. to sign tokens, is never used directly
+func newPseudonymizer(secret string) pseudonymizer {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("audit pseudonyms"))
+	return pseudonymizer{key: mac.Sum(nil)}
+}
+
+// pseudonym returns the pseudonym of a value, or "" for an empty one
+func (p pseudonymizer) pseudonym(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(value))
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Pseudonymize replaces the values of the named fields with pseudonyms
+// from pseudonym, e.g. Recorder.Pseudonym, so the diff shows that personal
+// fields changed without recording them. Empty values stay empty.
+func (c Changes) Pseudonymize(pseudonym func(string) string, fields ...string) Changes {
+	for name, change := range c {
+		if slices.Contains(fields, name) {
+			c[name] = Change{From: pseudonymValue(pseudonym, change.From), To: pseudonymValue(pseudonym, change.To)}
+		}
+	}
+	return c
+}
+
+// pseudonymValue pseudonymizes a diffed value, keeping nil as nil
+func pseudonymValue(pseudonym func(string) string, value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	return pseudonym(fmt.Sprint(v.Interface()))
+}
@@ -0,0 +1,20 @@
+package audit
+
+import (
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RegisterRoutes registers the audit log routes behind guards, which must
+// authenticate the caller and restrict access to administrators. secret
+// keys pseudonyms, as for NewRecorder.
+func RegisterRoutes(router *gin.RouterGroup, db *gorm.DB, secret string, guards ...gin.HandlerFunc) {
+	handler := NewHandler(NewStore(db, secret))
+
+	// Audit routes
+	events := router.Group("/audit", guards...)
+	{
+		events.GET("/events", handler.ListEvents)
+		events.GET("/verify", handler.VerifyChain)
+	}
+}
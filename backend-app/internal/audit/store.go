@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Filter selects audit events; empty fields match every event
+type Filter struct {
+	Action     string     `form:"action"` // Exact action, or a prefix ending in "." such as "auth."
+	ActorID    *uint      `form:"actor_id"`
+	TargetType string     `form:"target_type"`
+	TargetID   string     `form:"target_id"`
+	RequestID  string     `form:"request_id"`
+	IP         string     `form:"ip"` // Matched by its pseudonym
+	From       *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To         *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// Store reads the audit log
+type Store struct {
+	db         *gorm.DB
+	pseudonyms pseudonymizer
+	chainKey   chainKey
+}
+
+// NewStore creates a new audit log reader, with the secret recorders key
+// pseudonyms and the hash chain with
+func NewStore(db *gorm.DB, secret string) *Store {
+	return &Store{db: db, pseudonyms: newPseudonymizer(secret), chainKey: newChainKey(secret)}
+}
+
+// Find returns a page of events matching filter, newest first
+func (s *Store) Find(filter *Filter, page, limit int) ([]Event, int64, error) {
+	query := s.db.Model(&Event{})
+	if filter.Action != "" {
+		if strings.HasSuffix(filter.Action, ".") {
+			query = query.Where("action LIKE ?", escapeLike(filter.Action)+"%")
+		} else {
+			query = query.Where("action = ?", filter.Action)
+		}
+	}
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.RequestID != "" {
+		query = query.Where("request_id = ?", filter.RequestID)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip = ?", s.pseudonyms.pseudonym(filter.IP))
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []Event
+	offset := (page - 1) * limit
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// ExportUser returns the events a user performed or that targeted the user,
+// oldest first, for the user's personal data export
+func (s *Store) ExportUser(userID uint) (map[string]interface{}, error) {
+	events := []Event{}
+	if err := s.db.Where("actor_id = ? OR (target_type = ? AND target_id = ?)", userID, "user", strconv.FormatUint(uint64(userID), 10)).
+		Order("id").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"audit_events.json": events}, nil
+}
+
+// escapeLike escapes LIKE wildcards so values match literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedPasswordChecker reports whether a password appears in a known breach
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// rangeFileChecker looks passwords up in a local copy of a k-anonymity
+// range dataset. The directory holds one file per 5-character uppercase
+// SHA-1 prefix (e.g. 5BAA6.txt), each listing "SUFFIX:COUNT" lines exactly
+// like the Have I Been Pwned range API, so only the matching prefix file
+// is ever read.
+type rangeFileChecker struct {
+	dir string
+}
+
+// NewBreachedPasswordChecker creates a checker backed by a range file directory
+func NewBreachedPasswordChecker(dir string) BreachedPasswordChecker {
+	return &rangeFileChecker{dir: dir}
+}
+
+func (c *rangeFileChecker) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	file, err := os.Open(filepath.Join(c.dir, prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		candidate, _, _ := strings.Cut(line, ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// opaqueTokenBytes gives 256 bits of entropy per token
+const opaqueTokenBytes = 32
+
+// GenerateOpaqueToken returns a random URL-safe token and the hash to store
+// in its place. Only the hash is persisted; the token is handed out once.
+func GenerateOpaqueToken() (token, hash string, err error) {
+	buf := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken hashes a high-entropy token for storage and lookup
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
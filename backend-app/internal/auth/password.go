@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	ErrUnsupportedHash = errors.New("unsupported password hash format")
+	ErrMalformedHash   = errors.New("malformed password hash")
+)
+
+// PasswordHasher hashes and verifies passwords
+type PasswordHasher interface {
+	// Hash returns an encoded hash embedding the algorithm and its parameters
+	Hash(password string) (string, error)
+	// Verify reports whether password matches the encoded hash
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether the encoded hash uses an outdated algorithm or parameters
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2Params configures argon2id hashing
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follows the OWASP baseline recommendation
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// NewPasswordHasher returns a hasher that creates hashes with the preferred
+// algorithm and verifies hashes produced by any supported algorithm
+func NewPasswordHasher(algorithm string, argonParams Argon2Params, bcryptCost int) (PasswordHasher, error) {
+	hashers := map[string]PasswordHasher{
+		AlgorithmArgon2id: &argon2idHasher{params: argonParams},
+		AlgorithmBcrypt:   &bcryptHasher{cost: bcryptCost},
+	}
+
+	preferred, ok := hashers[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+	return &multiHasher{preferred: preferred, hashers: hashers}, nil
+}
+
+// multiHasher dispatches verification on the encoded hash's algorithm
+type multiHasher struct {
+	preferred PasswordHasher
+	hashers   map[string]PasswordHasher
+}
+
+func (m *multiHasher) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+func (m *multiHasher) Verify(password, encoded string) (bool, error) {
+	hasher, ok := m.hashers[algorithmOf(encoded)]
+	if !ok {
+		return false, ErrUnsupportedHash
+	}
+	return hasher.Verify(password, encoded)
+}
+
+func (m *multiHasher) NeedsRehash(encoded string) bool {
+	if _, ok := m.hashers[algorithmOf(encoded)]; !ok {
+		return true
+	}
+	return m.preferred.NeedsRehash(encoded)
+}
+
+// algorithmOf identifies the algorithm of an encoded hash
+func algorithmOf(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return AlgorithmBcrypt
+	default:
+		return ""
+	}
+}
+
+// argon2idHasher encodes hashes in PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type argon2idHasher struct {
+	params Argon2Params
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.params.Memory,
+		h.params.Iterations,
+		h.params.Parallelism,
+		phcEncoding.EncodeToString(salt),
+		phcEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
+
+func (h *argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.params.Memory ||
+		params.Iterations != h.params.Iterations ||
+		params.Parallelism != h.params.Parallelism ||
+		params.KeyLength != h.params.KeyLength ||
+		uint32(len(salt)) != h.params.SaltLength
+}
+
+// decodeArgon2id parses a PHC-format argon2id hash
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	if version != argon2.Version {
+		return params, nil, nil, ErrUnsupportedHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := phcEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	key, err := phcEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// bcryptHasher wraps golang.org/x/crypto/bcrypt
+type bcryptHasher struct {
+	cost int
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *bcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy describes the rules a new password must satisfy
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// PolicyError lists every rule a password violated
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password does not meet policy: " + strings.Join(e.Violations, "; ")
+}
+
+// minPersonalTokenLength ignores very short name parts like "Jo" that would
+// otherwise reject a large share of legitimate passwords
+const minPersonalTokenLength = 3
+
+// Check returns the violated rules for password. personal holds values tied
+// to the account, such as the email and name, which must not appear in it.
+func (p PasswordPolicy) Check(password string, personal ...string) []string {
+	var violations []string
+
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters long", p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	for _, token := range personalTokens(personal) {
+		if strings.Contains(lowered, token) {
+			violations = append(violations, "must not contain your name or email address")
+			break
+		}
+	}
+
+	return violations
+}
+
+// personalTokens splits emails and names into lowercase tokens worth matching
+func personalTokens(values []string) []string {
+	var tokens []string
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			value = local
+		}
+		parts := strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, part := range parts {
+			if utf8.RuneCountInString(part) >= minPersonalTokenLength {
+				tokens = append(tokens, part)
+			}
+		}
+	}
+	return tokens
+}
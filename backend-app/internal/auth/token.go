@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token purposes, stored in the "typ" claim so a token minted for one
+// step of a flow cannot be replayed in another.
+const (
+	PurposeAccess       = "access"
+	PurposeMFAChallenge = "mfa_challenge"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrWrongPurpose = errors.New("token not valid for this operation")
+)
+
+// Claims represents the JWT claims issued by the API
+type Claims struct {
+	Purpose   string `json:"typ"`
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	ClientID  string `json:"client_id,omitempty"` // Set on tokens issued to OAuth clients
+	Scope     string `json:"scope,omitempty"`     // Space-separated scopes granted to the client
+	jwt.RegisteredClaims
+}
+
+// TokenSpec describes the token to issue
+type TokenSpec struct {
+	UserID    uint
+	Role      string
+	Purpose   string
+	SessionID string
+	ClientID  string
+	Scopes    []string
+}
+
+// Scopes returns the scopes granted to an OAuth client token
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// UserID returns the numeric user ID stored in the subject claim
+func (c *Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return uint(id), nil
+}
+
+// TokenManager issues and verifies signed JWTs
+type TokenManager struct {
+	secret []byte
+	issuer string
+	now    func() time.Time
+}
+
+// NewTokenManager creates a new token manager using HMAC-SHA256
+func NewTokenManager(secret, issuer string) *TokenManager {
+	return &TokenManager{secret: []byte(secret), issuer: issuer, now: time.Now}
+}
+
+// Issue signs a token described by spec with the given lifetime
+func (m *TokenManager) Issue(spec TokenSpec, ttl time.Duration) (string, time.Time, error) {
+	now := m.now()
+	expiresAt := now.Add(ttl)
+	claims := Claims{
+		Purpose:   spec.Purpose,
+		Role:      spec.Role,
+		SessionID: spec.SessionID,
+		ClientID:  spec.ClientID,
+		Scope:     strings.Join(spec.Scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Subject:   strconv.FormatUint(uint64(spec.UserID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// Parse verifies a token's signature, expiry and purpose
+func (m *TokenManager) Parse(tokenString, purpose string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return m.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithTimeFunc(m.now),
+	)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Purpose != purpose {
+		return nil, ErrWrongPurpose
+	}
+	return claims, nil
+}
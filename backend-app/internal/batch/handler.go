@@ -0,0 +1,283 @@
+// Package batch runs several API calls in one HTTP request. Each call is
+// dispatched through the router as its own request, with the caller's
+// credentials, so it passes the same authentication, authorization and
+// validation as when it is sent on its own. Atomic batches run their calls
+// in one database transaction, which the calls' requests carry in their
+// context.
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/database"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"gorm.io/gorm"
+)
+
+// forwardedHeaders are copied from the batch request to every call
+var forwardedHeaders = []string{"Authorization", middleware.APIKeyHeader, "User-Agent"}
+
+// callHeaders may be set per call
+var callHeaders = []string{"Content-Type", "If-Match", "If-None-Match", "Idempotency-Key"}
+
+// resultHeaders are returned with each call's result
+var resultHeaders = []string{"ETag", "Location", "Retry-After"}
+
+// errRolledBack rolls back an atomic batch in which a call failed
+var errRolledBack = errors.New("batch rolled back")
+
+// Request is a batch of API calls
+type Request struct {
+	Requests []Call `json:"requests" binding:"required,min=1,max=50,dive"`
+	// StopOnError stops the batch at the first call that fails; the
+	// remaining calls are not run and report 424 Failed Dependency. Calls
+	// that already succeeded are not rolled back.
+	StopOnError bool `json:"stop_on_error" example:"false"`
+	// Atomic runs the calls in one transaction: when a call fails, the
+	// changes of every call are rolled back, the calls that succeeded report
+	// 424 and the remaining ones are not run. Emails and audit events are
+	// sent and recorded once the batch commits. Only user management calls
+	// without an Idempotency-Key may run in an atomic batch.
+	Atomic bool `json:"atomic" example:"false"`
+}
+
+// Call is a single API call within a batch
+type Call struct {
+	Method  string            `json:"method" binding:"required,oneof=GET POST PUT PATCH DELETE" example:"PATCH"`
+	Path    string            `json:"path" binding:"required" example:"/users/42"` // Relative to /api/v1, query string allowed
+	Headers map[string]string `json:"headers,omitempty"`                           // Content-Type, If-Match, If-None-Match or Idempotency-Key
+	Body    json.RawMessage   `json:"body,omitempty" swaggertype:"object"`
+}
+
+// Response holds the result of every call, in request order
+type Response struct {
+	Results []Result `json:"results"`
+}
+
+// Result is the response to a single call
+type Result struct {
+	Status  int               `json:"status" example:"200"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty" swaggertype:"object"`
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error   string `json:"error" example:"Invalid request"`
+	Details string `json:"details,omitempty"`
+}
+
+// Handler dispatches batched calls
+type Handler struct {
+	router        http.Handler
+	prefix        string                 // Path calls are relative to, e.g. /api/v1
+	self          string                 // Path of the batch endpoint, which calls may not target
+	db            *gorm.DB               // Runs the transactions of atomic batches
+	transactional func(path string) bool // Reports whether a path, relative to prefix, may run in an atomic batch
+}
+
+// NewHandler creates a batch handler dispatching calls through router.
+// Atomic batches run in transactions on db and may only call the paths
+// transactional accepts, whose handlers take part in the transaction.
+func NewHandler(router http.Handler, prefix, self string, db *gorm.DB, transactional func(path string) bool) *Handler {
+	return &Handler{router: router, prefix: prefix, self: self, db: db, transactional: transactional}
+}
+
+// Batch godoc
+// @Summary      Run several API calls
+// @Description  Run up to 50 API calls in one request. Each call is dispatched as its own request with the caller's credentials and gets its own status, ETag and body in the results, in request order. Calls run in order and commit their changes as they run. With stop_on_error, the batch stops at the first call that fails and the rest report 424; calls that already succeeded are not rolled back. With atomic, the calls run in one transaction: when one fails, every change is rolled back and the other calls report 424. Atomic batches may only contain user management calls, without Idempotency-Key.
+// @Tags         batch
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        request body Request true "Calls to run"
+// @Success      200  {object}  Response
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /batch [post]
+func (h *Handler) Batch(c *gin.Context) {
+	var req Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	targets := make([]*url.URL, len(req.Requests))
+	for i, call := range req.Requests {
+		target, ok := h.target(call.Path)
+		if !ok {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Details: "requests[" + strconv.Itoa(i) + "].path must be an API path other than the batch endpoint",
+			})
+			return
+		}
+		if req.Atomic && !h.transactional(strings.TrimPrefix(target.Path, h.prefix)) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid request",
+				Details: "requests[" + strconv.Itoa(i) + "].path cannot run in an atomic batch",
+			})
+			return
+		}
+		for name := range call.Headers {
+			if !containsHeader(callHeaders, name) {
+				c.JSON(http.StatusBadRequest, ErrorResponse{
+					Error:   "Invalid request",
+					Details: "requests[" + strconv.Itoa(i) + "] cannot set header " + name,
+				})
+				return
+			}
+			// Idempotency records are kept outside the transaction and
+			// would replay responses of rolled back calls
+			if req.Atomic && strings.EqualFold(name, "Idempotency-Key") {
+				c.JSON(http.StatusBadRequest, ErrorResponse{
+					Error:   "Invalid request",
+					Details: "requests[" + strconv.Itoa(i) + "] cannot set Idempotency-Key in an atomic batch",
+				})
+				return
+			}
+		}
+		targets[i] = target
+	}
+
+	if !req.Atomic {
+		results, _ := h.run(c.Request.Context(), c, req.Requests, targets, req.StopOnError)
+		c.JSON(http.StatusOK, Response{Results: results})
+		return
+	}
+
+	var results []Result
+	failed := -1
+	err := database.Transaction(c.Request.Context(), h.db, func(ctx context.Context) error {
+		if results, failed = h.run(ctx, c, req.Requests, targets, true); failed >= 0 {
+			return errRolledBack
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errRolledBack):
+		for i := range failed {
+			results[i] = failedDependency("Rolled back because a later request failed")
+		}
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to commit the batch"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Results: results})
+}
+
+// run dispatches calls in order with ctx as their requests' context. With
+// stopOnError, the calls after the first that fails are not run. It
+// returns the index of that call, or -1 when none failed.
+func (h *Handler) run(ctx context.Context, c *gin.Context, calls []Call, targets []*url.URL, stopOnError bool) ([]Result, int) {
+	results := make([]Result, len(calls))
+	failed := -1
+	for i, call := range calls {
+		if failed >= 0 {
+			results[i] = failedDependency("Not run because an earlier request failed")
+			continue
+		}
+		results[i] = h.dispatch(ctx, c, i, call, targets[i])
+		if stopOnError && results[i].Status >= http.StatusBadRequest {
+			failed = i
+		}
+	}
+	return results, failed
+}
+
+// failedDependency is the result of a call that was not run or rolled back
+func failedDependency(reason string) Result {
+	body, _ := json.Marshal(ErrorResponse{Error: reason})
+	return Result{Status: http.StatusFailedDependency, Body: body}
+}
+
+// target resolves a call path against the API prefix, rejecting paths
+// that escape it or target the batch endpoint itself. Paths are checked
+// unescaped, as the router matches them.
+func (h *Handler) target(callPath string) (*url.URL, bool) {
+	u, err := url.Parse(callPath)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return nil, false
+	}
+	resolved := path.Clean(h.prefix + u.Path)
+	if !strings.HasPrefix(resolved, h.prefix+"/") || resolved == h.self || strings.HasPrefix(resolved, h.self+"/") {
+		return nil, false
+	}
+	return &url.URL{Path: resolved, RawQuery: u.RawQuery}, true
+}
+
+// dispatch runs one call through the router with ctx as its request's
+// context and captures its response
+func (h *Handler) dispatch(ctx context.Context, c *gin.Context, index int, call Call, target *url.URL) Result {
+	var body *bytes.Reader
+	if len(call.Body) > 0 && string(call.Body) != "null" {
+		body = bytes.NewReader(call.Body)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+
+	sub, err := http.NewRequestWithContext(ctx, call.Method, target.String(), body)
+	if err != nil {
+		encoded, _ := json.Marshal(ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return Result{Status: http.StatusBadRequest, Body: encoded}
+	}
+	// Calls come from the client IP the batch request was resolved to, as
+	// its forwarding headers are not copied
+	sub.RemoteAddr = net.JoinHostPort(c.ClientIP(), "0")
+	for _, name := range forwardedHeaders {
+		if value := c.GetHeader(name); value != "" {
+			sub.Header.Set(name, value)
+		}
+	}
+	if body.Len() > 0 {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range call.Headers {
+		sub.Header.Set(name, value)
+	}
+	sub.Header.Set(middleware.RequestIDHeader, middleware.RequestID(c)+"-"+strconv.Itoa(index))
+
+	recorder := httptest.NewRecorder()
+	h.router.ServeHTTP(recorder, sub)
+
+	result := Result{Status: recorder.Code}
+	for _, name := range resultHeaders {
+		if value := recorder.Header().Get(name); value != "" {
+			if result.Headers == nil {
+				result.Headers = map[string]string{}
+			}
+			result.Headers[name] = value
+		}
+	}
+	if recorder.Body.Len() > 0 {
+		if json.Valid(recorder.Body.Bytes()) {
+			result.Body = recorder.Body.Bytes()
+		} else {
+			result.Body, _ = json.Marshal(recorder.Body.String())
+		}
+	}
+	return result
+}
+
+// containsHeader reports whether names holds name, ignoring case
+func containsHeader(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package batch
+
+import (
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RegisterRoutes registers the batch endpoint behind guards, which must
+// authenticate the caller. Calls are dispatched through engine and are
+// relative to router's base path. Atomic batches run in transactions on db
+// and may only call the paths transactional accepts.
+func RegisterRoutes(router *gin.RouterGroup, engine *gin.Engine, db *gorm.DB, transactional func(path string) bool, guards ...gin.HandlerFunc) {
+	handler := NewHandler(engine, router.BasePath(), router.BasePath()+"/batch", db, transactional)
+
+	// Batch routes
+	router.POST("/batch", append(guards, handler.Batch)...)
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"expvar"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache reads values through a store. Concurrent misses for a key share a
+// single load, so an expired hot key does not send a stampede of queries to
+// the database.
+type Cache struct {
+	name  string
+	store Store
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	calls map[string]*call
+
+	hits, misses, shared, errors atomic.Int64
+}
+
+// call is a load in flight
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+	stale bool // Invalidated while loading, so the value is not stored
+}
+
+// Stats counts how a cache's reads were served
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Shared int64 `json:"shared"` // Misses served by another caller's load
+	Errors int64 `json:"errors"` // Store failures; reads fall back to loading
+}
+
+// New creates a cache keeping values in store for ttl. Keys are prefixed
+// with name, and its statistics are published as the expvar cache.<name>,
+// so each name may only be used once.
+func New(name string, store Store, ttl time.Duration) *Cache {
+	c := &Cache{name: name, store: store, ttl: ttl, calls: map[string]*call{}}
+	expvar.Publish("cache."+name, expvar.Func(func() interface{} { return c.Stats() }))
+	return c
+}
+
+// Fetch returns the value at key, calling load on a miss and storing its
+// result. Errors from load are returned and not cached.
+func (c *Cache) Fetch(key string, load func() ([]byte, error)) ([]byte, error) {
+	key = "cache:" + c.name + ":" + key
+	value, ok, err := c.store.Get(key)
+	if err != nil {
+		c.errors.Add(1)
+		log.Printf("⚠️  Cache %s read failed: %v", c.name, err)
+	} else if ok {
+		c.hits.Add(1)
+		return value, nil
+	}
+	c.misses.Add(1)
+
+	c.mu.Lock()
+	if inFlight, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		c.shared.Add(1)
+		<-inFlight.done
+		return inFlight.value, inFlight.err
+	}
+	current := &call{done: make(chan struct{})}
+	c.calls[key] = current
+	c.mu.Unlock()
+
+	defer close(current.done)
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+	}()
+
+	current.value, current.err = load()
+	if current.err != nil {
+		return nil, current.err
+	}
+	if err := c.store.Set(key, current.value, c.ttl); err != nil {
+		c.errors.Add(1)
+		log.Printf("⚠️  Cache %s write failed: %v", c.name, err)
+		return current.value, nil
+	}
+
+	// An invalidation that raced with the load may have run before the
+	// value was stored; drop it again so the stale value does not linger
+	c.mu.Lock()
+	stale := current.stale
+	c.mu.Unlock()
+	if stale {
+		c.delete(key)
+	}
+	return current.value, nil
+}
+
+// Invalidate removes keys, e.g. after the values they cache changed. Loads
+// of the keys already in flight are not stored.
+func (c *Cache) Invalidate(keys ...string) {
+	prefixed := make([]string, len(keys))
+	c.mu.Lock()
+	for i, key := range keys {
+		prefixed[i] = "cache:" + c.name + ":" + key
+		if inFlight, ok := c.calls[prefixed[i]]; ok {
+			inFlight.stale = true
+		}
+	}
+	c.mu.Unlock()
+	c.delete(prefixed...)
+}
+
+// Stats returns the cache's counters since it was created
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Shared: c.shared.Load(),
+		Errors: c.errors.Load(),
+	}
+}
+
+func (c *Cache) delete(keys ...string) {
+	if err := c.store.Delete(keys...); err != nil {
+		c.errors.Add(1)
+		log.Printf("⚠️  Cache %s invalidation failed: %v", c.name, err)
+	}
+}
@@ -0,0 +1,131 @@
+// Package cache reads values through a Store, in memory or in Redis, and
+// collapses concurrent loads of the same key into one.
+package cache
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/redis"
+)
+
+// Store keeps expiring values. Returned values must not be modified.
+type Store interface {
+	// Get returns the value at key, reporting false when it is missing or expired
+	Get(key string) ([]byte, bool, error)
+	// Set stores value at key for ttl
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes the keys; missing keys are ignored
+	Delete(keys ...string) error
+}
+
+// LRU keeps up to size values in memory, evicting the least recently used
+// once full. Values are only visible to the instance holding them.
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List // Most recently used first
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewLRU creates an in-memory store holding at most size values
+func NewLRU(size int) *LRU {
+	return &LRU{size: max(size, 1), items: map[string]*list.Element{}, order: list.New()}
+}
+
+// Get implements Store
+func (s *LRU) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		s.remove(element)
+		return nil, false, nil
+	}
+	s.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+// Set implements Store
+func (s *LRU) Set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &lruEntry{key: key, value: value, expiresAt: time.Now().Add(ttl)}
+	if element, ok := s.items[key]; ok {
+		element.Value = entry
+		s.order.MoveToFront(element)
+		return nil
+	}
+	s.items[key] = s.order.PushFront(entry)
+	for s.order.Len() > s.size {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// Delete implements Store
+func (s *LRU) Delete(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := s.items[key]; ok {
+			s.remove(element)
+		}
+	}
+	return nil
+}
+
+// remove drops an element; callers hold mu
+func (s *LRU) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.items, element.Value.(*lruEntry).key)
+}
+
+// RedisStore keeps values in Redis, shared by every instance using it
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a store backed by client
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Get implements Store
+func (s *RedisStore) Get(key string) ([]byte, bool, error) {
+	value, ok, err := redis.String(s.client.Do("GET", key))
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return []byte(value), true, nil
+}
+
+// Set implements Store
+func (s *RedisStore) Set(key string, value []byte, ttl time.Duration) error {
+	_, err := s.client.Do("SET", key, string(value), "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	return err
+}
+
+// Delete implements Store
+func (s *RedisStore) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.client.Do(append([]string{"DEL"}, keys...)...)
+	return err
+}
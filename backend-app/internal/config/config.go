@@ -1,11 +1,48 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OIDCProviderConfig is a relying-party registration with an OpenID provider
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// RateLimitPolicyConfig allows Limit requests per Window to Route, a method
+// and route pattern such as "POST /api/v1/users/login" or * for every
+// route, counted per Key: ip, user or api_key
+type RateLimitPolicyConfig struct {
+	Name   string
+	Route  string
+	Limit  int
+	Window time.Duration
+	Key    string
+}
+
+// defaultRateLimitPolicies are used for policies named in RATE_LIMIT_POLICIES
+// that are not configured in full
+var defaultRateLimitPolicies = map[string]RateLimitPolicyConfig{
+	"login":    {Route: "POST /api/v1/users/login", Limit: 10, Window: time.Minute, Key: "ip"},
+	"register": {Route: "POST /api/v1/users/register", Limit: 5, Window: time.Hour, Key: "ip"},
+	"api":      {Route: "*", Limit: 600, Window: time.Minute, Key: "user"},
+}
 
 type Config struct {
-	AppName string
-	AppEnv  string
-	AppPort string
+	AppName    string
+	AppEnv     string
+	AppPort    string
+	AppBaseURL string
+
+	// Proxies, as IPs or CIDRs, whose X-Forwarded-For and X-Real-IP headers
+	// are trusted for the client IP; none by default
+	TrustedProxies []string
 
 	// Database
 	DBHost     string
@@ -13,19 +50,291 @@ type Config struct {
 	DBUser     string
 	DBPassword string
 	DBName     string
+
+	// Auth
+	JWTSecret       string
+	JWTAccessTTL    time.Duration
+	JWTRefreshTTL   time.Duration
+	SessionCacheTTL time.Duration
+	MFAChallengeTTL time.Duration
+	MFAIssuer       string
+	AdminEmails     []string
+
+	// Password hashing
+	PasswordHashAlgorithm string
+	Argon2MemoryKiB       int
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	BcryptCost            int
+
+	// Password policy
+	PasswordMinLength     int
+	PasswordMaxLength     int
+	PasswordRequireUpper  bool
+	PasswordRequireLower  bool
+	PasswordRequireDigit  bool
+	PasswordRequireSymbol bool
+	PasswordHistorySize   int
+	BreachedPasswordsDir  string
+
+	// Login brute-force protection
+	LoginMaxFailures     int
+	LoginIPMaxFailures   int
+	LoginLockoutDuration time.Duration
+	LoginBackoffBase     time.Duration
+	LoginBackoffMax      time.Duration
+
+	// Email
+	SMTPHost       string
+	SMTPPort       string
+	SMTPUsername   string
+	SMTPPassword   string
+	MailFrom       string
+	EmailChangeTTL time.Duration
+
+	// OpenID Connect social login
+	OIDCProviders         []OIDCProviderConfig
+	OIDCStateTTL          time.Duration
+	OIDCMockEnabled       bool // Serve the built-in mock provider at /mock-oidc (never in production)
+	OIDCStateCookieSecure bool // Send the cookie binding a sign-in to the browser over HTTPS only
+
+	// OAuth2 authorization server
+	OAuthCodeTTL         time.Duration
+	OAuthAccessTokenTTL  time.Duration
+	OAuthRefreshTokenTTL time.Duration
+
+	// Deleted accounts are purged permanently after the retention period;
+	// zero keeps them forever
+	UserDeletedRetention time.Duration
+	UserPurgeInterval    time.Duration
+
+	// Personal data exports and account erasure
+	DataExportTTL      time.Duration
+	ErasureConfirmTTL  time.Duration
+	ErasureGracePeriod time.Duration
+	PrivacyJobInterval time.Duration
+
+	// Responses to POST requests with an Idempotency-Key are replayed for
+	// IdempotencyKeyTTL; an in-flight request holds its key for at most
+	// IdempotencyLockTTL
+	IdempotencyKeyTTL          time.Duration
+	IdempotencyLockTTL         time.Duration
+	IdempotencyCleanupInterval time.Duration
+
+	// Users read by ID are cached for UserCacheTTL in memory, up to
+	// UserCacheSize users, or in Redis; none disables the cache. Memory
+	// caches are per instance, so other instances see changes once the
+	// cached copies expire.
+	UserCacheStore string
+	UserCacheTTL   time.Duration
+	UserCacheSize  int
+
+	// Response compression; responses smaller than CompressMinSize or of
+	// other content types are sent uncompressed. CompressEncodings lists
+	// gzip, br and zstd in the order preferred when a client accepts
+	// several equally; CompressLevel applies to gzip.
+	CompressEnabled      bool
+	CompressEncodings    []string
+	CompressLevel        int
+	CompressMinSize      int
+	CompressContentTypes []string
+
+	// Default Cache-Control of the Swagger UI and of API responses
+	CacheControlSwagger string
+	CacheControlAPI     string
+
+	// Browser protections. Development allows cross-origin requests from
+	// any origin; production sends HSTS and Secure cookies, which need HTTPS.
+	CORSEnabled            bool
+	CORSAllowedOrigins     []string // * allows any origin, without credentials
+	CORSAllowedMethods     []string
+	CORSAllowedHeaders     []string
+	CORSExposedHeaders     []string
+	CORSAllowCredentials   bool
+	CORSMaxAge             time.Duration
+	SecurityHeadersEnabled bool
+	HSTSMaxAge             time.Duration // Zero omits Strict-Transport-Security
+	ContentSecurityPolicy  string
+	ReferrerPolicy         string
+	CSRFEnabled            bool
+	CSRFCookieName         string
+	CSRFSessionCookies     []string // Cookies that authenticate browser sessions
+	CSRFCookieSecure       bool
+
+	// Rate limiting
+	RateLimitEnabled  bool
+	RateLimitStore    string // memory or redis
+	RateLimitPolicies []RateLimitPolicyConfig
+
+	// Redis
+	RedisAddr        string
+	RedisPassword    string
+	RedisStubEnabled bool // Serve an in-process Redis-compatible stub at REDIS_ADDR (never in production)
 }
 
 func Load() *Config {
+	appEnv := getEnv("APP_ENV", "development")
+	production := appEnv == "production"
+
+	// Development front ends may be served from any origin
+	corsOrigins := []string{}
+	hstsMaxAge := time.Duration(0)
+	if production {
+		hstsMaxAge = 365 * 24 * time.Hour
+	} else {
+		corsOrigins = []string{"*"}
+	}
+
 	return &Config{
-		AppName:    getEnv("APP_NAME", "go-backend"),
-		AppEnv:     getEnv("APP_ENV", "development"),
-		AppPort:    getEnv("APP_PORT", "8080"),
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "3306"),
-		DBUser:     getEnv("DB_USER", "root"),
-		DBPassword: getEnv("DB_PASSWORD", ""),
-		DBName:     getEnv("DB_NAME", "go_backend"),
+		AppName:         getEnv("APP_NAME", "go-backend"),
+		AppEnv:          appEnv,
+		AppPort:         getEnv("APP_PORT", "8080"),
+		AppBaseURL:      getEnv("APP_BASE_URL", "http://localhost:8080"),
+		TrustedProxies:  getEnvList("TRUSTED_PROXIES"),
+		DBHost:          getEnv("DB_HOST", "localhost"),
+		DBPort:          getEnv("DB_PORT", "3306"),
+		DBUser:          getEnv("DB_USER", "root"),
+		DBPassword:      getEnv("DB_PASSWORD", ""),
+		DBName:          getEnv("DB_NAME", "go_backend"),
+		JWTSecret:       getEnv("JWT_SECRET", ""),
+		JWTAccessTTL:    getEnvDuration("JWT_ACCESS_TTL", 15*time.Minute),
+		JWTRefreshTTL:   getEnvDuration("JWT_REFRESH_TTL", 30*24*time.Hour),
+		SessionCacheTTL: getEnvDuration("SESSION_CACHE_TTL", 30*time.Second),
+		MFAChallengeTTL: getEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+		MFAIssuer:       getEnv("MFA_ISSUER", "Go Backend"),
+		AdminEmails:     getEnvList("ADMIN_EMAILS"),
+
+		PasswordHashAlgorithm: getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+		Argon2MemoryKiB:       getEnvInt("ARGON2_MEMORY_KIB", 64*1024),
+		Argon2Iterations:      getEnvInt("ARGON2_ITERATIONS", 3),
+		Argon2Parallelism:     getEnvInt("ARGON2_PARALLELISM", 2),
+		BcryptCost:            getEnvInt("BCRYPT_COST", 10),
+
+		PasswordMinLength:     getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:     getEnvInt("PASSWORD_MAX_LENGTH", 128),
+		PasswordRequireUpper:  getEnvBool("PASSWORD_REQUIRE_UPPER", true),
+		PasswordRequireLower:  getEnvBool("PASSWORD_REQUIRE_LOWER", true),
+		PasswordRequireDigit:  getEnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		PasswordRequireSymbol: getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		PasswordHistorySize:   getEnvInt("PASSWORD_HISTORY_SIZE", 5),
+		BreachedPasswordsDir:  getEnv("BREACHED_PASSWORDS_DIR", ""),
+
+		LoginMaxFailures:     getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures:   getEnvInt("LOGIN_IP_MAX_FAILURES", 50),
+		LoginLockoutDuration: getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginBackoffBase:     getEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginBackoffMax:      getEnvDuration("LOGIN_BACKOFF_MAX", time.Minute),
+
+		SMTPHost:       getEnv("SMTP_HOST", ""),
+		SMTPPort:       getEnv("SMTP_PORT", "587"),
+		SMTPUsername:   getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:   getEnv("SMTP_PASSWORD", ""),
+		MailFrom:       getEnv("MAIL_FROM", "no-reply@example.com"),
+		EmailChangeTTL: getEnvDuration("EMAIL_CHANGE_TTL", 24*time.Hour),
+
+		OIDCProviders:         loadOIDCProviders(),
+		OIDCStateTTL:          getEnvDuration("OIDC_STATE_TTL", 10*time.Minute),
+		OIDCMockEnabled:       getEnvBool("OIDC_MOCK_ENABLED", false),
+		OIDCStateCookieSecure: getEnvBool("OIDC_STATE_COOKIE_SECURE", production),
+
+		OAuthCodeTTL:         getEnvDuration("OAUTH_CODE_TTL", time.Minute),
+		OAuthAccessTokenTTL:  getEnvDuration("OAUTH_ACCESS_TOKEN_TTL", time.Hour),
+		OAuthRefreshTokenTTL: getEnvDuration("OAUTH_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		UserDeletedRetention: getEnvDuration("USER_DELETED_RETENTION", 30*24*time.Hour),
+		UserPurgeInterval:    getEnvDuration("USER_PURGE_INTERVAL", time.Hour),
+
+		DataExportTTL:      getEnvDuration("DATA_EXPORT_TTL", 7*24*time.Hour),
+		ErasureConfirmTTL:  getEnvDuration("ERASURE_CONFIRM_TTL", 24*time.Hour),
+		ErasureGracePeriod: getEnvDuration("ERASURE_GRACE_PERIOD", 7*24*time.Hour),
+		PrivacyJobInterval: getEnvDuration("PRIVACY_JOB_INTERVAL", time.Hour),
+
+		IdempotencyKeyTTL:          getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		IdempotencyLockTTL:         getEnvDuration("IDEMPOTENCY_LOCK_TTL", time.Minute),
+		IdempotencyCleanupInterval: getEnvDuration("IDEMPOTENCY_CLEANUP_INTERVAL", time.Hour),
+
+		UserCacheStore: getEnv("USER_CACHE_STORE", "memory"),
+		UserCacheTTL:   getEnvDuration("USER_CACHE_TTL", 30*time.Second),
+		UserCacheSize:  getEnvInt("USER_CACHE_SIZE", 10000),
+
+		CompressEnabled:      getEnvBool("COMPRESS_ENABLED", true),
+		CompressEncodings:    getEnvListDefault("COMPRESS_ENCODINGS", []string{"br", "gzip", "zstd"}),
+		CompressLevel:        getEnvInt("COMPRESS_LEVEL", 6),
+		CompressMinSize:      getEnvInt("COMPRESS_MIN_SIZE", 1024),
+		CompressContentTypes: getEnvListDefault("COMPRESS_CONTENT_TYPES", []string{"text/", "application/json", "application/javascript", "application/x-ndjson", "application/xml", "image/svg+xml"}),
+
+		CacheControlSwagger: getEnv("CACHE_CONTROL_SWAGGER", "public, max-age=3600"),
+		CacheControlAPI:     getEnv("CACHE_CONTROL_API", "private, no-cache"),
+
+		CORSEnabled:            getEnvBool("CORS_ENABLED", true),
+		CORSAllowedOrigins:     getEnvListDefault("CORS_ALLOWED_ORIGINS", corsOrigins),
+		CORSAllowedMethods:     getEnvListDefault("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE"}),
+		CORSAllowedHeaders:     getEnvListDefault("CORS_ALLOWED_HEADERS", []string{"Authorization", "Content-Type", "X-API-Key", "X-Request-ID", "X-CSRF-Token", "If-Match", "If-None-Match", "Idempotency-Key"}),
+		CORSExposedHeaders:     getEnvListDefault("CORS_EXPOSED_HEADERS", []string{"ETag", "Location", "Retry-After", "X-Request-ID", "Idempotent-Replayed", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"}),
+		CORSAllowCredentials:   getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+		CORSMaxAge:             getEnvDuration("CORS_MAX_AGE", 10*time.Minute),
+		SecurityHeadersEnabled: getEnvBool("SECURITY_HEADERS_ENABLED", true),
+		HSTSMaxAge:             getEnvDuration("HSTS_MAX_AGE", hstsMaxAge),
+		ContentSecurityPolicy:  getEnv("CONTENT_SECURITY_POLICY", "default-src 'none'; frame-ancestors 'none'"),
+		ReferrerPolicy:         getEnv("REFERRER_POLICY", "no-referrer"),
+		CSRFEnabled:            getEnvBool("CSRF_ENABLED", true),
+		CSRFCookieName:         getEnv("CSRF_COOKIE_NAME", "csrf_token"),
+		CSRFSessionCookies:     getEnvList("CSRF_SESSION_COOKIES"),
+		CSRFCookieSecure:       getEnvBool("CSRF_COOKIE_SECURE", production),
+
+		RateLimitEnabled:  getEnvBool("RATE_LIMIT_ENABLED", true),
+		RateLimitStore:    getEnv("RATE_LIMIT_STORE", "memory"),
+		RateLimitPolicies: loadRateLimitPolicies(),
+
+		RedisAddr:        getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:    getEnv("REDIS_PASSWORD", ""),
+		RedisStubEnabled: getEnvBool("REDIS_STUB_ENABLED", false),
+	}
+}
+
+// loadOIDCProviders reads the providers named in OIDC_PROVIDERS, e.g. "google"
+// is configured by OIDC_GOOGLE_ISSUER, OIDC_GOOGLE_CLIENT_ID and
+// OIDC_GOOGLE_CLIENT_SECRET. Providers without OIDC support (such as plain
+// GitHub OAuth apps) must be fronted by an OIDC-compliant broker.
+func loadOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range getEnvList("OIDC_PROVIDERS") {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProviderConfig{
+			Name:         strings.ToLower(name),
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+		})
+	}
+	return providers
+}
+
+// loadRateLimitPolicies reads the policies named in RATE_LIMIT_POLICIES,
+// by default login, register and api. A policy such as "login" is
+// configured by RATE_LIMIT_LOGIN_ROUTE, RATE_LIMIT_LOGIN_LIMIT,
+// RATE_LIMIT_LOGIN_WINDOW and RATE_LIMIT_LOGIN_KEY; settings left unset
+// keep the built-in policy of that name.
+func loadRateLimitPolicies() []RateLimitPolicyConfig {
+	names := getEnvList("RATE_LIMIT_POLICIES")
+	if len(names) == 0 {
+		names = []string{"login", "register", "api"}
 	}
+
+	var policies []RateLimitPolicyConfig
+	for _, name := range names {
+		name = strings.ToLower(name)
+		prefix := "RATE_LIMIT_" + strings.ToUpper(name) + "_"
+		defaults := defaultRateLimitPolicies[name]
+		policies = append(policies, RateLimitPolicyConfig{
+			Name:   name,
+			Route:  getEnv(prefix+"ROUTE", defaults.Route),
+			Limit:  getEnvInt(prefix+"LIMIT", defaults.Limit),
+			Window: getEnvDuration(prefix+"WINDOW", defaults.Window),
+			Key:    getEnv(prefix+"KEY", defaults.Key),
+		})
+	}
+	return policies
 }
 
 func getEnv(key, defaultValue string) string {
@@ -34,3 +343,47 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvListDefault(key string, defaultValue []string) []string {
+	if values := getEnvList(key); len(values) > 0 {
+		return values
+	}
+	return defaultValue
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// txKey is the context key of the transaction started by Transaction
+type txKey struct{}
+
+// tx is a transaction carried in a context, with the work deferred until
+// it commits
+type tx struct {
+	db          *gorm.DB
+	afterCommit []func()
+}
+
+// Transaction runs fn in a transaction on db, passing it a context that
+// carries the transaction, so that work done on behalf of several requests,
+// such as the calls of an atomic batch, commits or rolls back as one. The
+// transaction commits when fn returns nil; functions deferred with
+// AfterCommit then run in order.
+func Transaction(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	t := &tx{}
+	err := db.WithContext(ctx).Transaction(func(db *gorm.DB) error {
+		t.db = db
+		return fn(context.WithValue(ctx, txKey{}, t))
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range t.afterCommit {
+		f()
+	}
+	return nil
+}
+
+// Conn returns db bound to ctx. Within Transaction it returns the
+// transaction instead, so repositories bound with Conn take part in it;
+// their own transactions become savepoints.
+func Conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if t, ok := ctx.Value(txKey{}).(*tx); ok {
+		return t.db.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
+// InTransaction reports whether ctx carries a transaction
+func InTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*tx)
+	return ok
+}
+
+// AfterCommit runs fn once the transaction ctx carries has committed, and
+// never if it rolls back. Outside a transaction fn runs right away. Side
+// effects that cannot be undone, such as sending email, are deferred with
+// it.
+func AfterCommit(ctx context.Context, fn func()) {
+	if t, ok := ctx.Value(txKey{}).(*tx); ok {
+		t.afterCommit = append(t.afterCommit, fn)
+		return
+	}
+	fn()
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/savindaJ/backend-app/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// update runs an UPDATE of user id on the connection ctx selects, inside a
+// transaction of its own as repositories do
+func update(ctx context.Context, db *gorm.DB, id int) error {
+	return Conn(ctx, db).Transaction(func(tx *gorm.DB) error {
+		return tx.Exec("UPDATE users SET version = version + 1 WHERE id = ?", id).Error
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	errFailed := errors.New("call failed")
+	tests := []struct {
+		name       string
+		fail       bool
+		wantSQL    []string
+		wantEffect []string
+	}{
+		{
+			name: "commit",
+			wantSQL: []string{testdb.Begin,
+				"SAVEPOINT", "UPDATE users",
+				"SAVEPOINT", "UPDATE users",
+				testdb.Commit},
+			wantEffect: []string{"mail 1", "mail 2"},
+		},
+		{
+			name: "rollback",
+			fail: true,
+			wantSQL: []string{testdb.Begin,
+				"SAVEPOINT", "UPDATE users",
+				"SAVEPOINT", "UPDATE users",
+				testdb.Rollback},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &testdb.Conn{}
+			db := testdb.Open(t, conn)
+			var effects []string
+
+			err := Transaction(context.Background(), db, func(ctx context.Context) error {
+				if !InTransaction(ctx) {
+					t.Error("InTransaction() = false inside the transaction")
+				}
+				for id := 1; id <= 2; id++ {
+					if err := update(ctx, db, id); err != nil {
+						return err
+					}
+					AfterCommit(ctx, func() { effects = append(effects, "mail "+strconv.Itoa(id)) })
+				}
+				if len(effects) != 0 {
+					t.Errorf("ran %v before the transaction committed", effects)
+				}
+				if tt.fail {
+					return errFailed
+				}
+				return nil
+			})
+
+			if tt.fail != errors.Is(err, errFailed) {
+				t.Fatalf("Transaction() = %v, want fail %v", err, tt.fail)
+			}
+			var got []string
+			for _, statement := range conn.SQL() {
+				got = append(got, statementPrefix(statement))
+			}
+			if !slices.Equal(got, tt.wantSQL) {
+				t.Errorf("ran %v, want %v", conn.SQL(), tt.wantSQL)
+			}
+			if !slices.Equal(effects, tt.wantEffect) {
+				t.Errorf("ran %v after the transaction, want %v", effects, tt.wantEffect)
+			}
+		})
+	}
+}
+
+func TestOutsideTransaction(t *testing.T) {
+	conn := &testdb.Conn{}
+	db := testdb.Open(t, conn)
+	ctx := context.Background()
+
+	if InTransaction(ctx) {
+		t.Error("InTransaction() = true without a transaction")
+	}
+	ran := false
+	AfterCommit(ctx, func() { ran = true })
+	if !ran {
+		t.Error("AfterCommit() deferred a function without a transaction")
+	}
+
+	if err := update(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{testdb.Begin, "UPDATE users", testdb.Commit}
+	var got []string
+	for _, statement := range conn.SQL() {
+		got = append(got, statementPrefix(statement))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ran %v, want %v", conn.SQL(), want)
+	}
+}
+
+// statementPrefix shortens a statement to its first two words, dropping
+// generated savepoint names
+func statementPrefix(statement string) string {
+	words := strings.Fields(statement)
+	if len(words) > 2 {
+		words = words[:2]
+	}
+	if words[0] == "SAVEPOINT" {
+		words = words[:1]
+	}
+	return strings.Join(words, " ")
+}
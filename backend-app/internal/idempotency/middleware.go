@@ -0,0 +1,192 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers used by the middleware
+const (
+	KeyHeader      = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxKeyLength bounds keys accepted from clients
+const maxKeyLength = 255
+
+// replayedHeaders are the response headers stored and replayed with a response
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// errorResponse mirrors the modules' error body
+type errorResponse struct {
+	Error   string `json:"error"`
+	Details string `json:"details,omitempty"`
+}
+
+// Middleware makes POST requests carrying an Idempotency-Key header safe to
+// retry. The first request with a key runs; retries with the same key,
+// target and credentials replay its response for ttl. A key reused with a
+// different body is rejected with 422, and a retry arriving while the
+// first request still runs gets 409. Requests that end in a server error
+// or 429 are forgotten so they can be retried. lockTTL bounds how long an
+// in-flight request holds its key if the server handling it dies.
+//
+// Responses are stored as sent, so only guard routes whose responses
+// carry no credentials.
+func Middleware(store *Store, ttl, lockTTL time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(KeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{
+				Error: KeyHeader + " must be at most " + strconv.Itoa(maxKeyLength) + " characters",
+			})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		record := &Record{
+			Scope:       scope(c, key),
+			Fingerprint: hash([]byte(c.ContentType()), body),
+			LockedUntil: now.Add(lockTTL),
+			ExpiresAt:   now.Add(ttl),
+		}
+		existing, err := store.Reserve(record, now)
+		if err != nil {
+			log.Printf("⚠️  Failed to reserve idempotency key: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse{Error: "Failed to process request"})
+			return
+		}
+
+		if existing != nil {
+			if existing.Fingerprint != record.Fingerprint {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, errorResponse{
+					Error: KeyHeader + " was already used with a different request",
+				})
+				return
+			}
+			if !existing.InFlight() {
+				replay(c, existing)
+				return
+			}
+			// A request whose lock expired was abandoned and may run again
+			claimed := false
+			if now.After(existing.LockedUntil) {
+				if claimed, err = store.TakeOver(existing, record.LockedUntil); err != nil {
+					log.Printf("⚠️  Failed to take over idempotency key: %v", err)
+				}
+			}
+			if !claimed {
+				c.Header("Retry-After", "1")
+				c.AbortWithStatusJSON(http.StatusConflict, errorResponse{
+					Error: "A request with this " + KeyHeader + " is still being processed",
+				})
+				return
+			}
+			record = existing
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+			if err := store.Release(record.ID); err != nil {
+				log.Printf("⚠️  Failed to release idempotency key: %v", err)
+			}
+			return
+		}
+		if err := store.Complete(record.ID, status, encodeHeader(recorder.Header()), recorder.body.Bytes()); err != nil {
+			log.Printf("⚠️  Failed to store idempotent response: %v", err)
+		}
+	}
+}
+
+// scope identifies a key for one request target and caller, so keys
+// chosen by different clients never collide
+func scope(c *gin.Context, key string) string {
+	return hash(
+		[]byte(c.Request.Method),
+		[]byte(c.Request.URL.Path),
+		[]byte(c.GetHeader("Authorization")),
+		[]byte(c.GetHeader("X-API-Key")),
+		[]byte(key),
+	)
+}
+
+// hash returns the hex SHA-256 of length-prefixed parts
+func hash(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(strconv.Itoa(len(part)) + ":"))
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay writes a stored response
+func replay(c *gin.Context, record *Record) {
+	var header map[string][]string
+	if record.Header != "" {
+		if err := json.Unmarshal([]byte(record.Header), &header); err != nil {
+			log.Printf("⚠️  Failed to decode stored response headers: %v", err)
+		}
+	}
+	for name, values := range header {
+		for _, value := range values {
+			c.Writer.Header().Add(name, value)
+		}
+	}
+	c.Header(ReplayedHeader, "true")
+	c.Status(record.Status)
+	c.Writer.Write(record.Body)
+	c.Abort()
+}
+
+// encodeHeader keeps the replayed headers of a response
+func encodeHeader(header http.Header) string {
+	kept := map[string][]string{}
+	for _, name := range replayedHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			kept[name] = values
+		}
+	}
+	encoded, _ := json.Marshal(kept)
+	return string(encoded)
+}
+
+// responseRecorder copies the response body while it is written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
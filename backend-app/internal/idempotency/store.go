@@ -0,0 +1,126 @@
+// Package idempotency makes POST requests safe to retry. Clients send an
+// Idempotency-Key header; the first request with a key runs and its
+// response is stored, and retries with the same key replay that response
+// instead of running the request again.
+package idempotency
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Record is a request made with an idempotency key: in flight until its
+// response is stored, then replayed until it expires
+type Record struct {
+	ID          uint      `gorm:"primaryKey"`
+	Scope       string    `gorm:"size:64;uniqueIndex;not null"` // Hash of the key, request target and caller
+	Fingerprint string    `gorm:"size:64;not null"`             // Hash of the request body
+	Status      int       `gorm:"not null;default:0"`           // Zero while in flight
+	Header      string    `gorm:"type:text"`                    // Replayed response headers, JSON encoded
+	Body        []byte    `gorm:"type:longblob"`
+	LockedUntil time.Time `gorm:"not null"` // An in-flight request older than this is presumed dead
+	ExpiresAt   time.Time `gorm:"index;not null"`
+	CreatedAt   time.Time
+}
+
+// TableName overrides the table name
+func (Record) TableName() string {
+	return "idempotency_keys"
+}
+
+// InFlight reports whether the request is still being processed
+func (r *Record) InFlight() bool {
+	return r.Status == 0
+}
+
+// Store keeps idempotency records
+type Store struct {
+	db *gorm.DB
+}
+
+// NewStore creates a new idempotency record store
+func NewStore(db *gorm.DB) *Store {
+	return &Store{db: db}
+}
+
+// Reserve inserts record unless its scope is already taken by a record
+// that has not expired. It returns the existing record, or nil when
+// record was reserved and the request may run.
+func (s *Store) Reserve(record *Record, now time.Time) (*Record, error) {
+	var existing *Record
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("scope = ? AND expires_at <= ?", record.Scope, now).Delete(&Record{}).Error; err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+
+		existing = &Record{}
+		return tx.Where("scope = ?", record.Scope).First(existing).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// TakeOver claims an in-flight record whose lock expired, e.g. because the
+// server handling it stopped, reporting whether the claim succeeded
+func (s *Store) TakeOver(record *Record, lockedUntil time.Time) (bool, error) {
+	result := s.db.Model(&Record{}).
+		Where("id = ? AND status = 0 AND locked_until = ?", record.ID, record.LockedUntil).
+		Update("locked_until", lockedUntil)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	record.LockedUntil = lockedUntil
+	return true, nil
+}
+
+// Complete stores the response of a reserved request
+func (s *Store) Complete(id uint, status int, header string, body []byte) error {
+	return s.db.Model(&Record{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status": status,
+		"header": header,
+		"body":   body,
+	}).Error
+}
+
+// Release removes a reserved record so the request can be retried
+func (s *Store) Release(id uint) error {
+	return s.db.Delete(&Record{}, id).Error
+}
+
+// DeleteExpired removes records that expired before now
+func (s *Store) DeleteExpired(now time.Time) (int64, error) {
+	result := s.db.Where("expires_at <= ?", now).Delete(&Record{})
+	return result.RowsAffected, result.Error
+}
+
+// StartCleanup deletes expired records every interval in the background
+func (s *Store) StartCleanup(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := s.DeleteExpired(time.Now()); err != nil {
+				log.Printf("⚠️  Failed to delete expired idempotency keys: %v", err)
+			}
+		}
+	}()
+}
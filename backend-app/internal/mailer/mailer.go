@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+
+	"github.com/savindaJ/backend-app/internal/config"
+)
+
+// Message represents a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New returns an SMTP mailer when SMTP_HOST is configured, otherwise a
+// mailer that only logs messages, which is convenient in development
+func New(cfg *config.Config) Mailer {
+	if cfg.SMTPHost == "" {
+		return &logMailer{}
+	}
+	return &smtpMailer{
+		addr: fmt.Sprintf("%s:%s", cfg.SMTPHost, cfg.SMTPPort),
+		from: cfg.MailFrom,
+		auth: smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost),
+	}
+}
+
+// logMailer writes messages to the log instead of sending them
+type logMailer struct{}
+
+func (m *logMailer) Send(msg Message) error {
+	log.Printf("📧 Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// smtpMailer sends messages through an SMTP server
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (m *smtpMailer) Send(msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("mailer: header values must not contain line breaks")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(msg.Body)
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(b.String()))
+}
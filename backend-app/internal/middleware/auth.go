@@ -1 +1,214 @@
 package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/auth"
+)
+
+// Context keys set by the auth middleware
+const (
+	ContextUserID    = "userID"
+	ContextRole      = "role"
+	ContextSessionID = "sessionID"
+	ContextAPIKeyID  = "apiKeyID"
+	ContextScopes    = "scopes"
+	ContextClientID  = "clientID"
+)
+
+// APIKeyHeader carries a personal API key
+const APIKeyHeader = "X-API-Key"
+
+// SessionValidator reports whether a login session is still active
+type SessionValidator interface {
+	IsSessionActive(sessionID string) bool
+}
+
+// SessionRouter dispatches session checks to the validator registered for
+// the session ID's prefix, so login sessions and OAuth grants can be
+// validated by their own stores
+type SessionRouter struct {
+	Default  SessionValidator
+	Prefixes map[string]SessionValidator
+}
+
+// IsSessionActive implements SessionValidator
+func (r SessionRouter) IsSessionActive(sessionID string) bool {
+	for prefix, validator := range r.Prefixes {
+		if strings.HasPrefix(sessionID, prefix) {
+			return validator.IsSessionActive(sessionID)
+		}
+	}
+	return r.Default.IsSessionActive(sessionID)
+}
+
+// APIKeyPrincipal is the caller identified by an API key
+type APIKeyPrincipal struct {
+	KeyID  uint
+	UserID uint
+	Role   string
+	Scopes []string
+}
+
+// APIKeyValidator resolves an API key to its principal
+type APIKeyValidator interface {
+	ValidateAPIKey(key string) (*APIKeyPrincipal, error)
+}
+
+// Auth authenticates the caller with a Bearer access token or, failing
+// that, an X-API-Key header and stores the caller in the context. Tokens
+// bound to a session are rejected once it is revoked. Tokens issued to
+// OAuth clients and API keys carry scopes that RequireScope enforces.
+func Auth(tokens *auth.TokenManager, sessions SessionValidator, apiKeys APIKeyValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		tokenString, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || tokenString == "" {
+			if key := c.GetHeader(APIKeyHeader); key != "" {
+				authenticateAPIKey(c, apiKeys, key)
+				return
+			}
+			abortUnauthorized(c, "missing bearer token or API key")
+			return
+		}
+
+		claims, err := tokens.Parse(tokenString, auth.PurposeAccess)
+		if err != nil {
+			abortUnauthorized(c, err.Error())
+			return
+		}
+
+		userID, err := claims.UserID()
+		if err != nil {
+			abortUnauthorized(c, err.Error())
+			return
+		}
+
+		if claims.SessionID != "" && !sessions.IsSessionActive(claims.SessionID) {
+			abortUnauthorized(c, "session has been revoked")
+			return
+		}
+
+		c.Set(ContextUserID, userID)
+		c.Set(ContextRole, claims.Role)
+		c.Set(ContextSessionID, claims.SessionID)
+		if claims.ClientID != "" {
+			c.Set(ContextClientID, claims.ClientID)
+			c.Set(ContextScopes, claims.Scopes())
+		}
+		c.Next()
+	}
+}
+
+// authenticateAPIKey stores an API key's principal, including its scopes, in the context
+func authenticateAPIKey(c *gin.Context, apiKeys APIKeyValidator, key string) {
+	principal, err := apiKeys.ValidateAPIKey(key)
+	if err != nil {
+		abortUnauthorized(c, err.Error())
+		return
+	}
+
+	c.Set(ContextUserID, principal.UserID)
+	c.Set(ContextRole, principal.Role)
+	c.Set(ContextAPIKeyID, principal.KeyID)
+	c.Set(ContextScopes, principal.Scopes)
+	c.Next()
+}
+
+// UserID returns the authenticated user's ID from the context
+func UserID(c *gin.Context) (uint, bool) {
+	id, ok := c.Get(ContextUserID)
+	if !ok {
+		return 0, false
+	}
+	userID, ok := id.(uint)
+	return userID, ok
+}
+
+// SessionID returns the session of the authenticated caller's token, if any
+func SessionID(c *gin.Context) string {
+	return c.GetString(ContextSessionID)
+}
+
+// Role returns the authenticated user's role from the context
+func Role(c *gin.Context) string {
+	return c.GetString(ContextRole)
+}
+
+// APIKeyID returns the API key the caller authenticated with, if any
+func APIKeyID(c *gin.Context) (uint, bool) {
+	id, ok := c.Get(ContextAPIKeyID)
+	if !ok {
+		return 0, false
+	}
+	keyID, ok := id.(uint)
+	return keyID, ok
+}
+
+// ClientID returns the OAuth client the caller's token was issued to, if any
+func ClientID(c *gin.Context) string {
+	return c.GetString(ContextClientID)
+}
+
+// HasScope reports whether the caller may act within scope. Users who signed
+// in directly act with the full rights of their role; API keys and OAuth
+// client tokens are limited to the scopes they were granted.
+func HasScope(c *gin.Context, scope string) bool {
+	scopes, ok := c.Get(ContextScopes)
+	if !ok {
+		return true
+	}
+	granted, _ := scopes.([]string)
+	for _, s := range granted {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope rejects API keys and OAuth client tokens that were not
+// granted scope. It must run after Auth.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasScope(c, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden", "details": "missing scope " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireFirstParty restricts a route to users who signed in directly,
+// rejecting API keys and OAuth client tokens, e.g. for account security
+// settings. It must run after Auth.
+func RequireFirstParty() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, delegated := c.Get(ContextScopes); delegated {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden", "details": "not available to API keys or OAuth clients"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRole rejects callers whose role is not one of the given roles.
+// It must run after Auth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := Role(c)
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden", "details": "insufficient role"})
+	}
+}
+
+func abortUnauthorized(c *gin.Context, details string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized", "details": details})
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// CacheControl sets a default Cache-Control header for a route group, e.g.
+// "public, max-age=3600" for static assets. Handlers can still override it,
+// for instance with no-store on responses carrying credentials.
+func CacheControl(value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value != "" {
+			c.Header("Cache-Control", value)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,291 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoding is a content coding the Compress middleware can apply: gzip, br
+// or zstd. Other codings plug in by implementing it.
+type Encoding interface {
+	// Name is the coding's token in Accept-Encoding and Content-Encoding
+	Name() string
+	// NewWriter compresses to w. Close must flush the remaining output;
+	// writers that also implement Flush() error support streamed responses.
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+// CompressOptions configures the Compress middleware
+type CompressOptions struct {
+	Encodings    []Encoding // In order of preference when the client accepts several equally
+	MinSize      int        // Smaller responses are sent uncompressed
+	ContentTypes []string   // Media types, e.g. application/json, or type prefixes, e.g. text/
+}
+
+// Compress compresses responses with the encoding the client prefers in
+// Accept-Encoding. Responses are buffered up to MinSize to decide: smaller
+// ones, ones of other content types, partial content and responses that
+// are already encoded are sent as they are. A strong ETag gets the coding
+// appended, e.g. "3-gzip", as each coding of a representation needs its
+// own strong tag; IfMatch and NotModified accept both forms.
+func Compress(opts CompressOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), opts.Encodings)
+		if encoding == nil || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		writer := &compressWriter{ResponseWriter: c.Writer, encoding: encoding, opts: &opts}
+		c.Writer = writer
+		c.Next()
+		writer.finish()
+	}
+}
+
+// negotiateEncoding picks the supported encoding with the highest quality
+// in an Accept-Encoding header, preferring earlier encodings on ties. It
+// returns nil when the client accepts none of them.
+func negotiateEncoding(header string, encodings []Encoding) Encoding {
+	if header == "" {
+		return nil
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if name != "" {
+			qualities[name] = q
+		}
+	}
+
+	var best Encoding
+	bestQ := 0.0
+	for _, encoding := range encodings {
+		q, ok := qualities[encoding.Name()]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the start of a response until it can decide
+// whether to compress it, then writes through the encoder or directly
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding Encoding
+	opts     *CompressOptions
+	buf      []byte
+	decided  bool
+	encoder  io.WriteCloser // Nil when the response is sent uncompressed
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, data...)
+		if len(w.buf) < w.opts.MinSize {
+			return len(data), nil
+		}
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow sends the headers of a response without a body as they are
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.decided && len(w.buf) == 0 {
+		w.decided = true
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *compressWriter) Written() bool {
+	return len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+// Flush sends what was written so far. A response flushed before reaching
+// MinSize is expected to be streamed and is compressed if eligible.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide chooses whether to compress and writes the buffered start of the
+// response. streaming skips the MinSize check.
+func (w *compressWriter) decide(streaming bool) error {
+	w.decided = true
+	buf := w.buf
+	w.buf = nil
+
+	if w.compressible(len(buf), streaming) {
+		header := w.Header()
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding.Name())
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", codingTag(etag, w.encoding.Name()))
+		}
+		w.encoder = w.encoding.NewWriter(w.ResponseWriter)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// compressible reports whether the response may be compressed
+func (w *compressWriter) compressible(size int, streaming bool) bool {
+	status := w.Status()
+	header := w.Header()
+	switch {
+	case size < w.opts.MinSize && !streaming,
+		status < http.StatusOK, status == http.StatusNoContent,
+		status == http.StatusPartialContent, status == http.StatusNotModified,
+		header.Get("Content-Encoding") != "":
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, allowed := range w.opts.ContentTypes {
+		if mediaType == allowed || strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// finish sends a response that stayed below MinSize and completes the
+// compressed stream
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
+
+// resetWriter is a compressing writer that can be reused for another
+// response
+type resetWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// pooledEncoding compresses with reset writers reused across responses
+type pooledEncoding struct {
+	name string
+	pool sync.Pool
+}
+
+func newPooledEncoding(name string, newWriter func() resetWriter) *pooledEncoding {
+	e := &pooledEncoding{name: name}
+	e.pool.New = func() interface{} {
+		return newWriter()
+	}
+	return e
+}
+
+// NewGzip creates the gzip encoding at a compress/gzip level
+func NewGzip(level int) (Encoding, error) {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return nil, err
+	}
+	return newPooledEncoding("gzip", func() resetWriter {
+		writer, _ := gzip.NewWriterLevel(io.Discard, level)
+		return writer
+	}), nil
+}
+
+// brotliLevel trades ratio for speed, as responses are compressed on the fly
+const brotliLevel = 4
+
+// NewBrotli creates the br encoding
+func NewBrotli() Encoding {
+	return newPooledEncoding("br", func() resetWriter {
+		return brotli.NewWriterLevel(io.Discard, brotliLevel)
+	})
+}
+
+// NewZstd creates the zstd encoding. Each response is compressed on the
+// goroutine serving it.
+func NewZstd() Encoding {
+	return newPooledEncoding("zstd", func() resetWriter {
+		writer, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+		return writer
+	})
+}
+
+func (e *pooledEncoding) Name() string {
+	return e.name
+}
+
+func (e *pooledEncoding) NewWriter(w io.Writer) io.WriteCloser {
+	writer := e.pool.Get().(resetWriter)
+	writer.Reset(w)
+	return &pooledWriter{resetWriter: writer, pool: &e.pool}
+}
+
+// pooledWriter returns its writer to the pool once closed
+type pooledWriter struct {
+	resetWriter
+	pool *sync.Pool
+}
+
+func (w *pooledWriter) Close() error {
+	err := w.resetWriter.Close()
+	w.pool.Put(w.resetWriter)
+	return err
+}
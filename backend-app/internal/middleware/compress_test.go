@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// decoders decompress a response body by Content-Encoding
+var decoders = map[string]func(r io.Reader) (io.Reader, error){
+	"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+}
+
+// compressRouter serves body on /body, and on /stream in two flushed parts
+func compressRouter(t *testing.T, body string) *gin.Engine {
+	t.Helper()
+	gzipEncoding, err := NewGzip(gzip.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Compress(CompressOptions{
+		Encodings:    []Encoding{NewBrotli(), gzipEncoding, NewZstd()},
+		MinSize:      1024,
+		ContentTypes: []string{"text/", "application/json", "application/x-ndjson"},
+	}))
+	r.GET("/body", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(body))
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Type", "application/x-ndjson")
+		half := len(body) / 2
+		c.Writer.WriteString(body[:half])
+		c.Writer.Flush()
+		c.Writer.WriteString(body[half:])
+	})
+	return r
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	bodies := map[string]string{
+		"text": strings.Repeat("The quick brown fox jumps over the lazy dog. ", 500),
+		"json": strings.Repeat(`{"id":42,"name":"Ada Lovelace","email":"ada@example.com"},`, 2000),
+		"incompressible": func() string {
+			var b strings.Builder
+			x := uint32(2463534242)
+			for b.Len() < 64<<10 {
+				x ^= x << 13
+				x ^= x >> 17
+				x ^= x << 5
+				b.WriteByte(byte(x))
+			}
+			return b.String()
+		}(),
+	}
+
+	for name, body := range bodies {
+		r := compressRouter(t, body)
+		for encoding, decode := range decoders {
+			t.Run(name+"/"+encoding, func(t *testing.T) {
+				// Reuse pooled writers across responses
+				for i := 0; i < 3; i++ {
+					req := httptest.NewRequest(http.MethodGet, "/body", nil)
+					req.Header.Set("Accept-Encoding", encoding)
+					w := httptest.NewRecorder()
+					r.ServeHTTP(w, req)
+
+					if got := w.Header().Get("Content-Encoding"); got != encoding {
+						t.Fatalf("Content-Encoding = %q, want %q", got, encoding)
+					}
+					if w.Header().Get("Content-Length") != "" {
+						t.Error("Content-Length of the uncompressed body was kept")
+					}
+					reader, err := decode(w.Body)
+					if err != nil {
+						t.Fatal(err)
+					}
+					decoded, err := io.ReadAll(reader)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(decoded, []byte(body)) {
+						t.Fatalf("decoded %d bytes that differ from the %d sent", len(decoded), len(body))
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCompressStream(t *testing.T) {
+	body := strings.Repeat(`{"event":"tick"}`+"\n", 100)
+	r := compressRouter(t, body)
+	for encoding, decode := range decoders {
+		t.Run(encoding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			// Streamed responses are compressed below MinSize
+			if got := w.Header().Get("Content-Encoding"); got != encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, encoding)
+			}
+			reader, err := decode(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := io.ReadAll(reader)
+			if err != nil || string(decoded) != body {
+				t.Errorf("decoded %q, %v, want the streamed body", decoded, err)
+			}
+		})
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Compress(CompressOptions{Encodings: []Encoding{NewBrotli()}, MinSize: 16, ContentTypes: []string{"text/"}}))
+	long := strings.Repeat("a", 64)
+	r.GET("/small", func(c *gin.Context) { c.String(http.StatusOK, "small") })
+	r.GET("/image", func(c *gin.Context) { c.Data(http.StatusOK, "image/png", []byte(long)) })
+	r.GET("/text", func(c *gin.Context) { c.String(http.StatusOK, long) })
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+	}{
+		{"/small", "br"},
+		{"/image", "br"},
+		{"/text", ""},
+		{"/text", "gzip"},
+		{"/text", "br;q=0"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("GET %s with Accept-Encoding %q: Content-Encoding = %q, want none", tt.path, tt.acceptEncoding, got)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("GET %s: Vary = %q, want Accept-Encoding", tt.path, w.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestCompressETag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Compress(CompressOptions{Encodings: []Encoding{NewBrotli()}, MinSize: 16, ContentTypes: []string{"text/"}}))
+	long := strings.Repeat("a", 64)
+	r.GET("/strong", func(c *gin.Context) {
+		c.Header("ETag", ETag(3))
+		c.String(http.StatusOK, long)
+	})
+	r.GET("/weak", func(c *gin.Context) {
+		c.Header("ETag", `W/"3"`)
+		c.String(http.StatusOK, long)
+	})
+
+	tests := []struct {
+		path     string
+		wantETag string
+	}{
+		{"/strong", `"3-br"`},
+		{"/weak", `W/"3"`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Accept-Encoding", "br")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Header().Get("Content-Encoding") != "br" || w.Header().Get("ETag") != tt.wantETag {
+			t.Errorf("GET %s: Content-Encoding %q, ETag %s, want br and %s",
+				tt.path, w.Header().Get("Content-Encoding"), w.Header().Get("ETag"), tt.wantETag)
+		}
+	}
+}
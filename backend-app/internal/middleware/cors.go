@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnyOrigin allows cross-origin requests from every origin
+const AnyOrigin = "*"
+
+var ErrInvalidCORS = errors.New("CORS cannot allow credentials from any origin; list the origins")
+
+// CORSOptions configures which browser origins may call the API
+type CORSOptions struct {
+	AllowedOrigins   []string // Origins such as https://app.example.com, or * for any
+	AllowedMethods   []string
+	AllowedHeaders   []string      // Request headers preflights may ask for
+	ExposedHeaders   []string      // Response headers scripts may read
+	AllowCredentials bool          // Let browsers send cookies; not with *
+	MaxAge           time.Duration // How long browsers may cache a preflight
+}
+
+// CORS answers preflight requests and marks responses to allowed origins
+// as readable by them. Requests from other origins are served without CORS
+// headers, so browsers withhold the response from the calling script;
+// their preflights are rejected with 403.
+func CORS(opts CORSOptions) (gin.HandlerFunc, error) {
+	anyOrigin := slices.Contains(opts.AllowedOrigins, AnyOrigin)
+	if anyOrigin && opts.AllowCredentials {
+		return nil, ErrInvalidCORS
+	}
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	headers := strings.Join(opts.AllowedHeaders, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge / time.Second))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		if !anyOrigin {
+			header.Add("Vary", "Origin")
+		}
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !anyOrigin && !slices.Contains(opts.AllowedOrigins, origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if anyOrigin {
+			header.Set("Access-Control-Allow-Origin", AnyOrigin)
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if opts.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", methods)
+			header.Set("Access-Control-Allow-Headers", headers)
+			header.Set("Access-Control-Max-Age", maxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		if exposed != "" {
+			header.Set("Access-Control-Expose-Headers", exposed)
+		}
+		c.Next()
+	}, nil
+}
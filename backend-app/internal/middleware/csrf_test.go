@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testCSRFToken = "0123456789abcdef0123456789abcdef"
+
+var testCSRFOptions = CSRFOptions{
+	CookieName:     "csrf_token",
+	HeaderName:     "X-CSRF-Token",
+	FormField:      "csrf_token",
+	SessionCookies: []string{"session"},
+}
+
+// csrfRouter serves /resource behind CSRF protection, echoing the token
+// CSRFToken returns on GET /token
+func csrfRouter(opts CSRFOptions) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CSRF(opts))
+	r.Any("/resource", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/token", func(c *gin.Context) {
+		c.String(http.StatusOK, CSRFToken(c))
+	})
+	return r
+}
+
+func TestCSRF(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		cookies    map[string]string
+		header     http.Header
+		form       url.Values
+		wantStatus int
+	}{
+		{name: "safe method with session", method: http.MethodGet,
+			cookies: map[string]string{"session": "s"}, wantStatus: http.StatusNoContent},
+		{name: "HEAD with session", method: http.MethodHead,
+			cookies: map[string]string{"session": "s"}, wantStatus: http.StatusNoContent},
+		{name: "OPTIONS with session", method: http.MethodOptions,
+			cookies: map[string]string{"session": "s"}, wantStatus: http.StatusNoContent},
+		{name: "no cookies", method: http.MethodPost, wantStatus: http.StatusNoContent},
+		{name: "unrelated cookie", method: http.MethodPost,
+			cookies: map[string]string{"theme": "dark"}, wantStatus: http.StatusNoContent},
+
+		{name: "session without token", method: http.MethodPost,
+			cookies: map[string]string{"session": "s"}, wantStatus: http.StatusForbidden},
+		{name: "token cookie without header", method: http.MethodPost,
+			cookies: map[string]string{"csrf_token": testCSRFToken}, wantStatus: http.StatusForbidden},
+		{name: "header without token cookie", method: http.MethodPost,
+			cookies: map[string]string{"session": "s"}, header: http.Header{"X-Csrf-Token": {testCSRFToken}}, wantStatus: http.StatusForbidden},
+		{name: "mismatched header", method: http.MethodPut,
+			cookies: map[string]string{"session": "s", "csrf_token": testCSRFToken},
+			header:  http.Header{"X-Csrf-Token": {strings.Repeat("0", 32)}}, wantStatus: http.StatusForbidden},
+		{name: "empty cookie and header", method: http.MethodPost,
+			cookies: map[string]string{"session": "s", "csrf_token": ""},
+			header:  http.Header{"X-Csrf-Token": {""}}, wantStatus: http.StatusForbidden},
+		{name: "token in another format", method: http.MethodPost,
+			cookies: map[string]string{"session": "s", "csrf_token": "attacker-chosen"},
+			header:  http.Header{"X-Csrf-Token": {"attacker-chosen"}}, wantStatus: http.StatusForbidden},
+		{name: "uppercase token", method: http.MethodPost,
+			cookies: map[string]string{"session": "s", "csrf_token": strings.ToUpper(testCSRFToken)},
+			header:  http.Header{"X-Csrf-Token": {strings.ToUpper(testCSRFToken)}}, wantStatus: http.StatusForbidden},
+
+		{name: "matching header", method: http.MethodPost,
+			cookies: map[string]string{"session": "s", "csrf_token": testCSRFToken},
+			header:  http.Header{"X-Csrf-Token": {testCSRFToken}}, wantStatus: http.StatusNoContent},
+		{name: "matching header on DELETE", method: http.MethodDelete,
+			cookies: map[string]string{"session": "s", "csrf_token": testCSRFToken},
+			header:  http.Header{"X-Csrf-Token": {testCSRFToken}}, wantStatus: http.StatusNoContent},
+		{name: "matching form field", method: http.MethodPost,
+			cookies: map[string]string{"session": "s", "csrf_token": testCSRFToken},
+			form:    url.Values{"csrf_token": {testCSRFToken}}, wantStatus: http.StatusNoContent},
+		{name: "mismatched form field", method: http.MethodPost,
+			cookies: map[string]string{"session": "s", "csrf_token": testCSRFToken},
+			form:    url.Values{"csrf_token": {strings.Repeat("0", 32)}}, wantStatus: http.StatusForbidden},
+		{name: "header takes precedence over form field", method: http.MethodPost,
+			cookies: map[string]string{"session": "s", "csrf_token": testCSRFToken},
+			header:  http.Header{"X-Csrf-Token": {strings.Repeat("0", 32)}},
+			form:    url.Values{"csrf_token": {testCSRFToken}}, wantStatus: http.StatusForbidden},
+
+		{name: "bearer token exempt", method: http.MethodPost,
+			cookies: map[string]string{"session": "s"},
+			header:  http.Header{"Authorization": {"Bearer token"}}, wantStatus: http.StatusNoContent},
+		{name: "API key exempt", method: http.MethodPost,
+			cookies: map[string]string{"session": "s"},
+			header:  http.Header{APIKeyHeader: {"key"}}, wantStatus: http.StatusNoContent},
+	}
+
+	r := csrfRouter(testCSRFOptions)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.form != nil {
+				req = httptest.NewRequest(tt.method, "/resource", strings.NewReader(tt.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(tt.method, "/resource", nil)
+			}
+			for name, values := range tt.header {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			for name, value := range tt.cookies {
+				req.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestCSRFFormFieldDisabled(t *testing.T) {
+	opts := testCSRFOptions
+	opts.FormField = ""
+	r := csrfRouter(opts)
+
+	req := httptest.NewRequest(http.MethodPost, "/resource", strings.NewReader("csrf_token="+testCSRFToken))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "csrf_token", Value: testCSRFToken})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403 without a form field configured", w.Code)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		cookie    string // Empty for none
+		secure    bool
+		wantIssue bool
+	}{
+		{name: "no cookie", wantIssue: true},
+		{name: "no cookie over HTTPS", secure: true, wantIssue: true},
+		{name: "valid cookie", cookie: testCSRFToken},
+		{name: "malformed cookie", cookie: "attacker-chosen", wantIssue: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testCSRFOptions
+			opts.Secure = tt.secure
+			req := httptest.NewRequest(http.MethodGet, "/token", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "csrf_token", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			csrfRouter(opts).ServeHTTP(w, req)
+
+			token := w.Body.String()
+			if !validCSRFToken(token) {
+				t.Fatalf("CSRFToken() = %q, want a valid token", token)
+			}
+			cookies := w.Result().Cookies()
+			if !tt.wantIssue {
+				if token != tt.cookie || len(cookies) != 0 {
+					t.Errorf("CSRFToken() = %q and set %d cookies, want the existing token", token, len(cookies))
+				}
+				return
+			}
+			if token == tt.cookie || len(cookies) != 1 {
+				t.Fatalf("CSRFToken() = %q and set %d cookies, want a new token in a cookie", token, len(cookies))
+			}
+			cookie := cookies[0]
+			if cookie.Name != "csrf_token" || cookie.Value != token || cookie.Path != "/" ||
+				cookie.HttpOnly || cookie.Secure != tt.secure || cookie.SameSite != http.SameSiteLaxMode {
+				t.Errorf("cookie = %+v, want a script-readable, SameSite=Lax cookie with secure %v", cookie, tt.secure)
+			}
+		})
+	}
+
+	// Each caller gets its own token
+	first, second := httptest.NewRecorder(), httptest.NewRecorder()
+	r := csrfRouter(testCSRFOptions)
+	r.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/token", nil))
+	r.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/token", nil))
+	if first.Body.String() == second.Body.String() {
+		t.Error("two callers were issued the same token")
+	}
+}
+
+func TestCSRFTokenDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/token", func(c *gin.Context) {
+		c.String(http.StatusOK, CSRFToken(c))
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/token", nil))
+	if w.Body.String() != "" || len(w.Result().Cookies()) != 0 {
+		t.Errorf("CSRFToken() = %q without protection, want no token", w.Body)
+	}
+}
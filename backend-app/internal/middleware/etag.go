@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETag formats a resource version as a strong entity tag, e.g. "3"
+func ETag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// codingTag appends a content coding to a strong entity tag, e.g. "3" and
+// gzip give "3-gzip", for the compressed representation
+func codingTag(etag, coding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + coding + `"`
+}
+
+// baseTag strips the content coding codingTag appends, returning the tag
+// of the uncompressed representation
+func baseTag(tag string) string {
+	if i := strings.LastIndexByte(tag, '-'); i > 0 && strings.HasSuffix(tag, `"`) {
+		return tag[:i] + `"`
+	}
+	return tag
+}
+
+// IfMatch reads the resource versions accepted by the If-Match header.
+// ok is false when the header is absent. A nil slice means "*", which
+// accepts any version; weak or malformed tags never match, so a header
+// holding only those yields an empty, non-nil slice. Tags of compressed
+// representations match the version they were compressed from.
+func IfMatch(c *gin.Context) (versions []uint, ok bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		return nil, false
+	}
+
+	versions = []uint{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil, true
+		}
+		// If-Match uses the strong comparison, so weak tags are skipped
+		if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2 {
+			continue
+		}
+		tag = baseTag(tag)
+		version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 32)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, uint(version))
+	}
+	return versions, true
+}
+
+// NotModified sets the ETag header and, when the If-None-Match header
+// matches it or the tag of a compressed copy, writes a 304 Not Modified
+// carrying the matched tag. Handlers return without a body when it
+// reports true.
+func NotModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		// If-None-Match uses the weak comparison
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+		if baseTag(tag) == etag {
+			c.Header("ETag", tag)
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
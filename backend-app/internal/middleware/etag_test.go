@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []uint
+		wantOK bool
+	}{
+		{header: "", want: nil, wantOK: false},
+		{header: "*", want: nil, wantOK: true},
+		{header: `"3"`, want: []uint{3}, wantOK: true},
+		{header: `"3", "5"`, want: []uint{3, 5}, wantOK: true},
+		{header: `"3-gzip"`, want: []uint{3}, wantOK: true},
+		{header: `"3-br", "4-zstd"`, want: []uint{3, 4}, wantOK: true},
+		{header: `W/"3"`, want: []uint{}, wantOK: true},
+		{header: `"abc", 3`, want: []uint{}, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPut, "/", nil)
+		c.Request.Header.Set("If-Match", tt.header)
+		got, ok := IfMatch(c)
+		if ok != tt.wantOK || !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("IfMatch(%s) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		header   string
+		want     bool
+		wantETag string
+	}{
+		{header: "", want: false, wantETag: `"3"`},
+		{header: `"2"`, want: false, wantETag: `"3"`},
+		{header: `"3"`, want: true, wantETag: `"3"`},
+		{header: `W/"3"`, want: true, wantETag: `"3"`},
+		{header: `"2", "3"`, want: true, wantETag: `"3"`},
+		{header: "*", want: true, wantETag: `"3"`},
+		{header: `"3-gzip"`, want: true, wantETag: `"3-gzip"`},
+		{header: `"2-gzip"`, want: false, wantETag: `"3"`},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Header.Set("If-None-Match", tt.header)
+		got := NotModified(c, ETag(3))
+		if got != tt.want || w.Header().Get("ETag") != tt.wantETag {
+			t.Errorf("NotModified() with If-None-Match %s = %v, ETag %s, want %v, %s",
+				tt.header, got, w.Header().Get("ETag"), tt.want, tt.wantETag)
+		}
+	}
+}
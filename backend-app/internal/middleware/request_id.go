@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the ID that correlates a request across logs,
+// audit events and upstream proxies
+const RequestIDHeader = "X-Request-ID"
+
+// ContextRequestID is the context key of the current request's ID
+const ContextRequestID = "requestID"
+
+// maxRequestIDLength bounds request IDs accepted from clients
+const maxRequestIDLength = 64
+
+// AssignRequestID reuses a well-formed X-Request-ID from the client or a
+// proxy, or generates one, and echoes it in the response
+func AssignRequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		c.Set(ContextRequestID, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// RequestID returns the current request's ID
+func RequestID(c *gin.Context) string {
+	return c.GetString(ContextRequestID)
+}
+
+// validRequestID accepts short IDs of URL-safe characters so client values
+// cannot inject anything into logs or headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,112 @@
+package oauth
+
+import (
+	"html/template"
+	"log"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// consentPage is the server-rendered sign-in and consent screen. It posts
+// back to /oauth/authorize with the original request in hidden fields; the
+// request is validated again on submission.
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Authorize {{.ClientName}}</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #f4f5f7; margin: 0; }
+main { max-width: 420px; margin: 48px auto; background: #fff; padding: 32px; border-radius: 8px; box-shadow: 0 1px 3px rgba(0,0,0,.15); }
+h1 { font-size: 1.25rem; margin-top: 0; }
+ul { padding-left: 1.25rem; }
+label { display: block; margin-top: 12px; font-size: .9rem; }
+input { width: 100%; box-sizing: border-box; padding: 8px; margin-top: 4px; }
+.error { background: #fdecea; color: #8a1c12; padding: 8px 12px; border-radius: 4px; }
+.actions { display: flex; gap: 8px; margin-top: 20px; }
+button { flex: 1; padding: 10px; border: 0; border-radius: 4px; cursor: pointer; }
+button[value=approve] { background: #1f6feb; color: #fff; }
+</style>
+</head>
+<body>
+<main>
+{{if .Fatal}}
+<h1>Authorization failed</h1>
+<p class="error">{{.Error}}</p>
+{{else}}
+<h1>{{.ClientName}} wants to access your account</h1>
+<p>Signing in will allow it to:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="authorize">
+{{range $name, $value := .Hidden}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}
+<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+{{if .AskCode}}<label>Two-factor code <input name="code" inputmode="numeric" autocomplete="one-time-code"></label>{{end}}
+<div class="actions">
+<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+<button type="submit" name="decision" value="approve">Allow</button>
+</div>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+// consentView is the data rendered by consentPage
+type consentView struct {
+	Fatal      bool
+	Error      string
+	ClientName string
+	Scopes     []string
+	Hidden     map[string]string
+	Email      string
+	AskCode    bool
+}
+
+// newConsentView prepares the consent screen for a validated request
+func newConsentView(authz *Authorization) consentView {
+	scopes := make([]string, 0, len(authz.Scopes))
+	for _, scope := range authz.Scopes {
+		scopes = append(scopes, SupportedScopes[scope])
+	}
+	slices.Sort(scopes)
+
+	req := authz.Request
+	return consentView{
+		ClientName: authz.Client.Name,
+		Scopes:     scopes,
+		Hidden: map[string]string{
+			"response_type":         req.ResponseType,
+			"client_id":             req.ClientID,
+			"redirect_uri":          req.RedirectURI,
+			"scope":                 req.Scope,
+			"state":                 req.State,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": req.CodeChallengeMethod,
+		},
+	}
+}
+
+// renderConsent writes the consent screen. The page must never be framed
+// (clickjacking) or cached.
+func renderConsent(c *gin.Context, status int, view consentView) {
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := consentPage.Execute(c.Writer, view); err != nil {
+		log.Printf("⚠️  Failed to render consent page: %v", err)
+	}
+}
+
+// renderAuthorizeError shows an error that cannot be sent to the client
+func renderAuthorizeError(c *gin.Context, status int, message string) {
+	renderConsent(c, status, consentView{Fatal: true, Error: message})
+}
@@ -0,0 +1,140 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/auth"
+	"gorm.io/gorm"
+)
+
+// GrantIDPrefix distinguishes grant IDs from login session IDs in the "sid"
+// claim, so the auth middleware can route revocation checks
+const GrantIDPrefix = "oag_"
+
+// maxCachedGrants bounds the grant cache before expired entries are swept
+const maxCachedGrants = 10000
+
+// GrantCache answers "is this grant still active?" for the auth middleware,
+// mirroring the user module's session cache. Revocations made by this
+// process take effect immediately; others once the entry is older than ttl.
+type GrantCache struct {
+	repo OAuthRepository
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.RWMutex
+	entries map[string]grantEntry
+}
+
+type grantEntry struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// NewGrantCache creates a grant cache backed by the oauth_grants table
+func NewGrantCache(db *gorm.DB, ttl time.Duration) *GrantCache {
+	return &GrantCache{
+		repo:    NewOAuthRepository(db),
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]grantEntry),
+	}
+}
+
+// IsSessionActive implements middleware.SessionValidator for grant IDs
+func (c *GrantCache) IsSessionActive(id string) bool {
+	now := c.now()
+
+	c.mu.RLock()
+	entry, ok := c.entries[id]
+	c.mu.RUnlock()
+	if ok && now.Sub(entry.checkedAt) < c.ttl {
+		return entry.active
+	}
+
+	grant, err := c.repo.FindGrant(id)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("⚠️  Failed to look up OAuth grant %s: %v", id, err)
+		}
+		return false
+	}
+
+	active := grant.Active(now)
+	c.store(id, active, now)
+	return active
+}
+
+// Revoke marks grants as inactive in the cache
+func (c *GrantCache) Revoke(ids ...string) {
+	now := c.now()
+	for _, id := range ids {
+		c.store(id, false, now)
+	}
+}
+
+func (c *GrantCache) store(id string, active bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCachedGrants {
+		for key, entry := range c.entries {
+			if now.Sub(entry.checkedAt) >= c.ttl {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[id] = grantEntry{active: active, checkedAt: now}
+}
+
+// randomHex returns n random bytes, hex-encoded
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// newGrantID returns a random grant ID
+func newGrantID() (string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	return GrantIDPrefix + id, nil
+}
+
+// newClientID returns a random public client identifier
+func newClientID() (string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	return "cli_" + id, nil
+}
+
+// newRefreshToken returns a refresh token of the form "<grant ID>.<secret>"
+// together with the hash of the secret to store
+func newRefreshToken(grantID string) (token, hash string, err error) {
+	secret, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	return grantID + "." + secret, hash, nil
+}
+
+// parseRefreshToken splits a refresh token into its grant ID and secret hash
+func parseRefreshToken(token string) (grantID, hash string, ok bool) {
+	grantID, secret, ok := strings.Cut(token, ".")
+	if !ok || !strings.HasPrefix(grantID, GrantIDPrefix) || secret == "" {
+		return "", "", false
+	}
+	return grantID, auth.HashOpaqueToken(secret), true
+}
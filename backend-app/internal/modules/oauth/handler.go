@@ -0,0 +1,373 @@
+package oauth
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"github.com/savindaJ/backend-app/internal/modules/user"
+)
+
+// OAuthHandler handles HTTP requests for the authorization server
+type OAuthHandler struct {
+	service OAuthService
+	users   user.UserService
+}
+
+// NewOAuthHandler creates a new OAuth handler
+func NewOAuthHandler(service OAuthService, users user.UserService) *OAuthHandler {
+	return &OAuthHandler{service: service, users: users}
+}
+
+// SuccessResponse represents a success response
+type SuccessResponse struct {
+	Message string `json:"message" example:"operation successful"`
+}
+
+// RegisterClient godoc
+// @Summary      Register OAuth client
+// @Description  Register a third-party application. Confidential clients receive a client secret, which is only shown in this response.
+// @Tags         oauth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body CreateClientRequest true "Client metadata"
+// @Success      201  {object}  ClientCreatedResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /oauth/clients [post]
+func (h *OAuthHandler) RegisterClient(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	var req CreateClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid_client_metadata", ErrorDescription: err.Error()})
+		return
+	}
+
+	client, err := h.service.RegisterClient(userID, &req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, client)
+}
+
+// ListClients godoc
+// @Summary      List OAuth clients
+// @Description  List the applications registered by the current user
+// @Tags         oauth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   ClientResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /oauth/clients [get]
+func (h *OAuthHandler) ListClients(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	clients, err := h.service.ListClients(userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, clients)
+}
+
+// DeleteClient godoc
+// @Summary      Delete OAuth client
+// @Description  Delete one of the current user's applications and revoke all tokens issued to it
+// @Tags         oauth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        clientId  path      string  true  "Client ID"
+// @Success      200  {object}  SuccessResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /oauth/clients/{clientId} [delete]
+func (h *OAuthHandler) DeleteClient(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	if err := h.service.DeleteClient(userID, c.Param("clientId")); err != nil {
+		if err == ErrClientNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "not_found", ErrorDescription: err.Error()})
+			return
+		}
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Client deleted successfully"})
+}
+
+// Authorize godoc
+// @Summary      Authorization endpoint
+// @Description  Start an authorization code flow (PKCE with S256 is required). Renders the sign-in and consent screen; errors in a verified request are redirected to the client.
+// @Tags         oauth
+// @Produce      html
+// @Param        response_type          query  string  true   "Must be code"
+// @Param        client_id              query  string  true   "Client ID"
+// @Param        redirect_uri           query  string  false  "Registered redirect URI"
+// @Param        scope                  query  string  false  "Space-separated scopes"
+// @Param        state                  query  string  false  "Opaque value returned to the client"
+// @Param        code_challenge         query  string  true   "PKCE code challenge"
+// @Param        code_challenge_method  query  string  true   "Must be S256"
+// @Success      200
+// @Success      302
+// @Failure      400
+// @Router       /oauth/authorize [get]
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	var req AuthorizationRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		renderAuthorizeError(c, http.StatusBadRequest, "The authorization request is malformed.")
+		return
+	}
+
+	authz, ok := h.validate(c, &req)
+	if !ok {
+		return
+	}
+
+	renderConsent(c, http.StatusOK, newConsentView(authz))
+}
+
+// Decide godoc
+// @Summary      Submit consent
+// @Description  Sign in and approve or deny an authorization request from the consent screen. Redirects to the client with a code or an error.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      html
+// @Param        email     formData  string  true   "Email"
+// @Param        password  formData  string  true   "Password"
+// @Param        code      formData  string  false  "Two-factor code"
+// @Param        decision  formData  string  true   "approve or deny"
+// @Success      303
+// @Failure      400
+// @Failure      401
+// @Failure      429
+// @Router       /oauth/authorize [post]
+func (h *OAuthHandler) Decide(c *gin.Context) {
+	var form ConsentForm
+	if err := c.ShouldBind(&form); err != nil {
+		renderAuthorizeError(c, http.StatusBadRequest, "The authorization request is malformed.")
+		return
+	}
+
+	authz, ok := h.validate(c, &form.AuthorizationRequest)
+	if !ok {
+		return
+	}
+
+	if form.Decision != "approve" {
+		c.Redirect(http.StatusSeeOther, authz.ErrorURL(oauthError("access_denied", "the user denied the request")))
+		return
+	}
+
+	view := newConsentView(authz)
+	view.Email = form.Email
+	if form.Email == "" || form.Password == "" {
+		view.Error = "Enter your email and password."
+		renderConsent(c, http.StatusBadRequest, view)
+		return
+	}
+
+	meta := user.RequestMeta{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	account, err := h.users.Authenticate(&user.LoginRequest{Email: form.Email, Password: form.Password}, form.Code, meta)
+	if err != nil {
+		var throttled *user.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			view.Error = throttled.Error()
+			renderConsent(c, http.StatusTooManyRequests, view)
+		case err == user.ErrMFACodeRequired:
+			view.AskCode = true
+			view.Error = "Enter the code from your authenticator app or a recovery code."
+			renderConsent(c, http.StatusUnauthorized, view)
+		case err == user.ErrInvalidMFACode:
+			view.AskCode = true
+			view.Error = err.Error()
+			renderConsent(c, http.StatusUnauthorized, view)
+		case err == user.ErrInvalidCredentials:
+			view.Error = "Invalid email or password."
+			renderConsent(c, http.StatusUnauthorized, view)
+		default:
+			renderAuthorizeError(c, http.StatusInternalServerError, "Something went wrong. Please try again.")
+		}
+		return
+	}
+
+	redirect, err := h.service.Approve(authz, account.ID)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, authz.ErrorURL(oauthError("server_error", "failed to issue authorization code")))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, redirect)
+}
+
+// validate checks an authorization request, rendering or redirecting the
+// error when it is invalid
+func (h *OAuthHandler) validate(c *gin.Context, req *AuthorizationRequest) (*Authorization, bool) {
+	authz, err := h.service.ValidateAuthorization(req)
+	if err == nil {
+		return authz, true
+	}
+
+	var oauthErr *Error
+	switch {
+	case !errors.As(err, &oauthErr):
+		renderAuthorizeError(c, http.StatusInternalServerError, "Something went wrong. Please try again.")
+	case authz == nil:
+		renderAuthorizeError(c, http.StatusBadRequest, oauthErr.Description)
+	default:
+		c.Redirect(http.StatusFound, authz.ErrorURL(oauthErr))
+	}
+	return nil, false
+}
+
+// Token godoc
+// @Summary      Token endpoint
+// @Description  Exchange an authorization code (with code_verifier), a refresh token or client credentials for an access token. Clients authenticate with HTTP Basic or client_id/client_secret form fields; public clients send client_id only.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        grant_type     formData  string  true   "authorization_code, refresh_token or client_credentials"
+// @Param        code           formData  string  false  "Authorization code"
+// @Param        redirect_uri   formData  string  false  "Redirect URI sent to the authorization endpoint"
+// @Param        code_verifier  formData  string  false  "PKCE code verifier"
+// @Param        refresh_token  formData  string  false  "Refresh token"
+// @Param        scope          formData  string  false  "Space-separated scopes"
+// @Param        client_id      formData  string  false  "Client ID"
+// @Param        client_secret  formData  string  false  "Client secret"
+// @Success      200  {object}  TokenResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /oauth/token [post]
+func (h *OAuthHandler) Token(c *gin.Context) {
+	noStore(c)
+
+	var req TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(c)
+	response, err := h.service.Token(clientID, clientSecret, &req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Introspect godoc
+// @Summary      Token introspection
+// @Description  Report whether an access or refresh token issued to the calling client is active (RFC 7662). Requires a confidential client.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        token          formData  string  true   "Token to introspect"
+// @Param        client_id      formData  string  false  "Client ID"
+// @Param        client_secret  formData  string  false  "Client secret"
+// @Success      200  {object}  IntrospectionResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /oauth/introspect [post]
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	noStore(c)
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid_request", ErrorDescription: "token is required"})
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(c)
+	response, err := h.service.Introspect(clientID, clientSecret, token)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Revoke godoc
+// @Summary      Token revocation
+// @Description  Revoke an access or refresh token and every token of the same grant (RFC 7009). Unknown tokens are ignored.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        token          formData  string  true   "Token to revoke"
+// @Param        client_id      formData  string  false  "Client ID"
+// @Param        client_secret  formData  string  false  "Client secret"
+// @Success      200
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /oauth/revoke [post]
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	noStore(c)
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid_request", ErrorDescription: "token is required"})
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(c)
+	if err := h.service.Revoke(clientID, clientSecret, token); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// clientCredentials reads client authentication from HTTP Basic, whose
+// values are form-encoded (RFC 6749 section 2.3.1), or from the request body
+func clientCredentials(c *gin.Context) (string, string) {
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		decodedID, errID := url.QueryUnescape(id)
+		decodedSecret, errSecret := url.QueryUnescape(secret)
+		if errID == nil && errSecret == nil {
+			return decodedID, decodedSecret
+		}
+		return id, secret
+	}
+	return c.PostForm("client_id"), c.PostForm("client_secret")
+}
+
+// noStore marks a response as containing credentials
+func noStore(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+}
+
+// respondError writes an OAuth error response
+func respondError(c *gin.Context, err error) {
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "server_error"})
+		return
+	}
+
+	if oauthErr.Status() == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	c.JSON(oauthErr.Status(), ErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+}
@@ -0,0 +1,209 @@
+package oauth
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/modules/user"
+)
+
+// Grant types
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// SupportedScopes lists the scopes clients may request, with the
+// description shown on the consent screen
+var SupportedScopes = map[string]string{
+	user.ScopeUsersRead:  "View user profiles",
+	user.ScopeUsersWrite: "Update and delete users on your behalf",
+}
+
+// Client is a registered third-party application
+type Client struct {
+	ID           uint   `gorm:"primaryKey"`
+	ClientID     string `gorm:"size:40;uniqueIndex;not null"`
+	SecretHash   string `gorm:"size:64"` // Empty for public clients
+	Name         string `gorm:"size:100;not null"`
+	OwnerID      uint   `gorm:"index;not null"`     // User who registered the client
+	RedirectURIs string `gorm:"type:text;not null"` // Newline-separated, matched exactly
+	Scopes       string `gorm:"size:255;not null"`  // Space-separated scopes the client may request
+	GrantTypes   string `gorm:"size:100;not null"`  // Space-separated
+	Public       bool   `gorm:"not null;default:false"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// TableName overrides the table name
+func (Client) TableName() string {
+	return "oauth_clients"
+}
+
+// RedirectURIList returns the registered redirect URIs
+func (c *Client) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+// ScopeList returns the scopes the client may request
+func (c *Client) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// GrantTypeList returns the grant types the client may use
+func (c *Client) GrantTypeList() []string {
+	return strings.Fields(c.GrantTypes)
+}
+
+// AllowsGrant reports whether the client may use a grant type
+func (c *Client) AllowsGrant(grantType string) bool {
+	return slices.Contains(c.GrantTypeList(), grantType)
+}
+
+// ToResponse converts Client to ClientResponse
+func (c *Client) ToResponse() ClientResponse {
+	return ClientResponse{
+		ClientID:     c.ClientID,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectURIList(),
+		Scopes:       c.ScopeList(),
+		GrantTypes:   c.GrantTypeList(),
+		Public:       c.Public,
+		CreatedAt:    c.CreatedAt,
+	}
+}
+
+// AuthorizationCode is an issued authorization code. Codes are single use:
+// redeeming one twice revokes the grant issued for it.
+type AuthorizationCode struct {
+	CodeHash      string `gorm:"primaryKey;size:64"`
+	ClientID      string `gorm:"size:40;index;not null"`
+	UserID        uint   `gorm:"not null"`
+	RedirectURI   string `gorm:"type:text"` // As sent to /authorize, which /token must repeat
+	Scopes        string `gorm:"size:255;not null"`
+	CodeChallenge string `gorm:"size:128;not null"`
+	GrantID       string `gorm:"size:36"`
+	UsedAt        *time.Time
+	ExpiresAt     time.Time `gorm:"index;not null"`
+	CreatedAt     time.Time
+}
+
+// TableName overrides the table name
+func (AuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+// Grant is an authorization given to a client. Every token issued for it
+// carries the grant ID, so revoking the grant revokes them all.
+type Grant struct {
+	ID               string `gorm:"primaryKey;size:36"`
+	ClientID         string `gorm:"size:40;index;not null"`
+	UserID           uint   `gorm:"index;not null"` // Resource owner; the client owner for client credentials
+	GrantType        string `gorm:"size:32;not null"`
+	Scopes           string `gorm:"size:255;not null"`
+	RefreshTokenHash string `gorm:"size:64"` // Empty when no refresh token was issued
+	CreatedAt        time.Time
+	LastUsedAt       time.Time  `gorm:"not null"`
+	ExpiresAt        time.Time  `gorm:"index;not null"`
+	RevokedAt        *time.Time `gorm:"index"`
+}
+
+// TableName overrides the table name
+func (Grant) TableName() string {
+	return "oauth_grants"
+}
+
+// Active reports whether the grant is neither revoked nor expired
+func (g *Grant) Active(now time.Time) bool {
+	return g.RevokedAt == nil && now.Before(g.ExpiresAt)
+}
+
+// ScopeList returns the granted scopes
+func (g *Grant) ScopeList() []string {
+	return strings.Fields(g.Scopes)
+}
+
+// CreateClientRequest represents the request body for registering a client
+type CreateClientRequest struct {
+	Name         string   `json:"name" binding:"required,max=100" example:"Partner Dashboard"`
+	RedirectURIs []string `json:"redirect_uris" binding:"omitempty,dive,url" example:"https://partner.example.com/callback"`
+	Scopes       []string `json:"scopes" binding:"required,min=1,dive,oneof=users:read users:write" example:"users:read"`
+	GrantTypes   []string `json:"grant_types" binding:"required,min=1,dive,oneof=authorization_code client_credentials" example:"authorization_code"`
+	Public       bool     `json:"public" example:"false"` // Clients that cannot keep a secret, e.g. SPAs and mobile apps
+}
+
+// ClientResponse represents a registered client without its secret
+type ClientResponse struct {
+	ClientID     string    `json:"client_id" example:"cli_3f9a1c0b2e7d4e5a6f80"`
+	Name         string    `json:"name" example:"Partner Dashboard"`
+	RedirectURIs []string  `json:"redirect_uris" example:"https://partner.example.com/callback"`
+	Scopes       []string  `json:"scopes" example:"users:read"`
+	GrantTypes   []string  `json:"grant_types" example:"authorization_code"`
+	Public       bool      `json:"public" example:"false"`
+	CreatedAt    time.Time `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+// ClientCreatedResponse includes the client secret, which is only shown once
+type ClientCreatedResponse struct {
+	ClientResponse
+	ClientSecret string `json:"client_secret,omitempty" example:"Qm9vbGVhbiBzZWNyZXQgdmFsdWU"`
+}
+
+// AuthorizationRequest holds the parameters of an authorization request
+type AuthorizationRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+// ConsentForm is submitted from the consent screen: the authorization
+// request, the user's credentials and their decision
+type ConsentForm struct {
+	AuthorizationRequest
+	Email    string `form:"email"`
+	Password string `form:"password"`
+	Code     string `form:"code"`     // Two-factor code, when enabled
+	Decision string `form:"decision"` // "approve" or "deny"
+}
+
+// TokenRequest holds the parameters of a token request
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+}
+
+// TokenResponse represents a successful token response (RFC 6749 section 5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token" example:"eyJhbGciOiJIUzI1NiIs..."`
+	TokenType    string `json:"token_type" example:"Bearer"`
+	ExpiresIn    int    `json:"expires_in" example:"3600"`
+	RefreshToken string `json:"refresh_token,omitempty" example:"oag_3f9a...e1.Qm9vbGVhbg"`
+	Scope        string `json:"scope" example:"users:read"`
+}
+
+// IntrospectionResponse represents a token introspection result (RFC 7662)
+type IntrospectionResponse struct {
+	Active    bool   `json:"active" example:"true"`
+	Scope     string `json:"scope,omitempty" example:"users:read"`
+	ClientID  string `json:"client_id,omitempty" example:"cli_3f9a1c0b2e7d4e5a6f80"`
+	Subject   string `json:"sub,omitempty" example:"1"`
+	TokenType string `json:"token_type,omitempty" example:"access_token"`
+	ExpiresAt int64  `json:"exp,omitempty" example:"1704070800"`
+	IssuedAt  int64  `json:"iat,omitempty" example:"1704067200"`
+}
+
+// ErrorResponse represents an OAuth error response (RFC 6749 section 5.2)
+type ErrorResponse struct {
+	Error            string `json:"error" example:"invalid_grant"`
+	ErrorDescription string `json:"error_description,omitempty" example:"authorization code is invalid or expired"`
+}
@@ -0,0 +1,216 @@
+package oauth
+
+import (
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/modules/user"
+	"gorm.io/gorm"
+)
+
+// OAuthRepository interface defines the contract for OAuth data access
+type OAuthRepository interface {
+	CreateClient(client *Client) error
+	FindClient(clientID string) (*Client, error)
+	FindClientsByOwner(ownerID uint) ([]Client, error)
+	DeleteClient(ownerID uint, clientID string) (bool, error)
+	CreateCode(code *AuthorizationCode) error
+	RedeemCode(codeHash string, now time.Time) (*AuthorizationCode, bool, error)
+	SetCodeGrant(codeHash, grantID string) error
+	CreateGrant(grant *Grant) error
+	FindGrant(id string) (*Grant, error)
+	RotateGrantToken(id, oldHash, newHash string, now, expiresAt time.Time) (bool, error)
+	RevokeGrant(id string, now time.Time) error
+	RevokeClientGrants(clientID string, now time.Time) ([]string, error)
+}
+
+// oauthRepository implements OAuthRepository using GORM
+type oauthRepository struct {
+	db *gorm.DB
+}
+
+// NewOAuthRepository creates a new OAuth repository
+func NewOAuthRepository(db *gorm.DB) OAuthRepository {
+	return &oauthRepository{db: db}
+}
+
+// CreateClient stores a new client
+func (r *oauthRepository) CreateClient(client *Client) error {
+	return r.db.Create(client).Error
+}
+
+// FindClient finds a client by its public client ID
+func (r *oauthRepository) FindClient(clientID string) (*Client, error) {
+	var client Client
+	if err := r.db.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// FindClientsByOwner lists the clients registered by a user
+func (r *oauthRepository) FindClientsByOwner(ownerID uint) ([]Client, error) {
+	var clients []Client
+	err := r.db.Where("owner_id = ?", ownerID).Order("created_at DESC").Find(&clients).Error
+	return clients, err
+}
+
+// DeleteClient deletes one of a user's clients, reporting whether it existed
+func (r *oauthRepository) DeleteClient(ownerID uint, clientID string) (bool, error) {
+	result := r.db.Where("owner_id = ? AND client_id = ?", ownerID, clientID).Delete(&Client{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CreateCode stores an authorization code and prunes expired ones
+func (r *oauthRepository) CreateCode(code *AuthorizationCode) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&AuthorizationCode{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(code).Error
+}
+
+// RedeemCode marks an authorization code as used. It reports false, with
+// the code, when the code had already been used.
+func (r *oauthRepository) RedeemCode(codeHash string, now time.Time) (*AuthorizationCode, bool, error) {
+	var code AuthorizationCode
+	if err := r.db.Where("code_hash = ?", codeHash).First(&code).Error; err != nil {
+		return nil, false, err
+	}
+
+	result := r.db.Model(&AuthorizationCode{}).
+		Where("code_hash = ? AND used_at IS NULL", codeHash).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Reload to see the grant issued by the first redemption
+		if err := r.db.Where("code_hash = ?", codeHash).First(&code).Error; err != nil {
+			return nil, false, err
+		}
+		return &code, false, nil
+	}
+	return &code, true, nil
+}
+
+// SetCodeGrant records the grant issued for an authorization code
+func (r *oauthRepository) SetCodeGrant(codeHash, grantID string) error {
+	return r.db.Model(&AuthorizationCode{}).Where("code_hash = ?", codeHash).Update("grant_id", grantID).Error
+}
+
+// CreateGrant stores a new grant
+func (r *oauthRepository) CreateGrant(grant *Grant) error {
+	return r.db.Create(grant).Error
+}
+
+// FindGrant finds a grant by ID
+func (r *oauthRepository) FindGrant(id string) (*Grant, error) {
+	var grant Grant
+	if err := r.db.Where("id = ?", id).First(&grant).Error; err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// RotateGrantToken swaps the refresh token hash of an active grant. It
+// reports false when oldHash is no longer current.
+func (r *oauthRepository) RotateGrantToken(id, oldHash, newHash string, now, expiresAt time.Time) (bool, error) {
+	result := r.db.Model(&Grant{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", id, oldHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash": newHash,
+			"last_used_at":       now,
+			"expires_at":         expiresAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// RevokeGrant revokes a grant
+func (r *oauthRepository) RevokeGrant(id string, now time.Time) error {
+	return r.db.Model(&Grant{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", now).Error
+}
+
+// RevokeClientGrants revokes all active grants of a client and returns their IDs
+func (r *oauthRepository) RevokeClientGrants(clientID string, now time.Time) ([]string, error) {
+	var ids []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Grant{}).
+			Where("client_id = ? AND revoked_at IS NULL", clientID).
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return tx.Model(&Grant{}).Where("id IN ?", ids).Update("revoked_at", now).Error
+	})
+	return ids, err
+}
+
+// PurgeUserData removes the clients, grants and authorization codes of purged
+// users, including everything issued to their clients. It runs as a user
+// purge hook inside the purge transaction.
+func PurgeUserData(tx *gorm.DB, userIDs []uint) error {
+	clientIDs := tx.Model(&Client{}).Select("client_id").Where("owner_id IN ?", userIDs)
+
+	if err := tx.Where("user_id IN ? OR client_id IN (?)", userIDs, clientIDs).Delete(&AuthorizationCode{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id IN ? OR client_id IN (?)", userIDs, clientIDs).Delete(&Grant{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("owner_id IN ?", userIDs).Delete(&Client{}).Error
+}
+
+// exportedGrant describes an authorization a user gave, without token hashes
+type exportedGrant struct {
+	ClientID   string     `json:"client_id"`
+	GrantType  string     `json:"grant_type"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// ExportUserData returns the export source for the clients a user registered
+// and the authorizations they gave to clients
+func ExportUserData(db *gorm.DB) user.ExportSource {
+	return func(userID uint) (map[string]interface{}, error) {
+		var clients []Client
+		if err := db.Where("owner_id = ?", userID).Order("id").Find(&clients).Error; err != nil {
+			return nil, err
+		}
+		exportedClients := make([]ClientResponse, len(clients))
+		for i := range clients {
+			exportedClients[i] = clients[i].ToResponse()
+		}
+
+		var grants []Grant
+		if err := db.Where("user_id = ?", userID).Order("created_at").Find(&grants).Error; err != nil {
+			return nil, err
+		}
+		exportedGrants := make([]exportedGrant, len(grants))
+		for i, grant := range grants {
+			exportedGrants[i] = exportedGrant{
+				ClientID:   grant.ClientID,
+				GrantType:  grant.GrantType,
+				Scopes:     grant.ScopeList(),
+				CreatedAt:  grant.CreatedAt,
+				LastUsedAt: grant.LastUsedAt,
+				ExpiresAt:  grant.ExpiresAt,
+				RevokedAt:  grant.RevokedAt,
+			}
+		}
+
+		return map[string]interface{}{
+			"oauth_clients.json": exportedClients,
+			"oauth_grants.json":  exportedGrants,
+		}, nil
+	}
+}
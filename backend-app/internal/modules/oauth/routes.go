@@ -0,0 +1,36 @@
+package oauth
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"github.com/savindaJ/backend-app/internal/modules/user"
+	"gorm.io/gorm"
+)
+
+// RegisterRoutes registers the authorization server routes
+func RegisterRoutes(router *gin.RouterGroup, db *gorm.DB, cfg *config.Config, tokens *auth.TokenManager, users user.UserService, grants *GrantCache, requireAuth gin.HandlerFunc) {
+	// Initialize dependencies
+	repo := NewOAuthRepository(db)
+	service := NewOAuthService(repo, tokens, users, grants, cfg, audit.NewRecorder(db, cfg.JWTSecret))
+	handler := NewOAuthHandler(service, users)
+
+	// OAuth routes
+	oauth := router.Group("/oauth")
+	{
+		// Authorization server endpoints
+		oauth.GET("/authorize", handler.Authorize)
+		oauth.POST("/authorize", handler.Decide)
+		oauth.POST("/token", handler.Token)
+		oauth.POST("/introspect", handler.Introspect)
+		oauth.POST("/revoke", handler.Revoke)
+
+		// Client registration requires an interactive login
+		clients := oauth.Group("/clients", requireAuth, middleware.RequireFirstParty())
+		clients.POST("", handler.RegisterClient)
+		clients.GET("", handler.ListClients)
+		clients.DELETE("/:clientId", handler.DeleteClient)
+	}
+}
@@ -0,0 +1,688 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/modules/user"
+	"gorm.io/gorm"
+)
+
+var ErrClientNotFound = errors.New("client not found")
+
+// Error is an OAuth protocol error (RFC 6749 sections 4.1.2.1 and 5.2)
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// Status returns the HTTP status used when the error is returned as JSON
+func (e *Error) Status() int {
+	switch e.Code {
+	case "invalid_client":
+		return http.StatusUnauthorized
+	case "server_error":
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func oauthError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+var (
+	errInvalidClient = oauthError("invalid_client", "client authentication failed")
+	errInvalidGrant  = oauthError("invalid_grant", "authorization grant is invalid, expired or revoked")
+)
+
+// Authorization is a validated authorization request
+type Authorization struct {
+	Client      *Client
+	RedirectURI string // Registered URI the response is sent to
+	Scopes      []string
+	Request     AuthorizationRequest
+}
+
+// RedirectURL returns the client redirect URI with response parameters and the request state
+func (a *Authorization) RedirectURL(params url.Values) string {
+	target, _ := url.Parse(a.RedirectURI)
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if a.Request.State != "" {
+		query.Set("state", a.Request.State)
+	}
+	target.RawQuery = query.Encode()
+	return target.String()
+}
+
+// ErrorURL returns the redirect that reports err to the client
+func (a *Authorization) ErrorURL(err *Error) string {
+	return a.RedirectURL(url.Values{"error": {err.Code}, "error_description": {err.Description}})
+}
+
+// OAuthService interface defines the contract for the authorization server
+type OAuthService interface {
+	RegisterClient(ownerID uint, req *CreateClientRequest) (*ClientCreatedResponse, error)
+	ListClients(ownerID uint) ([]ClientResponse, error)
+	DeleteClient(ownerID uint, clientID string) error
+	ValidateAuthorization(req *AuthorizationRequest) (*Authorization, error)
+	Approve(authz *Authorization, userID uint) (string, error)
+	Token(clientID, clientSecret string, req *TokenRequest) (*TokenResponse, error)
+	Introspect(clientID, clientSecret, token string) (*IntrospectionResponse, error)
+	Revoke(clientID, clientSecret, token string) error
+}
+
+// oauthService implements OAuthService
+type oauthService struct {
+	repo    OAuthRepository
+	tokens  *auth.TokenManager
+	users   user.UserService
+	grants  *GrantCache
+	cfg     *config.Config
+	auditor audit.Recorder
+}
+
+// NewOAuthService creates a new OAuth service
+func NewOAuthService(repo OAuthRepository, tokens *auth.TokenManager, users user.UserService, grants *GrantCache, cfg *config.Config, auditor audit.Recorder) OAuthService {
+	return &oauthService{
+		repo:    repo,
+		tokens:  tokens,
+		users:   users,
+		grants:  grants,
+		cfg:     cfg,
+		auditor: auditor,
+	}
+}
+
+// RegisterClient registers a third-party application. Confidential clients
+// receive a secret, which is only returned here.
+func (s *oauthService) RegisterClient(ownerID uint, req *CreateClientRequest) (*ClientCreatedResponse, error) {
+	grantTypes := normalizeList(req.GrantTypes)
+	if slices.Contains(grantTypes, GrantAuthorizationCode) && len(req.RedirectURIs) == 0 {
+		return nil, oauthError("invalid_redirect_uri", "the authorization_code grant requires at least one redirect URI")
+	}
+	if req.Public && slices.Contains(grantTypes, GrantClientCredentials) {
+		return nil, oauthError("invalid_client_metadata", "public clients cannot use the client_credentials grant")
+	}
+	for _, uri := range req.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return nil, err
+		}
+	}
+
+	clientID, err := newClientID()
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		ClientID:     clientID,
+		Name:         strings.TrimSpace(req.Name),
+		OwnerID:      ownerID,
+		RedirectURIs: strings.Join(req.RedirectURIs, "\n"),
+		Scopes:       strings.Join(normalizeList(req.Scopes), " "),
+		GrantTypes:   strings.Join(grantTypes, " "),
+		Public:       req.Public,
+	}
+
+	var secret string
+	if !client.Public {
+		secret, client.SecretHash, err = auth.GenerateOpaqueToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.CreateClient(client); err != nil {
+		return nil, err
+	}
+
+	s.record(&audit.Event{
+		Action:     "oauth.client_registered",
+		ActorID:    &ownerID,
+		TargetType: "oauth_client",
+		TargetID:   client.ClientID,
+	})
+	return &ClientCreatedResponse{ClientResponse: client.ToResponse(), ClientSecret: secret}, nil
+}
+
+// ListClients lists the clients registered by a user
+func (s *oauthService) ListClients(ownerID uint) ([]ClientResponse, error) {
+	clients, err := s.repo.FindClientsByOwner(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]ClientResponse, len(clients))
+	for i, client := range clients {
+		responses[i] = client.ToResponse()
+	}
+	return responses, nil
+}
+
+// DeleteClient deletes a client and revokes every grant issued to it
+func (s *oauthService) DeleteClient(ownerID uint, clientID string) error {
+	deleted, err := s.repo.DeleteClient(ownerID, clientID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrClientNotFound
+	}
+
+	revoked, err := s.repo.RevokeClientGrants(clientID, time.Now())
+	if err != nil {
+		return err
+	}
+	s.grants.Revoke(revoked...)
+
+	s.record(&audit.Event{
+		Action:     "oauth.client_deleted",
+		ActorID:    &ownerID,
+		TargetType: "oauth_client",
+		TargetID:   clientID,
+	})
+	return nil
+}
+
+// ValidateAuthorization checks an authorization request. When the client
+// or redirect URI cannot be verified it returns no Authorization, and the
+// error must be shown to the user rather than sent to the redirect URI.
+func (s *oauthService) ValidateAuthorization(req *AuthorizationRequest) (*Authorization, error) {
+	client, err := s.repo.FindClient(req.ClientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, oauthError("invalid_client", "unknown client_id")
+		}
+		return nil, err
+	}
+
+	registered := client.RedirectURIList()
+	redirectURI := req.RedirectURI
+	if redirectURI == "" && len(registered) == 1 {
+		redirectURI = registered[0]
+	}
+	if !slices.Contains(registered, redirectURI) {
+		return nil, oauthError("invalid_request", "redirect_uri is not registered for this client")
+	}
+
+	authz := &Authorization{Client: client, RedirectURI: redirectURI, Request: *req}
+	if req.ResponseType != "code" {
+		return authz, oauthError("unsupported_response_type", "only response_type=code is supported")
+	}
+	if !client.AllowsGrant(GrantAuthorizationCode) {
+		return authz, oauthError("unauthorized_client", "client may not use the authorization code grant")
+	}
+	if req.CodeChallengeMethod != "S256" || !validPKCEValue(req.CodeChallenge) {
+		return authz, oauthError("invalid_request", "PKCE with code_challenge_method=S256 is required")
+	}
+
+	authz.Scopes, err = resolveScopes(req.Scope, client.ScopeList())
+	if err != nil {
+		return authz, err
+	}
+	return authz, nil
+}
+
+// Approve issues an authorization code after the user consented and
+// returns the redirect that delivers it to the client
+func (s *oauthService) Approve(authz *Authorization, userID uint) (string, error) {
+	code, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := s.repo.CreateCode(&AuthorizationCode{
+		CodeHash:      hash,
+		ClientID:      authz.Client.ClientID,
+		UserID:        userID,
+		RedirectURI:   authz.Request.RedirectURI,
+		Scopes:        strings.Join(authz.Scopes, " "),
+		CodeChallenge: authz.Request.CodeChallenge,
+		ExpiresAt:     now.Add(s.cfg.OAuthCodeTTL),
+	}); err != nil {
+		return "", err
+	}
+
+	s.record((&audit.Event{
+		Action:     "oauth.consent_granted",
+		ActorID:    &userID,
+		TargetType: "oauth_client",
+		TargetID:   authz.Client.ClientID,
+	}).WithMetadata(map[string]interface{}{"scopes": authz.Scopes}))
+
+	return authz.RedirectURL(url.Values{"code": {code}}), nil
+}
+
+// Token implements the token endpoint for all supported grant types
+func (s *oauthService) Token(clientID, clientSecret string, req *TokenRequest) (*TokenResponse, error) {
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		client, err := s.authenticateClient(clientID, clientSecret, false)
+		if err != nil {
+			return nil, err
+		}
+		return s.exchangeCode(client, req)
+	case GrantRefreshToken:
+		client, err := s.authenticateClient(clientID, clientSecret, false)
+		if err != nil {
+			return nil, err
+		}
+		return s.refresh(client, req)
+	case GrantClientCredentials:
+		client, err := s.authenticateClient(clientID, clientSecret, true)
+		if err != nil {
+			return nil, err
+		}
+		return s.clientCredentials(client, req)
+	case "":
+		return nil, oauthError("invalid_request", "grant_type is required")
+	default:
+		return nil, oauthError("unsupported_grant_type", "grant type "+req.GrantType+" is not supported")
+	}
+}
+
+// exchangeCode redeems an authorization code. A code redeemed twice was
+// intercepted, so the grant issued on first use is revoked.
+func (s *oauthService) exchangeCode(client *Client, req *TokenRequest) (*TokenResponse, error) {
+	if !client.AllowsGrant(GrantAuthorizationCode) {
+		return nil, oauthError("unauthorized_client", "client may not use the authorization code grant")
+	}
+
+	now := time.Now()
+	codeHash := auth.HashOpaqueToken(req.Code)
+	code, fresh, err := s.repo.RedeemCode(codeHash, now)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidGrant
+		}
+		return nil, err
+	}
+	if !fresh {
+		if code.GrantID != "" {
+			if err := s.repo.RevokeGrant(code.GrantID, now); err != nil {
+				return nil, err
+			}
+			s.grants.Revoke(code.GrantID)
+		}
+		s.record(&audit.Event{
+			Action:     "oauth.code_reused",
+			TargetType: "oauth_client",
+			TargetID:   client.ClientID,
+		})
+		return nil, errInvalidGrant
+	}
+
+	if code.ClientID != client.ClientID || now.After(code.ExpiresAt) || code.RedirectURI != req.RedirectURI {
+		return nil, errInvalidGrant
+	}
+	if !validPKCEValue(req.CodeVerifier) || subtle.ConstantTimeCompare([]byte(s256(req.CodeVerifier)), []byte(code.CodeChallenge)) != 1 {
+		return nil, oauthError("invalid_grant", "code_verifier does not match the code challenge")
+	}
+
+	owner, err := s.resourceOwner(code.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	grantID, err := newGrantID()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshHash, err := newRefreshToken(grantID)
+	if err != nil {
+		return nil, err
+	}
+	grant := &Grant{
+		ID:               grantID,
+		ClientID:         client.ClientID,
+		UserID:           owner.ID,
+		GrantType:        GrantAuthorizationCode,
+		Scopes:           code.Scopes,
+		RefreshTokenHash: refreshHash,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(s.cfg.OAuthRefreshTokenTTL),
+	}
+	if err := s.repo.CreateGrant(grant); err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetCodeGrant(codeHash, grantID); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(grant, owner.Role, grant.ScopeList(), refreshToken)
+}
+
+// refresh rotates a refresh token. Presenting an already rotated refresh
+// token revokes the grant, as it must have leaked.
+func (s *oauthService) refresh(client *Client, req *TokenRequest) (*TokenResponse, error) {
+	grantID, hash, ok := parseRefreshToken(req.RefreshToken)
+	if !ok {
+		return nil, errInvalidGrant
+	}
+
+	grant, err := s.repo.FindGrant(grantID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidGrant
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if grant.ClientID != client.ClientID || grant.RefreshTokenHash == "" || !grant.Active(now) {
+		return nil, errInvalidGrant
+	}
+
+	scopes, err := resolveScopes(req.Scope, grant.ScopeList())
+	if err != nil {
+		return nil, err
+	}
+
+	newToken, newHash, err := newRefreshToken(grantID)
+	if err != nil {
+		return nil, err
+	}
+	rotated := false
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(grant.RefreshTokenHash)) == 1 {
+		rotated, err = s.repo.RotateGrantToken(grantID, hash, newHash, now, now.Add(s.cfg.OAuthRefreshTokenTTL))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !rotated {
+		if err := s.repo.RevokeGrant(grantID, now); err != nil {
+			return nil, err
+		}
+		s.grants.Revoke(grantID)
+		s.record(&audit.Event{
+			Action:     "oauth.refresh_token_reused",
+			TargetType: "oauth_grant",
+			TargetID:   grantID,
+		})
+		return nil, errInvalidGrant
+	}
+
+	owner, err := s.resourceOwner(grant.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokens(grant, owner.Role, scopes, newToken)
+}
+
+// clientCredentials issues a token that acts as the client's owner, limited
+// to the requested scopes. No refresh token is issued.
+func (s *oauthService) clientCredentials(client *Client, req *TokenRequest) (*TokenResponse, error) {
+	if !client.AllowsGrant(GrantClientCredentials) {
+		return nil, oauthError("unauthorized_client", "client may not use the client credentials grant")
+	}
+
+	scopes, err := resolveScopes(req.Scope, client.ScopeList())
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.resourceOwner(client.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	grantID, err := newGrantID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	grant := &Grant{
+		ID:         grantID,
+		ClientID:   client.ClientID,
+		UserID:     owner.ID,
+		GrantType:  GrantClientCredentials,
+		Scopes:     strings.Join(scopes, " "),
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.cfg.OAuthAccessTokenTTL),
+	}
+	if err := s.repo.CreateGrant(grant); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(grant, owner.Role, scopes, "")
+}
+
+// issueTokens issues an access token bound to a grant
+func (s *oauthService) issueTokens(grant *Grant, role string, scopes []string, refreshToken string) (*TokenResponse, error) {
+	token, _, err := s.tokens.Issue(auth.TokenSpec{
+		UserID:    grant.UserID,
+		Role:      role,
+		Purpose:   auth.PurposeAccess,
+		SessionID: grant.ID,
+		ClientID:  grant.ClientID,
+		Scopes:    scopes,
+	}, s.cfg.OAuthAccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.cfg.OAuthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// Introspect reports whether a token issued to the calling client is
+// active. Tokens of other clients are reported as inactive.
+func (s *oauthService) Introspect(clientID, clientSecret, token string) (*IntrospectionResponse, error) {
+	client, err := s.authenticateClient(clientID, clientSecret, true)
+	if err != nil {
+		return nil, err
+	}
+	inactive := &IntrospectionResponse{Active: false}
+	now := time.Now()
+
+	if claims, err := s.tokens.Parse(token, auth.PurposeAccess); err == nil {
+		if claims.ClientID != client.ClientID || !s.grants.IsSessionActive(claims.SessionID) {
+			return inactive, nil
+		}
+		return &IntrospectionResponse{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "access_token",
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+		}, nil
+	}
+
+	grant, ok, err := s.findRefreshGrant(token)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || grant.ClientID != client.ClientID || !grant.Active(now) {
+		return inactive, nil
+	}
+	return &IntrospectionResponse{
+		Active:    true,
+		Scope:     grant.Scopes,
+		ClientID:  grant.ClientID,
+		Subject:   strconv.FormatUint(uint64(grant.UserID), 10),
+		TokenType: "refresh_token",
+		ExpiresAt: grant.ExpiresAt.Unix(),
+		IssuedAt:  grant.LastUsedAt.Unix(),
+	}, nil
+}
+
+// Revoke revokes the grant behind an access or refresh token (RFC 7009).
+// Unknown tokens and tokens of other clients are ignored.
+func (s *oauthService) Revoke(clientID, clientSecret, token string) error {
+	client, err := s.authenticateClient(clientID, clientSecret, false)
+	if err != nil {
+		return err
+	}
+
+	var grantID string
+	if claims, err := s.tokens.Parse(token, auth.PurposeAccess); err == nil {
+		if claims.ClientID != client.ClientID {
+			return nil
+		}
+		grantID = claims.SessionID
+	} else {
+		grant, ok, err := s.findRefreshGrant(token)
+		if err != nil {
+			return err
+		}
+		if !ok || grant.ClientID != client.ClientID {
+			return nil
+		}
+		grantID = grant.ID
+	}
+
+	if err := s.repo.RevokeGrant(grantID, time.Now()); err != nil {
+		return err
+	}
+	s.grants.Revoke(grantID)
+	return nil
+}
+
+// findRefreshGrant looks up the grant of a refresh token, checking its secret
+func (s *oauthService) findRefreshGrant(token string) (*Grant, bool, error) {
+	grantID, hash, ok := parseRefreshToken(token)
+	if !ok {
+		return nil, false, nil
+	}
+	grant, err := s.repo.FindGrant(grantID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if grant.RefreshTokenHash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(grant.RefreshTokenHash)) != 1 {
+		return nil, false, nil
+	}
+	return grant, true, nil
+}
+
+// authenticateClient verifies client credentials. Public clients identify
+// themselves with client_id alone, which is only accepted where PKCE or
+// refresh-token rotation binds the request to the client.
+func (s *oauthService) authenticateClient(clientID, clientSecret string, requireConfidential bool) (*Client, error) {
+	if clientID == "" {
+		return nil, errInvalidClient
+	}
+	client, err := s.repo.FindClient(clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidClient
+		}
+		return nil, err
+	}
+
+	if client.Public {
+		if requireConfidential || clientSecret != "" {
+			return nil, errInvalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashOpaqueToken(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, errInvalidClient
+	}
+	return client, nil
+}
+
+// resourceOwner loads the user tokens are issued for
+func (s *oauthService) resourceOwner(userID uint) (*user.UserResponse, error) {
+	owner, err := s.users.GetByID(userID)
+	if err != nil {
+		if err == user.ErrUserNotFound {
+			return nil, errInvalidGrant
+		}
+		return nil, err
+	}
+	return owner, nil
+}
+
+// record writes an audit event; failures are logged and never block the action
+func (s *oauthService) record(event *audit.Event) {
+	if err := s.auditor.Record(event); err != nil {
+		log.Printf("⚠️  Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// resolveScopes parses a space-separated scope parameter. An empty request
+// means all allowed scopes; anything outside allowed is rejected.
+func resolveScopes(requested string, allowed []string) ([]string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return normalizeList(allowed), nil
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return nil, oauthError("invalid_scope", "scope "+scope+" is not allowed for this client")
+		}
+	}
+	return normalizeList(scopes), nil
+}
+
+// normalizeList sorts and de-duplicates values
+func normalizeList(values []string) []string {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// validateRedirectURI accepts absolute HTTPS URIs, and HTTP on loopback for
+// native and development clients (RFC 8252 section 7.3)
+func validateRedirectURI(raw string) error {
+	uri, err := url.Parse(raw)
+	if err != nil || !uri.IsAbs() || uri.Fragment != "" || strings.ContainsAny(raw, " \n") {
+		return oauthError("invalid_redirect_uri", "redirect URI "+raw+" must be absolute and without a fragment")
+	}
+	switch uri.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if host := uri.Hostname(); host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return nil
+		}
+	}
+	return oauthError("invalid_redirect_uri", "redirect URI "+raw+" must use https (http is allowed for loopback only)")
+}
+
+// validPKCEValue checks a code verifier or S256 challenge (RFC 7636 section 4.1)
+func validPKCEValue(value string) bool {
+	if len(value) < 43 || len(value) > 128 {
+		return false
+	}
+	for _, r := range value {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("-._~", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// s256 derives the S256 code challenge of a verifier
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
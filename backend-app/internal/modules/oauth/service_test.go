@@ -0,0 +1,458 @@
+package oauth
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/modules/user"
+	"gorm.io/gorm"
+)
+
+const (
+	testRedirectURI = "https://app.example.com/callback"
+	// RFC 7636 appendix B
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+// memoryRepository implements OAuthRepository in memory
+type memoryRepository struct {
+	clients map[string]*Client
+	codes   map[string]*AuthorizationCode
+	grants  map[string]*Grant
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		clients: map[string]*Client{},
+		codes:   map[string]*AuthorizationCode{},
+		grants:  map[string]*Grant{},
+	}
+}
+
+func (r *memoryRepository) CreateClient(client *Client) error {
+	r.clients[client.ClientID] = client
+	return nil
+}
+
+func (r *memoryRepository) FindClient(clientID string) (*Client, error) {
+	client, ok := r.clients[clientID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *client
+	return &copied, nil
+}
+
+func (r *memoryRepository) FindClientsByOwner(ownerID uint) ([]Client, error) {
+	var clients []Client
+	for _, client := range r.clients {
+		if client.OwnerID == ownerID {
+			clients = append(clients, *client)
+		}
+	}
+	return clients, nil
+}
+
+func (r *memoryRepository) DeleteClient(ownerID uint, clientID string) (bool, error) {
+	client, ok := r.clients[clientID]
+	if !ok || client.OwnerID != ownerID {
+		return false, nil
+	}
+	delete(r.clients, clientID)
+	return true, nil
+}
+
+func (r *memoryRepository) CreateCode(code *AuthorizationCode) error {
+	copied := *code
+	r.codes[code.CodeHash] = &copied
+	return nil
+}
+
+func (r *memoryRepository) RedeemCode(codeHash string, now time.Time) (*AuthorizationCode, bool, error) {
+	code, ok := r.codes[codeHash]
+	if !ok {
+		return nil, false, gorm.ErrRecordNotFound
+	}
+	copied := *code
+	if code.UsedAt != nil {
+		return &copied, false, nil
+	}
+	code.UsedAt = &now
+	return &copied, true, nil
+}
+
+func (r *memoryRepository) SetCodeGrant(codeHash, grantID string) error {
+	r.codes[codeHash].GrantID = grantID
+	return nil
+}
+
+func (r *memoryRepository) CreateGrant(grant *Grant) error {
+	copied := *grant
+	r.grants[grant.ID] = &copied
+	return nil
+}
+
+func (r *memoryRepository) FindGrant(id string) (*Grant, error) {
+	grant, ok := r.grants[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *grant
+	return &copied, nil
+}
+
+func (r *memoryRepository) RotateGrantToken(id, oldHash, newHash string, now, expiresAt time.Time) (bool, error) {
+	grant, ok := r.grants[id]
+	if !ok || grant.RefreshTokenHash != oldHash || grant.RevokedAt != nil {
+		return false, nil
+	}
+	grant.RefreshTokenHash, grant.LastUsedAt, grant.ExpiresAt = newHash, now, expiresAt
+	return true, nil
+}
+
+func (r *memoryRepository) RevokeGrant(id string, now time.Time) error {
+	if grant, ok := r.grants[id]; ok && grant.RevokedAt == nil {
+		grant.RevokedAt = &now
+	}
+	return nil
+}
+
+func (r *memoryRepository) RevokeClientGrants(clientID string, now time.Time) ([]string, error) {
+	var ids []string
+	for id, grant := range r.grants {
+		if grant.ClientID == clientID && grant.RevokedAt == nil {
+			grant.RevokedAt = &now
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// stubUsers serves the resource owners tokens are issued for
+type stubUsers struct {
+	user.UserService
+	users map[uint]*user.UserResponse
+}
+
+func (s *stubUsers) GetByID(id uint) (*user.UserResponse, error) {
+	if u, ok := s.users[id]; ok {
+		return u, nil
+	}
+	return nil, user.ErrUserNotFound
+}
+
+// recordedActions keeps the actions of recorded audit events
+type recordedActions []string
+
+func (r *recordedActions) Record(event *audit.Event) error {
+	*r = append(*r, event.Action)
+	return nil
+}
+
+func (r *recordedActions) Pseudonym(value string) string {
+	return value
+}
+
+type testServer struct {
+	service *oauthService
+	repo    *memoryRepository
+	grants  *GrantCache
+	actions *recordedActions
+}
+
+// newTestServer creates a service with a public client allowed to use
+// the authorization code and refresh token grants, and a confidential one
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	repo := newMemoryRepository()
+	repo.CreateClient(&Client{
+		ClientID:     "cli_public",
+		OwnerID:      1,
+		RedirectURIs: testRedirectURI,
+		Scopes:       "users:read users:write",
+		GrantTypes:   "authorization_code refresh_token",
+		Public:       true,
+	})
+	repo.CreateClient(&Client{
+		ClientID:     "cli_other",
+		SecretHash:   auth.HashOpaqueToken("other-secret"),
+		OwnerID:      1,
+		RedirectURIs: testRedirectURI,
+		Scopes:       "users:read",
+		GrantTypes:   "authorization_code refresh_token",
+	})
+
+	grants := &GrantCache{repo: repo, ttl: time.Minute, now: time.Now, entries: map[string]grantEntry{}}
+	actions := &recordedActions{}
+	cfg := &config.Config{
+		OAuthCodeTTL:         time.Minute,
+		OAuthAccessTokenTTL:  time.Hour,
+		OAuthRefreshTokenTTL: 24 * time.Hour,
+	}
+	users := &stubUsers{users: map[uint]*user.UserResponse{
+		1: {ID: 1, Role: user.RoleUser},
+		2: {ID: 2, Role: user.RoleUser},
+	}}
+	service := NewOAuthService(repo, auth.NewTokenManager("test-secret", "test"), users, grants, cfg, actions).(*oauthService)
+	return &testServer{service: service, repo: repo, grants: grants, actions: actions}
+}
+
+// authorize runs the authorization request and consent of user 2 and
+// returns the issued code
+func (s *testServer) authorize(t *testing.T, challenge string) string {
+	t.Helper()
+	authz, err := s.service.ValidateAuthorization(&AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            "cli_public",
+		RedirectURI:         testRedirectURI,
+		Scope:               "users:read",
+		State:               "xyz",
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: "S256",
+	})
+	if err != nil {
+		t.Fatalf("ValidateAuthorization() error = %v", err)
+	}
+	redirect, err := s.service.Approve(authz, 2)
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	target, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := target.Query().Get("state"); state != "xyz" {
+		t.Fatalf("redirect state = %q, want xyz", state)
+	}
+	return target.Query().Get("code")
+}
+
+// exchange redeems a code with the public client
+func (s *testServer) exchange(code, verifier, redirectURI string) (*TokenResponse, error) {
+	return s.service.Token("cli_public", "", &TokenRequest{
+		GrantType:    GrantAuthorizationCode,
+		Code:         code,
+		RedirectURI:  redirectURI,
+		CodeVerifier: verifier,
+	})
+}
+
+// oauthCode returns the OAuth error code of err, or "" when it is none
+func oauthCode(err error) string {
+	var oauthErr *Error
+	if errors.As(err, &oauthErr) {
+		return oauthErr.Code
+	}
+	return ""
+}
+
+func TestS256(t *testing.T) {
+	if got := s256(testVerifier); got != testChallenge {
+		t.Errorf("s256() = %s, want %s", got, testChallenge)
+	}
+}
+
+func TestValidateAuthorizationPKCE(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		wantCode  string
+	}{
+		{"S256", testChallenge, "S256", ""},
+		{"missing challenge", "", "S256", "invalid_request"},
+		{"missing method", testChallenge, "", "invalid_request"},
+		{"plain method", testVerifier, "plain", "invalid_request"},
+		{"lowercase method", testChallenge, "s256", "invalid_request"},
+		{"too short", testChallenge[:42], "S256", "invalid_request"},
+		{"too long", strings.Repeat("a", 129), "S256", "invalid_request"},
+		{"invalid characters", testChallenge[:42] + "+", "S256", "invalid_request"},
+	}
+
+	s := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authz, err := s.service.ValidateAuthorization(&AuthorizationRequest{
+				ResponseType:        "code",
+				ClientID:            "cli_public",
+				RedirectURI:         testRedirectURI,
+				CodeChallenge:       tt.challenge,
+				CodeChallengeMethod: tt.method,
+			})
+			if code := oauthCode(err); code != tt.wantCode {
+				t.Fatalf("error = %v, want code %q", err, tt.wantCode)
+			}
+			// Errors after the redirect URI was verified go to the client
+			if authz == nil {
+				t.Fatal("no authorization to redirect the error to")
+			}
+		})
+	}
+}
+
+func TestExchangeCodePKCE(t *testing.T) {
+	tests := []struct {
+		name        string
+		verifier    string
+		redirectURI string
+		wantCode    string
+	}{
+		{"matching verifier", testVerifier, testRedirectURI, ""},
+		{"missing verifier", "", testRedirectURI, "invalid_grant"},
+		{"other verifier", strings.Repeat("a", 43), testRedirectURI, "invalid_grant"},
+		{"challenge as verifier", testChallenge, testRedirectURI, "invalid_grant"},
+		{"verifier too short", testVerifier[:42], testRedirectURI, "invalid_grant"},
+		{"other redirect URI", testVerifier, "https://app.example.com/other", "invalid_grant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			code := s.authorize(t, testChallenge)
+
+			tokens, err := s.exchange(code, tt.verifier, tt.redirectURI)
+			if got := oauthCode(err); got != tt.wantCode {
+				t.Fatalf("error = %v, want code %q", err, tt.wantCode)
+			}
+			if tt.wantCode != "" {
+				if len(s.repo.grants) != 0 {
+					t.Errorf("a failed exchange created %d grants", len(s.repo.grants))
+				}
+				return
+			}
+			if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.Scope != "users:read" {
+				t.Errorf("tokens = %+v, want an access and refresh token for users:read", tokens)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeChecks(t *testing.T) {
+	s := newTestServer(t)
+
+	code := s.authorize(t, testChallenge)
+	_, err := s.service.Token("cli_other", "other-secret", &TokenRequest{
+		GrantType:    GrantAuthorizationCode,
+		Code:         code,
+		RedirectURI:  testRedirectURI,
+		CodeVerifier: testVerifier,
+	})
+	if oauthCode(err) != "invalid_grant" {
+		t.Errorf("code of another client: error = %v, want invalid_grant", err)
+	}
+
+	code = s.authorize(t, testChallenge)
+	s.repo.codes[auth.HashOpaqueToken(code)].ExpiresAt = time.Now().Add(-time.Second)
+	if _, err := s.exchange(code, testVerifier, testRedirectURI); oauthCode(err) != "invalid_grant" {
+		t.Errorf("expired code: error = %v, want invalid_grant", err)
+	}
+
+	if _, err := s.exchange("unknown", testVerifier, testRedirectURI); oauthCode(err) != "invalid_grant" {
+		t.Errorf("unknown code: error = %v, want invalid_grant", err)
+	}
+}
+
+func TestExchangeCodeReuseRevokesGrant(t *testing.T) {
+	s := newTestServer(t)
+	code := s.authorize(t, testChallenge)
+
+	tokens, err := s.exchange(code, testVerifier, testRedirectURI)
+	if err != nil {
+		t.Fatalf("first exchange: %v", err)
+	}
+	claims, err := s.service.tokens.Parse(tokens.AccessToken, auth.PurposeAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.grants.IsSessionActive(claims.SessionID) {
+		t.Fatal("grant inactive after the first exchange")
+	}
+
+	if _, err := s.exchange(code, testVerifier, testRedirectURI); oauthCode(err) != "invalid_grant" {
+		t.Fatalf("second exchange: error = %v, want invalid_grant", err)
+	}
+	if s.grants.IsSessionActive(claims.SessionID) {
+		t.Error("grant of a reused code is still active")
+	}
+	if s.repo.grants[claims.SessionID].RevokedAt == nil {
+		t.Error("grant of a reused code was not revoked")
+	}
+	if !slices.Contains(*s.actions, "oauth.code_reused") {
+		t.Errorf("recorded %v, want oauth.code_reused", *s.actions)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestServer(t)
+	first, err := s.exchange(s.authorize(t, testChallenge), testVerifier, testRedirectURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(token, scope string) (*TokenResponse, error) {
+		return s.service.Token("cli_public", "", &TokenRequest{GrantType: GrantRefreshToken, RefreshToken: token, Scope: scope})
+	}
+
+	second, err := refresh(first.RefreshToken, "")
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == "" {
+		t.Fatalf("refresh did not rotate the token: %+v", second)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		scope    string
+		wantCode string
+	}{
+		{"malformed token", "not-a-token", "", "invalid_grant"},
+		{"unknown grant", GrantIDPrefix + "0000.secret", "", "invalid_grant"},
+		{"scope beyond the grant", second.RefreshToken, "users:write", "invalid_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := refresh(tt.token, tt.scope); oauthCode(err) != tt.wantCode {
+				t.Errorf("error = %v, want %q", err, tt.wantCode)
+			}
+		})
+	}
+
+	// Another client cannot use the token, and does not revoke the grant
+	_, err = s.service.Token("cli_other", "other-secret", &TokenRequest{GrantType: GrantRefreshToken, RefreshToken: second.RefreshToken})
+	if oauthCode(err) != "invalid_grant" {
+		t.Errorf("refresh by another client: error = %v, want invalid_grant", err)
+	}
+
+	third, err := refresh(second.RefreshToken, "users:read")
+	if err != nil {
+		t.Fatalf("refresh with the current token: %v", err)
+	}
+
+	// Presenting a rotated token means it leaked: the grant is revoked, and
+	// with it the current refresh and access tokens
+	if _, err := refresh(first.RefreshToken, ""); oauthCode(err) != "invalid_grant" {
+		t.Fatalf("reused token: error = %v, want invalid_grant", err)
+	}
+	if !slices.Contains(*s.actions, "oauth.refresh_token_reused") {
+		t.Errorf("recorded %v, want oauth.refresh_token_reused", *s.actions)
+	}
+	if _, err := refresh(third.RefreshToken, ""); oauthCode(err) != "invalid_grant" {
+		t.Errorf("current token after reuse: error = %v, want invalid_grant", err)
+	}
+	claims, err := s.service.tokens.Parse(third.AccessToken, auth.PurposeAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.grants.IsSessionActive(claims.SessionID) {
+		t.Error("access token still active after refresh token reuse")
+	}
+}
@@ -0,0 +1,262 @@
+package privacy
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"github.com/savindaJ/backend-app/internal/modules/user"
+)
+
+// PrivacyHandler handles HTTP requests for data exports and erasure
+type PrivacyHandler struct {
+	service PrivacyService
+}
+
+// NewPrivacyHandler creates a new privacy handler
+func NewPrivacyHandler(service PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{service: service}
+}
+
+// RequestExport godoc
+// @Summary      Request data export
+// @Description  Start generating a ZIP archive of JSON files with all data kept about the current user. The export is generated in the background; poll the export list and download it once ready.
+// @Tags         privacy
+// @Produce      json
+// @Security     BearerAuth
+// @Success      202  {object}  ExportResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/exports [post]
+func (h *PrivacyHandler) RequestExport(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	export, err := h.service.RequestExport(userID, requestMeta(c))
+	if err != nil {
+		if err == ErrExportInProgress {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to request data export"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, export)
+}
+
+// ListExports godoc
+// @Summary      List data exports
+// @Description  List the current user's data exports, newest first
+// @Tags         privacy
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   ExportResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/exports [get]
+func (h *PrivacyHandler) ListExports(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	exports, err := h.service.ListExports(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve data exports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, exports)
+}
+
+// DownloadExport godoc
+// @Summary      Download data export
+// @Description  Download a ready data export as a ZIP archive
+// @Tags         privacy
+// @Produce      application/zip
+// @Security     BearerAuth
+// @Param        exportId path string true "Export ID"
+// @Success      200  {file}    file
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      410  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/exports/{exportId}/download [get]
+func (h *PrivacyHandler) DownloadExport(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	export, err := h.service.DownloadExport(userID, c.Param("exportId"))
+	if err != nil {
+		switch err {
+		case ErrExportNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		case ErrExportNotReady:
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		case ErrExportExpired:
+			c.JSON(http.StatusGone, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to download data export"})
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="data-export-`+export.ID+`.zip"`)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/zip", export.Archive)
+}
+
+// RequestErasure godoc
+// @Summary      Request account erasure
+// @Description  Request the erasure of the current user's account and personal data. The password is required unless the account signs in with an identity provider only. A confirmation token is emailed; once confirmed, the erasure runs after a grace period during which it can be cancelled.
+// @Tags         privacy
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body ErasureRequestBody true "Current password"
+// @Success      202  {object}  ErasureResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/erasure [post]
+func (h *PrivacyHandler) RequestErasure(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	var req ErasureRequestBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request body", Details: err.Error()})
+		return
+	}
+
+	request, err := h.service.RequestErasure(userID, req.Password, requestMeta(c))
+	if err != nil {
+		if respondThrottled(c, err) {
+			return
+		}
+		switch err {
+		case user.ErrInvalidCurrentPassword:
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		case user.ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		case ErrErasureAlreadyScheduled:
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to request account erasure"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, request)
+}
+
+// GetErasure godoc
+// @Summary      Get account erasure
+// @Description  Get the current user's most recent erasure request
+// @Tags         privacy
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  ErasureResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/erasure [get]
+func (h *PrivacyHandler) GetErasure(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	request, err := h.service.GetErasure(userID)
+	if err != nil {
+		if err == ErrErasureNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve erasure request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+// CancelErasure godoc
+// @Summary      Cancel account erasure
+// @Description  Cancel the current user's erasure request while it awaits confirmation or its grace period
+// @Tags         privacy
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  ErasureResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/erasure [delete]
+func (h *PrivacyHandler) CancelErasure(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	request, err := h.service.CancelErasure(userID, requestMeta(c))
+	if err != nil {
+		if err == ErrErasureNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to cancel account erasure"})
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+// ConfirmErasure godoc
+// @Summary      Confirm account erasure
+// @Description  Confirm an erasure request with the emailed token. The erasure is scheduled to run when the grace period ends.
+// @Tags         privacy
+// @Accept       json
+// @Produce      json
+// @Param        request body ConfirmErasureRequest true "Confirmation token"
+// @Success      200  {object}  ErasureResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/erasure/confirm [post]
+func (h *PrivacyHandler) ConfirmErasure(c *gin.Context) {
+	var req ConfirmErasureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request body", Details: err.Error()})
+		return
+	}
+
+	request, err := h.service.ConfirmErasure(req.Token, requestMeta(c))
+	if err != nil {
+		if err == ErrInvalidErasureToken {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to confirm account erasure"})
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+// requestMeta extracts client details used for throttling and auditing
+func requestMeta(c *gin.Context) user.RequestMeta {
+	return user.RequestMeta{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: middleware.RequestID(c),
+		SessionID: middleware.SessionID(c),
+	}
+}
+
+// respondThrottled writes a 429 with Retry-After when err is a login throttle
+func respondThrottled(c *gin.Context, err error) bool {
+	var throttled *user.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: throttled.Error()})
+	return true
+}
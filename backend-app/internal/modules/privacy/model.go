@@ -0,0 +1,139 @@
+package privacy
+
+import (
+	"time"
+)
+
+// Export statuses
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// Erasure request statuses
+const (
+	ErasurePending   = "pending"   // Waiting for the emailed confirmation
+	ErasureScheduled = "scheduled" // Confirmed; runs when the grace period ends
+	ErasureCancelled = "cancelled"
+	ErasureCompleted = "completed"
+)
+
+// exportIDPrefix marks export IDs, which appear in download URLs
+const exportIDPrefix = "exp_"
+
+// DataExport is an archive of everything the application keeps about a
+// user. It is generated in the background and expires after DATA_EXPORT_TTL.
+type DataExport struct {
+	ID          string `gorm:"primaryKey;size:40"`
+	UserID      uint   `gorm:"index;not null"`
+	Status      string `gorm:"size:16;index;not null"`
+	Archive     []byte `gorm:"type:longblob"` // ZIP of JSON files, set once ready
+	Size        int64  `gorm:"not null;default:0"`
+	Error       string `gorm:"size:255"`
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time `gorm:"index"`
+	CreatedAt   time.Time
+}
+
+// TableName overrides the table name
+func (DataExport) TableName() string {
+	return "user_data_exports"
+}
+
+// ToResponse converts DataExport to ExportResponse
+func (e *DataExport) ToResponse() ExportResponse {
+	return ExportResponse{
+		ID:          e.ID,
+		Status:      e.Status,
+		Size:        e.Size,
+		Error:       e.Error,
+		CreatedAt:   e.CreatedAt,
+		CompletedAt: e.CompletedAt,
+		ExpiresAt:   e.ExpiresAt,
+	}
+}
+
+// ErasureRequest is a user's request to have their personal data erased.
+// It must be confirmed from the emailed link, then runs after a grace period
+// during which the user can still cancel it.
+type ErasureRequest struct {
+	ID             uint   `gorm:"primaryKey"`
+	UserID         uint   `gorm:"index;not null"`
+	Status         string `gorm:"size:16;index;not null"`
+	TokenHash      string `gorm:"size:64;index"` // Cleared once confirmed
+	TokenExpiresAt *time.Time
+	ScheduledFor   *time.Time `gorm:"index"`
+	ConfirmedAt    *time.Time
+	CancelledAt    *time.Time
+	CompletedAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// TableName overrides the table name
+func (ErasureRequest) TableName() string {
+	return "user_erasure_requests"
+}
+
+// Open reports whether the request is still waiting for confirmation or
+// for its grace period to end
+func (r *ErasureRequest) Open() bool {
+	return r.Status == ErasurePending || r.Status == ErasureScheduled
+}
+
+// ToResponse converts ErasureRequest to ErasureResponse
+func (r *ErasureRequest) ToResponse() ErasureResponse {
+	return ErasureResponse{
+		ID:           r.ID,
+		Status:       r.Status,
+		ScheduledFor: r.ScheduledFor,
+		ConfirmedAt:  r.ConfirmedAt,
+		CancelledAt:  r.CancelledAt,
+		CompletedAt:  r.CompletedAt,
+		CreatedAt:    r.CreatedAt,
+	}
+}
+
+// ExportResponse represents a data export in API responses
+type ExportResponse struct {
+	ID          string     `json:"id" example:"exp_9f0c2d4e6a8b4c1d9f0c2d4e6a8b4c1d"`
+	Status      string     `json:"status" example:"ready"`
+	Size        int64      `json:"size" example:"20480"` // Archive size in bytes
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" example:"2024-01-01T00:00:00Z"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" example:"2024-01-01T00:00:05Z"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" example:"2024-01-08T00:00:05Z"`
+}
+
+// ErasureRequestBody represents the request body for requesting erasure
+type ErasureRequestBody struct {
+	Password string `json:"password" example:"password123"` // Required unless the account signs in with an identity provider only
+}
+
+// ConfirmErasureRequest represents the request body for confirming erasure
+type ConfirmErasureRequest struct {
+	Token string `json:"token" binding:"required" example:"q3x9Vt0bX8k2..."`
+}
+
+// ErasureResponse represents an erasure request in API responses
+type ErasureResponse struct {
+	ID           uint       `json:"id" example:"1"`
+	Status       string     `json:"status" example:"scheduled"`
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty" example:"2024-01-08T00:00:00Z"`
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty" example:"2024-01-01T00:00:00Z"`
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error   string `json:"error" example:"error message"`
+	Details string `json:"details,omitempty" example:"detailed error information"`
+}
+
+// SuccessResponse represents a success response
+type SuccessResponse struct {
+	Message string `json:"message" example:"operation successful"`
+}
@@ -0,0 +1,192 @@
+package privacy
+
+import (
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/modules/user"
+	"gorm.io/gorm"
+)
+
+// exportColumns are the export columns listed without the archive itself
+var exportColumns = []string{"id", "user_id", "status", "size", "error", "completed_at", "expires_at", "created_at"}
+
+// PrivacyRepository interface defines the contract for export and erasure data access
+type PrivacyRepository interface {
+	CreateExport(export *DataExport) error
+	FindExports(userID uint) ([]DataExport, error)
+	FindExport(userID uint, id string) (*DataExport, error)
+	FindPendingExportIDs() ([]string, error)
+	HasPendingExport(userID uint) (bool, error)
+	LoadPendingExport(id string) (*DataExport, error)
+	SaveExport(export *DataExport) error
+	DeleteExpiredExports(now time.Time) (int64, error)
+
+	CreateErasure(request *ErasureRequest) error
+	FindOpenErasure(userID uint) (*ErasureRequest, error)
+	FindLatestErasure(userID uint) (*ErasureRequest, error)
+	FindErasures(userID uint) ([]ErasureRequest, error)
+	FindErasureByToken(tokenHash string) (*ErasureRequest, error)
+	SaveErasure(request *ErasureRequest) error
+	FindDueErasures(now time.Time, limit int) ([]ErasureRequest, error)
+	CompleteErasure(id, userID uint, now time.Time, hooks []user.PurgeHook) (bool, error)
+}
+
+// privacyRepository implements PrivacyRepository
+type privacyRepository struct {
+	db *gorm.DB
+}
+
+// NewPrivacyRepository creates a new privacy repository
+func NewPrivacyRepository(db *gorm.DB) PrivacyRepository {
+	return &privacyRepository{db: db}
+}
+
+// CreateExport inserts a new export
+func (r *privacyRepository) CreateExport(export *DataExport) error {
+	return r.db.Create(export).Error
+}
+
+// FindExports lists a user's exports, newest first, without their archives
+func (r *privacyRepository) FindExports(userID uint) ([]DataExport, error) {
+	var exports []DataExport
+	err := r.db.Select(exportColumns).Where("user_id = ?", userID).Order("created_at DESC").Find(&exports).Error
+	return exports, err
+}
+
+// FindExport finds an export of a user, including its archive
+func (r *privacyRepository) FindExport(userID uint, id string) (*DataExport, error) {
+	var export DataExport
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// FindPendingExportIDs lists the exports still to be generated, oldest first
+func (r *privacyRepository) FindPendingExportIDs() ([]string, error) {
+	var ids []string
+	err := r.db.Model(&DataExport{}).Where("status = ?", ExportPending).Order("created_at").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// HasPendingExport reports whether a user has an export being generated
+func (r *privacyRepository) HasPendingExport(userID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&DataExport{}).Where("user_id = ? AND status = ?", userID, ExportPending).Count(&count).Error
+	return count > 0, err
+}
+
+// LoadPendingExport finds an export that is still to be generated
+func (r *privacyRepository) LoadPendingExport(id string) (*DataExport, error) {
+	var export DataExport
+	if err := r.db.Select(exportColumns).Where("id = ? AND status = ?", id, ExportPending).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// SaveExport updates an export
+func (r *privacyRepository) SaveExport(export *DataExport) error {
+	return r.db.Save(export).Error
+}
+
+// DeleteExpiredExports removes exports whose download period has ended
+func (r *privacyRepository) DeleteExpiredExports(now time.Time) (int64, error) {
+	result := r.db.Where("expires_at < ?", now).Delete(&DataExport{})
+	return result.RowsAffected, result.Error
+}
+
+// CreateErasure inserts a new erasure request
+func (r *privacyRepository) CreateErasure(request *ErasureRequest) error {
+	return r.db.Create(request).Error
+}
+
+// FindOpenErasure finds a user's erasure request that is pending or scheduled
+func (r *privacyRepository) FindOpenErasure(userID uint) (*ErasureRequest, error) {
+	var request ErasureRequest
+	if err := r.db.Where("user_id = ? AND status IN ?", userID, []string{ErasurePending, ErasureScheduled}).
+		Order("id DESC").First(&request).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// FindLatestErasure finds a user's most recent erasure request
+func (r *privacyRepository) FindLatestErasure(userID uint) (*ErasureRequest, error) {
+	var request ErasureRequest
+	if err := r.db.Where("user_id = ?", userID).Order("id DESC").First(&request).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// FindErasures lists a user's erasure requests, oldest first
+func (r *privacyRepository) FindErasures(userID uint) ([]ErasureRequest, error) {
+	var requests []ErasureRequest
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&requests).Error
+	return requests, err
+}
+
+// FindErasureByToken finds a pending erasure request by its confirmation token hash
+func (r *privacyRepository) FindErasureByToken(tokenHash string) (*ErasureRequest, error) {
+	var request ErasureRequest
+	if err := r.db.Where("token_hash = ? AND status = ?", tokenHash, ErasurePending).First(&request).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// SaveErasure updates an erasure request
+func (r *privacyRepository) SaveErasure(request *ErasureRequest) error {
+	return r.db.Save(request).Error
+}
+
+// FindDueErasures lists up to limit scheduled erasures whose grace period has ended
+func (r *privacyRepository) FindDueErasures(now time.Time, limit int) ([]ErasureRequest, error) {
+	var requests []ErasureRequest
+	err := r.db.Where("status = ? AND scheduled_for <= ?", ErasureScheduled, now).
+		Order("scheduled_for").Limit(limit).Find(&requests).Error
+	return requests, err
+}
+
+// CompleteErasure runs the erasure hooks for a user and marks the request
+// completed in one transaction. It reports false when the request is no
+// longer scheduled, e.g. because it was cancelled in the meantime.
+func (r *privacyRepository) CompleteErasure(id, userID uint, now time.Time, hooks []user.PurgeHook) (bool, error) {
+	completed := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&ErasureRequest{}).
+			Where("id = ? AND status = ?", id, ErasureScheduled).
+			Updates(map[string]interface{}{"status": ErasureCompleted, "completed_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		for _, hook := range hooks {
+			if err := hook(tx, []uint{userID}); err != nil {
+				return err
+			}
+		}
+		completed = true
+		return nil
+	})
+	return completed, err
+}
+
+// PurgeUserData removes the exports and erasure requests of purged users. It
+// runs as a user purge hook inside the purge transaction.
+func PurgeUserData(tx *gorm.DB, userIDs []uint) error {
+	if err := tx.Where("user_id IN ?", userIDs).Delete(&DataExport{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id IN ?", userIDs).Delete(&ErasureRequest{}).Error
+}
+
+// deleteExports removes the exports of erased users; their erasure requests
+// are kept as a record that the erasure happened
+func deleteExports(tx *gorm.DB, userIDs []uint) error {
+	return tx.Where("user_id IN ?", userIDs).Delete(&DataExport{}).Error
+}
@@ -0,0 +1,39 @@
+package privacy
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/mailer"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"github.com/savindaJ/backend-app/internal/modules/user"
+	"gorm.io/gorm"
+)
+
+// RegisterRoutes registers the data export and erasure routes and starts
+// the worker that generates exports and runs erasures. sources and hooks
+// export and erase the data each module keeps about a user; invalidate
+// drops erased users from the user cache.
+func RegisterRoutes(router *gin.RouterGroup, db *gorm.DB, cfg *config.Config, users user.UserService, requireAuth gin.HandlerFunc, sources []user.ExportSource, hooks []user.PurgeHook, invalidate user.CacheInvalidator) {
+	// Initialize dependencies
+	worker := NewWorker(db, cfg, users, sources, hooks, invalidate)
+	service := NewPrivacyService(NewPrivacyRepository(db), users, cfg, audit.NewRecorder(db, cfg.JWTSecret), mailer.New(cfg), worker)
+	handler := NewPrivacyHandler(service)
+
+	worker.Start()
+
+	privacy := router.Group("/users")
+	{
+		// Public routes
+		privacy.POST("/erasure/confirm", handler.ConfirmErasure)
+
+		// Exports and erasure of the current user require an interactive login
+		account := privacy.Group("/me", requireAuth, middleware.RequireFirstParty())
+		account.POST("/exports", handler.RequestExport)
+		account.GET("/exports", handler.ListExports)
+		account.GET("/exports/:exportId/download", handler.DownloadExport)
+		account.POST("/erasure", handler.RequestErasure)
+		account.GET("/erasure", handler.GetErasure)
+		account.DELETE("/erasure", handler.CancelErasure)
+	}
+}
@@ -0,0 +1,310 @@
+package privacy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/mailer"
+	"github.com/savindaJ/backend-app/internal/modules/user"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrExportNotFound   = errors.New("data export not found")
+	ErrExportInProgress = errors.New("a data export is already being generated")
+	ErrExportNotReady   = errors.New("data export is not ready")
+	ErrExportExpired    = errors.New("data export has expired; request a new one")
+
+	ErrErasureNotFound         = errors.New("erasure request not found")
+	ErrErasureAlreadyScheduled = errors.New("account erasure is already scheduled")
+	ErrInvalidErasureToken     = errors.New("invalid or expired erasure confirmation token")
+)
+
+// PrivacyService interface defines the contract for data exports and erasure
+type PrivacyService interface {
+	RequestExport(userID uint, meta user.RequestMeta) (*ExportResponse, error)
+	ListExports(userID uint) ([]ExportResponse, error)
+	DownloadExport(userID uint, id string) (*DataExport, error)
+	RequestErasure(userID uint, password string, meta user.RequestMeta) (*ErasureResponse, error)
+	ConfirmErasure(token string, meta user.RequestMeta) (*ErasureResponse, error)
+	CancelErasure(userID uint, meta user.RequestMeta) (*ErasureResponse, error)
+	GetErasure(userID uint) (*ErasureResponse, error)
+}
+
+// privacyService implements PrivacyService
+type privacyService struct {
+	repo    PrivacyRepository
+	users   user.UserService
+	cfg     *config.Config
+	auditor audit.Recorder
+	mailer  mailer.Mailer
+	worker  *Worker
+}
+
+// NewPrivacyService creates a new privacy service. Exports are handed to
+// worker to be generated in the background.
+func NewPrivacyService(repo PrivacyRepository, users user.UserService, cfg *config.Config, auditor audit.Recorder, mail mailer.Mailer, worker *Worker) PrivacyService {
+	return &privacyService{
+		repo:    repo,
+		users:   users,
+		cfg:     cfg,
+		auditor: auditor,
+		mailer:  mail,
+		worker:  worker,
+	}
+}
+
+// newExportID returns a random, unguessable export ID
+func newExportID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return exportIDPrefix + hex.EncodeToString(buf), nil
+}
+
+// RequestExport queues a new export of the user's data. Only one export
+// may be generated at a time per user.
+func (s *privacyService) RequestExport(userID uint, meta user.RequestMeta) (*ExportResponse, error) {
+	pending, err := s.repo.HasPendingExport(userID)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, ErrExportInProgress
+	}
+
+	id, err := newExportID()
+	if err != nil {
+		return nil, err
+	}
+	export := &DataExport{ID: id, UserID: userID, Status: ExportPending}
+	if err := s.repo.CreateExport(export); err != nil {
+		return nil, err
+	}
+
+	s.worker.Enqueue(export.ID)
+	s.record(&audit.Event{
+		Action:     "privacy.export_requested",
+		ActorID:    &userID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(userID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+
+	response := export.ToResponse()
+	return &response, nil
+}
+
+// ListExports lists the user's exports, newest first
+func (s *privacyService) ListExports(userID uint) ([]ExportResponse, error) {
+	exports, err := s.repo.FindExports(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]ExportResponse, len(exports))
+	for i := range exports {
+		responses[i] = exports[i].ToResponse()
+	}
+	return responses, nil
+}
+
+// DownloadExport returns a ready export of the user, including its archive
+func (s *privacyService) DownloadExport(userID uint, id string) (*DataExport, error) {
+	export, err := s.repo.FindExport(userID, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+	if export.Status != ExportReady {
+		return nil, ErrExportNotReady
+	}
+	if export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt) {
+		return nil, ErrExportExpired
+	}
+	return export, nil
+}
+
+// RequestErasure starts an erasure of the user's personal data after
+// re-checking their password, and emails a confirmation token. A request
+// still awaiting confirmation is replaced.
+func (s *privacyService) RequestErasure(userID uint, password string, meta user.RequestMeta) (*ErasureResponse, error) {
+	account, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.Reauthenticate(userID, password, meta); err != nil {
+		return nil, err
+	}
+
+	open, err := s.repo.FindOpenErasure(userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if open != nil {
+		if open.Status == ErasureScheduled {
+			return nil, ErrErasureAlreadyScheduled
+		}
+		now := time.Now()
+		open.Status = ErasureCancelled
+		open.TokenHash = ""
+		open.CancelledAt = &now
+		if err := s.repo.SaveErasure(open); err != nil {
+			return nil, err
+		}
+	}
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(s.cfg.ErasureConfirmTTL)
+	request := &ErasureRequest{
+		UserID:         userID,
+		Status:         ErasurePending,
+		TokenHash:      tokenHash,
+		TokenExpiresAt: &expiresAt,
+	}
+	if err := s.repo.CreateErasure(request); err != nil {
+		return nil, err
+	}
+
+	if err := s.mailer.Send(mailer.Message{
+		To:      account.Email,
+		Subject: "Confirm the erasure of your account",
+		Body: "We received a request to erase your account and personal data. To confirm, submit the token below to " +
+			s.cfg.AppBaseURL + "/api/v1/users/erasure/confirm before " + expiresAt.Format(time.RFC1123) + ":\n\n" + token +
+			"\n\nIf this wasn't you, ignore this email and change your password.",
+	}); err != nil {
+		return nil, err
+	}
+
+	s.record(&audit.Event{
+		Action:     "privacy.erasure_requested",
+		ActorID:    &userID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(userID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+
+	response := request.ToResponse()
+	return &response, nil
+}
+
+// ConfirmErasure schedules a requested erasure to run once the grace
+// period ends
+func (s *privacyService) ConfirmErasure(token string, meta user.RequestMeta) (*ErasureResponse, error) {
+	request, err := s.repo.FindErasureByToken(auth.HashOpaqueToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidErasureToken
+		}
+		return nil, err
+	}
+	if request.TokenExpiresAt == nil || time.Now().After(*request.TokenExpiresAt) {
+		return nil, ErrInvalidErasureToken
+	}
+
+	now := time.Now()
+	scheduledFor := now.Add(s.cfg.ErasureGracePeriod)
+	request.Status = ErasureScheduled
+	request.TokenHash = ""
+	request.TokenExpiresAt = nil
+	request.ConfirmedAt = &now
+	request.ScheduledFor = &scheduledFor
+	if err := s.repo.SaveErasure(request); err != nil {
+		return nil, err
+	}
+
+	s.record(&audit.Event{
+		Action:     "privacy.erasure_confirmed",
+		ActorID:    &request.UserID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(request.UserID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+	if account, err := s.users.GetByID(request.UserID); err == nil {
+		s.notify(mailer.Message{
+			To:      account.Email,
+			Subject: "Your account is scheduled for erasure",
+			Body: "Your account and personal data will be erased on " + scheduledFor.Format(time.RFC1123) +
+				". Until then you can sign in and cancel the erasure from your account settings.",
+		})
+	}
+
+	response := request.ToResponse()
+	return &response, nil
+}
+
+// CancelErasure cancels the user's pending or scheduled erasure
+func (s *privacyService) CancelErasure(userID uint, meta user.RequestMeta) (*ErasureResponse, error) {
+	request, err := s.repo.FindOpenErasure(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrErasureNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	request.Status = ErasureCancelled
+	request.TokenHash = ""
+	request.TokenExpiresAt = nil
+	request.CancelledAt = &now
+	if err := s.repo.SaveErasure(request); err != nil {
+		return nil, err
+	}
+
+	s.record(&audit.Event{
+		Action:     "privacy.erasure_cancelled",
+		ActorID:    &userID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(userID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+
+	response := request.ToResponse()
+	return &response, nil
+}
+
+// GetErasure returns the user's most recent erasure request
+func (s *privacyService) GetErasure(userID uint) (*ErasureResponse, error) {
+	request, err := s.repo.FindLatestErasure(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrErasureNotFound
+		}
+		return nil, err
+	}
+
+	response := request.ToResponse()
+	return &response, nil
+}
+
+// record writes an audit event; failures are logged and never block the action
+func (s *privacyService) record(event *audit.Event) {
+	if err := s.auditor.Record(event); err != nil {
+		log.Printf("⚠️  Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// notify sends an informational email; failures are logged and never block the action
+func (s *privacyService) notify(msg mailer.Message) {
+	if err := s.mailer.Send(msg); err != nil {
+		log.Printf("⚠️  Failed to send %q email: %v", msg.Subject, err)
+	}
+}
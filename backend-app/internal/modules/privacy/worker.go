@@ -0,0 +1,289 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/mailer"
+	"github.com/savindaJ/backend-app/internal/modules/user"
+	"gorm.io/gorm"
+)
+
+// exportQueueSize bounds the exports waiting to be generated in memory;
+// exports that do not fit are picked up by the next periodic run
+const exportQueueSize = 100
+
+// erasureBatchSize is the number of erasures run per periodic run
+const erasureBatchSize = 100
+
+// Worker generates data exports in the background and periodically runs
+// due erasures and removes expired exports
+type Worker struct {
+	repo       PrivacyRepository
+	users      user.UserService
+	auditor    audit.Recorder
+	mailer     mailer.Mailer
+	sources    []user.ExportSource
+	hooks      []user.PurgeHook
+	invalidate user.CacheInvalidator
+	exportTTL  time.Duration
+	interval   time.Duration
+	queue      chan string
+}
+
+// NewWorker creates the export and erasure worker. sources collect what each
+// module keeps about a user for exports; hooks erase it when an erasure runs,
+// after which invalidate drops the user from the user cache.
+func NewWorker(db *gorm.DB, cfg *config.Config, users user.UserService, sources []user.ExportSource, hooks []user.PurgeHook, invalidate user.CacheInvalidator) *Worker {
+	w := &Worker{
+		repo:       NewPrivacyRepository(db),
+		users:      users,
+		invalidate: invalidate,
+		auditor:    audit.NewRecorder(db, cfg.JWTSecret),
+		mailer:     mailer.New(cfg),
+		exportTTL:  cfg.DataExportTTL,
+		interval:   cfg.PrivacyJobInterval,
+		queue:      make(chan string, exportQueueSize),
+	}
+	w.sources = append([]user.ExportSource{w.exportOwnData}, sources...)
+	w.hooks = append([]user.PurgeHook{deleteExports}, hooks...)
+	return w
+}
+
+// Enqueue schedules an export to be generated without blocking
+func (w *Worker) Enqueue(exportID string) {
+	select {
+	case w.queue <- exportID:
+	default:
+		log.Printf("⚠️  Export queue is full; export %s will be generated on the next run", exportID)
+	}
+}
+
+// Start generates queued exports in the background and runs the periodic
+// jobs now and then every interval
+func (w *Worker) Start() {
+	go func() {
+		for exportID := range w.queue {
+			w.generate(exportID)
+		}
+	}()
+
+	if w.interval <= 0 {
+		log.Println("ℹ️  Periodic privacy jobs are disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			w.Run(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// Run re-queues exports left pending, e.g. by a restart, runs the erasures
+// whose grace period has ended and removes expired exports
+func (w *Worker) Run(now time.Time) {
+	ids, err := w.repo.FindPendingExportIDs()
+	if err != nil {
+		log.Printf("⚠️  Failed to load pending exports: %v", err)
+	}
+	for _, id := range ids {
+		w.Enqueue(id)
+	}
+
+	if err := w.eraseDue(now); err != nil {
+		log.Printf("⚠️  Failed to run account erasures: %v", err)
+	}
+
+	deleted, err := w.repo.DeleteExpiredExports(now)
+	if err != nil {
+		log.Printf("⚠️  Failed to delete expired exports: %v", err)
+	} else if deleted > 0 {
+		log.Printf("🗑️  Deleted %d expired data exports", deleted)
+	}
+}
+
+// generate builds the archive of a pending export and notifies the user
+func (w *Worker) generate(exportID string) {
+	export, err := w.repo.LoadPendingExport(exportID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("⚠️  Failed to load export %s: %v", exportID, err)
+		}
+		return
+	}
+
+	now := time.Now()
+	export.CompletedAt = &now
+	archive, err := w.buildArchive(export.UserID)
+	if err != nil {
+		log.Printf("⚠️  Failed to generate export %s: %v", exportID, err)
+		export.Status = ExportFailed
+		export.Error = "Failed to collect account data"
+	} else {
+		expiresAt := now.Add(w.exportTTL)
+		export.Status = ExportReady
+		export.Archive = archive
+		export.Size = int64(len(archive))
+		export.ExpiresAt = &expiresAt
+	}
+	if err := w.repo.SaveExport(export); err != nil {
+		log.Printf("⚠️  Failed to save export %s: %v", exportID, err)
+		return
+	}
+
+	if export.Status != ExportReady {
+		return
+	}
+	if account, err := w.users.GetByID(export.UserID); err == nil {
+		w.notify(mailer.Message{
+			To:      account.Email,
+			Subject: "Your data export is ready",
+			Body: "The export of your account data you requested is ready. Download it from your account settings before " +
+				export.ExpiresAt.Format(time.RFC1123) + ".",
+		})
+	}
+}
+
+// buildArchive collects a user's data from every source into a ZIP of
+// JSON files
+func (w *Worker) buildArchive(userID uint) ([]byte, error) {
+	files := map[string]interface{}{}
+	for _, source := range w.sources {
+		data, err := source(userID)
+		if err != nil {
+			return nil, err
+		}
+		for name, content := range data {
+			if _, exists := files[name]; exists {
+				return nil, fmt.Errorf("export file %s is provided by more than one source", name)
+			}
+			files[name] = content
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range names {
+		content, err := json.MarshalIndent(files[name], "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("encode %s: %w", name, err)
+		}
+		file, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exportOwnData is the export source for the user's exports and erasure requests
+func (w *Worker) exportOwnData(userID uint) (map[string]interface{}, error) {
+	exports, err := w.repo.FindExports(userID)
+	if err != nil {
+		return nil, err
+	}
+	exportedExports := make([]ExportResponse, len(exports))
+	for i := range exports {
+		exportedExports[i] = exports[i].ToResponse()
+	}
+
+	requests, err := w.repo.FindErasures(userID)
+	if err != nil {
+		return nil, err
+	}
+	exportedRequests := make([]ErasureResponse, len(requests))
+	for i := range requests {
+		exportedRequests[i] = requests[i].ToResponse()
+	}
+
+	return map[string]interface{}{
+		"data_exports.json":     exportedExports,
+		"erasure_requests.json": exportedRequests,
+	}, nil
+}
+
+// eraseDue runs the erasures whose grace period has ended
+func (w *Worker) eraseDue(now time.Time) error {
+	requests, err := w.repo.FindDueErasures(now, erasureBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, request := range requests {
+		if err := w.erase(&request, now); err != nil {
+			log.Printf("⚠️  Failed to erase user %d: %v", request.UserID, err)
+		}
+	}
+	return nil
+}
+
+// erase signs the user out everywhere, then anonymizes and removes their
+// data in one transaction and sends a last email to the old address
+func (w *Worker) erase(request *ErasureRequest, now time.Time) error {
+	// The address is gone once erased; deleted accounts are not emailed
+	account, _ := w.users.GetByID(request.UserID)
+
+	if err := w.users.RevokeAllSessions(request.UserID); err != nil {
+		return err
+	}
+	completed, err := w.repo.CompleteErasure(request.ID, request.UserID, now, w.hooks)
+	if err != nil || !completed {
+		return err
+	}
+	w.invalidate([]uint{request.UserID})
+
+	w.record(&audit.Event{
+		Action:     "user.erased",
+		ActorID:    &request.UserID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(request.UserID), 10),
+	})
+	log.Printf("🗑️  Erased personal data of user %d", request.UserID)
+
+	if account != nil {
+		w.notify(mailer.Message{
+			To:      account.Email,
+			Subject: "Your account has been erased",
+			Body:    "As you requested, your account and the personal data associated with it have been erased.",
+		})
+	}
+	return nil
+}
+
+// record writes an audit event; failures are logged and never block the job
+func (w *Worker) record(event *audit.Event) {
+	if err := w.auditor.Record(event); err != nil {
+		log.Printf("⚠️  Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// notify sends an informational email; failures are logged and never block the job
+func (w *Worker) notify(msg mailer.Message) {
+	if err := w.mailer.Send(msg); err != nil {
+		log.Printf("⚠️  Failed to send %q email: %v", msg.Subject, err)
+	}
+}
@@ -0,0 +1,108 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"gorm.io/gorm"
+)
+
+// API keys look like gbk_<prefix>_<secret>. The prefix is stored in clear
+// text for lookup and display; the whole key is only stored hashed.
+const (
+	apiKeyPrefix        = "gbk_"
+	apiKeyPrefixBytes   = 6
+	apiKeyTouchInterval = time.Minute
+	maxAPIKeysPerUser   = 20
+)
+
+// newAPIKey generates a key and returns it with its prefix and hash
+func newAPIKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, apiKeyPrefixBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(buf)
+
+	secret, _, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + prefix + "_" + secret
+	return key, prefix, auth.HashOpaqueToken(key), nil
+}
+
+// parseAPIKey extracts the lookup prefix from a key
+func parseAPIKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 2*apiKeyPrefixBytes || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// APIKeyAuthenticator validates X-API-Key headers for the auth middleware
+type APIKeyAuthenticator struct {
+	repo UserRepository
+	now  func() time.Time
+}
+
+// NewAPIKeyAuthenticator creates an authenticator backed by the api_keys table
+func NewAPIKeyAuthenticator(db *gorm.DB) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{repo: NewUserRepository(db), now: time.Now}
+}
+
+// ValidateAPIKey implements middleware.APIKeyValidator. The key acts as its
+// owner with the owner's current role, limited to the key's scopes. The
+// last-used time is written at most once per apiKeyTouchInterval.
+func (a *APIKeyAuthenticator) ValidateAPIKey(key string) (*middleware.APIKeyPrincipal, error) {
+	prefix, ok := parseAPIKey(key)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey, err := a.repo.FindAPIKeyByPrefix(prefix)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("⚠️  Failed to look up API key %s: %v", prefix, err)
+		}
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := a.now()
+	if subtle.ConstantTimeCompare([]byte(auth.HashOpaqueToken(key)), []byte(apiKey.KeyHash)) != 1 || !apiKey.Active(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	owner, err := a.repo.FindByID(apiKey.UserID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("⚠️  Failed to look up owner of API key %s: %v", prefix, err)
+		}
+		return nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		if err := a.repo.TouchAPIKey(apiKey.ID, now); err != nil {
+			log.Printf("⚠️  Failed to update last-used time of API key %s: %v", prefix, err)
+		}
+	}
+
+	return &middleware.APIKeyPrincipal{
+		KeyID:  apiKey.ID,
+		UserID: owner.ID,
+		Role:   owner.Role,
+		Scopes: apiKey.ScopeList(),
+	}, nil
+}
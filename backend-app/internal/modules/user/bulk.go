@@ -0,0 +1,273 @@
+package user
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Bulk import and export formats
+const (
+	CSVType    = "text/csv"
+	NDJSONType = "application/x-ndjson"
+)
+
+// Bulk import limits
+const (
+	importBatchSize   = 500
+	importHashWorkers = 4
+	maxImportErrors   = 1000
+	maxNDJSONLine     = 64 * 1024
+	exportFlushEvery  = 100
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported format; use " + CSVType + " or " + NDJSONType)
+	ErrInvalidImport     = errors.New("invalid import file")
+)
+
+// importColumns are the CSV columns of an import; every one is required
+var importColumns = []string{"name", "email", "password"}
+
+// exportColumns are the CSV columns of an export
+var exportColumns = []string{"id", "name", "email", "role", "email_verified_at", "mfa_enabled", "created_at", "updated_at"}
+
+// RowError reports an import row that cannot be read or is invalid
+type RowError struct {
+	Row   int
+	Email string
+	Err   string
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Err)
+}
+
+// UserRows reads the users of an import one row at a time, so imports of
+// any size are never held in memory
+type UserRows interface {
+	// Next returns the next user, validated with the CreateUserRequest
+	// binding rules, and its 1-based row number. Rows that cannot be read
+	// or are invalid return a *RowError and the rows continue; io.EOF ends
+	// them, and any other error aborts the import.
+	Next() (int, *CreateUserRequest, error)
+}
+
+// NewUserRows reads an import in the format of contentType. CSV imports
+// start with a header naming the name, email and password columns.
+func NewUserRows(contentType string, r io.Reader) (UserRows, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	switch mediaType {
+	case CSVType:
+		return newCSVRows(r)
+	case NDJSONType:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 4096), maxNDJSONLine)
+		return &ndjsonRows{scanner: scanner}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// csvRows reads CSV imports
+type csvRows struct {
+	reader  *csv.Reader
+	columns map[string]int
+	row     int
+}
+
+func newCSVRows(r io.Reader) (*csvRows, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Row length is checked per row
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: missing header", ErrInvalidImport)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !slices.Contains(importColumns, name) {
+			return nil, fmt.Errorf("%w: unknown column %q; columns are %s", ErrInvalidImport, name, strings.Join(importColumns, ", "))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("%w: column %q is repeated", ErrInvalidImport, name)
+		}
+		columns[name] = i
+	}
+	for _, name := range importColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing column %q", ErrInvalidImport, name)
+		}
+	}
+
+	return &csvRows{reader: reader, columns: columns}, nil
+}
+
+// Next implements UserRows
+func (r *csvRows) Next() (int, *CreateUserRequest, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return 0, nil, io.EOF
+	}
+	r.row++
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return r.row, nil, &RowError{Row: r.row, Err: parseErr.Err.Error()}
+		}
+		return r.row, nil, err
+	}
+	if len(record) != len(r.columns) {
+		return r.row, nil, &RowError{Row: r.row, Err: fmt.Sprintf("expected %d fields, got %d", len(r.columns), len(record))}
+	}
+
+	req := &CreateUserRequest{
+		Name:     strings.TrimSpace(record[r.columns["name"]]),
+		Email:    strings.TrimSpace(record[r.columns["email"]]),
+		Password: record[r.columns["password"]],
+	}
+	return r.row, req, validateRow(r.row, req)
+}
+
+// ndjsonRows reads newline-delimited JSON imports, one object per line
+type ndjsonRows struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+// Next implements UserRows. Blank lines are skipped.
+func (r *ndjsonRows) Next() (int, *CreateUserRequest, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		r.row++
+
+		var req CreateUserRequest
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			return r.row, nil, &RowError{Row: r.row, Err: "invalid JSON: " + err.Error()}
+		}
+		return r.row, &req, validateRow(r.row, &req)
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return r.row + 1, nil, fmt.Errorf("%w: row %d is longer than %d bytes", ErrInvalidImport, r.row+1, maxNDJSONLine)
+		}
+		return 0, nil, err
+	}
+	return 0, nil, io.EOF
+}
+
+// validateRow applies the CreateUserRequest binding rules to an import row
+func validateRow(row int, req *CreateUserRequest) error {
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return &RowError{Row: row, Email: req.Email, Err: err.Error()}
+	}
+	return nil
+}
+
+// addError records a failed import row, keeping at most maxImportErrors
+func (r *ImportResult) addError(err *RowError) {
+	r.Failed++
+	if len(r.Errors) >= maxImportErrors {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, ImportRowError{Row: err.Row, Email: err.Email, Error: err.Err})
+}
+
+// UserWriter writes exported users in a bulk format
+type UserWriter interface {
+	Write(user *UserResponse) error
+	// Flush writes buffered users to the underlying writer
+	Flush() error
+}
+
+// NewUserWriter creates a writer for format, CSV or NDJSON
+func NewUserWriter(format string, w io.Writer) (UserWriter, error) {
+	switch format {
+	case CSVType:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(exportColumns); err != nil {
+			return nil, err
+		}
+		return &csvUserWriter{writer: writer}, nil
+	case NDJSONType:
+		buffered := bufio.NewWriter(w)
+		return &ndjsonUserWriter{buffered: buffered, encoder: json.NewEncoder(buffered)}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// csvUserWriter writes users as CSV rows
+type csvUserWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvUserWriter) Write(user *UserResponse) error {
+	verifiedAt := ""
+	if user.EmailVerifiedAt != nil {
+		verifiedAt = user.EmailVerifiedAt.UTC().Format(time.RFC3339)
+	}
+	return w.writer.Write([]string{
+		strconv.FormatUint(uint64(user.ID), 10),
+		csvCell(user.Name),
+		csvCell(user.Email),
+		user.Role,
+		verifiedAt,
+		strconv.FormatBool(user.MFAEnabled),
+		user.CreatedAt.UTC().Format(time.RFC3339),
+		user.UpdatedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+func (w *csvUserWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// csvCell neutralizes values that spreadsheets would evaluate as formulas
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// ndjsonUserWriter writes users as one JSON object per line
+type ndjsonUserWriter struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (w *ndjsonUserWriter) Write(user *UserResponse) error {
+	return w.encoder.Encode(user)
+}
+
+func (w *ndjsonUserWriter) Flush() error {
+	return w.buffered.Flush()
+}
@@ -0,0 +1,214 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/cache"
+	"github.com/savindaJ/backend-app/internal/database"
+	"github.com/savindaJ/backend-app/internal/query"
+)
+
+// cachedUserRepository serves the users read by ID for display through a
+// cache and invalidates them whenever the repository changes them. Only
+// the fields responses show are cached, never credentials, so users loaded
+// to be changed or to verify a password or code come from the database.
+// Code changing users outside the repository, such as erasures in another
+// module's transaction, drops them with a CacheInvalidator. Within a
+// transaction the cache is bypassed, as it must not serve or store
+// changes that may still roll back.
+type cachedUserRepository struct {
+	UserRepository
+	cache *cache.Cache
+	ctx   context.Context
+}
+
+// NewCachedUserRepository decorates repo with a read-through cache of users
+// by ID
+func NewCachedUserRepository(repo UserRepository, c *cache.Cache) UserRepository {
+	return &cachedUserRepository{UserRepository: repo, cache: c, ctx: context.Background()}
+}
+
+// WithContext returns the repository bound to ctx
+func (r *cachedUserRepository) WithContext(ctx context.Context) UserRepository {
+	return &cachedUserRepository{UserRepository: r.UserRepository.WithContext(ctx), cache: r.cache, ctx: ctx}
+}
+
+// invalidate drops users from the cache. Within a transaction they are
+// dropped again once it commits, in case a concurrent read cached the
+// previous version in between.
+func (r *cachedUserRepository) invalidate(keys ...string) {
+	r.cache.Invalidate(keys...)
+	if database.InTransaction(r.ctx) {
+		database.AfterCommit(r.ctx, func() { r.cache.Invalidate(keys...) })
+	}
+}
+
+// FindByIDWith finds a user by ID for display, from the cache when
+// possible; the user has no credentials and must not be saved. Users are
+// cached without relations, so views including any are loaded from the
+// database.
+func (r *cachedUserRepository) FindByIDWith(id uint, view *query.View) (*User, error) {
+	if view.LoadsRelations() || database.InTransaction(r.ctx) {
+		return r.UserRepository.FindByIDWith(id, view)
+	}
+
+	encoded, err := r.cache.Fetch(userCacheKey(id), func() ([]byte, error) {
+		user, err := r.UserRepository.FindByID(id)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(newCachedUser(user)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every caller decodes its own copy, so none can change another's
+	var cached cachedUser
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&cached); err != nil {
+		log.Printf("⚠️  Discarding undecodable cached user %d: %v", id, err)
+		r.cache.Invalidate(userCacheKey(id))
+		return r.UserRepository.FindByIDWith(id, view)
+	}
+	return cached.user(), nil
+}
+
+// Update saves a user and invalidates it, also when the update lost a
+// race, as the cached copy is then outdated
+func (r *cachedUserRepository) Update(user *User) error {
+	defer r.invalidate(userCacheKey(user.ID))
+	return r.UserRepository.Update(user)
+}
+
+// UpdatePassword replaces a user's password hash and invalidates the user
+func (r *cachedUserRepository) UpdatePassword(id uint, hash string) error {
+	defer r.invalidate(userCacheKey(id))
+	return r.UserRepository.UpdatePassword(id, hash)
+}
+
+// Delete soft deletes a user and invalidates it
+func (r *cachedUserRepository) Delete(user *User) error {
+	defer r.invalidate(userCacheKey(user.ID))
+	return r.UserRepository.Delete(user)
+}
+
+// Restore clears a user's soft delete and invalidates it
+func (r *cachedUserRepository) Restore(id uint) (bool, error) {
+	defer r.invalidate(userCacheKey(id))
+	return r.UserRepository.Restore(id)
+}
+
+// PurgeDeleted permanently removes deleted users and invalidates them
+func (r *cachedUserRepository) PurgeDeleted(before time.Time, limit int, hooks []PurgeHook) ([]User, error) {
+	users, err := r.UserRepository.PurgeDeleted(before, limit, hooks)
+	if len(users) > 0 {
+		keys := make([]string, len(users))
+		for i, user := range users {
+			keys[i] = userCacheKey(user.ID)
+		}
+		r.invalidate(keys...)
+	}
+	return users, err
+}
+
+// AdvanceMFAStep records the last accepted TOTP step and invalidates the user
+func (r *cachedUserRepository) AdvanceMFAStep(userID uint, step int64) (bool, error) {
+	defer r.invalidate(userCacheKey(userID))
+	return r.UserRepository.AdvanceMFAStep(userID, step)
+}
+
+// SetRoleByEmail assigns a role by email and invalidates the user
+func (r *cachedUserRepository) SetRoleByEmail(email, role string) (bool, error) {
+	found, err := r.UserRepository.SetRoleByEmail(email, role)
+	if found {
+		if user, err := r.UserRepository.FindByEmail(email); err == nil {
+			r.invalidate(userCacheKey(user.ID))
+		}
+	}
+	return found, err
+}
+
+// SaveProfile saves a user's name and profile and invalidates the user
+func (r *cachedUserRepository) SaveProfile(user *User, profile *UserProfile) error {
+	defer r.invalidate(userCacheKey(user.ID))
+	return r.UserRepository.SaveProfile(user, profile)
+}
+
+// cachedUser holds what user responses show. Password hashes, TOTP
+// secrets and email change tokens are left out, as the cache may be shared
+// with other services and is not as well protected as the database.
+type cachedUser struct {
+	ID              uint
+	Name            string
+	Email           string
+	Role            string
+	EmailVerifiedAt *time.Time
+	PendingEmail    string
+	MFAEnabled      bool
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Version         uint
+}
+
+func newCachedUser(u *User) *cachedUser {
+	return &cachedUser{
+		ID:              u.ID,
+		Name:            u.Name,
+		Email:           u.Email,
+		Role:            u.Role,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		PendingEmail:    u.PendingEmail,
+		MFAEnabled:      u.MFAEnabled,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+		Version:         u.Version,
+	}
+}
+
+func (c *cachedUser) user() *User {
+	return &User{
+		ID:              c.ID,
+		Name:            c.Name,
+		Email:           c.Email,
+		Role:            c.Role,
+		EmailVerifiedAt: c.EmailVerifiedAt,
+		PendingEmail:    c.PendingEmail,
+		MFAEnabled:      c.MFAEnabled,
+		CreatedAt:       c.CreatedAt,
+		UpdatedAt:       c.UpdatedAt,
+		Version:         c.Version,
+	}
+}
+
+// CacheInvalidator drops users from the user cache. Code that changes users
+// outside the user repository, such as purges and erasures, calls it once
+// its transaction has committed.
+type CacheInvalidator func(userIDs []uint)
+
+// NewCacheInvalidator creates the invalidator of c; without a cache it
+// does nothing
+func NewCacheInvalidator(c *cache.Cache) CacheInvalidator {
+	return func(userIDs []uint) {
+		if c == nil || len(userIDs) == 0 {
+			return
+		}
+		keys := make([]string, len(userIDs))
+		for i, id := range userIDs {
+			keys[i] = userCacheKey(id)
+		}
+		c.Invalidate(keys...)
+	}
+}
+
+func userCacheKey(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
@@ -1,26 +1,53 @@
 package user
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"github.com/savindaJ/backend-app/internal/patch"
+	"github.com/savindaJ/backend-app/internal/query"
 )
 
 // UserHandler handles HTTP requests for users
 type UserHandler struct {
-	service UserService
+	service     UserService
+	cursors     *query.Codec
+	stateTTL    time.Duration
+	secureState bool
 }
 
-// NewUserHandler creates a new user handler
-func NewUserHandler(service UserService) *UserHandler {
-	return &UserHandler{service: service}
+// users returns the service bound to the request, so that the calls of an
+// atomic batch run in its transaction
+func (h *UserHandler) users(c *gin.Context) UserService {
+	return h.service.WithContext(c.Request.Context())
+}
+
+// oidcStateCookie holds the binding of a pending sign-in to the browser
+// that started it
+const oidcStateCookie = "oidc_state"
+
+// NewUserHandler creates a new user handler. cursors signs pagination
+// cursors; stateTTL and secureState configure the sign-in state cookie.
+func NewUserHandler(service UserService, cursors *query.Codec, stateTTL time.Duration, secureState bool) *UserHandler {
+	return &UserHandler{service: service, cursors: cursors, stateTTL: stateTTL, secureState: secureState}
 }
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error" example:"error message"`
-	Details string `json:"details,omitempty" example:"additional details"`
+	Error      string   `json:"error" example:"error message"`
+	Details    string   `json:"details,omitempty" example:"additional details"`
+	Violations []string `json:"violations,omitempty" example:"must contain a digit"`
 }
 
 // SuccessResponse represents a success response
@@ -37,16 +64,36 @@ type PaginatedResponse struct {
 	TotalPages int         `json:"total_pages" example:"10"`
 }
 
+// CursorPaginatedResponse represents a keyset-paginated response. Cursors
+// are opaque and only valid with the sort order they were issued for.
+type CursorPaginatedResponse struct {
+	Data       interface{} `json:"data"`
+	Limit      int         `json:"limit" example:"10"`
+	Total      *int64      `json:"total,omitempty" example:"100"` // Only with include_total=true
+	NextCursor string      `json:"next_cursor,omitempty" example:"eyJzIjoiaWQiLCJ2IjpbInU6MTAiXX0.3q2-7w"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+	Links      PageLinks   `json:"links"`
+}
+
+// PageLinks holds the URLs of the adjacent pages
+type PageLinks struct {
+	Next string `json:"next,omitempty" example:"/api/v1/users?cursor=eyJzIjoiaWQiLCJ2IjpbInU6MTAiXX0.3q2-7w&limit=10"`
+	Prev string `json:"prev,omitempty"`
+}
+
 // Register godoc
 // @Summary      Register a new user
-// @Description  Create a new user account
+// @Description  Create a new user account. With an Idempotency-Key, retries replay the first response instead of registering again.
 // @Tags         users
 // @Accept       json
 // @Produce      json
-// @Param        request body CreateUserRequest true "User registration data"
+// @Param        Idempotency-Key  header  string             false  "Unique key making retries safe, e.g. a UUID"
+// @Param        request          body    CreateUserRequest  true   "User registration data"
 // @Success      201  {object}  UserResponse
 // @Failure      400  {object}  ErrorResponse
 // @Failure      409  {object}  ErrorResponse
+// @Failure      422  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
 // @Router       /users/register [post]
 func (h *UserHandler) Register(c *gin.Context) {
@@ -56,8 +103,11 @@ func (h *UserHandler) Register(c *gin.Context) {
 		return
 	}
 
-	user, err := h.service.Register(&req)
+	user, err := h.users(c).Register(&req, requestMeta(c))
 	if err != nil {
+		if respondPolicyViolation(c, err) {
+			return
+		}
 		if err == ErrEmailAlreadyExists {
 			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
 			return
@@ -71,7 +121,7 @@ func (h *UserHandler) Register(c *gin.Context) {
 
 // Login godoc
 // @Summary      User login
-// @Description  Authenticate a user and return a token
+// @Description  Authenticate a user and return a token. Accounts with two-factor authentication receive a challenge token to exchange at /users/login/mfa.
 // @Tags         users
 // @Accept       json
 // @Produce      json
@@ -79,6 +129,7 @@ func (h *UserHandler) Register(c *gin.Context) {
 // @Success      200  {object}  LoginResponse
 // @Failure      400  {object}  ErrorResponse
 // @Failure      401  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
 // @Router       /users/login [post]
 func (h *UserHandler) Login(c *gin.Context) {
@@ -88,8 +139,11 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := h.service.Login(&req)
+	response, err := h.users(c).Login(&req, requestMeta(c))
 	if err != nil {
+		if respondThrottled(c, err) {
+			return
+		}
 		if err == ErrInvalidCredentials {
 			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
 			return
@@ -98,160 +152,1549 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// TODO: Generate JWT token here
-	response := LoginResponse{
-		Token: "jwt-token-placeholder",
-		User:  user.ToResponse(),
+	c.JSON(http.StatusOK, response)
+}
+
+// LoginMFA godoc
+// @Summary      Complete two-factor login
+// @Description  Exchange a login challenge token and a TOTP or recovery code for an access token
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request body MFALoginRequest true "Challenge token and code"
+// @Success      200  {object}  LoginResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/login/mfa [post]
+func (h *UserHandler) LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	response, err := h.users(c).VerifyMFALogin(&req, requestMeta(c))
+	if err != nil {
+		if respondThrottled(c, err) {
+			return
+		}
+		if err == ErrInvalidCredentials || err == ErrInvalidMFACode {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Login failed"})
+		return
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// GetAll godoc
-// @Summary      Get all users
-// @Description  Retrieve all users with pagination
-// @Tags         users
+// Refresh godoc
+// @Summary      Refresh access token
+// @Description  Exchange a refresh token for a new access token and a rotated refresh token. Reusing an old refresh token revokes its session.
+// @Tags         sessions
 // @Accept       json
 // @Produce      json
-// @Param        page   query     int  false  "Page number"  default(1)
-// @Param        limit  query     int  false  "Items per page"  default(10)
-// @Success      200  {object}  PaginatedResponse
+// @Param        request body RefreshTokenRequest true "Refresh token"
+// @Success      200  {object}  LoginResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
-// @Router       /users [get]
-func (h *UserHandler) GetAll(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+// @Router       /users/token/refresh [post]
+func (h *UserHandler) Refresh(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
 
-	if page < 1 {
-		page = 1
+	response, err := h.users(c).Refresh(req.RefreshToken, requestMeta(c))
+	if err != nil {
+		if err == ErrInvalidRefreshToken {
+			c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to refresh token"})
+		return
 	}
-	if limit < 1 || limit > 100 {
-		limit = 10
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Logout godoc
+// @Summary      Log out
+// @Description  Revoke the session of the current access token
+// @Tags         sessions
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  SuccessResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/logout [post]
+func (h *UserHandler) Logout(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	if err := h.users(c).Logout(userID, middleware.SessionID(c)); err != nil {
+		if err == ErrSessionNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to log out"})
+		return
 	}
 
-	users, total, err := h.service.GetAll(page, limit)
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Logged out successfully"})
+}
+
+// ListSessions godoc
+// @Summary      List sessions
+// @Description  List the current user's active sessions (devices)
+// @Tags         sessions
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   SessionResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/sessions [get]
+func (h *UserHandler) ListSessions(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	sessions, err := h.users(c).ListSessions(userID, middleware.SessionID(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch users"})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch sessions"})
 		return
 	}
 
-	totalPages := int(total) / limit
-	if int(total)%limit != 0 {
-		totalPages++
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary      Revoke session
+// @Description  Sign out one of the current user's sessions
+// @Tags         sessions
+// @Produce      json
+// @Security     BearerAuth
+// @Param        sessionId  path      string  true  "Session ID"
+// @Success      200  {object}  SuccessResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/sessions/{sessionId} [delete]
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	if err := h.users(c).RevokeSession(userID, c.Param("sessionId")); err != nil {
+		if err == ErrSessionNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke session"})
+		return
 	}
 
-	c.JSON(http.StatusOK, PaginatedResponse{
-		Data:       users,
-		Total:      total,
-		Page:       page,
-		Limit:      limit,
-		TotalPages: totalPages,
-	})
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Session revoked successfully"})
 }
 
-// GetByID godoc
-// @Summary      Get user by ID
-// @Description  Retrieve a user by their ID
-// @Tags         users
-// @Accept       json
+// RevokeAllSessions godoc
+// @Summary      Log out everywhere
+// @Description  Revoke all of the current user's sessions, including the current one
+// @Tags         sessions
 // @Produce      json
-// @Param        id   path      int  true  "User ID"
-// @Success      200  {object}  UserResponse
+// @Security     BearerAuth
+// @Success      200  {object}  SuccessResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/sessions [delete]
+func (h *UserHandler) RevokeAllSessions(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	if err := h.users(c).RevokeAllSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Logged out of all sessions"})
+}
+
+// OIDCLogin godoc
+// @Summary      Sign in with an identity provider
+// @Description  Start an OpenID Connect authorization code flow (with PKCE) and redirect to the provider. Sets a short-lived cookie the callback requires, so the flow must complete in the same browser.
+// @Tags         identities
+// @Produce      json
+// @Param        provider  path  string  true  "Provider name"  example(google)
+// @Success      302
+// @Failure      404  {object}  ErrorResponse
+// @Failure      502  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/oidc/{provider}/login [get]
+func (h *UserHandler) OIDCLogin(c *gin.Context) {
+	response, err := h.users(c).StartOIDCLogin(c.Request.Context(), c.Param("provider"), nil)
+	if err != nil {
+		respondOIDCError(c, err, "Failed to start sign-in")
+		return
+	}
+
+	h.setOIDCStateCookie(c, response.Binding, int(h.stateTTL.Seconds()))
+	c.Redirect(http.StatusFound, response.AuthorizationURL)
+}
+
+// OIDCCallback godoc
+// @Summary      Identity provider callback
+// @Description  Complete an OpenID Connect sign-in. Requires the state cookie set when the flow started. Signs in the user linked to the identity, links it to the account with the same verified email, or creates an account. When a signed-in user started the flow to link an identity, the linked identity is returned instead.
+// @Tags         identities
+// @Produce      json
+// @Param        provider  path   string  true   "Provider name"
+// @Param        code      query  string  false  "Authorization code"
+// @Param        state     query  string  true   "State issued when the flow started"
+// @Param        error     query  string  false  "Error reported by the provider"
+// @Success      200  {object}  LoginResponse
+// @Success      201  {object}  IdentityResponse
 // @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/oidc/{provider}/callback [get]
+func (h *UserHandler) OIDCCallback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: ErrOIDCLoginFailed.Error(), Details: providerErr})
+		return
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: "code and state are required"})
+		return
+	}
+
+	cookie, _ := c.Cookie(oidcStateCookie)
+	h.setOIDCStateCookie(c, "", -1)
+	result, err := h.users(c).CompleteOIDCLogin(c.Request.Context(), c.Param("provider"), state, cookie, code, requestMeta(c))
+	if err != nil {
+		respondOIDCError(c, err, "Failed to complete sign-in")
+		return
+	}
+
+	if result.Identity != nil {
+		c.JSON(http.StatusCreated, result.Identity)
+		return
+	}
+	c.JSON(http.StatusOK, result.Login)
+}
+
+// LinkIdentity godoc
+// @Summary      Link identity
+// @Description  Start an OpenID Connect flow that links a provider identity to the current user. Send the user agent to the returned URL; it must keep the state cookie set by this response, which the callback requires.
+// @Tags         identities
+// @Produce      json
+// @Security     BearerAuth
+// @Param        provider  path  string  true  "Provider name"
+// @Success      200  {object}  OIDCAuthorizationResponse
+// @Failure      401  {object}  ErrorResponse
 // @Failure      404  {object}  ErrorResponse
+// @Failure      502  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
-// @Router       /users/{id} [get]
-func (h *UserHandler) GetByID(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+// @Router       /users/me/identities/{provider} [post]
+func (h *UserHandler) LinkIdentity(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	response, err := h.users(c).StartOIDCLogin(c.Request.Context(), c.Param("provider"), &userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		respondOIDCError(c, err, "Failed to start linking")
 		return
 	}
 
-	user, err := h.service.GetByID(uint(id))
+	h.setOIDCStateCookie(c, response.Binding, int(h.stateTTL.Seconds()))
+	c.JSON(http.StatusOK, response)
+}
+
+// ListIdentities godoc
+// @Summary      List linked identities
+// @Description  List the external identities linked to the current user
+// @Tags         identities
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   IdentityResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/identities [get]
+func (h *UserHandler) ListIdentities(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	identities, err := h.users(c).ListIdentities(userID)
 	if err != nil {
-		if err == ErrUserNotFound {
-			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch user"})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch identities"})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, identities)
 }
 
-// Update godoc
-// @Summary      Update user
-// @Description  Update an existing user
-// @Tags         users
-// @Accept       json
+// UnlinkIdentity godoc
+// @Summary      Unlink identity
+// @Description  Remove a linked identity. The last identity of an account without a password cannot be removed.
+// @Tags         identities
 // @Produce      json
-// @Param        id      path      int                true  "User ID"
-// @Param        request body      UpdateUserRequest  true  "User update data"
-// @Success      200  {object}  UserResponse
+// @Security     BearerAuth
+// @Param        identityId  path      int  true  "Identity ID"
+// @Success      200  {object}  SuccessResponse
 // @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
 // @Failure      404  {object}  ErrorResponse
 // @Failure      409  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
-// @Router       /users/{id} [put]
-func (h *UserHandler) Update(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+// @Router       /users/me/identities/{identityId} [delete]
+func (h *UserHandler) UnlinkIdentity(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	identityID, err := strconv.ParseUint(c.Param("identityId"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid identity ID"})
 		return
 	}
 
-	var req UpdateUserRequest
+	if err := h.users(c).UnlinkIdentity(userID, uint(identityID), requestMeta(c)); err != nil {
+		switch err {
+		case ErrIdentityNotFound, ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		case ErrLastLoginMethod:
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to unlink identity"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Identity unlinked successfully"})
+}
+
+// CreateAPIKey godoc
+// @Summary      Create API key
+// @Description  Create a personal API key for scripts, sent as the X-API-Key header. The key is only shown in this response.
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body CreateAPIKeyRequest true "Key name, scopes and optional expiry"
+// @Success      201  {object}  APIKeyCreatedResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/api-keys [post]
+func (h *UserHandler) CreateAPIKey(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	var req CreateAPIKeyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
 		return
 	}
 
-	user, err := h.service.Update(uint(id), &req)
+	key, err := h.users(c).CreateAPIKey(userID, &req, requestMeta(c))
 	if err != nil {
-		if err == ErrUserNotFound {
+		switch err {
+		case ErrInvalidAPIKeyExpiry:
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		case ErrTooManyAPIKeys:
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		case ErrUserNotFound:
 			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
-			return
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create API key"})
 		}
-		if err == ErrEmailAlreadyExists {
-			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+// ListAPIKeys godoc
+// @Summary      List API keys
+// @Description  List the current user's active API keys
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   APIKeyResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/api-keys [get]
+func (h *UserHandler) ListAPIKeys(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	keys, err := h.users(c).ListAPIKeys(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey godoc
+// @Summary      Revoke API key
+// @Description  Revoke one of the current user's API keys
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Param        keyId  path      int  true  "API key ID"
+// @Success      200  {object}  SuccessResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/api-keys/{keyId} [delete]
+func (h *UserHandler) RevokeAPIKey(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	keyID, err := strconv.ParseUint(c.Param("keyId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid API key ID"})
+		return
+	}
+
+	if err := h.users(c).RevokeAPIKey(userID, uint(keyID), requestMeta(c)); err != nil {
+		if err == ErrAPIKeyNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update user"})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke API key"})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, SuccessResponse{Message: "API key revoked successfully"})
 }
 
-// Delete godoc
-// @Summary      Delete user
-// @Description  Delete a user by ID
+// Unlock godoc
+// @Summary      Unlock account
+// @Description  Clear failed-login lockout for a user, optionally also for a client IP (admin only)
 // @Tags         users
 // @Accept       json
 // @Produce      json
-// @Param        id   path      int  true  "User ID"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        id      path      int            true   "User ID"
+// @Param        request body      UnlockRequest  false  "IP to unlock"
 // @Success      200  {object}  SuccessResponse
 // @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
 // @Failure      404  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
-// @Router       /users/{id} [delete]
-func (h *UserHandler) Delete(c *gin.Context) {
+// @Router       /users/{id}/unlock [post]
+func (h *UserHandler) Unlock(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
 		return
 	}
 
-	if err := h.service.Delete(uint(id)); err != nil {
+	var req UnlockRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+			return
+		}
+	}
+
+	actorID, _ := middleware.UserID(c)
+	if err := h.users(c).Unlock(actorID, uint(id), req.IP, requestMeta(c)); err != nil {
 		if err == ErrUserNotFound {
 			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete user"})
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to unlock user"})
 		return
 	}
 
-	c.JSON(http.StatusOK, SuccessResponse{Message: "User deleted successfully"})
+	c.JSON(http.StatusOK, SuccessResponse{Message: "User unlocked successfully"})
+}
+
+// Import godoc
+// @Summary      Import users
+// @Description  Create users in bulk from a CSV file with a name,email,password header or from NDJSON objects shaped like CreateUserRequest. The file is read row by row; every row is validated like a registration and valid rows are created in batches, each in its own transaction. Invalid rows and emails already taken are reported and skipped. Admin only.
+// @Tags         users
+// @Accept       text/csv
+// @Accept       application/x-ndjson
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        dry_run  query     bool    false  "Only validate the rows; create nothing"
+// @Param        request  body      string  true   "CSV or NDJSON rows"
+// @Success      200  {object}  ImportResult
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      415  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/import [post]
+func (h *UserHandler) Import(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid query", Details: "dry_run must be true or false"})
+		return
+	}
+
+	rows, err := NewUserRows(c.GetHeader("Content-Type"), c.Request.Body)
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	actorID, _ := middleware.UserID(c)
+	result, err := h.users(c).Import(actorID, rows, dryRun, requestMeta(c))
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// respondImportError maps errors that abort an import to responses
+func respondImportError(c *gin.Context, err error) {
+	switch {
+	case err == ErrUnsupportedFormat:
+		c.JSON(http.StatusUnsupportedMediaType, ErrorResponse{Error: err.Error()})
+	case errors.Is(err, ErrInvalidImport):
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid import", Details: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to import users"})
+	}
+}
+
+// Export godoc
+// @Summary      Export users
+// @Description  Stream every user matching the listing filters as CSV or NDJSON, ordered by ID. Users are read in batches, so exports of any size are not held in memory. Admin only.
+// @Tags         users
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        format          query     string  false  "Export format"  Enums(csv, ndjson)  default(csv)
+// @Param        q               query     string  false  "Search name and email"
+// @Param        email           query     string  false  "Filter by email substring"
+// @Param        role            query     string  false  "Filter by role; comma-separated for several"  Enums(user, admin)
+// @Param        verified        query     bool    false  "Filter by whether the email is verified"
+// @Param        created_from    query     string  false  "Created at or after this RFC 3339 timestamp or date"
+// @Param        created_before  query     string  false  "Created before this RFC 3339 timestamp or date"
+// @Success      200  {file}    file
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/export [get]
+func (h *UserHandler) Export(c *gin.Context) {
+	formats := map[string]string{"csv": CSVType, "ndjson": NDJSONType}
+	format := c.DefaultQuery("format", "csv")
+	contentType, ok := formats[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid query", Details: "format must be csv or ndjson"})
+		return
+	}
+	q, err := userListSpec.Parse(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid query", Details: err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="users.`+format+`"`)
+	c.Header("Cache-Control", "no-store")
+	writer, err := NewUserWriter(contentType, c.Writer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to export users"})
+		return
+	}
+
+	written := 0
+	err = h.users(c).Export(q, func(user *UserResponse) error {
+		if err := writer.Write(user); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Disposition", "")
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to export users"})
+			return
+		}
+		// The response has started; the truncated body is all that can signal the failure
+		log.Printf("⚠️  User export failed after %d users: %v", written, err)
+		c.Abort()
+	}
+}
+
+// ListDeleted godoc
+// @Summary      List deleted users
+// @Description  Retrieve soft-deleted users, most recently deleted first, with the time each will be purged (admin only)
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        page   query     int  false  "Page number"  default(1)
+// @Param        limit  query     int  false  "Items per page"  default(10)
+// @Success      200  {object}  PaginatedResponse{data=[]DeletedUserResponse}
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/deleted [get]
+func (h *UserHandler) ListDeleted(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	users, total, err := h.users(c).ListDeleted(page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch deleted users"})
+		return
+	}
+
+	totalPages := int(total) / limit
+	if int(total)%limit != 0 {
+		totalPages++
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Data:       users,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: totalPages,
+	})
+}
+
+// Restore godoc
+// @Summary      Restore deleted user
+// @Description  Undo the deletion of a user that has not been purged yet (admin only)
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  UserResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/{id}/restore [post]
+func (h *UserHandler) Restore(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	actorID, _ := middleware.UserID(c)
+	user, err := h.users(c).Restore(actorID, uint(id), requestMeta(c))
+	if err != nil {
+		switch err {
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "deleted user not found"})
+		case ErrEmailAlreadyExists:
+			c.JSON(http.StatusConflict, ErrorResponse{Error: "Cannot restore user", Details: "another account now uses this email"})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to restore user"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// EnrollMFA godoc
+// @Summary      Start two-factor enrollment
+// @Description  Generate a TOTP secret for the current user, returned as an otpauth:// URI and a base64 QR code PNG
+// @Tags         mfa
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  MFAEnrollResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/mfa/enroll [post]
+func (h *UserHandler) EnrollMFA(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	response, err := h.users(c).EnrollMFA(userID)
+	if err != nil {
+		if err == ErrMFAAlreadyEnabled {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ConfirmMFA godoc
+// @Summary      Confirm two-factor enrollment
+// @Description  Enable two-factor authentication with a first valid code. Returns recovery codes that are shown only once.
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body MFACodeRequest true "TOTP code"
+// @Success      200  {object}  MFARecoveryCodesResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/mfa/confirm [post]
+func (h *UserHandler) ConfirmMFA(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	response, err := h.users(c).ConfirmMFA(userID, req.Code)
+	if err != nil {
+		switch err {
+		case ErrInvalidMFACode, ErrMFANotEnrolled:
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		case ErrMFAAlreadyEnabled:
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to confirm enrollment"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DisableMFA godoc
+// @Summary      Disable two-factor authentication
+// @Description  Turn off two-factor authentication for the current user after verifying a TOTP code
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request body MFACodeRequest true "TOTP code"
+// @Success      200  {object}  SuccessResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me/mfa/disable [post]
+func (h *UserHandler) DisableMFA(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := h.users(c).DisableMFA(userID, req.Code); err != nil {
+		switch err {
+		case ErrInvalidMFACode, ErrMFANotEnabled:
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to disable two-factor authentication"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Two-factor authentication disabled"})
+}
+
+// GetAll godoc
+// @Summary      Get all users
+// @Description  Retrieve users with filtering, sorting and free-text search on name and email. Pages are selected with page and limit, or, when the cursor parameter is present (empty for the first page), with keyset pagination, which returns a CursorPaginatedResponse with next and previous cursors and links instead. Admin only.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        page            query     int     false  "Page number"  default(1)
+// @Param        limit           query     int     false  "Items per page"  default(10)
+// @Param        cursor          query     string  false  "Keyset pagination cursor from next_cursor or prev_cursor; empty for the first page"
+// @Param        include_total   query     bool    false  "Count the matching users in keyset pagination"
+// @Param        q               query     string  false  "Search name and email"
+// @Param        email           query     string  false  "Email contains"
+// @Param        role            query     string  false  "Comma-separated roles"  example(admin,user)
+// @Param        verified        query     bool    false  "Email verified"
+// @Param        created_from    query     string  false  "Created at or after (RFC 3339 or date)"  example(2024-01-01)
+// @Param        created_before  query     string  false  "Created before (RFC 3339 or date)"  example(2024-02-01)
+// @Param        sort            query     string  false  "Comma-separated sort fields, prefixed with - for descending: id, name, email, role, created_at, updated_at"  default(id)  example(-created_at,name)
+// @Param        fields          query     string  false  "Comma-separated attributes to return; id is always returned"  example(id,name,email)
+// @Param        include         query     string  false  "Comma-separated related resources to embed: profile"  example(profile)
+// @Success      200  {object}  PaginatedResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users [get]
+func (h *UserHandler) GetAll(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	q, err := userListSpec.Parse(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid query", Details: err.Error()})
+		return
+	}
+	view, ok := parseView(c)
+	if !ok {
+		return
+	}
+
+	if token, ok := c.GetQuery(query.CursorParam); ok {
+		h.getPage(c, q, view, token, limit)
+		return
+	}
+
+	users, total, err := h.users(c).GetAll(q, view, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch users"})
+		return
+	}
+	data, err := view.RenderAll(users)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch users"})
+		return
+	}
+
+	totalPages := int(total) / limit
+	if int(total)%limit != 0 {
+		totalPages++
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Data:       data,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: totalPages,
+	})
+}
+
+// getPage responds with a keyset-paginated page of users
+func (h *UserHandler) getPage(c *gin.Context, q *query.Query, view *query.View, token string, limit int) {
+	cursor, err := h.cursors.Decode(token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid query", Details: err.Error()})
+		return
+	}
+	count, _ := strconv.ParseBool(c.Query("include_total"))
+
+	users, page, err := h.users(c).GetPage(q, view, cursor, limit, count)
+	if err != nil {
+		if err == query.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid query", Details: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch users"})
+		return
+	}
+	data, err := view.RenderAll(users)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch users"})
+		return
+	}
+
+	response := CursorPaginatedResponse{Data: data, Limit: limit, Total: page.Total}
+	if page.Next != nil {
+		response.NextCursor = h.cursors.Encode(page.Next)
+	}
+	if page.Prev != nil {
+		response.PrevCursor = h.cursors.Encode(page.Prev)
+	}
+	response.Links.Next, response.Links.Prev = h.cursors.Links(c.Request.URL, page)
+	c.JSON(http.StatusOK, response)
+}
+
+// GetByID godoc
+// @Summary      Get user by ID
+// @Description  Retrieve a user by their ID. Admins may read any user; other callers only themselves.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        id             path      int     true   "User ID"
+// @Param        fields         query     string  false  "Comma-separated attributes to return; id is always returned"  example(id,name,email)
+// @Param        include        query     string  false  "Comma-separated related resources to embed: profile"  example(profile)
+// @Param        If-None-Match  header    string  false  "ETag of a cached copy; 304 is returned while it is current"
+// @Success      200  {object}  UserResponse
+// @Header       200  {string}  ETag  "Version of the user"
+// @Success      304  "Not modified"
+// @Failure      400  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/{id} [get]
+func (h *UserHandler) GetByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+	// Other users' accounts are not revealed to non-admins, not even whether
+	// they exist
+	if userID, _ := middleware.UserID(c); middleware.Role(c) != RoleAdmin && uint(id) != userID {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: ErrUserNotFound.Error()})
+		return
+	}
+
+	view, ok := parseView(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.users(c).GetByIDWith(uint(id), view)
+	if err != nil {
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch user"})
+		return
+	}
+	if middleware.NotModified(c, middleware.ETag(user.Version)) {
+		return
+	}
+	data, err := view.Render(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, data)
+}
+
+// parseView parses the fields and include parameters, writing a 400 and
+// returning false when they are invalid
+func parseView(c *gin.Context) (*query.View, bool) {
+	view, err := userViewSpec.Parse(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid query", Details: err.Error()})
+		return nil, false
+	}
+	return view, true
+}
+
+// Update godoc
+// @Summary      Update user
+// @Description  Update an existing user. If-Match must carry the ETag of the user's current version, as returned by GET, or * to update regardless; a stale ETag fails with 412. Admin only.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        id        path      int                true  "User ID"
+// @Param        If-Match  header    string             true  "ETag of the version being updated, or *"
+// @Param        request   body      UpdateUserRequest  true  "User update data"
+// @Success      200  {object}  UserResponse
+// @Header       200  {string}  ETag  "New version of the user"
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      412  {object}  ErrorResponse
+// @Failure      428  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/{id} [put]
+func (h *UserHandler) Update(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	versions, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	actorID, _ := middleware.UserID(c)
+	user, err := h.users(c).Update(actorID, uint(id), versions, &req, requestMeta(c))
+	if err != nil {
+		switch err {
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		case ErrVersionMismatch:
+			c.JSON(http.StatusPreconditionFailed, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update user"})
+		}
+		return
+	}
+
+	c.Header("ETag", middleware.ETag(user.Version))
+	c.JSON(http.StatusOK, user)
+}
+
+// Patch godoc
+// @Summary      Patch user
+// @Description  Partially update a user's name and profile with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) applied to the user's UserPatchDocument. Removing a profile field clears it. The patched document must pass the same validation as an update; a failed JSON Patch test operation returns 409. If-Match must carry the ETag of the user's current version, or * to patch regardless. Admin only.
+// @Tags         users
+// @Accept       application/merge-patch+json
+// @Accept       application/json-patch+json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        id        path      int                true  "User ID"
+// @Param        If-Match  header    string             true  "ETag of the version being patched, or *"
+// @Param        request   body      UserPatchDocument  true  "Merge patch, or an array of JSON Patch operations"
+// @Success      200  {object}  CurrentUserResponse
+// @Header       200  {string}  ETag  "New version of the user"
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      412  {object}  ErrorResponse
+// @Failure      415  {object}  ErrorResponse
+// @Failure      422  {object}  ErrorResponse
+// @Failure      428  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/{id} [patch]
+func (h *UserHandler) Patch(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	versions, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+	p, err := patch.Parse(c.GetHeader("Content-Type"), body)
+	if err != nil {
+		if err == patch.ErrUnsupportedType {
+			c.JSON(http.StatusUnsupportedMediaType, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid patch", Details: err.Error()})
+		return
+	}
+
+	user, err := h.users(c).GetProfile(uint(id))
+	if err != nil {
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update user"})
+		return
+	}
+	if versions != nil && !slices.Contains(versions, user.Version) {
+		c.JSON(http.StatusPreconditionFailed, ErrorResponse{Error: ErrVersionMismatch.Error()})
+		return
+	}
+
+	document, err := json.Marshal(patchDocument(user))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update user"})
+		return
+	}
+	patched, err := p.Apply(document)
+	if err != nil {
+		if errors.Is(err, patch.ErrTestFailed) {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: "Patch test failed", Details: err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: "Patch cannot be applied", Details: err.Error()})
+		return
+	}
+
+	var result UserPatchDocument
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: "Invalid patched user", Details: err.Error()})
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&result); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: "Invalid patched user", Details: err.Error()})
+		return
+	}
+
+	// Pin the update to the version the patch was applied to, so a
+	// concurrent change is reported rather than patched blindly
+	actorID, _ := middleware.UserID(c)
+	updated, err := h.users(c).UpdateProfile(actorID, uint(id), []uint{user.Version}, result.updateRequest(), requestMeta(c))
+	if err != nil {
+		switch err {
+		case ErrInvalidLocale, ErrInvalidTimezone, ErrInvalidAvatarURL:
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: "Invalid patched user", Details: err.Error()})
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		case ErrVersionMismatch:
+			c.JSON(http.StatusPreconditionFailed, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update user"})
+		}
+		return
+	}
+
+	c.Header("ETag", middleware.ETag(updated.Version))
+	c.JSON(http.StatusOK, updated)
+}
+
+// requireIfMatch reads the If-Match header, writing a 428 and returning
+// false when it is missing so updates cannot silently overwrite each other
+func requireIfMatch(c *gin.Context) ([]uint, bool) {
+	versions, ok := middleware.IfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionRequired, ErrorResponse{
+			Error:   "If-Match header is required",
+			Details: "send the ETag returned when the user was retrieved, or * to skip the check",
+		})
+		return nil, false
+	}
+	return versions, true
+}
+
+// ChangePassword godoc
+// @Summary      Change password
+// @Description  Change the current user's password. Requires the current password.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id      path      int                    true  "User ID"
+// @Param        request body      ChangePasswordRequest  true  "Current and new password"
+// @Success      200  {object}  SuccessResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/{id}/password [put]
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	id, ok := selfID(c)
+	if !ok {
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := h.users(c).ChangePassword(id, &req, requestMeta(c)); err != nil {
+		if respondThrottled(c, err) || respondPolicyViolation(c, err) {
+			return
+		}
+		switch err {
+		case ErrInvalidCurrentPassword:
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to change password"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Password changed successfully"})
+}
+
+// ChangeEmail godoc
+// @Summary      Request email change
+// @Description  Start changing the current user's email. Requires the current password; a confirmation token is sent to the new address.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id      path      int                 true  "User ID"
+// @Param        request body      ChangeEmailRequest  true  "New email and current password"
+// @Success      202  {object}  SuccessResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/{id}/email [post]
+func (h *UserHandler) ChangeEmail(c *gin.Context) {
+	id, ok := selfID(c)
+	if !ok {
+		return
+	}
+
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := h.users(c).RequestEmailChange(id, &req, requestMeta(c)); err != nil {
+		if respondThrottled(c, err) {
+			return
+		}
+		switch err {
+		case ErrSameEmail:
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		case ErrInvalidCurrentPassword:
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		case ErrEmailAlreadyExists:
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to request email change"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, SuccessResponse{Message: "Confirmation sent to the new email address"})
+}
+
+// ConfirmEmailChange godoc
+// @Summary      Confirm email change
+// @Description  Apply a pending email change using the token sent to the new address
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        request body ConfirmEmailChangeRequest true "Confirmation token"
+// @Success      200  {object}  UserResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/email/confirm [post]
+func (h *UserHandler) ConfirmEmailChange(c *gin.Context) {
+	var req ConfirmEmailChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	user, err := h.users(c).ConfirmEmailChange(req.Token, requestMeta(c))
+	if err != nil {
+		switch err {
+		case ErrInvalidEmailToken:
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		case ErrEmailAlreadyExists:
+			c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to confirm email change"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Delete godoc
+// @Summary      Delete user
+// @Description  Delete a user by ID. If-Match must carry the ETag of the user's current version, or * to delete regardless. Admin only.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        id        path      int     true  "User ID"
+// @Param        If-Match  header    string  true  "ETag of the version being deleted, or *"
+// @Success      200  {object}  SuccessResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      412  {object}  ErrorResponse
+// @Failure      428  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/{id} [delete]
+func (h *UserHandler) Delete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		return
+	}
+
+	versions, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	actorID, _ := middleware.UserID(c)
+	if err := h.users(c).Delete(actorID, uint(id), versions, requestMeta(c)); err != nil {
+		switch err {
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		case ErrVersionMismatch:
+			c.JSON(http.StatusPreconditionFailed, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete user"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "User deleted successfully"})
+}
+
+// GetMe godoc
+// @Summary      Get current user
+// @Description  Retrieve the authenticated user with their profile
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        If-None-Match  header    string  false  "ETag of a cached copy; 304 is returned while it is current"
+// @Success      200  {object}  CurrentUserResponse
+// @Header       200  {string}  ETag  "Version of the user"
+// @Success      304  "Not modified"
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me [get]
+func (h *UserHandler) GetMe(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	user, err := h.users(c).GetProfile(userID)
+	if err != nil {
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to fetch user"})
+		return
+	}
+	if middleware.NotModified(c, middleware.ETag(user.Version)) {
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateMe godoc
+// @Summary      Update current user
+// @Description  Update the authenticated user's name and profile. Omitted profile fields are left unchanged; empty strings clear them. If-Match must carry the ETag of the user's current version, as returned by GET /users/me, or * to update regardless; a stale ETag fails with 412.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Param        If-Match  header    string                true  "ETag of the version being updated, or *"
+// @Param        request   body      UpdateProfileRequest  true  "Profile update data"
+// @Success      200  {object}  CurrentUserResponse
+// @Header       200  {string}  ETag  "New version of the user"
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      412  {object}  ErrorResponse
+// @Failure      428  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me [put]
+func (h *UserHandler) UpdateMe(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	versions, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	user, err := h.users(c).UpdateProfile(userID, userID, versions, &req, requestMeta(c))
+	if err != nil {
+		switch err {
+		case ErrInvalidLocale, ErrInvalidTimezone, ErrInvalidAvatarURL:
+			c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		case ErrVersionMismatch:
+			c.JSON(http.StatusPreconditionFailed, ErrorResponse{Error: err.Error()})
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update user"})
+		}
+		return
+	}
+
+	c.Header("ETag", middleware.ETag(user.Version))
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteMe godoc
+// @Summary      Delete current user
+// @Description  Delete the authenticated user's account and sign out all of their sessions. Requires the current password. If-Match must carry the ETag of the user's current version, or * to delete regardless.
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        If-Match  header    string                true  "ETag of the version being deleted, or *"
+// @Param        request   body      DeleteAccountRequest  true  "Current password"
+// @Success      200  {object}  SuccessResponse
+// @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
+// @Failure      403  {object}  ErrorResponse
+// @Failure      404  {object}  ErrorResponse
+// @Failure      412  {object}  ErrorResponse
+// @Failure      428  {object}  ErrorResponse
+// @Failure      429  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Router       /users/me [delete]
+func (h *UserHandler) DeleteMe(c *gin.Context) {
+	userID, _ := middleware.UserID(c)
+
+	versions, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request", Details: err.Error()})
+		return
+	}
+
+	if err := h.users(c).DeleteAccount(userID, versions, req.Password, requestMeta(c)); err != nil {
+		if respondThrottled(c, err) {
+			return
+		}
+		switch err {
+		case ErrInvalidCurrentPassword:
+			c.JSON(http.StatusForbidden, ErrorResponse{Error: err.Error()})
+		case ErrVersionMismatch:
+			c.JSON(http.StatusPreconditionFailed, ErrorResponse{Error: err.Error()})
+		case ErrUserNotFound:
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete user"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "User deleted successfully"})
+}
+
+// selfID parses the :id path parameter and ensures it is the caller's own
+// ID, writing the error response and returning false otherwise
+func selfID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid user ID"})
+		return 0, false
+	}
+
+	userID, _ := middleware.UserID(c)
+	if uint(id) != userID {
+		c.JSON(http.StatusForbidden, ErrorResponse{Error: "You can only change your own account"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// requestMeta extracts client details used for throttling, sessions and auditing
+func requestMeta(c *gin.Context) RequestMeta {
+	return RequestMeta{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: middleware.RequestID(c),
+		SessionID: middleware.SessionID(c),
+	}
+}
+
+// respondPolicyViolation writes a 400 listing password policy violations
+func respondPolicyViolation(c *gin.Context, err error) bool {
+	var policyErr *auth.PolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+
+	c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Password does not meet policy", Violations: policyErr.Violations})
+	return true
+}
+
+// respondThrottled writes a 429 with Retry-After when err is a login throttle
+func respondThrottled(c *gin.Context, err error) bool {
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	c.JSON(http.StatusTooManyRequests, ErrorResponse{Error: throttled.Error()})
+	return true
+}
+
+// setOIDCStateCookie sets or, with a negative maxAge, clears the cookie
+// binding a sign-in to the browser. Lax lets it through on the provider's
+// top-level redirect back to the callback.
+func (h *UserHandler) setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, "/", "", h.secureState, true)
+}
+
+// respondOIDCError maps OpenID Connect flow errors to HTTP responses
+func respondOIDCError(c *gin.Context, err error, fallback string) {
+	switch err {
+	case ErrUnknownProvider, ErrUserNotFound:
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: err.Error()})
+	case ErrInvalidOIDCState, ErrIdentityEmailMissing:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+	case ErrOIDCLoginFailed:
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: err.Error()})
+	case ErrProviderUnavailable:
+		c.JSON(http.StatusBadGateway, ErrorResponse{Error: err.Error()})
+	case ErrIdentityEmailConflict, ErrIdentityAlreadyLinked:
+		c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: fallback})
+	}
 }
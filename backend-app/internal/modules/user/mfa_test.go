@@ -0,0 +1,79 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/config"
+	"gorm.io/gorm"
+)
+
+// mfaRepository keeps one user in memory, guarding Update by version as the
+// database does
+type mfaRepository struct {
+	UserRepository
+	user User
+}
+
+func (r *mfaRepository) WithContext(context.Context) UserRepository {
+	return r
+}
+
+func (r *mfaRepository) FindByID(id uint) (*User, error) {
+	if id != r.user.ID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	user := r.user
+	return &user, nil
+}
+
+func (r *mfaRepository) Update(user *User) error {
+	if user.Version != r.user.Version {
+		return ErrVersionMismatch
+	}
+	user.Version++
+	r.user = *user
+	return nil
+}
+
+func (r *mfaRepository) AdvanceMFAStep(userID uint, step int64) (bool, error) {
+	if r.user.MFALastStep >= step {
+		return false, nil
+	}
+	r.user.MFALastStep = step
+	r.user.Version++
+	return true, nil
+}
+
+func (r *mfaRepository) ReplaceRecoveryCodes(uint, []string) error { return nil }
+func (r *mfaRepository) DeleteRecoveryCodes(uint) error            { return nil }
+
+func TestMFAKeepsAcceptedStep(t *testing.T) {
+	repo := &mfaRepository{user: User{ID: 1, Email: "ada@example.com", MFASecret: rfcSecret, Version: 3}}
+	cfg := &config.Config{JWTAccessTTL: 15 * time.Minute, JWTRefreshTTL: time.Hour}
+	service := NewUserService(repo, auth.NewTokenManager("test-secret", "test"), nil, cfg, discardRecorder{}, nil, nil, nil)
+
+	step := time.Now().Unix() / 30
+	code := hotp([]byte("12345678901234567890"), uint64(step))
+	if _, err := service.ConfirmMFA(1, code); err != nil {
+		t.Fatalf("ConfirmMFA() = %v", err)
+	}
+	if !repo.user.MFAEnabled || repo.user.MFALastStep != step || repo.user.Version != 5 {
+		t.Fatalf("user = enabled %v, step %d, version %d, want enabled at step %d and version 5",
+			repo.user.MFAEnabled, repo.user.MFALastStep, repo.user.Version, step)
+	}
+
+	// The code of the step confirmed with is spent
+	if err := service.DisableMFA(1, code); err != ErrInvalidMFACode {
+		t.Fatalf("DisableMFA() with a used code = %v, want %v", err, ErrInvalidMFACode)
+	}
+	code = hotp([]byte("12345678901234567890"), uint64(step+1))
+	if err := service.DisableMFA(1, code); err != nil {
+		t.Fatalf("DisableMFA() = %v", err)
+	}
+	if repo.user.MFAEnabled || repo.user.Version != 7 {
+		t.Errorf("user = enabled %v, version %d, want disabled at version 7", repo.user.MFAEnabled, repo.user.Version)
+	}
+}
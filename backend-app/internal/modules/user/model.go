@@ -1,20 +1,87 @@
 package user
 
 import (
+	"strings"
 	"time"
 
+	"github.com/savindaJ/backend-app/internal/query"
 	"gorm.io/gorm"
 )
 
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// userListSpec declares the filters, sort fields and search accepted by the
+// user listing
+var userListSpec = query.Spec{
+	Filters: map[string]query.Filter{
+		"email":          {Column: "email", Op: query.Contains},
+		"role":           {Column: "role", Op: query.Equal, Allowed: []string{RoleUser, RoleAdmin}},
+		"verified":       {Column: "email_verified_at", Op: query.Present},
+		"created_from":   {Column: "created_at", Op: query.From},
+		"created_before": {Column: "created_at", Op: query.Before},
+	},
+	Sorts: map[string]string{
+		"id":         "id",
+		"name":       "name",
+		"email":      "email",
+		"role":       "role",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	},
+	Search:      []string{"name", "email"},
+	DefaultSort: "id",
+	TieBreaker:  "id",
+}
+
+// userViewSpec declares the attributes clients may select with fields= and
+// the relations they may embed with include= in user responses
+var userViewSpec = query.NewViewSpec(UserResponse{}, map[string]string{
+	"profile": "Profile",
+})
+
 // User represents a user in the system
 type User struct {
 	ID        uint           `gorm:"primaryKey" json:"id" example:"1"`
 	Name      string         `gorm:"size:100;not null" json:"name" example:"John Doe"`
-	Email     string         `gorm:"size:100;uniqueIndex;not null" json:"email" example:"john@example.com"`
-	Password  string         `gorm:"size:255;not null" json:"-"` // "-" hides from JSON
+	Email     string         `gorm:"size:100;index;not null" json:"email" example:"john@example.com"`
+	Password  string         `gorm:"size:255;not null" json:"-"` // "-" hides from JSON; empty for social-login-only accounts
+	Role      string         `gorm:"size:20;not null;default:user" json:"role" example:"user"`
 	CreatedAt time.Time      `json:"created_at" example:"2024-01-01T00:00:00Z"`
 	UpdatedAt time.Time      `json:"updated_at" example:"2024-01-01T00:00:00Z"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"` // Soft delete
+
+	// Version is incremented by every update and served as the ETag, so
+	// concurrent edits are detected instead of overwriting each other
+	Version uint `gorm:"not null;default:1" json:"-"`
+
+	// ActiveEmail mirrors Email until the user is soft-deleted. Its unique
+	// index keeps emails unique among live accounts while letting a deleted
+	// account's email be registered again.
+	ActiveEmail *string `gorm:"->;type:varchar(100) AS (IF(deleted_at IS NULL, email, NULL)) STORED;uniqueIndex" json:"-"`
+
+	// Email verification and pending email change. The token is stored
+	// hashed and confirmed from the new address before Email is replaced.
+	EmailVerifiedAt      *time.Time `json:"email_verified_at,omitempty" example:"2024-01-01T00:00:00Z"`
+	PendingEmail         string     `gorm:"size:100" json:"-"`
+	EmailChangeTokenHash string     `gorm:"size:64;index" json:"-"`
+	EmailChangeExpiresAt *time.Time `json:"-"`
+
+	// Two-factor authentication (TOTP). The secret is set at enrollment and
+	// only takes effect once MFAEnabled is flipped by a confirmed code.
+	MFAEnabled  bool   `gorm:"not null;default:false" json:"mfa_enabled"`
+	MFASecret   string `gorm:"size:64" json:"-"`
+	MFALastStep int64  `gorm:"not null;default:0" json:"-"` // Last accepted TOTP step, prevents replay
+
+	// ErasedAt is set when the user's personal data was erased on request.
+	// The row is kept, anonymized and soft-deleted, so references stay valid.
+	ErasedAt *time.Time `json:"-"`
+
+	// Profile is only loaded when a response includes it
+	Profile *UserProfile `gorm:"foreignKey:UserID;constraint:-" json:"-"`
 }
 
 // TableName overrides the table name
@@ -22,37 +89,386 @@ func (User) TableName() string {
 	return "users"
 }
 
+// HasPassword reports whether the user can sign in with a password. Accounts
+// created through social login have none until they set one.
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
+// RecoveryCode represents a hashed one-time MFA recovery code
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index;not null"`
+	CodeHash  string     `gorm:"size:64;not null"`
+	UsedAt    *time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// TableName overrides the table name
+func (RecoveryCode) TableName() string {
+	return "user_recovery_codes"
+}
+
+// PasswordHistory stores previous password hashes to prevent reuse
+type PasswordHistory struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	Hash      string `gorm:"size:255;not null"`
+	CreatedAt time.Time
+}
+
+// TableName overrides the table name
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
+
+// Login throttle scopes
+const (
+	ThrottleScopeAccount = "account"
+	ThrottleScopeIP      = "ip"
+)
+
+// LoginThrottle tracks consecutive failed logins per account or client IP
+type LoginThrottle struct {
+	ID            uint       `gorm:"primaryKey"`
+	Scope         string     `gorm:"size:16;not null;uniqueIndex:idx_login_throttle_key"`
+	Identifier    string     `gorm:"size:191;not null;uniqueIndex:idx_login_throttle_key"` // Normalized email or IP
+	Failures      int        `gorm:"not null;default:0"`
+	LastFailureAt time.Time  `gorm:"not null"`
+	LockedUntil   *time.Time `gorm:"index"`
+}
+
+// TableName overrides the table name
+func (LoginThrottle) TableName() string {
+	return "login_throttles"
+}
+
+// Session represents a login on one device. Its ID identifies the refresh
+// token family: every rotated refresh token of the login shares it.
+type Session struct {
+	ID               string `gorm:"primaryKey;size:32"`
+	UserID           uint   `gorm:"index;not null"`
+	RefreshTokenHash string `gorm:"size:64;not null"`
+	UserAgent        string `gorm:"size:255"`
+	IP               string `gorm:"size:45"`
+	CreatedAt        time.Time
+	LastSeenAt       time.Time  `gorm:"not null"`
+	ExpiresAt        time.Time  `gorm:"index;not null"`
+	RevokedAt        *time.Time `gorm:"index"`
+}
+
+// TableName overrides the table name
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// Active reports whether the session is neither revoked nor expired
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// ToResponse converts Session to SessionResponse
+func (s *Session) ToResponse(currentID string) SessionResponse {
+	return SessionResponse{
+		ID:         s.ID,
+		UserAgent:  s.UserAgent,
+		IP:         s.IP,
+		CreatedAt:  s.CreatedAt,
+		LastSeenAt: s.LastSeenAt,
+		ExpiresAt:  s.ExpiresAt,
+		Current:    s.ID == currentID,
+	}
+}
+
+// LinkedIdentity links an account at an external OpenID provider to a user
+type LinkedIdentity struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index;not null"`
+	Provider    string `gorm:"size:50;not null;uniqueIndex:idx_linked_identity"`
+	Subject     string `gorm:"size:191;not null;uniqueIndex:idx_linked_identity"` // Provider's stable user ID ("sub")
+	Email       string `gorm:"size:100"`
+	CreatedAt   time.Time
+	LastLoginAt time.Time `gorm:"not null"`
+}
+
+// TableName overrides the table name
+func (LinkedIdentity) TableName() string {
+	return "linked_identities"
+}
+
+// ToResponse converts LinkedIdentity to IdentityResponse
+func (i *LinkedIdentity) ToResponse() IdentityResponse {
+	return IdentityResponse{
+		ID:          i.ID,
+		Provider:    i.Provider,
+		Email:       i.Email,
+		CreatedAt:   i.CreatedAt,
+		LastLoginAt: i.LastLoginAt,
+	}
+}
+
+// OIDCLoginState is a pending OpenID Connect authorization request. The
+// state parameter is stored hashed and consumed exactly once by the callback.
+type OIDCLoginState struct {
+	StateHash    string `gorm:"primaryKey;size:64"`
+	Provider     string `gorm:"size:50;not null"`
+	Nonce        string `gorm:"size:64;not null"`
+	CodeVerifier string `gorm:"size:64;not null"`
+	LinkUserID   *uint  // Set when a signed-in user links a new identity
+	CreatedAt    time.Time
+	ExpiresAt    time.Time `gorm:"index;not null"`
+}
+
+// TableName overrides the table name
+func (OIDCLoginState) TableName() string {
+	return "oidc_login_states"
+}
+
+// API key scopes
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+// APIKey is a personal key for machine-to-machine access. Only a hash of
+// the key is stored; the prefix identifies it in listings and lookups.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index;not null"`
+	Name       string `gorm:"size:100;not null"`
+	Prefix     string `gorm:"size:16;uniqueIndex;not null"`
+	KeyHash    string `gorm:"size:64;not null"`
+	Scopes     string `gorm:"size:255;not null"` // Space-separated
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time `gorm:"index"`
+	CreatedAt  time.Time
+}
+
+// TableName overrides the table name
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// ScopeList returns the key's scopes
+func (k *APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// Active reports whether the key is neither revoked nor expired
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// ToResponse converts APIKey to APIKeyResponse
+func (k *APIKey) ToResponse() APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     apiKeyPrefix + k.Prefix,
+		Scopes:     k.ScopeList(),
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
+// UserProfile holds optional profile details managed by the user
+type UserProfile struct {
+	UserID      uint   `gorm:"primaryKey;autoIncrement:false"`
+	DisplayName string `gorm:"size:100;not null;default:''"`
+	AvatarURL   string `gorm:"size:512;not null;default:''"`
+	Locale      string `gorm:"size:35;not null;default:''"` // BCP 47 language tag, e.g. en-US
+	Timezone    string `gorm:"size:64;not null;default:''"` // IANA time zone, e.g. Europe/Berlin
+	Bio         string `gorm:"size:500;not null;default:''"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TableName overrides the table name
+func (UserProfile) TableName() string {
+	return "user_profiles"
+}
+
+// ToResponse converts UserProfile to ProfileResponse
+func (p *UserProfile) ToResponse() ProfileResponse {
+	return ProfileResponse{
+		DisplayName: p.DisplayName,
+		AvatarURL:   p.AvatarURL,
+		Locale:      p.Locale,
+		Timezone:    p.Timezone,
+		Bio:         p.Bio,
+	}
+}
+
+// RequestMeta carries details about the client making a request
+type RequestMeta struct {
+	IP        string
+	UserAgent string
+	RequestID string
+	SessionID string // Session of the caller's access token, if authenticated
+}
+
 // CreateUserRequest represents the request body for creating a user
 type CreateUserRequest struct {
 	Name     string `json:"name" binding:"required,min=2,max=100" example:"John Doe"`
 	Email    string `json:"email" binding:"required,email" example:"john@example.com"`
-	Password string `json:"password" binding:"required,min=6" example:"secret123"`
+	Password string `json:"password" binding:"required" example:"Secret123"` // Checked against the password policy
+}
+
+// UpdateProfileRequest represents the request body for updating the current
+// user. Omitted profile fields are left unchanged; empty strings clear them.
+type UpdateProfileRequest struct {
+	Name        string  `json:"name" binding:"omitempty,min=2,max=100" example:"John Doe"`
+	DisplayName *string `json:"display_name" binding:"omitempty,max=100" example:"Johnny"`
+	AvatarURL   *string `json:"avatar_url" binding:"omitempty,url,max=512" example:"https://cdn.example.com/avatars/1.png"`
+	Locale      *string `json:"locale" binding:"omitempty,max=35" example:"en-US"`
+	Timezone    *string `json:"timezone" binding:"omitempty,max=64" example:"Europe/Berlin"`
+	Bio         *string `json:"bio" binding:"omitempty,max=500" example:"Backend developer"`
 }
 
-// UpdateUserRequest represents the request body for updating a user
+// DeleteAccountRequest represents the request body for deleting the current user
+type DeleteAccountRequest struct {
+	Password string `json:"password" example:"Secret123"` // Required unless the account signs in with an identity provider only
+}
+
+// UpdateUserRequest represents the request body for updating a user.
+// Email changes go through ChangeEmailRequest and must be confirmed.
 type UpdateUserRequest struct {
-	Name  string `json:"name" binding:"omitempty,min=2,max=100" example:"John Updated"`
-	Email string `json:"email" binding:"omitempty,email" example:"john.updated@example.com"`
+	Name string `json:"name" binding:"omitempty,min=2,max=100" example:"John Updated"`
+}
+
+// UserPatchDocument is the document PATCH /users/{id} applies patches to.
+// It holds the user's name and profile, and replaces them as a whole:
+// removing the required name fails validation rather than leaving it
+// unchanged, and removing a profile field, for example with a merge patch
+// setting it to null, clears it.
+type UserPatchDocument struct {
+	Name        string `json:"name" binding:"required,min=2,max=100" example:"John Updated"`
+	DisplayName string `json:"display_name,omitempty" binding:"omitempty,max=100" example:"Johnny"`
+	AvatarURL   string `json:"avatar_url,omitempty" binding:"omitempty,url,max=512" example:"https://cdn.example.com/avatars/1.png"`
+	Locale      string `json:"locale,omitempty" binding:"omitempty,max=35" example:"en-US"`
+	Timezone    string `json:"timezone,omitempty" binding:"omitempty,max=64" example:"Europe/Berlin"`
+	Bio         string `json:"bio,omitempty" binding:"omitempty,max=500" example:"Backend developer"`
+}
+
+// patchDocument returns the document patches to a user and their profile
+// are applied to
+func patchDocument(user *CurrentUserResponse) UserPatchDocument {
+	return UserPatchDocument{
+		Name:        user.Name,
+		DisplayName: user.Profile.DisplayName,
+		AvatarURL:   user.Profile.AvatarURL,
+		Locale:      user.Profile.Locale,
+		Timezone:    user.Profile.Timezone,
+		Bio:         user.Profile.Bio,
+	}
+}
+
+// updateRequest returns the profile update replacing the name and every
+// profile field with those of the document
+func (d *UserPatchDocument) updateRequest() *UpdateProfileRequest {
+	return &UpdateProfileRequest{
+		Name:        d.Name,
+		DisplayName: &d.DisplayName,
+		AvatarURL:   &d.AvatarURL,
+		Locale:      &d.Locale,
+		Timezone:    &d.Timezone,
+		Bio:         &d.Bio,
+	}
+}
+
+// ChangePasswordRequest represents the request body for changing a password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required" example:"Secret123"`
+	NewPassword     string `json:"new_password" binding:"required" example:"N3w-Secret123"`
+}
+
+// ChangeEmailRequest represents the request body for starting an email change
+type ChangeEmailRequest struct {
+	NewEmail        string `json:"new_email" binding:"required,email,max=100" example:"john.new@example.com"`
+	CurrentPassword string `json:"current_password" binding:"required" example:"Secret123"`
+}
+
+// ConfirmEmailChangeRequest represents the request body for confirming an email change
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" binding:"required" example:"q3Jf8...Zx"`
 }
 
 // UserResponse represents the response body for user data
 type UserResponse struct {
-	ID        uint      `json:"id" example:"1"`
-	Name      string    `json:"name" example:"John Doe"`
-	Email     string    `json:"email" example:"john@example.com"`
-	CreatedAt time.Time `json:"created_at" example:"2024-01-01T00:00:00Z"`
-	UpdatedAt time.Time `json:"updated_at" example:"2024-01-01T00:00:00Z"`
+	ID              uint       `json:"id" example:"1"`
+	Name            string     `json:"name" example:"John Doe"`
+	Email           string     `json:"email" example:"john@example.com"`
+	Role            string     `json:"role" example:"user"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" example:"2024-01-01T00:00:00Z"`
+	PendingEmail    string     `json:"pending_email,omitempty" example:"john.new@example.com"`
+	MFAEnabled      bool       `json:"mfa_enabled" example:"false"`
+	CreatedAt       time.Time  `json:"created_at" example:"2024-01-01T00:00:00Z"`
+	UpdatedAt       time.Time  `json:"updated_at" example:"2024-01-01T00:00:00Z"`
+
+	Profile *ProfileResponse `json:"profile,omitempty"` // Only with include=profile
+	Version uint             `json:"-"`                 // Served as the ETag header
 }
 
 // ToResponse converts User to UserResponse
 func (u *User) ToResponse() *UserResponse {
-	return &UserResponse{
-		ID:        u.ID,
-		Name:      u.Name,
-		Email:     u.Email,
-		CreatedAt: u.CreatedAt,
-		UpdatedAt: u.UpdatedAt,
+	response := &UserResponse{
+		ID:              u.ID,
+		Name:            u.Name,
+		Email:           u.Email,
+		Role:            u.Role,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		PendingEmail:    u.PendingEmail,
+		MFAEnabled:      u.MFAEnabled,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+		Version:         u.Version,
+	}
+	if u.Profile != nil {
+		profile := u.Profile.ToResponse()
+		response.Profile = &profile
 	}
+	return response
+}
+
+// ProfileResponse represents a user's profile details
+type ProfileResponse struct {
+	DisplayName string `json:"display_name" example:"Johnny"`
+	AvatarURL   string `json:"avatar_url" example:"https://cdn.example.com/avatars/1.png"`
+	Locale      string `json:"locale" example:"en-US"`
+	Timezone    string `json:"timezone" example:"Europe/Berlin"`
+	Bio         string `json:"bio" example:"Backend developer"`
+}
+
+// CurrentUserResponse represents the current user with their profile
+type CurrentUserResponse struct {
+	UserResponse
+	Profile ProfileResponse `json:"profile"`
+}
+
+// DeletedUserResponse represents a soft-deleted user awaiting purge
+type DeletedUserResponse struct {
+	UserResponse
+	DeletedAt time.Time  `json:"deleted_at" example:"2024-01-01T00:00:00Z"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty" example:"2024-01-31T00:00:00Z"` // Omitted when deleted users are kept forever
+}
+
+// ImportResult reports the outcome of a bulk user import
+type ImportResult struct {
+	DryRun          bool             `json:"dry_run" example:"false"`
+	Rows            int              `json:"rows" example:"1000"`
+	Created         int              `json:"created" example:"998"` // Users that would be created, in a dry run
+	Failed          int              `json:"failed" example:"2"`
+	Errors          []ImportRowError `json:"errors"`
+	ErrorsTruncated bool             `json:"errors_truncated,omitempty" example:"false"` // Set when only the first errors are listed
+}
+
+// ImportRowError explains why an import row was not imported
+type ImportRowError struct {
+	Row   int    `json:"row" example:"42"` // 1-based, not counting the CSV header
+	Email string `json:"email,omitempty" example:"john@example.com"`
+	Error string `json:"error" example:"email already exists"`
 }
 
 // LoginRequest represents the request body for user login
@@ -61,8 +477,99 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required" example:"secret123"`
 }
 
-// LoginResponse represents the response body for login
+// UnlockRequest represents the request body for unlocking an account
+type UnlockRequest struct {
+	IP string `json:"ip" binding:"omitempty,ip" example:"203.0.113.7"` // Also clear failures recorded for this IP
+}
+
+// LoginResponse represents the response body for login. When the account has
+// two-factor authentication enabled, only MFARequired and ChallengeToken are
+// set and the challenge must be exchanged via /users/login/mfa.
 type LoginResponse struct {
-	Token string        `json:"token" example:"eyJhbGciOiJIUzI1NiIs..."`
-	User  *UserResponse `json:"user"`
+	Token          string        `json:"token,omitempty" example:"eyJhbGciOiJIUzI1NiIs..."`
+	ExpiresAt      *time.Time    `json:"expires_at,omitempty" example:"2024-01-01T00:15:00Z"`
+	RefreshToken   string        `json:"refresh_token,omitempty" example:"3b9f0c...e1.Qm9vbGVhbg"`
+	User           *UserResponse `json:"user,omitempty"`
+	MFARequired    bool          `json:"mfa_required,omitempty" example:"false"`
+	ChallengeToken string        `json:"challenge_token,omitempty" example:"eyJhbGciOiJIUzI1NiIs..."`
+}
+
+// MFALoginRequest represents the second login step for accounts with 2FA
+type MFALoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required" example:"eyJhbGciOiJIUzI1NiIs..."`
+	Code           string `json:"code" binding:"required" example:"123456"` // TOTP code or recovery code
+}
+
+// MFAEnrollResponse represents the response body for starting 2FA enrollment
+type MFAEnrollResponse struct {
+	Secret     string `json:"secret" example:"JBSWY3DPEHPK3PXP"`
+	OTPAuthURL string `json:"otpauth_url" example:"otpauth://totp/Go%20Backend:john@example.com?secret=JBSWY3DPEHPK3PXP"`
+	QRCodePNG  []byte `json:"qr_code_png" swaggertype:"string" format:"base64"`
+}
+
+// MFACodeRequest represents a request carrying a single TOTP code
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric" example:"123456"`
+}
+
+// MFARecoveryCodesResponse lists recovery codes; they are only shown once
+type MFARecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes" example:"3f9a1c0b2e-7d4e5a6f80"`
+}
+
+// RefreshTokenRequest represents the request body for refreshing an access token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required" example:"3b9f0c...e1.Qm9vbGVhbg"`
+}
+
+// SessionResponse represents an active login session
+type SessionResponse struct {
+	ID         string    `json:"id" example:"3b9f0c6d2a7e4f1b8c5d9e0a1b2c3d4e"`
+	UserAgent  string    `json:"user_agent" example:"Mozilla/5.0"`
+	IP         string    `json:"ip" example:"203.0.113.7"`
+	CreatedAt  time.Time `json:"created_at" example:"2024-01-01T00:00:00Z"`
+	LastSeenAt time.Time `json:"last_seen_at" example:"2024-01-01T00:00:00Z"`
+	ExpiresAt  time.Time `json:"expires_at" example:"2024-01-31T00:00:00Z"`
+	Current    bool      `json:"current" example:"true"`
+}
+
+// OIDCAuthorizationResponse carries the provider URL to send the user agent to
+type OIDCAuthorizationResponse struct {
+	AuthorizationURL string `json:"authorization_url" example:"https://accounts.google.com/o/oauth2/v2/auth?client_id=..."`
+	// Binding ties the flow to the user agent that started it, which must
+	// present it at the callback; it is sent in a cookie, never in the body
+	Binding string `json:"-"`
+}
+
+// IdentityResponse represents an external identity linked to the user
+type IdentityResponse struct {
+	ID          uint      `json:"id" example:"1"`
+	Provider    string    `json:"provider" example:"google"`
+	Email       string    `json:"email" example:"john@gmail.com"`
+	CreatedAt   time.Time `json:"created_at" example:"2024-01-01T00:00:00Z"`
+	LastLoginAt time.Time `json:"last_login_at" example:"2024-01-01T00:00:00Z"`
+}
+
+// CreateAPIKeyRequest represents the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100" example:"CI deploy script"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,oneof=users:read users:write" example:"users:read"`
+	ExpiresAt *time.Time `json:"expires_at" example:"2025-01-01T00:00:00Z"` // Optional; keys without expiry stay valid until revoked
+}
+
+// APIKeyResponse represents an API key without its secret
+type APIKeyResponse struct {
+	ID         uint       `json:"id" example:"1"`
+	Name       string     `json:"name" example:"CI deploy script"`
+	Prefix     string     `json:"prefix" example:"gbk_3f9a1c0b2e7d"`
+	Scopes     []string   `json:"scopes" example:"users:read"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" example:"2025-01-01T00:00:00Z"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" example:"2024-01-01T00:00:00Z"`
+	CreatedAt  time.Time  `json:"created_at" example:"2024-01-01T00:00:00Z"`
+}
+
+// APIKeyCreatedResponse includes the full key, which is only shown once
+type APIKeyCreatedResponse struct {
+	APIKeyResponse
+	Key string `json:"key" example:"gbk_3f9a1c0b2e7d_Qm9vbGVhbiBzZWNyZXQgdmFsdWU"`
 }
@@ -0,0 +1,383 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"github.com/savindaJ/backend-app/internal/oidc"
+	"github.com/savindaJ/backend-app/internal/query"
+	"gorm.io/gorm"
+)
+
+const testIssuer = "https://app.example.com/mock-oidc"
+
+// oidcRepository keeps the records of OpenID Connect sign-ins in memory
+type oidcRepository struct {
+	UserRepository
+	users      map[uint]*User
+	states     map[string]*OIDCLoginState
+	identities []*LinkedIdentity
+	sessions   []*Session
+}
+
+func (r *oidcRepository) WithContext(context.Context) UserRepository {
+	return r
+}
+
+func (r *oidcRepository) Create(user *User) error {
+	user.ID = uint(len(r.users) + 1)
+	r.users[user.ID] = user
+	return nil
+}
+
+func (r *oidcRepository) FindByID(id uint) (*User, error) {
+	if user, ok := r.users[id]; ok {
+		return user, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *oidcRepository) FindByEmail(email string) (*User, error) {
+	for _, user := range r.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *oidcRepository) CreateSession(session *Session) error {
+	r.sessions = append(r.sessions, session)
+	return nil
+}
+
+func (r *oidcRepository) CreateOIDCState(state *OIDCLoginState) error {
+	r.states[state.StateHash] = state
+	return nil
+}
+
+func (r *oidcRepository) ConsumeOIDCState(stateHash string) (*OIDCLoginState, error) {
+	state, ok := r.states[stateHash]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(r.states, stateHash)
+	return state, nil
+}
+
+func (r *oidcRepository) FindIdentity(provider, subject string) (*LinkedIdentity, error) {
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *oidcRepository) CreateIdentity(identity *LinkedIdentity) error {
+	identity.ID = uint(len(r.identities) + 1)
+	r.identities = append(r.identities, identity)
+	return nil
+}
+
+func (r *oidcRepository) TouchIdentity(id uint, email string, now time.Time) error {
+	identity := r.identities[id-1]
+	identity.Email, identity.LastLoginAt = email, now
+	return nil
+}
+
+// discardRecorder drops audit events
+type discardRecorder struct{}
+
+func (discardRecorder) Record(*audit.Event) error     { return nil }
+func (discardRecorder) Pseudonym(value string) string { return value }
+
+// oidcServer serves the sign-in routes with the mock provider registered
+// as "mock"
+type oidcServer struct {
+	router *gin.Engine
+	mock   *oidc.MockProvider
+	repo   *oidcRepository
+}
+
+func newOIDCServer(t *testing.T) *oidcServer {
+	t.Helper()
+	mock, err := oidc.NewMockProvider(oidc.MockConfig{Issuer: testIssuer, ClientID: "client", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers := oidc.NewRegistry()
+	providers.Register(oidc.NewClient(oidc.Config{
+		Name:         "mock",
+		Issuer:       testIssuer,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example.com/api/v1/users/oidc/mock/callback",
+	}, mock.HTTPClient()))
+
+	repo := &oidcRepository{users: map[uint]*User{}, states: map[string]*OIDCLoginState{}}
+	cfg := &config.Config{OIDCStateTTL: 10 * time.Minute, JWTAccessTTL: 15 * time.Minute, JWTRefreshTTL: time.Hour}
+	service := NewUserService(repo, auth.NewTokenManager("test-secret", "test"), nil, cfg, discardRecorder{}, nil, nil, providers)
+	handler := NewUserHandler(service, query.NewCodec("test-secret"), cfg.OIDCStateTTL, true)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/oidc/:provider/login", handler.OIDCLogin)
+	r.GET("/oidc/:provider/callback", handler.OIDCCallback)
+	// Stands in for authentication as user 1
+	r.POST("/oidc/:provider/link", func(c *gin.Context) {
+		c.Set(middleware.ContextUserID, uint(1))
+		handler.LinkIdentity(c)
+	})
+	return &oidcServer{router: r, mock: mock, repo: repo}
+}
+
+func (s *oidcServer) serve(method, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// stateCookie returns the login state cookie a response set
+func stateCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return cookie
+		}
+	}
+	t.Fatal("no login state cookie was set")
+	return nil
+}
+
+// start begins a sign-in, returning the provider URL and the state cookie
+func (s *oidcServer) start(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+	w := s.serve(http.MethodGet, "/oidc/mock/login", nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body)
+	}
+	cookie := stateCookie(t, w)
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge != 600 {
+		t.Errorf("state cookie = %+v, want an HttpOnly, Secure, SameSite=Lax cookie for the state lifetime", cookie)
+	}
+	return w.Header().Get("Location"), cookie
+}
+
+// authorize signs in at the provider as email and returns the callback
+// path the provider redirects to
+func (s *oidcServer) authorize(t *testing.T, authURL, email string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, testIssuer+"/authorize?") {
+		t.Fatalf("authorization URL %s is not the provider's", authURL)
+	}
+	params := u.Query()
+	params.Set("login_hint", email)
+	w := httptest.NewRecorder()
+	s.mock.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("authorize: status %d: %s", w.Code, w.Body)
+	}
+	callback, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimPrefix(callback.Path, "/api/v1/users") + "?" + callback.RawQuery
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s := newOIDCServer(t)
+
+	login := func() LoginResponse {
+		t.Helper()
+		authURL, cookie := s.start(t)
+		w := s.serve(http.MethodGet, s.authorize(t, authURL, "jane@example.com"), cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("callback: status %d: %s", w.Code, w.Body)
+		}
+		if cleared := stateCookie(t, w); cleared.Value != "" || cleared.MaxAge >= 0 {
+			t.Errorf("callback left the state cookie %+v", cleared)
+		}
+		var response LoginResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	// The first sign-in creates a verified account with the identity linked
+	first := login()
+	if first.Token == "" || first.RefreshToken == "" || first.User.Email != "jane@example.com" {
+		t.Fatalf("first login = %+v, want tokens for jane@example.com", first)
+	}
+	created := s.repo.users[first.User.ID]
+	if created == nil || created.EmailVerifiedAt == nil || created.Name != "jane" {
+		t.Errorf("created user = %+v, want a verified account named jane", created)
+	}
+	if len(s.repo.identities) != 1 || s.repo.identities[0].UserID != first.User.ID {
+		t.Errorf("identities = %+v, want one linked to user %d", s.repo.identities, first.User.ID)
+	}
+
+	// Later sign-ins find the account through the identity
+	second := login()
+	if second.User.ID != first.User.ID || len(s.repo.users) != 1 || len(s.repo.identities) != 1 {
+		t.Errorf("second login signed in user %d with %d users, want user %d", second.User.ID, len(s.repo.users), first.User.ID)
+	}
+	if len(s.repo.sessions) != 2 || len(s.repo.states) != 0 {
+		t.Errorf("%d sessions and %d pending states, want 2 and 0", len(s.repo.sessions), len(s.repo.states))
+	}
+}
+
+func TestOIDCCallbackBinding(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie func(own, other *http.Cookie) *http.Cookie
+	}{
+		{"no cookie", func(own, other *http.Cookie) *http.Cookie { return nil }},
+		{"empty cookie", func(own, other *http.Cookie) *http.Cookie { return &http.Cookie{Name: oidcStateCookie} }},
+		{"cookie of another flow", func(own, other *http.Cookie) *http.Cookie { return other }},
+		{"forged cookie", func(own, other *http.Cookie) *http.Cookie {
+			return &http.Cookie{Name: oidcStateCookie, Value: strings.Repeat("0", 64)}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newOIDCServer(t)
+			// An attacker starts a flow and signs in at the provider, then
+			// has a victim's browser, with its own flow under way, follow
+			// the callback
+			attackerURL, attackerCookie := s.start(t)
+			callback := s.authorize(t, attackerURL, "attacker@example.com")
+			_, victimCookie := s.start(t)
+
+			w := s.serve(http.MethodGet, callback, tt.cookie(attackerCookie, victimCookie))
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrInvalidOIDCState.Error()) {
+				t.Fatalf("callback: status %d: %s, want the state rejected", w.Code, w.Body)
+			}
+			if len(s.repo.users) != 0 || len(s.repo.sessions) != 0 {
+				t.Fatal("a callback without the binding signed in")
+			}
+
+			// The rejected attempt does not use up the state
+			if w := s.serve(http.MethodGet, callback, attackerCookie); w.Code != http.StatusOK {
+				t.Errorf("callback from the browser that started the flow: status %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	t.Run("replayed state", func(t *testing.T) {
+		s := newOIDCServer(t)
+		authURL, cookie := s.start(t)
+		callback := s.authorize(t, authURL, "jane@example.com")
+		if w := s.serve(http.MethodGet, callback, cookie); w.Code != http.StatusOK {
+			t.Fatalf("first callback: status %d: %s", w.Code, w.Body)
+		}
+		if w := s.serve(http.MethodGet, callback, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("replayed callback: status %d, want 400", w.Code)
+		}
+	})
+
+	t.Run("expired state", func(t *testing.T) {
+		s := newOIDCServer(t)
+		authURL, cookie := s.start(t)
+		callback := s.authorize(t, authURL, "jane@example.com")
+		for _, state := range s.repo.states {
+			state.ExpiresAt = time.Now().Add(-time.Second)
+		}
+		if w := s.serve(http.MethodGet, callback, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", w.Code)
+		}
+	})
+
+	t.Run("callback for another provider", func(t *testing.T) {
+		s := newOIDCServer(t)
+		authURL, cookie := s.start(t)
+		callback := strings.Replace(s.authorize(t, authURL, "jane@example.com"), "/oidc/mock/", "/oidc/other/", 1)
+		if w := s.serve(http.MethodGet, callback, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", w.Code)
+		}
+	})
+
+	t.Run("code of another flow", func(t *testing.T) {
+		s := newOIDCServer(t)
+		firstURL, _ := s.start(t)
+		secondURL, cookie := s.start(t)
+		first, _ := url.Parse(s.authorize(t, firstURL, "jane@example.com"))
+		second, _ := url.Parse(s.authorize(t, secondURL, "jane@example.com"))
+		// The first flow's code was issued for another PKCE challenge
+		callback := "/oidc/mock/callback?" + url.Values{"code": {first.Query().Get("code")}, "state": {second.Query().Get("state")}}.Encode()
+		if w := s.serve(http.MethodGet, callback, cookie); w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d: %s, want 401", w.Code, w.Body)
+		}
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		s := newOIDCServer(t)
+		_, cookie := s.start(t)
+		if w := s.serve(http.MethodGet, "/oidc/mock/callback?error=access_denied&state=x", cookie); w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want 401", w.Code)
+		}
+	})
+
+	t.Run("unverified account with the same email", func(t *testing.T) {
+		s := newOIDCServer(t)
+		s.repo.Create(&User{Name: "Jane", Email: "jane@example.com", Role: RoleUser})
+		authURL, cookie := s.start(t)
+		if w := s.serve(http.MethodGet, s.authorize(t, authURL, "jane@example.com"), cookie); w.Code != http.StatusConflict {
+			t.Errorf("status %d: %s, want 409", w.Code, w.Body)
+		}
+		if len(s.repo.identities) != 0 {
+			t.Error("the identity was linked to an unverified account")
+		}
+	})
+}
+
+func TestOIDCLinkIdentity(t *testing.T) {
+	s := newOIDCServer(t)
+	verified := time.Now()
+	s.repo.Create(&User{Name: "Jane", Email: "jane@example.com", Role: RoleUser, EmailVerifiedAt: &verified})
+
+	w := s.serve(http.MethodPost, "/oidc/mock/link", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("link: status %d: %s", w.Code, w.Body)
+	}
+	cookie := stateCookie(t, w)
+	var response map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if _, leaked := response["binding"]; leaked || len(response) != 1 {
+		t.Errorf("link response = %v, want only the authorization URL", response)
+	}
+
+	// The identity's email need not match the account's
+	w = s.serve(http.MethodGet, s.authorize(t, response["authorization_url"], "jane.work@example.com"), cookie)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("callback: status %d: %s", w.Code, w.Body)
+	}
+	if len(s.repo.identities) != 1 || s.repo.identities[0].UserID != 1 || len(s.repo.sessions) != 0 {
+		t.Errorf("identities = %+v with %d sessions, want one linked to user 1 and no sign-in", s.repo.identities, len(s.repo.sessions))
+	}
+}
@@ -0,0 +1,86 @@
+package user
+
+import (
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/config"
+)
+
+// passwordValidator enforces the password policy, the breached-password
+// list and password history for new passwords
+type passwordValidator struct {
+	repo        UserRepository
+	hasher      auth.PasswordHasher
+	policy      auth.PasswordPolicy
+	breached    auth.BreachedPasswordChecker
+	historySize int
+}
+
+func newPasswordValidator(repo UserRepository, hasher auth.PasswordHasher, cfg *config.Config) *passwordValidator {
+	v := &passwordValidator{
+		repo:   repo,
+		hasher: hasher,
+		policy: auth.PasswordPolicy{
+			MinLength:     cfg.PasswordMinLength,
+			MaxLength:     cfg.PasswordMaxLength,
+			RequireUpper:  cfg.PasswordRequireUpper,
+			RequireLower:  cfg.PasswordRequireLower,
+			RequireDigit:  cfg.PasswordRequireDigit,
+			RequireSymbol: cfg.PasswordRequireSymbol,
+		},
+		historySize: cfg.PasswordHistorySize,
+	}
+	if cfg.BreachedPasswordsDir != "" {
+		v.breached = auth.NewBreachedPasswordChecker(cfg.BreachedPasswordsDir)
+	}
+	return v
+}
+
+// withRepo returns a copy of the validator reading and writing history
+// through repo
+func (v *passwordValidator) withRepo(repo UserRepository) *passwordValidator {
+	bound := *v
+	bound.repo = repo
+	return &bound
+}
+
+// validate returns an *auth.PolicyError listing every violation. userID is
+// zero for new accounts, which have no history to check against.
+func (v *passwordValidator) validate(password string, userID uint, personal ...string) error {
+	violations := v.policy.Check(password, personal...)
+
+	if v.breached != nil {
+		breached, err := v.breached.IsBreached(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, "has appeared in a known data breach")
+		}
+	}
+
+	if userID != 0 && v.historySize > 0 {
+		hashes, err := v.repo.RecentPasswordHashes(userID, v.historySize)
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if ok, _ := v.hasher.Verify(password, hash); ok {
+				violations = append(violations, "must not match one of your recent passwords")
+				break
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &auth.PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// remember adds a newly set password hash to the user's history
+func (v *passwordValidator) remember(userID uint, hash string) error {
+	if v.historySize <= 0 {
+		return nil
+	}
+	return v.repo.AddPasswordHistory(userID, hash, v.historySize)
+}
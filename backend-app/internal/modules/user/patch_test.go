@@ -0,0 +1,135 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"github.com/savindaJ/backend-app/internal/query"
+	"gorm.io/gorm"
+)
+
+// profileRepository keeps a user and their profile in memory, saving them
+// only at the version they were read at
+type profileRepository struct {
+	UserRepository
+	user    User
+	profile *UserProfile
+}
+
+func (r *profileRepository) WithContext(context.Context) UserRepository {
+	return r
+}
+
+func (r *profileRepository) FindByID(id uint) (*User, error) {
+	if id != r.user.ID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	user := r.user
+	return &user, nil
+}
+
+func (r *profileRepository) FindProfile(userID uint) (*UserProfile, error) {
+	if r.profile == nil || userID != r.user.ID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	profile := *r.profile
+	return &profile, nil
+}
+
+func (r *profileRepository) SaveProfile(user *User, profile *UserProfile) error {
+	if user.Version != r.user.Version {
+		return ErrVersionMismatch
+	}
+	user.Version++
+	r.user, r.profile = *user, profile
+	return nil
+}
+
+// patchUser sends a merge patch for user 1 matching If-Match
+func patchUser(repo *profileRepository, ifMatch, body string) *httptest.ResponseRecorder {
+	cfg := &config.Config{JWTAccessTTL: 15 * time.Minute, JWTRefreshTTL: time.Hour}
+	service := NewUserService(repo, auth.NewTokenManager("test-secret", "test"), nil, cfg, discardRecorder{}, nil, nil, nil)
+	handler := NewUserHandler(service, query.NewCodec("test-secret"), time.Minute, true)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// Stands in for authentication as an admin
+	r.PATCH("/users/:id", func(c *gin.Context) {
+		c.Set(middleware.ContextUserID, uint(2))
+		handler.Patch(c)
+	})
+	req := httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", ifMatch)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestPatchProfile(t *testing.T) {
+	newRepo := func() *profileRepository {
+		return &profileRepository{
+			user: User{ID: 1, Name: "Ada Lovelace", Email: "ada@example.com", Role: RoleUser, Version: 3},
+			profile: &UserProfile{UserID: 1, DisplayName: "Ada", Locale: "en-GB",
+				Timezone: "Europe/London", Bio: "Analyst"},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		ifMatch     string
+		body        string
+		wantStatus  int
+		wantName    string
+		wantProfile UserProfile
+	}{
+		{name: "null clears a field", ifMatch: `"3"`, body: `{"bio": null}`, wantStatus: http.StatusOK,
+			wantName: "Ada Lovelace", wantProfile: UserProfile{DisplayName: "Ada", Locale: "en-GB", Timezone: "Europe/London"}},
+		{name: "sets name and fields", ifMatch: `"3"`, body: `{"name": "Ada King", "bio": "Mathematician", "locale": "fr-fr"}`,
+			wantStatus: http.StatusOK, wantName: "Ada King",
+			wantProfile: UserProfile{DisplayName: "Ada", Locale: "fr-FR", Timezone: "Europe/London", Bio: "Mathematician"}},
+		{name: "null name", ifMatch: `"3"`, body: `{"name": null}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown time zone", ifMatch: `"3"`, body: `{"timezone": "Mars/Olympus"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown field", ifMatch: `"3"`, body: `{"email": "eve@example.com"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "stale version", ifMatch: `"2"`, body: `{"bio": null}`, wantStatus: http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepo()
+			w := patchUser(repo, tt.ifMatch, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if repo.user.Version != 3 {
+					t.Errorf("version = %d after a rejected patch, want 3", repo.user.Version)
+				}
+				return
+			}
+
+			var got CurrentUserResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.wantProfile.ToResponse()
+			if got.Name != tt.wantName || got.Profile != want {
+				t.Errorf("patched to %q, %+v, want %q, %+v", got.Name, got.Profile, tt.wantName, want)
+			}
+			if saved := repo.profile.ToResponse(); repo.user.Name != tt.wantName || saved != want {
+				t.Errorf("saved %q, %+v, want %q, %+v", repo.user.Name, saved, tt.wantName, want)
+			}
+			if repo.user.Version != 4 || w.Header().Get("ETag") != `"4"` {
+				t.Errorf("version = %d, ETag %s, want 4", repo.user.Version, w.Header().Get("ETag"))
+			}
+		})
+	}
+}
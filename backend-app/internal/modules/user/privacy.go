@@ -0,0 +1,130 @@
+package user
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErasedName replaces the name of users whose data was erased
+const ErasedName = "Erased user"
+
+// ExportSource returns the data a module holds about a user for a data
+// export, keyed by file name within the export archive
+type ExportSource func(userID uint) (map[string]interface{}, error)
+
+// erasedEmail is a unique, undeliverable address for an erased user
+func erasedEmail(id uint) string {
+	return fmt.Sprintf("erased-%d@erased.invalid", id)
+}
+
+// exportedSession includes revoked sessions, unlike SessionResponse
+type exportedSession struct {
+	SessionResponse
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// exportedSecurity summarizes credentials without exposing secrets
+type exportedSecurity struct {
+	HasPassword            bool        `json:"has_password"`
+	MFAEnabled             bool        `json:"mfa_enabled"`
+	RecoveryCodesRemaining int64       `json:"recovery_codes_remaining"`
+	PasswordChangedAt      []time.Time `json:"password_changed_at"`
+}
+
+// ExportUserData returns the export source for the account, profile,
+// sessions, linked identities, API keys and security settings of a user
+func ExportUserData(db *gorm.DB) ExportSource {
+	return func(userID uint) (map[string]interface{}, error) {
+		var user User
+		if err := db.First(&user, userID).Error; err != nil {
+			return nil, err
+		}
+
+		var profile UserProfile
+		if err := db.Where("user_id = ?", userID).Limit(1).Find(&profile).Error; err != nil {
+			return nil, err
+		}
+
+		var sessions []Session
+		if err := db.Where("user_id = ?", userID).Order("created_at").Find(&sessions).Error; err != nil {
+			return nil, err
+		}
+		exportedSessions := make([]exportedSession, len(sessions))
+		for i := range sessions {
+			exportedSessions[i] = exportedSession{SessionResponse: sessions[i].ToResponse(""), RevokedAt: sessions[i].RevokedAt}
+		}
+
+		var identities []LinkedIdentity
+		if err := db.Where("user_id = ?", userID).Order("id").Find(&identities).Error; err != nil {
+			return nil, err
+		}
+		exportedIdentities := make([]IdentityResponse, len(identities))
+		for i := range identities {
+			exportedIdentities[i] = identities[i].ToResponse()
+		}
+
+		var keys []APIKey
+		if err := db.Where("user_id = ?", userID).Order("id").Find(&keys).Error; err != nil {
+			return nil, err
+		}
+		exportedKeys := make([]APIKeyResponse, len(keys))
+		for i := range keys {
+			exportedKeys[i] = keys[i].ToResponse()
+		}
+
+		security := exportedSecurity{HasPassword: user.HasPassword(), MFAEnabled: user.MFAEnabled, PasswordChangedAt: []time.Time{}}
+		if err := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&security.RecoveryCodesRemaining).Error; err != nil {
+			return nil, err
+		}
+		if err := db.Model(&PasswordHistory{}).Where("user_id = ?", userID).Order("created_at").Pluck("created_at", &security.PasswordChangedAt).Error; err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"account.json":    CurrentUserResponse{UserResponse: *user.ToResponse(), Profile: profile.ToResponse()},
+			"sessions.json":   exportedSessions,
+			"identities.json": exportedIdentities,
+			"api_keys.json":   exportedKeys,
+			"security.json":   security,
+		}, nil
+	}
+}
+
+// EraseUserData anonymizes users and removes their credentials, sessions,
+// identities, API keys and profiles. The user rows are kept, soft-deleted,
+// so that other tables and the audit log still reference valid IDs. It has
+// the signature of a PurgeHook so it can run in another module's transaction.
+func EraseUserData(tx *gorm.DB, userIDs []uint) error {
+	var users []User
+	if err := tx.Unscoped().Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	emails := make([]string, len(users))
+	for i, user := range users {
+		emails[i] = normalizeEmail(user.Email)
+		if err := tx.Unscoped().Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"name":                    ErasedName,
+			"email":                   erasedEmail(user.ID),
+			"password":                "",
+			"pending_email":           "",
+			"email_change_token_hash": "",
+			"email_change_expires_at": nil,
+			"email_verified_at":       nil,
+			"mfa_enabled":             false,
+			"mfa_secret":              "",
+			"mfa_last_step":           0,
+			"role":                    RoleUser,
+			"erased_at":               now,
+			"deleted_at":              gorm.Expr("COALESCE(deleted_at, ?)", now),
+			"version":                 gorm.Expr("version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	return deleteUserData(tx, userIDs, emails)
+}
@@ -0,0 +1,83 @@
+package user
+
+import (
+	"net/url"
+	"strings"
+	"time"
+	_ "time/tzdata" // Validate time zones on hosts without a zoneinfo database
+
+	"golang.org/x/text/language"
+)
+
+// normalizeLocale validates a BCP 47 language tag and returns its canonical
+// form, e.g. "en_us" becomes "en-US". An empty locale clears the setting.
+func normalizeLocale(locale string) (string, error) {
+	locale = strings.TrimSpace(locale)
+	if locale == "" {
+		return "", nil
+	}
+	tag, err := language.Parse(locale)
+	if err != nil || tag == language.Und {
+		return "", ErrInvalidLocale
+	}
+	return tag.String(), nil
+}
+
+// validateTimezone accepts IANA time zone names such as Europe/Berlin. An
+// empty time zone clears the setting.
+func validateTimezone(timezone string) error {
+	if timezone == "" {
+		return nil
+	}
+	if timezone == "Local" {
+		return ErrInvalidTimezone
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return ErrInvalidTimezone
+	}
+	return nil
+}
+
+// validateAvatarURL only allows absolute http and https URLs so the avatar
+// can be embedded safely by clients
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	parsed, err := url.Parse(avatarURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return ErrInvalidAvatarURL
+	}
+	return nil
+}
+
+// applyProfileUpdate validates the profile fields present in req and copies
+// them onto profile
+func applyProfileUpdate(profile *UserProfile, req *UpdateProfileRequest) error {
+	if req.DisplayName != nil {
+		profile.DisplayName = strings.TrimSpace(*req.DisplayName)
+	}
+	if req.AvatarURL != nil {
+		if err := validateAvatarURL(*req.AvatarURL); err != nil {
+			return err
+		}
+		profile.AvatarURL = *req.AvatarURL
+	}
+	if req.Locale != nil {
+		locale, err := normalizeLocale(*req.Locale)
+		if err != nil {
+			return err
+		}
+		profile.Locale = locale
+	}
+	if req.Timezone != nil {
+		if err := validateTimezone(*req.Timezone); err != nil {
+			return err
+		}
+		profile.Timezone = *req.Timezone
+	}
+	if req.Bio != nil {
+		profile.Bio = strings.TrimSpace(*req.Bio)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package user
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/config"
+	"gorm.io/gorm"
+)
+
+// purgeBatchSize is the number of users removed per transaction
+const purgeBatchSize = 100
+
+// legacyEmailIndex is the unique index on users.email that predates
+// soft-deleted users releasing their email
+const legacyEmailIndex = "idx_users_email"
+
+// PurgeHook removes the data another module keeps about users that are being
+// purged. It runs inside the purge transaction.
+type PurgeHook func(tx *gorm.DB, userIDs []uint) error
+
+// Purger permanently removes users that have been soft-deleted for longer
+// than the retention period
+type Purger struct {
+	repo       UserRepository
+	auditor    audit.Recorder
+	retention  time.Duration
+	interval   time.Duration
+	hooks      []PurgeHook
+	invalidate CacheInvalidator
+}
+
+// NewPurger creates a purger for deleted users. hooks clean up data other
+// modules keep about the purged users; invalidate drops them from the user
+// cache once they are gone.
+func NewPurger(db *gorm.DB, cfg *config.Config, invalidate CacheInvalidator, hooks ...PurgeHook) *Purger {
+	return &Purger{
+		repo:       NewUserRepository(db),
+		auditor:    audit.NewRecorder(db, cfg.JWTSecret),
+		retention:  cfg.UserDeletedRetention,
+		interval:   cfg.UserPurgeInterval,
+		hooks:      hooks,
+		invalidate: invalidate,
+	}
+}
+
+// Start purges deleted users now and then every interval in the
+// background. It does nothing when deleted users are kept forever.
+func (p *Purger) Start() {
+	if p.retention <= 0 || p.interval <= 0 {
+		log.Println("ℹ️  Purging of deleted users is disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := p.Purge(time.Now()); err != nil {
+				log.Printf("⚠️  Failed to purge deleted users: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Purge permanently removes users deleted more than the retention period
+// before now and returns how many were removed
+func (p *Purger) Purge(now time.Time) (int, error) {
+	before := now.Add(-p.retention)
+	purged := 0
+	for {
+		users, err := p.repo.PurgeDeleted(before, purgeBatchSize, p.hooks)
+		if err != nil {
+			return purged, err
+		}
+		ids := make([]uint, len(users))
+		for i, user := range users {
+			ids[i] = user.ID
+		}
+		p.invalidate(ids)
+		for _, user := range users {
+			p.record((&audit.Event{
+				Action:     "user.purged",
+				TargetType: "user",
+				TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+			}).WithMetadata(map[string]interface{}{"deleted_at": user.DeletedAt.Time}))
+		}
+		purged += len(users)
+		if len(users) < purgeBatchSize {
+			break
+		}
+	}
+
+	if purged > 0 {
+		log.Printf("🧹 Purged %d deleted users", purged)
+	}
+	return purged, nil
+}
+
+func (p *Purger) record(event *audit.Event) {
+	if err := p.auditor.Record(event); err != nil {
+		log.Printf("⚠️  Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// PrepareMigration drops the legacy unique index on users.email so that
+// AutoMigrate can replace it with a plain index; uniqueness is enforced on
+// ActiveEmail instead. It must run before AutoMigrate.
+func PrepareMigration(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&User{}) {
+		return nil
+	}
+
+	indexes, err := migrator.GetIndexes(&User{})
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index.Name() != legacyEmailIndex {
+			continue
+		}
+		if unique, ok := index.Unique(); ok && unique {
+			log.Printf("🔄 Dropping unique index %s so deleted users release their email", legacyEmailIndex)
+			return migrator.DropIndex(&User{}, legacyEmailIndex)
+		}
+	}
+	return nil
+}
@@ -1,17 +1,68 @@
 package user
 
 import (
+	"context"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/database"
+	"github.com/savindaJ/backend-app/internal/query"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // UserRepository interface defines the contract for user data access
 type UserRepository interface {
+	WithContext(ctx context.Context) UserRepository // Bound to ctx, taking part in the transaction it carries
 	Create(user *User) error
 	FindByID(id uint) (*User, error)
+	FindByIDWith(id uint, view *query.View) (*User, error) // For display only; may omit credentials
 	FindByEmail(email string) (*User, error)
-	FindAll(page, limit int) ([]User, int64, error)
+	FindByEmailChangeToken(tokenHash string) (*User, error)
+	FindAll(q *query.Query, view *query.View, page, limit int) ([]User, int64, error)
+	FindPage(q *query.Query, view *query.View, cursor *query.Cursor, limit int, count bool) ([]User, *query.Page, error)
 	Update(user *User) error
-	Delete(id uint) error
+	UpdatePassword(id uint, hash string) error
+	RecentPasswordHashes(userID uint, limit int) ([]string, error)
+	AddPasswordHistory(userID uint, hash string, keep int) error
+	Delete(user *User) error
+	FindEach(q *query.Query, batchSize int, fn func(users []User) error) error
+	FindExistingEmails(emails []string) ([]string, error)
+	CreateBatch(users []User, keepHistory bool) error
+	FindDeleted(page, limit int) ([]User, int64, error)
+	FindDeletedByID(id uint) (*User, error)
+	Restore(id uint) (bool, error)
+	PurgeDeleted(before time.Time, limit int, hooks []PurgeHook) ([]User, error)
+	ReplaceRecoveryCodes(userID uint, codeHashes []string) error
+	UseRecoveryCode(userID uint, codeHash string) (bool, error)
+	DeleteRecoveryCodes(userID uint) error
+	AdvanceMFAStep(userID uint, step int64) (bool, error)
+	SetRoleByEmail(email, role string) (bool, error)
+	FindThrottle(scope, identifier string) (*LoginThrottle, error)
+	RecordLoginFailure(scope, identifier string, now time.Time, window time.Duration) (*LoginThrottle, error)
+	LockThrottle(id uint, until time.Time) error
+	ResetThrottle(scope, identifier string) error
+	CreateSession(session *Session) error
+	FindSession(id string) (*Session, error)
+	RotateSessionToken(id, oldHash, newHash string, now, expiresAt time.Time) (bool, error)
+	TouchSession(id string, now time.Time) error
+	FindActiveSessions(userID uint, now time.Time) ([]Session, error)
+	RevokeSession(userID uint, id string, now time.Time) (bool, error)
+	RevokeUserSessions(userID uint, exceptID string, now time.Time) ([]string, error)
+	CreateOIDCState(state *OIDCLoginState) error
+	ConsumeOIDCState(stateHash string) (*OIDCLoginState, error)
+	FindIdentity(provider, subject string) (*LinkedIdentity, error)
+	FindIdentities(userID uint) ([]LinkedIdentity, error)
+	CountIdentities(userID uint) (int64, error)
+	CreateIdentity(identity *LinkedIdentity) error
+	TouchIdentity(id uint, email string, now time.Time) error
+	DeleteIdentity(userID, id uint) (bool, error)
+	CreateAPIKey(key *APIKey) error
+	FindAPIKeyByPrefix(prefix string) (*APIKey, error)
+	FindActiveAPIKeys(userID uint, now time.Time) ([]APIKey, error)
+	RevokeAPIKey(userID, id uint, now time.Time) (bool, error)
+	TouchAPIKey(id uint, now time.Time) error
+	FindProfile(userID uint) (*UserProfile, error)
+	SaveProfile(user *User, profile *UserProfile) error
 }
 
 // userRepository implements UserRepository using GORM
@@ -24,15 +75,27 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 	return &userRepository{db: db}
 }
 
+// WithContext returns the repository bound to ctx; within an atomic batch
+// its queries run in the batch's transaction
+func (r *userRepository) WithContext(ctx context.Context) UserRepository {
+	return &userRepository{db: database.Conn(ctx, r.db)}
+}
+
 // Create creates a new user in the database
 func (r *userRepository) Create(user *User) error {
+	user.Version = 1
 	return r.db.Create(user).Error
 }
 
 // FindByID finds a user by ID
 func (r *userRepository) FindByID(id uint) (*User, error) {
+	return r.FindByIDWith(id, nil)
+}
+
+// FindByIDWith finds a user by ID, loading the relations view includes
+func (r *userRepository) FindByIDWith(id uint, view *query.View) (*User, error) {
 	var user User
-	if err := r.db.First(&user, id).Error; err != nil {
+	if err := r.db.Scopes(view.Preload).First(&user, id).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -47,32 +110,576 @@ func (r *userRepository) FindByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// FindAll retrieves all users with pagination
-func (r *userRepository) FindAll(page, limit int) ([]User, int64, error) {
+// FindByEmailChangeToken finds the user with a pending email change for the token hash
+func (r *userRepository) FindByEmailChangeToken(tokenHash string) (*User, error) {
+	var user User
+	if err := r.db.Where("email_change_token_hash = ?", tokenHash).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindAll retrieves the users matching q with pagination, loading the
+// relations view includes
+func (r *userRepository) FindAll(q *query.Query, view *query.View, page, limit int) ([]User, int64, error) {
 	var users []User
 	var total int64
 
 	offset := (page - 1) * limit
 
 	// Get total count
-	if err := r.db.Model(&User{}).Count(&total).Error; err != nil {
+	if err := r.db.Model(&User{}).Scopes(q.Filter).Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
 
 	// Get paginated users
-	if err := r.db.Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+	if err := r.db.Scopes(q.Filter, q.Sort, view.Preload).Offset(offset).Limit(limit).Find(&users).Error; err != nil {
 		return nil, 0, err
 	}
 
 	return users, total, nil
 }
 
-// Update updates an existing user
+// FindPage retrieves the users matching q with keyset pagination, loading
+// the relations view includes. The total is only counted when requested,
+// since it costs a scan of the matches.
+func (r *userRepository) FindPage(q *query.Query, view *query.View, cursor *query.Cursor, limit int, count bool) ([]User, *query.Page, error) {
+	var users []User
+	page, err := q.Seek(r.db.Scopes(view.Preload), cursor, limit, &users)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if count {
+		var total int64
+		if err := r.db.Model(&User{}).Scopes(q.Filter).Count(&total).Error; err != nil {
+			return nil, nil, err
+		}
+		page.Total = &total
+	}
+
+	return users, page, nil
+}
+
+// Update saves a user and increments its version, provided the stored
+// version is still the one the user was loaded with. Otherwise another
+// update won the race and ErrVersionMismatch is returned.
 func (r *userRepository) Update(user *User) error {
-	return r.db.Save(user).Error
+	version := user.Version
+	user.Version++
+	result := r.db.Model(user).
+		Where("version = ?", version).
+		Select("*").
+		Omit("id", "created_at", "deleted_at", clause.Associations).
+		Updates(user)
+	if result.Error != nil {
+		user.Version = version
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		user.Version = version
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// UpdatePassword replaces only the stored password hash of a user. The
+// version is incremented so that an Update of a copy read before fails
+// rather than writing the old hash back.
+func (r *userRepository) UpdatePassword(id uint, hash string) error {
+	return r.db.Model(&User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"password": hash, "version": gorm.Expr("version + 1")}).Error
+}
+
+// RecentPasswordHashes returns a user's most recent password hashes, newest first
+func (r *userRepository) RecentPasswordHashes(userID uint, limit int) ([]string, error) {
+	var hashes []string
+	err := r.db.Model(&PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Pluck("hash", &hashes).Error
+	return hashes, err
+}
+
+// AddPasswordHistory records a password hash and prunes all but the newest keep entries
+func (r *userRepository) AddPasswordHistory(userID uint, hash string, keep int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&PasswordHistory{UserID: userID, Hash: hash}).Error; err != nil {
+			return err
+		}
+
+		var keepIDs []uint
+		if err := tx.Model(&PasswordHistory{}).
+			Where("user_id = ?", userID).
+			Order("id DESC").
+			Limit(keep).
+			Pluck("id", &keepIDs).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND id NOT IN ?", userID, keepIDs).Delete(&PasswordHistory{}).Error
+	})
+}
+
+// FindEach passes the users matching q's filters to fn in batches ordered
+// by ID, so every user can be visited without loading all of them
+func (r *userRepository) FindEach(q *query.Query, batchSize int, fn func(users []User) error) error {
+	var users []User
+	return r.db.Scopes(q.Filter).FindInBatches(&users, batchSize, func(tx *gorm.DB, batch int) error {
+		return fn(users)
+	}).Error
+}
+
+// FindExistingEmails returns which of the given emails belong to users
+func (r *userRepository) FindExistingEmails(emails []string) ([]string, error) {
+	var existing []string
+	err := r.db.Model(&User{}).Where("email IN ?", emails).Pluck("email", &existing).Error
+	return existing, err
+}
+
+// CreateBatch creates users in a single transaction. With keepHistory,
+// their password hashes are recorded in the password history too.
+func (r *userRepository) CreateBatch(users []User, keepHistory bool) error {
+	for i := range users {
+		users[i].Version = 1
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(&users).Error; err != nil {
+			return err
+		}
+		if !keepHistory {
+			return nil
+		}
+		history := make([]PasswordHistory, len(users))
+		for i, user := range users {
+			history[i] = PasswordHistory{UserID: user.ID, Hash: user.Password}
+		}
+		return tx.Create(&history).Error
+	})
+}
+
+// Delete soft deletes a user, provided it still has the version it was
+// loaded with
+func (r *userRepository) Delete(user *User) error {
+	result := r.db.Where("version = ?", user.Version).Delete(&User{}, user.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// FindDeleted retrieves soft-deleted users with pagination, most recently
+// deleted first. Erased users are kept for good and never listed.
+func (r *userRepository) FindDeleted(page, limit int) ([]User, int64, error) {
+	var users []User
+	var total int64
+
+	query := r.db.Unscoped().Model(&User{}).Where("deleted_at IS NOT NULL AND erased_at IS NULL")
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	if err := query.Order("deleted_at DESC").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// FindDeletedByID finds a soft-deleted user by ID
+func (r *userRepository) FindDeletedByID(id uint) (*User, error) {
+	var user User
+	if err := r.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL AND erased_at IS NULL", id).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Restore clears a user's soft delete. It reports false when the user is
+// not deleted or was erased.
+func (r *userRepository) Restore(id uint) (bool, error) {
+	result := r.db.Unscoped().Model(&User{}).
+		Where("id = ? AND deleted_at IS NOT NULL AND erased_at IS NULL", id).
+		Updates(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// PurgeDeleted permanently removes up to limit users soft-deleted before
+// the given time, except erased users, together with their dependent rows, and returns them.
+// hooks remove data other modules keep about the users in the same transaction.
+func (r *userRepository) PurgeDeleted(before time.Time, limit int, hooks []PurgeHook) ([]User, error) {
+	var users []User
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().
+			Where("deleted_at IS NOT NULL AND erased_at IS NULL AND deleted_at < ?", before).
+			Order("id").Limit(limit).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Find(&users).Error; err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(users))
+		emails := make([]string, len(users))
+		for i, user := range users {
+			ids[i] = user.ID
+			emails[i] = normalizeEmail(user.Email)
+		}
+
+		for _, hook := range hooks {
+			if err := hook(tx, ids); err != nil {
+				return err
+			}
+		}
+		if err := deleteUserData(tx, ids, emails); err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&User{}, ids).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// deleteUserData removes the rows that belong to users, leaving the users
+// themselves in place
+func deleteUserData(tx *gorm.DB, ids []uint, emails []string) error {
+	for _, model := range []interface{}{&RecoveryCode{}, &PasswordHistory{}, &Session{}, &LinkedIdentity{}, &APIKey{}, &UserProfile{}} {
+		if err := tx.Where("user_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("link_user_id IN ?", ids).Delete(&OIDCLoginState{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("scope = ? AND identifier IN ?", ThrottleScopeAccount, emails).Delete(&LoginThrottle{}).Error
+}
+
+// ReplaceRecoveryCodes discards a user's recovery codes and stores a new set
+func (r *userRepository) ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It reports false
+// when no matching unused code exists, so a code can only be spent once.
+func (r *userRepository) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	result := r.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
 }
 
-// Delete soft deletes a user by ID
-func (r *userRepository) Delete(id uint) error {
-	return r.db.Delete(&User{}, id).Error
+// DeleteRecoveryCodes removes all recovery codes of a user
+func (r *userRepository) DeleteRecoveryCodes(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
+
+// AdvanceMFAStep records the last accepted TOTP step and increments the
+// user's version, as UpdatePassword does. It reports false when the step is
+// not newer than the stored one, i.e. the code was already used.
+func (r *userRepository) AdvanceMFAStep(userID uint, step int64) (bool, error) {
+	result := r.db.Model(&User{}).
+		Where("id = ? AND mfa_last_step < ?", userID, step).
+		Updates(map[string]interface{}{"mfa_last_step": step, "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// SetRoleByEmail assigns a role to the user with the given email, reporting
+// whether such a user exists
+func (r *userRepository) SetRoleByEmail(email, role string) (bool, error) {
+	result := r.db.Model(&User{}).Where("email = ?", email).
+		Updates(map[string]interface{}{"role": role, "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// FindThrottle finds the failed-login record for a scope and identifier
+func (r *userRepository) FindThrottle(scope, identifier string) (*LoginThrottle, error) {
+	var throttle LoginThrottle
+	if err := r.db.Where("scope = ? AND identifier = ?", scope, identifier).First(&throttle).Error; err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+// RecordLoginFailure atomically increments the failure counter for a scope
+// and identifier. Counters whose last failure is older than window restart
+// from one, which also clears an expired lock.
+func (r *userRepository) RecordLoginFailure(scope, identifier string, now time.Time, window time.Duration) (*LoginThrottle, error) {
+	staleBefore := now.Add(-window)
+	err := r.db.Exec(
+		`INSERT INTO login_throttles (scope, identifier, failures, last_failure_at) VALUES (?, ?, 1, ?)
+		ON DUPLICATE KEY UPDATE
+			failures = IF(last_failure_at < ?, 1, failures + 1),
+			locked_until = IF(last_failure_at < ?, NULL, locked_until),
+			last_failure_at = ?`,
+		scope, identifier, now, staleBefore, staleBefore, now,
+	).Error
+	if err != nil {
+		return nil, err
+	}
+	return r.FindThrottle(scope, identifier)
+}
+
+// LockThrottle locks a scope and identifier until the given time
+func (r *userRepository) LockThrottle(id uint, until time.Time) error {
+	return r.db.Model(&LoginThrottle{}).Where("id = ?", id).Update("locked_until", until).Error
+}
+
+// ResetThrottle clears failed-login tracking for a scope and identifier
+func (r *userRepository) ResetThrottle(scope, identifier string) error {
+	return r.db.Where("scope = ? AND identifier = ?", scope, identifier).Delete(&LoginThrottle{}).Error
+}
+
+// CreateSession stores a new login session
+func (r *userRepository) CreateSession(session *Session) error {
+	return r.db.Create(session).Error
+}
+
+// FindSession finds a session by ID
+func (r *userRepository) FindSession(id string) (*Session, error) {
+	var session Session
+	if err := r.db.Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSessionToken swaps the refresh token hash of an active session. It
+// reports false when oldHash is no longer current, so concurrent refreshes
+// with the same token cannot both succeed.
+func (r *userRepository) RotateSessionToken(id, oldHash, newHash string, now, expiresAt time.Time) (bool, error) {
+	result := r.db.Model(&Session{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", id, oldHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash": newHash,
+			"last_seen_at":       now,
+			"expires_at":         expiresAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// TouchSession updates the last-seen time of a session
+func (r *userRepository) TouchSession(id string, now time.Time) error {
+	return r.db.Model(&Session{}).Where("id = ?", id).Update("last_seen_at", now).Error
+}
+
+// FindActiveSessions lists a user's sessions that are neither revoked nor expired
+func (r *userRepository) FindActiveSessions(userID uint, now time.Time) ([]Session, error) {
+	var sessions []Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession revokes one of a user's sessions, reporting whether it existed
+func (r *userRepository) RevokeSession(userID uint, id string, now time.Time) (bool, error) {
+	result := r.db.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// RevokeUserSessions revokes all of a user's sessions except exceptID and
+// returns the IDs it revoked
+func (r *userRepository) RevokeUserSessions(userID uint, exceptID string, now time.Time) ([]string, error) {
+	var ids []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, exceptID).
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return tx.Model(&Session{}).Where("id IN ?", ids).Update("revoked_at", now).Error
+	})
+	return ids, err
+}
+
+// CreateOIDCState stores a pending authorization request and prunes expired ones
+func (r *userRepository) CreateOIDCState(state *OIDCLoginState) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&OIDCLoginState{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(state).Error
+}
+
+// ConsumeOIDCState loads and deletes a pending authorization request, so a
+// state value can be redeemed only once
+func (r *userRepository) ConsumeOIDCState(stateHash string) (*OIDCLoginState, error) {
+	var state OIDCLoginState
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state_hash = ?", stateHash).First(&state).Error; err != nil {
+			return err
+		}
+		result := tx.Where("state_hash = ?", stateHash).Delete(&OIDCLoginState{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// FindIdentity finds a linked identity by provider and subject
+func (r *userRepository) FindIdentity(provider, subject string) (*LinkedIdentity, error) {
+	var identity LinkedIdentity
+	if err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// FindIdentities lists the identities linked to a user
+func (r *userRepository) FindIdentities(userID uint) ([]LinkedIdentity, error) {
+	var identities []LinkedIdentity
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+// CountIdentities counts the identities linked to a user
+func (r *userRepository) CountIdentities(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&LinkedIdentity{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// CreateIdentity links an external identity to a user
+func (r *userRepository) CreateIdentity(identity *LinkedIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+// TouchIdentity records a sign-in through a linked identity
+func (r *userRepository) TouchIdentity(id uint, email string, now time.Time) error {
+	return r.db.Model(&LinkedIdentity{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"email":         email,
+		"last_login_at": now,
+	}).Error
+}
+
+// DeleteIdentity unlinks one of a user's identities, reporting whether it existed
+func (r *userRepository) DeleteIdentity(userID, id uint) (bool, error) {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&LinkedIdentity{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CreateAPIKey stores a new API key
+func (r *userRepository) CreateAPIKey(key *APIKey) error {
+	return r.db.Create(key).Error
+}
+
+// FindAPIKeyByPrefix finds an API key by its public prefix
+func (r *userRepository) FindAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	var key APIKey
+	if err := r.db.Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// FindActiveAPIKeys lists a user's API keys that are neither revoked nor expired
+func (r *userRepository) FindActiveAPIKeys(userID uint, now time.Time) ([]APIKey, error) {
+	var keys []APIKey
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, now).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey revokes one of a user's API keys, reporting whether it existed
+func (r *userRepository) RevokeAPIKey(userID, id uint, now time.Time) (bool, error) {
+	result := r.db.Model(&APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// TouchAPIKey records when an API key was last used
+func (r *userRepository) TouchAPIKey(id uint, now time.Time) error {
+	return r.db.Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", now).Error
+}
+
+// FindProfile finds a user's profile
+func (r *userRepository) FindProfile(userID uint) (*UserProfile, error) {
+	var profile UserProfile
+	if err := r.db.First(&profile, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// SaveProfile saves a user's name and creates or replaces their profile in
+// one transaction. The user's version is incremented once, as the profile
+// can be included in user responses, and fails with ErrVersionMismatch when
+// the user changed since it was read.
+func (r *userRepository) SaveProfile(user *User, profile *UserProfile) error {
+	version, now := user.Version, time.Now()
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(profile).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&User{}).Where("id = ? AND version = ?", user.ID, version).
+			Updates(map[string]interface{}{"name": user.Name, "version": version + 1, "updated_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionMismatch
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	user.Version, user.UpdatedAt = version+1, now
+	return nil
 }
@@ -1,28 +1,139 @@
 package user
 
 import (
+	"log"
+	"strings"
+
 	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/cache"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/mailer"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"github.com/savindaJ/backend-app/internal/oidc"
+	"github.com/savindaJ/backend-app/internal/query"
 	"gorm.io/gorm"
 )
 
-// RegisterRoutes registers all user routes
-func RegisterRoutes(router *gin.RouterGroup, db *gorm.DB) {
+// RegisterRoutes registers all user routes and returns the user service for
+// modules that authenticate users. userCache, when set, caches users read by
+// ID. requireAuth authenticates protected routes; idempotent makes retries of
+// creating routes replay the first response.
+func RegisterRoutes(router *gin.RouterGroup, db *gorm.DB, cfg *config.Config, tokens *auth.TokenManager, sessions *SessionCache, providers *oidc.Registry, userCache *cache.Cache, requireAuth, idempotent gin.HandlerFunc) UserService {
 	// Initialize dependencies
+	hasher, err := auth.NewPasswordHasher(cfg.PasswordHashAlgorithm, auth.Argon2Params{
+		Memory:      uint32(cfg.Argon2MemoryKiB),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+		SaltLength:  auth.DefaultArgon2Params.SaltLength,
+		KeyLength:   auth.DefaultArgon2Params.KeyLength,
+	}, cfg.BcryptCost)
+	if err != nil {
+		log.Fatalf("❌ Invalid password hashing config: %v", err)
+	}
+
 	repo := NewUserRepository(db)
-	service := NewUserService(repo)
-	handler := NewUserHandler(service)
+	if userCache != nil {
+		repo = NewCachedUserRepository(repo, userCache)
+	}
+	service := NewUserService(repo, tokens, hasher, cfg, audit.NewRecorder(db, cfg.JWTSecret), mailer.New(cfg), sessions, providers)
+	handler := NewUserHandler(service, query.NewCodec(cfg.JWTSecret), cfg.OIDCStateTTL, cfg.OIDCStateCookieSecure)
+
+	bootstrapAdmins(repo, cfg.AdminEmails)
+
+	// Responses carrying tokens, secrets or recovery codes must not be stored
+	noStore := middleware.CacheControl("no-store")
 
 	// User routes
 	users := router.Group("/users")
 	{
 		// Public routes
-		users.POST("/register", handler.Register)
-		users.POST("/login", handler.Login)
-
-		// Protected routes (add auth middleware later)
-		users.GET("", handler.GetAll)
-		users.GET("/:id", handler.GetByID)
-		users.PUT("/:id", handler.Update)
-		users.DELETE("/:id", handler.Delete)
+		users.POST("/register", idempotent, handler.Register)
+		users.POST("/login", noStore, handler.Login)
+		users.POST("/login/mfa", noStore, handler.LoginMFA)
+		users.POST("/email/confirm", handler.ConfirmEmailChange)
+		users.POST("/token/refresh", noStore, handler.Refresh)
+		users.GET("/oidc/:provider/login", handler.OIDCLogin)
+		users.GET("/oidc/:provider/callback", noStore, handler.OIDCCallback)
+
+		// Protected routes, reachable with an access token, an OAuth client
+		// token or an API key granted the route's scope
+		protected := users.Group("", requireAuth)
+		protected.GET("/me", middleware.RequireScope(ScopeUsersRead), handler.GetMe)
+		protected.PUT("/me", middleware.RequireScope(ScopeUsersWrite), handler.UpdateMe)
+		// Admins read any user, other callers only themselves
+		protected.GET("/:id", middleware.RequireScope(ScopeUsersRead), handler.GetByID)
+
+		// Admin routes; users change their own account through /me
+		protected.GET("", middleware.RequireRole(RoleAdmin), middleware.RequireScope(ScopeUsersRead), handler.GetAll)
+		admin := protected.Group("", middleware.RequireRole(RoleAdmin), middleware.RequireScope(ScopeUsersWrite))
+		admin.PUT("/:id", handler.Update)
+		admin.PATCH("/:id", handler.Patch)
+		admin.DELETE("/:id", handler.Delete)
+		admin.POST("/:id/unlock", handler.Unlock)
+		admin.GET("/deleted", handler.ListDeleted)
+		admin.POST("/:id/restore", handler.Restore)
+		admin.POST("/import", handler.Import)
+		protected.GET("/export", middleware.RequireRole(RoleAdmin), middleware.RequireScope(ScopeUsersRead), handler.Export)
+
+		// Account security settings require an interactive login
+		account := protected.Group("", middleware.RequireFirstParty())
+		account.PUT("/:id/password", handler.ChangePassword)
+		account.POST("/:id/email", handler.ChangeEmail)
+		account.DELETE("/me", handler.DeleteMe)
+
+		// Sessions of the current user
+		account.POST("/logout", handler.Logout)
+		account.GET("/me/sessions", handler.ListSessions)
+		account.DELETE("/me/sessions", handler.RevokeAllSessions)
+		account.DELETE("/me/sessions/:sessionId", handler.RevokeSession)
+
+		// Two-factor authentication for the current user
+		account.POST("/me/mfa/enroll", noStore, handler.EnrollMFA)
+		account.POST("/me/mfa/confirm", noStore, handler.ConfirmMFA)
+		account.POST("/me/mfa/disable", handler.DisableMFA)
+
+		// External identities of the current user
+		account.GET("/me/identities", handler.ListIdentities)
+		account.POST("/me/identities/:provider", handler.LinkIdentity)
+		account.DELETE("/me/identities/:identityId", handler.UnlinkIdentity)
+
+		// API keys of the current user
+		account.GET("/me/api-keys", handler.ListAPIKeys)
+		account.POST("/me/api-keys", noStore, handler.CreateAPIKey)
+		account.DELETE("/me/api-keys/:keyId", handler.RevokeAPIKey)
+	}
+
+	return service
+}
+
+// TransactionalPath reports whether a call to path, relative to the API
+// prefix, runs in the transaction of an atomic batch. User routes do,
+// except sign-in, token refresh and OpenID Connect: the tokens they issue
+// would outlive a rollback.
+func TransactionalPath(path string) bool {
+	if path != "/users" && !strings.HasPrefix(path, "/users/") {
+		return false
+	}
+	for _, prefix := range []string{"/users/login", "/users/token/", "/users/oidc/"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// bootstrapAdmins grants the admin role to the configured accounts
+func bootstrapAdmins(repo UserRepository, emails []string) {
+	for _, email := range emails {
+		found, err := repo.SetRoleByEmail(email, RoleAdmin)
+		if err != nil {
+			log.Printf("⚠️  Failed to grant admin role to %s: %v", email, err)
+			continue
+		}
+		if !found {
+			log.Printf("⚠️  Admin account %s does not exist yet", email)
+		}
 	}
 }
@@ -1,9 +1,25 @@
 package user
 
 import (
+	"context"
+	"crypto/subtle"
 	"errors"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/config"
+	"github.com/savindaJ/backend-app/internal/database"
+	"github.com/savindaJ/backend-app/internal/mailer"
+	"github.com/savindaJ/backend-app/internal/oidc"
+	"github.com/savindaJ/backend-app/internal/query"
+	qrcode "github.com/skip2/go-qrcode"
 	"gorm.io/gorm"
 )
 
@@ -11,30 +27,160 @@ var (
 	ErrUserNotFound       = errors.New("user not found")
 	ErrEmailAlreadyExists = errors.New("email already exists")
 	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidMFACode     = errors.New("invalid two-factor code")
+	ErrMFACodeRequired    = errors.New("two-factor code required")
+	ErrMFAAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrMFANotEnrolled     = errors.New("two-factor enrollment has not been started")
+
+	ErrInvalidCurrentPassword = errors.New("current password is incorrect")
+	ErrInvalidEmailToken      = errors.New("invalid or expired email confirmation token")
+	ErrSameEmail              = errors.New("new email matches the current email")
+
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrSessionNotFound     = errors.New("session not found")
+
+	ErrUnknownProvider       = errors.New("unknown identity provider")
+	ErrInvalidOIDCState      = errors.New("invalid or expired login state")
+	ErrProviderUnavailable   = errors.New("identity provider is unavailable")
+	ErrOIDCLoginFailed       = errors.New("sign-in with the identity provider failed")
+	ErrIdentityEmailMissing  = errors.New("identity provider did not return an email address")
+	ErrIdentityEmailConflict = errors.New("an account with this email already exists; sign in and link the identity from your account")
+	ErrIdentityAlreadyLinked = errors.New("identity is already linked to another account")
+	ErrIdentityNotFound      = errors.New("linked identity not found")
+	ErrLastLoginMethod       = errors.New("cannot unlink the only way to sign in to this account")
+
+	ErrInvalidAPIKey       = errors.New("invalid or expired API key")
+	ErrAPIKeyNotFound      = errors.New("API key not found")
+	ErrInvalidAPIKeyExpiry = errors.New("API key expiry must be in the future")
+	ErrTooManyAPIKeys      = errors.New("API key limit reached; revoke an unused key first")
+
+	ErrInvalidLocale    = errors.New("locale must be a BCP 47 language tag such as en-US")
+	ErrInvalidTimezone  = errors.New("timezone must be an IANA time zone such as Europe/Berlin")
+	ErrInvalidAvatarURL = errors.New("avatar URL must be an absolute http or https URL")
+
+	ErrVersionMismatch = errors.New("user was modified since it was retrieved; fetch it again and retry")
 )
 
 // UserService interface defines the contract for user business logic
 type UserService interface {
-	Register(req *CreateUserRequest) (*UserResponse, error)
-	Login(req *LoginRequest) (*User, error)
+	// WithContext returns the service bound to a request's context, so that
+	// the calls of an atomic batch run in its transaction
+	WithContext(ctx context.Context) UserService
+	Register(req *CreateUserRequest, meta RequestMeta) (*UserResponse, error)
+	Login(req *LoginRequest, meta RequestMeta) (*LoginResponse, error)
+	Authenticate(req *LoginRequest, mfaCode string, meta RequestMeta) (*UserResponse, error)
+	VerifyMFALogin(req *MFALoginRequest, meta RequestMeta) (*LoginResponse, error)
+	Unlock(actorID, id uint, ip string, meta RequestMeta) error
+	EnrollMFA(userID uint) (*MFAEnrollResponse, error)
+	ConfirmMFA(userID uint, code string) (*MFARecoveryCodesResponse, error)
+	DisableMFA(userID uint, code string) error
 	GetByID(id uint) (*UserResponse, error)
-	GetAll(page, limit int) ([]UserResponse, int64, error)
-	Update(id uint, req *UpdateUserRequest) (*UserResponse, error)
-	Delete(id uint) error
+	GetByIDWith(id uint, view *query.View) (*UserResponse, error)
+	GetAll(q *query.Query, view *query.View, page, limit int) ([]UserResponse, int64, error)
+	GetPage(q *query.Query, view *query.View, cursor *query.Cursor, limit int, count bool) ([]UserResponse, *query.Page, error)
+	Update(actorID, id uint, versions []uint, req *UpdateUserRequest, meta RequestMeta) (*UserResponse, error)
+	GetProfile(userID uint) (*CurrentUserResponse, error)
+	UpdateProfile(actorID, id uint, versions []uint, req *UpdateProfileRequest, meta RequestMeta) (*CurrentUserResponse, error)
+	ChangePassword(id uint, req *ChangePasswordRequest, meta RequestMeta) error
+	Reauthenticate(userID uint, password string, meta RequestMeta) error
+	RequestEmailChange(id uint, req *ChangeEmailRequest, meta RequestMeta) error
+	ConfirmEmailChange(token string, meta RequestMeta) (*UserResponse, error)
+	Delete(actorID, id uint, versions []uint, meta RequestMeta) error
+	DeleteAccount(userID uint, versions []uint, password string, meta RequestMeta) error
+	Import(actorID uint, rows UserRows, dryRun bool, meta RequestMeta) (*ImportResult, error)
+	Export(q *query.Query, fn func(user *UserResponse) error) error
+	ListDeleted(page, limit int) ([]DeletedUserResponse, int64, error)
+	Restore(actorID, id uint, meta RequestMeta) (*UserResponse, error)
+	Refresh(refreshToken string, meta RequestMeta) (*LoginResponse, error)
+	Logout(userID uint, sessionID string) error
+	ListSessions(userID uint, currentSessionID string) ([]SessionResponse, error)
+	RevokeSession(userID uint, sessionID string) error
+	RevokeAllSessions(userID uint) error
+	StartOIDCLogin(ctx context.Context, provider string, linkUserID *uint) (*OIDCAuthorizationResponse, error)
+	CompleteOIDCLogin(ctx context.Context, provider, state, binding, code string, meta RequestMeta) (*OIDCResult, error)
+	ListIdentities(userID uint) ([]IdentityResponse, error)
+	UnlinkIdentity(userID, identityID uint, meta RequestMeta) error
+	CreateAPIKey(userID uint, req *CreateAPIKeyRequest, meta RequestMeta) (*APIKeyCreatedResponse, error)
+	ListAPIKeys(userID uint) ([]APIKeyResponse, error)
+	RevokeAPIKey(userID, keyID uint, meta RequestMeta) error
+}
+
+// OIDCResult is the outcome of an OpenID Connect callback: a login, or the
+// newly linked identity when a signed-in user started the flow
+type OIDCResult struct {
+	Login    *LoginResponse
+	Identity *IdentityResponse
 }
 
 // userService implements UserService
 type userService struct {
-	repo UserRepository
+	ctx       context.Context
+	repo      UserRepository
+	tokens    *auth.TokenManager
+	hasher    auth.PasswordHasher
+	cfg       *config.Config
+	auditor   audit.Recorder
+	mailer    mailer.Mailer
+	sessions  *SessionCache
+	throttle  *loginThrottler
+	passwords *passwordValidator
+	providers *oidc.Registry
+	dummy     *dummyPassword
+}
+
+// dummyPassword is a hash verified against when there is no user to check,
+// computed once and shared by every bound copy of the service
+type dummyPassword struct {
+	once sync.Once
+	hash string
 }
 
 // NewUserService creates a new user service
-func NewUserService(repo UserRepository) UserService {
-	return &userService{repo: repo}
+func NewUserService(repo UserRepository, tokens *auth.TokenManager, hasher auth.PasswordHasher, cfg *config.Config, auditor audit.Recorder, mail mailer.Mailer, sessions *SessionCache, providers *oidc.Registry) UserService {
+	return &userService{
+		ctx:       context.Background(),
+		repo:      repo,
+		tokens:    tokens,
+		hasher:    hasher,
+		cfg:       cfg,
+		auditor:   auditor,
+		mailer:    mail,
+		sessions:  sessions,
+		throttle:  newLoginThrottler(repo, auditor, cfg),
+		passwords: newPasswordValidator(repo, hasher, cfg),
+		providers: providers,
+		dummy:     &dummyPassword{},
+	}
+}
+
+// WithContext binds the repository and password history to ctx. Within an
+// atomic batch, audit events, emails and session revocations take effect
+// once the batch commits. Login throttling keeps its own connection, so
+// failed attempts count even when the batch rolls back.
+func (s *userService) WithContext(ctx context.Context) UserService {
+	bound := *s
+	bound.ctx = ctx
+	bound.repo = s.repo.WithContext(ctx)
+	bound.passwords = s.passwords.withRepo(bound.repo)
+	return &bound
+}
+
+// verifyDummyPassword burns the same hashing work as a real verification so
+// that unknown emails cannot be told apart by response time
+func (s *userService) verifyDummyPassword(password string) {
+	s.dummy.once.Do(func() {
+		s.dummy.hash, _ = s.hasher.Hash("dummy-password-for-timing")
+	})
+	s.hasher.Verify(password, s.dummy.hash)
 }
 
 // Register creates a new user
-func (s *userService) Register(req *CreateUserRequest) (*UserResponse, error) {
+func (s *userService) Register(req *CreateUserRequest, meta RequestMeta) (*UserResponse, error) {
+	if err := s.passwords.validate(req.Password, 0, req.Email, req.Name); err != nil {
+		return nil, err
+	}
+
 	// Check if email already exists
 	existingUser, err := s.repo.FindByEmail(req.Email)
 	if err == nil && existingUser != nil {
@@ -42,7 +188,7 @@ func (s *userService) Register(req *CreateUserRequest) (*UserResponse, error) {
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hasher.Hash(req.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -50,99 +196,1517 @@ func (s *userService) Register(req *CreateUserRequest) (*UserResponse, error) {
 	user := &User{
 		Name:     req.Name,
 		Email:    req.Email,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
+		Role:     RoleUser,
 	}
 
 	if err := s.repo.Create(user); err != nil {
 		return nil, err
 	}
+	if err := s.passwords.remember(user.ID, hashedPassword); err != nil {
+		return nil, err
+	}
+
+	s.record(&audit.Event{
+		Action:     "user.registered",
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+
+	return user.ToResponse(), nil
+}
+
+// Login authenticates a user. Accounts with two-factor authentication get a
+// short-lived challenge token instead of an access token. Repeated failures
+// per account and per IP are throttled with exponential backoff and lockout.
+func (s *userService) Login(req *LoginRequest, meta RequestMeta) (*LoginResponse, error) {
+	user, err := s.verifyPassword(req, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		return s.mfaChallenge(user)
+	}
+
+	if err := s.throttle.reset(ThrottleScopeAccount, normalizeEmail(user.Email)); err != nil {
+		return nil, err
+	}
+	return s.issueLogin(user, "password", meta)
+}
+
+// Authenticate verifies a user's password and, when enabled, second factor
+// without starting a session. It backs sign-in forms of other flows, such as
+// the OAuth consent screen, and is throttled like Login.
+func (s *userService) Authenticate(req *LoginRequest, mfaCode string, meta RequestMeta) (*UserResponse, error) {
+	user, err := s.verifyPassword(req, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		if mfaCode == "" {
+			return nil, ErrMFACodeRequired
+		}
+		if err := s.verifyMFACode(user, mfaCode, true); err != nil {
+			if err == ErrInvalidMFACode {
+				s.loginFailed(s.throttle.keys(user.Email, meta), user.Email, user, "invalid_mfa_code", meta)
+			}
+			return nil, err
+		}
+	}
 
+	if err := s.throttle.reset(ThrottleScopeAccount, normalizeEmail(user.Email)); err != nil {
+		return nil, err
+	}
+	s.recordLogin(user, "password", "", meta)
 	return user.ToResponse(), nil
 }
 
-// Login authenticates a user
-func (s *userService) Login(req *LoginRequest) (*User, error) {
+// verifyPassword checks an email and password, applying login throttling
+func (s *userService) verifyPassword(req *LoginRequest, meta RequestMeta) (*User, error) {
+	keys := s.throttle.keys(req.Email, meta)
+	if err := s.throttle.check(keys); err != nil {
+		s.recordLoginFailure(req.Email, nil, "throttled", meta)
+		return nil, err
+	}
+
 	user, err := s.repo.FindByEmail(req.Email)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.verifyDummyPassword(req.Password)
+			s.loginFailed(keys, req.Email, nil, "unknown_email", meta)
 			return nil, ErrInvalidCredentials
 		}
 		return nil, err
 	}
+	if !user.HasPassword() {
+		s.verifyDummyPassword(req.Password)
+		s.loginFailed(keys, req.Email, user, "no_password", meta)
+		return nil, ErrInvalidCredentials
+	}
 
 	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+	ok, err := s.hasher.Verify(req.Password, user.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.loginFailed(keys, req.Email, user, "invalid_password", meta)
 		return nil, ErrInvalidCredentials
 	}
+	s.upgradePasswordHash(user, req.Password)
 
 	return user, nil
 }
 
-// GetByID retrieves a user by ID
-func (s *userService) GetByID(id uint) (*UserResponse, error) {
-	user, err := s.repo.FindByID(id)
+// mfaChallenge issues the short-lived token that must be exchanged, together
+// with a second factor, for a login
+func (s *userService) mfaChallenge(user *User) (*LoginResponse, error) {
+	challenge, _, err := s.tokens.Issue(auth.TokenSpec{
+		UserID:  user.ID,
+		Role:    user.Role,
+		Purpose: auth.PurposeMFAChallenge,
+	}, s.cfg.MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{MFARequired: true, ChallengeToken: challenge}, nil
+}
+
+// VerifyMFALogin exchanges a challenge token and a TOTP or recovery code for an access token
+func (s *userService) VerifyMFALogin(req *MFALoginRequest, meta RequestMeta) (*LoginResponse, error) {
+	claims, err := s.tokens.Parse(req.ChallengeToken, auth.PurposeMFAChallenge)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := s.repo.FindByID(userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, ErrUserNotFound
+			return nil, ErrInvalidCredentials
 		}
 		return nil, err
 	}
-	return user.ToResponse(), nil
+	if !user.MFAEnabled {
+		return nil, ErrInvalidCredentials
+	}
+
+	keys := s.throttle.keys(user.Email, meta)
+	if err := s.throttle.check(keys); err != nil {
+		s.recordLoginFailure(user.Email, user, "throttled", meta)
+		return nil, err
+	}
+
+	if err := s.verifyMFACode(user, req.Code, true); err != nil {
+		if err == ErrInvalidMFACode {
+			s.loginFailed(keys, user.Email, user, "invalid_mfa_code", meta)
+		}
+		return nil, err
+	}
+
+	if err := s.throttle.reset(ThrottleScopeAccount, normalizeEmail(user.Email)); err != nil {
+		return nil, err
+	}
+	return s.issueLogin(user, "mfa", meta)
 }
 
-// GetAll retrieves all users with pagination
-func (s *userService) GetAll(page, limit int) ([]UserResponse, int64, error) {
-	users, total, err := s.repo.FindAll(page, limit)
+// Unlock clears failed-login tracking for a user's account and, optionally, an IP
+func (s *userService) Unlock(actorID, id uint, ip string, meta RequestMeta) error {
+	user, err := s.findUser(id)
 	if err != nil {
-		return nil, 0, err
+		return err
 	}
 
-	responses := make([]UserResponse, len(users))
-	for i, user := range users {
-		responses[i] = *user.ToResponse()
+	if err := s.throttle.reset(ThrottleScopeAccount, normalizeEmail(user.Email)); err != nil {
+		return err
+	}
+	if ip != "" {
+		if err := s.throttle.reset(ThrottleScopeIP, throttleIP(ip)); err != nil {
+			return err
+		}
 	}
 
-	return responses, total, nil
+	event := &audit.Event{
+		Action:     "auth.account_unlocked",
+		ActorID:    &actorID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	}
+	if ip != "" {
+		event.WithMetadata(map[string]string{"unlocked_ip": s.auditor.Pseudonym(ip)})
+	}
+	s.record(event)
+
+	return nil
 }
 
-// Update updates a user
-func (s *userService) Update(id uint, req *UpdateUserRequest) (*UserResponse, error) {
-	user, err := s.repo.FindByID(id)
+// EnrollMFA starts two-factor enrollment by generating a new pending secret
+func (s *userService) EnrollMFA(userID uint) (*MFAEnrollResponse, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	user.MFASecret = secret
+	user.MFALastStep = 0
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	uri := totpURI(s.cfg.MFAIssuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollResponse{Secret: secret, OTPAuthURL: uri, QRCodePNG: png}, nil
+}
+
+// ConfirmMFA enables two-factor authentication once the user proves they
+// can generate codes, and returns a fresh set of recovery codes
+func (s *userService) ConfirmMFA(userID uint, code string) (*MFARecoveryCodesResponse, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFASecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+
+	if err := s.verifyMFACode(user, code, false); err != nil {
+		return nil, err
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashRecoveryCode(c)
+	}
+	if err := s.repo.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		return nil, err
+	}
+
+	user.MFAEnabled = true
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	return &MFARecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableMFA turns off two-factor authentication after verifying a current code
+func (s *userService) DisableMFA(userID uint, code string) error {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+
+	if err := s.verifyMFACode(user, code, false); err != nil {
+		return err
+	}
+
+	if err := s.repo.DeleteRecoveryCodes(user.ID); err != nil {
+		return err
+	}
+
+	user.MFAEnabled = false
+	user.MFASecret = ""
+	user.MFALastStep = 0
+	return s.repo.Update(user)
+}
+
+// verifyMFACode accepts a TOTP code, or a recovery code when allowRecovery is set
+func (s *userService) verifyMFACode(user *User, code string, allowRecovery bool) error {
+	if step, ok := matchTOTP(user.MFASecret, code, time.Now()); ok {
+		fresh, err := s.repo.AdvanceMFAStep(user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidMFACode
+		}
+		// Keep user in step with the row, so it can still be updated
+		user.MFALastStep = step
+		user.Version++
+		return nil
+	}
+
+	if allowRecovery {
+		used, err := s.repo.UseRecoveryCode(user.ID, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+		if used {
+			return nil
+		}
+	}
+
+	return ErrInvalidMFACode
+}
+
+// upgradePasswordHash rehashes a verified password when its stored hash uses
+// an outdated algorithm or parameters. Failures are logged, not fatal.
+func (s *userService) upgradePasswordHash(user *User, password string) {
+	if !s.hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		log.Printf("⚠️  Failed to rehash password for user %d: %v", user.ID, err)
+		return
+	}
+	if err := s.repo.UpdatePassword(user.ID, hash); err != nil {
+		log.Printf("⚠️  Failed to store upgraded password hash for user %d: %v", user.ID, err)
+		return
+	}
+	user.Password = hash
+	user.Version++
+}
+
+// loginFailed counts a failed sign-in attempt towards throttling and records it
+func (s *userService) loginFailed(keys []throttleKey, email string, user *User, reason string, meta RequestMeta) {
+	s.throttle.fail(keys, meta)
+	s.recordLoginFailure(email, user, reason, meta)
+}
+
+// recordLoginFailure records a failed sign-in; user is nil for unknown emails
+func (s *userService) recordLoginFailure(email string, user *User, reason string, meta RequestMeta) {
+	event := &audit.Event{
+		Action:    "auth.login_failed",
+		IP:        meta.IP,
+		RequestID: meta.RequestID,
+	}
+	if user != nil {
+		event.TargetType = "user"
+		event.TargetID = strconv.FormatUint(uint64(user.ID), 10)
+	}
+	// Recorded right away, as the attempt happened even if the request's
+	// changes roll back
+	s.recordNow(event.WithMetadata(map[string]string{
+		"email":  s.auditor.Pseudonym(normalizeEmail(email)),
+		"reason": reason,
+	}))
+}
+
+// recordLogin records a successful sign-in and the session it started, if any
+func (s *userService) recordLogin(user *User, method, sessionID string, meta RequestMeta) {
+	metadata := map[string]string{"method": method}
+	if sessionID != "" {
+		metadata["session_id"] = sessionID
+	}
+	s.record((&audit.Event{
+		Action:     "auth.login_succeeded",
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	}).WithMetadata(metadata))
+}
+
+// issueLogin starts a new session for a fully authenticated user and
+// issues its access and refresh tokens. method names how the user signed
+// in for the audit log.
+func (s *userService) issueLogin(user *User, method string, meta RequestMeta) (*LoginResponse, error) {
+	sessionID, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshHash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:               sessionID,
+		UserID:           user.ID,
+		RefreshTokenHash: refreshHash,
+		UserAgent:        truncate(meta.UserAgent, 255),
+		IP:               meta.IP,
+		LastSeenAt:       now,
+		ExpiresAt:        now.Add(s.cfg.JWTRefreshTTL),
+	}
+	if err := s.repo.CreateSession(session); err != nil {
+		return nil, err
+	}
+
+	s.recordLogin(user, method, sessionID, meta)
+	return s.issueTokens(user, sessionID, refreshToken)
+}
+
+// issueTokens issues an access token bound to a session
+func (s *userService) issueTokens(user *User, sessionID, refreshToken string) (*LoginResponse, error) {
+	token, expiresAt, err := s.tokens.Issue(auth.TokenSpec{
+		UserID:    user.ID,
+		Role:      user.Role,
+		Purpose:   auth.PurposeAccess,
+		SessionID: sessionID,
+	}, s.cfg.JWTAccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{
+		Token:        token,
+		ExpiresAt:    &expiresAt,
+		RefreshToken: refreshToken,
+		User:         user.ToResponse(),
+	}, nil
+}
+
+// Refresh rotates a refresh token and issues a new access token. Presenting
+// a refresh token that was already rotated means it leaked, so the whole
+// session (token family) is revoked.
+func (s *userService) Refresh(refreshToken string, meta RequestMeta) (*LoginResponse, error) {
+	sessionID, hash, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.repo.FindSession(sessionID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, ErrUserNotFound
+			return nil, ErrInvalidRefreshToken
 		}
 		return nil, err
 	}
+	now := time.Now()
+	if !session.Active(now) {
+		return nil, ErrInvalidRefreshToken
+	}
 
-	// Check if new email already exists
-	if req.Email != "" && req.Email != user.Email {
-		existingUser, err := s.repo.FindByEmail(req.Email)
-		if err == nil && existingUser != nil {
-			return nil, ErrEmailAlreadyExists
+	newToken, newHash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	rotated := false
+	if hash == session.RefreshTokenHash {
+		rotated, err = s.repo.RotateSessionToken(sessionID, hash, newHash, now, now.Add(s.cfg.JWTRefreshTTL))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !rotated {
+		if _, err := s.repo.RevokeSession(session.UserID, sessionID, now); err != nil {
+			return nil, err
 		}
-		user.Email = req.Email
+		s.revokeCached(sessionID)
+		s.record(&audit.Event{
+			Action:     "auth.refresh_token_reused",
+			TargetType: "session",
+			TargetID:   sessionID,
+			IP:         meta.IP,
+			RequestID:  meta.RequestID,
+		})
+		return nil, ErrInvalidRefreshToken
 	}
 
-	if req.Name != "" {
-		user.Name = req.Name
+	user, err := s.repo.FindByID(session.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
 	}
 
-	if err := s.repo.Update(user); err != nil {
+	return s.issueTokens(user, sessionID, newToken)
+}
+
+// Logout revokes the caller's current session
+func (s *userService) Logout(userID uint, sessionID string) error {
+	if sessionID == "" {
+		return ErrSessionNotFound
+	}
+	return s.RevokeSession(userID, sessionID)
+}
+
+// ListSessions lists a user's active sessions, flagging the current one
+func (s *userService) ListSessions(userID uint, currentSessionID string) ([]SessionResponse, error) {
+	sessions, err := s.repo.FindActiveSessions(userID, time.Now())
+	if err != nil {
 		return nil, err
 	}
 
-	return user.ToResponse(), nil
+	responses := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		responses[i] = session.ToResponse(currentSessionID)
+	}
+	return responses, nil
+}
+
+// RevokeSession revokes one of a user's sessions
+func (s *userService) RevokeSession(userID uint, sessionID string) error {
+	revoked, err := s.repo.RevokeSession(userID, sessionID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrSessionNotFound
+	}
+	s.revokeCached(sessionID)
+	return nil
+}
+
+// RevokeAllSessions logs a user out everywhere
+func (s *userService) RevokeAllSessions(userID uint) error {
+	return s.revokeSessions(userID, "")
 }
 
-// Delete deletes a user
-func (s *userService) Delete(id uint) error {
-	_, err := s.repo.FindByID(id)
+// revokeSessions revokes every session of a user except keepID
+func (s *userService) revokeSessions(userID uint, keepID string) error {
+	ids, err := s.repo.RevokeUserSessions(userID, keepID, time.Now())
+	if err != nil {
+		return err
+	}
+	s.revokeCached(ids...)
+	return nil
+}
+
+// findUser loads a user and maps a missing record to ErrUserNotFound
+func (s *userService) findUser(id uint) (*User, error) {
+	user, err := s.repo.FindByID(id)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return ErrUserNotFound
+			return nil, ErrUserNotFound
 		}
-		return err
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetByID retrieves a user by ID
+func (s *userService) GetByID(id uint) (*UserResponse, error) {
+	return s.GetByIDWith(id, nil)
+}
+
+// GetByIDWith retrieves a user by ID with the relations view includes
+func (s *userService) GetByIDWith(id uint, view *query.View) (*UserResponse, error) {
+	user, err := s.repo.FindByIDWith(id, view)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user.ToResponse(), nil
+}
+
+// GetAll retrieves the users matching q with pagination
+func (s *userService) GetAll(q *query.Query, view *query.View, page, limit int) ([]UserResponse, int64, error) {
+	users, total, err := s.repo.FindAll(q, view, page, limit)
+	if err != nil {
+		return nil, 0, err
 	}
-	return s.repo.Delete(id)
+
+	responses := make([]UserResponse, len(users))
+	for i, user := range users {
+		responses[i] = *user.ToResponse()
+	}
+
+	return responses, total, nil
+}
+
+// GetPage retrieves the users matching q with keyset pagination
+func (s *userService) GetPage(q *query.Query, view *query.View, cursor *query.Cursor, limit int, count bool) ([]UserResponse, *query.Page, error) {
+	users, page, err := s.repo.FindPage(q, view, cursor, limit, count)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	responses := make([]UserResponse, len(users))
+	for i, user := range users {
+		responses[i] = *user.ToResponse()
+	}
+
+	return responses, page, nil
+}
+
+// Update updates a user, recording the changed fields. Unless versions is
+// nil, the user must currently have one of the given versions.
+func (s *userService) Update(actorID, id uint, versions []uint, req *UpdateUserRequest, meta RequestMeta) (*UserResponse, error) {
+	user, err := s.repo.FindByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if versions != nil && !slices.Contains(versions, user.Version) {
+		return nil, ErrVersionMismatch
+	}
+	before := user.ToResponse()
+
+	if req.Name != "" {
+		user.Name = req.Name
+	}
+
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	after := user.ToResponse()
+	if changes := audit.Diff(before, after, "updated_at").Pseudonymize(s.auditor.Pseudonym, personalFields...); len(changes) > 0 {
+		s.record((&audit.Event{
+			Action:     "user.updated",
+			ActorID:    &actorID,
+			TargetType: "user",
+			TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+			IP:         meta.IP,
+			RequestID:  meta.RequestID,
+		}).WithChanges(changes))
+	}
+
+	return after, nil
+}
+
+// GetProfile returns a user together with their profile details
+func (s *userService) GetProfile(userID uint) (*CurrentUserResponse, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.findProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &CurrentUserResponse{UserResponse: *user.ToResponse(), Profile: profile.ToResponse()}, nil
+}
+
+// UpdateProfile updates a user's name and profile details, recording the
+// changed fields as made by actorID. versions are the user versions the caller expects; nil
+// accepts any.
+func (s *userService) UpdateProfile(actorID, id uint, versions []uint, req *UpdateProfileRequest, meta RequestMeta) (*CurrentUserResponse, error) {
+	user, err := s.findUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if versions != nil && !slices.Contains(versions, user.Version) {
+		return nil, ErrVersionMismatch
+	}
+	profile, err := s.findProfile(id)
+	if err != nil {
+		return nil, err
+	}
+	beforeUser, beforeProfile := user.ToResponse(), profile.ToResponse()
+
+	if err := applyProfileUpdate(profile, req); err != nil {
+		return nil, err
+	}
+	if req.Name != "" {
+		user.Name = req.Name
+	}
+
+	afterProfile := profile.ToResponse()
+	changes := audit.Diff(beforeUser, user.ToResponse(), "updated_at").Pseudonymize(s.auditor.Pseudonym, personalFields...)
+	for field, change := range audit.Diff(beforeProfile, afterProfile).Pseudonymize(s.auditor.Pseudonym, personalFields...) {
+		changes["profile."+field] = change
+	}
+	if len(changes) == 0 {
+		return &CurrentUserResponse{UserResponse: *user.ToResponse(), Profile: afterProfile}, nil
+	}
+
+	// The name and profile are saved together so the returned version is current
+	if err := s.repo.SaveProfile(user, profile); err != nil {
+		return nil, err
+	}
+	s.record((&audit.Event{
+		Action:     "user.updated",
+		ActorID:    &actorID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	}).WithChanges(changes))
+
+	return &CurrentUserResponse{UserResponse: *user.ToResponse(), Profile: afterProfile}, nil
+}
+
+// findProfile loads a user's profile, or an empty one if none was saved yet
+func (s *userService) findProfile(userID uint) (*UserProfile, error) {
+	profile, err := s.repo.FindProfile(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &UserProfile{UserID: userID}, nil
+		}
+		return nil, err
+	}
+	return profile, nil
+}
+
+// ChangePassword replaces a user's password after re-authenticating with the current one
+func (s *userService) ChangePassword(id uint, req *ChangePasswordRequest, meta RequestMeta) error {
+	user, err := s.findUser(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.verifyCurrentPassword(user, req.CurrentPassword, meta); err != nil {
+		return err
+	}
+	if err := s.passwords.validate(req.NewPassword, user.ID, user.Email, user.Name); err != nil {
+		return err
+	}
+
+	hash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdatePassword(user.ID, hash); err != nil {
+		return err
+	}
+	if err := s.passwords.remember(user.ID, hash); err != nil {
+		return err
+	}
+
+	// Sign out every other device that may know the old password
+	if err := s.revokeSessions(user.ID, meta.SessionID); err != nil {
+		return err
+	}
+
+	s.record(&audit.Event{
+		Action:     "user.password_changed",
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+	s.notify(mailer.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body:    "The password for your account was just changed. If this wasn't you, reset your password and contact support immediately.",
+	})
+
+	return nil
+}
+
+// RequestEmailChange stores the new address as pending and sends a
+// confirmation token to it. Email is only replaced once confirmed.
+func (s *userService) RequestEmailChange(id uint, req *ChangeEmailRequest, meta RequestMeta) error {
+	user, err := s.findUser(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.verifyCurrentPassword(user, req.CurrentPassword, meta); err != nil {
+		return err
+	}
+	if normalizeEmail(req.NewEmail) == normalizeEmail(user.Email) {
+		return ErrSameEmail
+	}
+	if existingUser, err := s.repo.FindByEmail(req.NewEmail); err == nil && existingUser != nil {
+		return ErrEmailAlreadyExists
+	}
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(s.cfg.EmailChangeTTL)
+
+	user.PendingEmail = req.NewEmail
+	user.EmailChangeTokenHash = tokenHash
+	user.EmailChangeExpiresAt = &expiresAt
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+
+	if err := s.send(mailer.Message{
+		To:      req.NewEmail,
+		Subject: "Confirm your new email address",
+		Body: "Confirm this address for your account by submitting the token below to " +
+			s.cfg.AppBaseURL + "/api/v1/users/email/confirm before " + expiresAt.Format(time.RFC1123) + ":\n\n" + token,
+	}); err != nil {
+		return err
+	}
+
+	s.record(&audit.Event{
+		Action:     "user.email_change_requested",
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+
+	return nil
+}
+
+// ConfirmEmailChange applies a pending email change and notifies the old address
+func (s *userService) ConfirmEmailChange(token string, meta RequestMeta) (*UserResponse, error) {
+	user, err := s.repo.FindByEmailChangeToken(auth.HashOpaqueToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidEmailToken
+		}
+		return nil, err
+	}
+	if user.PendingEmail == "" || user.EmailChangeExpiresAt == nil || time.Now().After(*user.EmailChangeExpiresAt) {
+		return nil, ErrInvalidEmailToken
+	}
+
+	// The address may have been taken since the change was requested
+	if existingUser, err := s.repo.FindByEmail(user.PendingEmail); err == nil && existingUser != nil {
+		return nil, ErrEmailAlreadyExists
+	}
+
+	oldEmail := user.Email
+	now := time.Now()
+	user.Email = user.PendingEmail
+	user.EmailVerifiedAt = &now
+	user.PendingEmail = ""
+	user.EmailChangeTokenHash = ""
+	user.EmailChangeExpiresAt = nil
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.record((&audit.Event{
+		Action:     "user.email_changed",
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	}).WithMetadata(map[string]string{"from": s.auditor.Pseudonym(oldEmail), "to": s.auditor.Pseudonym(user.Email)}))
+	s.notify(mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body:    "The email address for your account was changed to " + user.Email + ". If this wasn't you, contact support immediately.",
+	})
+
+	return user.ToResponse(), nil
+}
+
+// Reauthenticate confirms a signed-in user's password before an action other
+// modules guard, e.g. erasing the account. Users without a password, who sign
+// in with an identity provider only, have nothing to confirm.
+func (s *userService) Reauthenticate(userID uint, password string, meta RequestMeta) error {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.HasPassword() {
+		return nil
+	}
+	return s.verifyCurrentPassword(user, password, meta)
+}
+
+// verifyCurrentPassword re-authenticates a user for a sensitive change.
+// Failures count towards login throttling so this cannot be used to guess passwords.
+func (s *userService) verifyCurrentPassword(user *User, password string, meta RequestMeta) error {
+	keys := s.throttle.keys(user.Email, meta)
+	if err := s.throttle.check(keys); err != nil {
+		return err
+	}
+
+	if !user.HasPassword() {
+		return ErrInvalidCurrentPassword
+	}
+
+	ok, err := s.hasher.Verify(password, user.Password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.throttle.fail(keys, meta)
+		return ErrInvalidCurrentPassword
+	}
+	return nil
+}
+
+// StartOIDCLogin begins an authorization code flow with PKCE and returns the
+// provider URL to send the user agent to, along with the binding the same
+// user agent must present at the callback. When linkUserID is set, the
+// callback links the identity to that user instead of signing in.
+func (s *userService) StartOIDCLogin(ctx context.Context, provider string, linkUserID *uint) (*OIDCAuthorizationResponse, error) {
+	client, err := s.providers.Get(provider)
+	if err != nil {
+		return nil, ErrUnknownProvider
+	}
+
+	state, err := oidc.RandomToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := oidc.RandomToken()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := oidc.RandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := client.AuthCodeURL(ctx, state, nonce, oidc.S256Challenge(verifier))
+	if err != nil {
+		log.Printf("⚠️  OIDC provider %s is unavailable: %v", provider, err)
+		return nil, ErrProviderUnavailable
+	}
+
+	stateHash := auth.HashOpaqueToken(state)
+	if err := s.repo.CreateOIDCState(&OIDCLoginState{
+		StateHash:    stateHash,
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(s.cfg.OIDCStateTTL),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &OIDCAuthorizationResponse{AuthorizationURL: authURL, Binding: stateHash}, nil
+}
+
+// CompleteOIDCLogin handles the provider callback: it redeems the state,
+// exchanges the code, verifies the ID token and then signs in the linked
+// user, links the identity to an existing account or creates a new one.
+// The binding issued with the state must come from the same user agent,
+// so an attacker cannot have a victim's browser complete a flow the
+// attacker started and end up signed in to, or linked with, their account.
+func (s *userService) CompleteOIDCLogin(ctx context.Context, provider, state, binding, code string, meta RequestMeta) (*OIDCResult, error) {
+	stateHash := auth.HashOpaqueToken(state)
+	if subtle.ConstantTimeCompare([]byte(binding), []byte(stateHash)) != 1 {
+		return nil, ErrInvalidOIDCState
+	}
+	pending, err := s.repo.ConsumeOIDCState(stateHash)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+	if pending.Provider != provider || time.Now().After(pending.ExpiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	client, err := s.providers.Get(provider)
+	if err != nil {
+		return nil, ErrUnknownProvider
+	}
+	claims, err := client.Exchange(ctx, code, pending.CodeVerifier, pending.Nonce)
+	if err != nil {
+		log.Printf("⚠️  OIDC login with %s failed: %v", provider, err)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	identity, err := s.repo.FindIdentity(provider, claims.Subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Linking an additional identity to the signed-in user
+	if pending.LinkUserID != nil {
+		if identity != nil {
+			if identity.UserID != *pending.LinkUserID {
+				return nil, ErrIdentityAlreadyLinked
+			}
+			response := identity.ToResponse()
+			return &OIDCResult{Identity: &response}, nil
+		}
+
+		user, err := s.findUser(*pending.LinkUserID)
+		if err != nil {
+			return nil, err
+		}
+		identity, err = s.linkIdentity(user, provider, claims, meta)
+		if err != nil {
+			return nil, err
+		}
+		response := identity.ToResponse()
+		return &OIDCResult{Identity: &response}, nil
+	}
+
+	var user *User
+	if identity != nil {
+		if err := s.repo.TouchIdentity(identity.ID, normalizeEmail(claims.Email), time.Now()); err != nil {
+			return nil, err
+		}
+		user, err = s.repo.FindByID(identity.UserID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrOIDCLoginFailed
+			}
+			return nil, err
+		}
+	} else {
+		user, err = s.resolveOIDCUser(claims)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.linkIdentity(user, provider, claims, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	var login *LoginResponse
+	if user.MFAEnabled {
+		login, err = s.mfaChallenge(user)
+	} else {
+		login, err = s.issueLogin(user, "oidc:"+provider, meta)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCResult{Login: login}, nil
+}
+
+// resolveOIDCUser finds the account for a first-time external identity by
+// email, or creates one. Matching by email is only safe when both the
+// provider and this service have verified the address; otherwise an
+// attacker could pre-register a victim's email, or a provider could assert
+// an address its user does not own.
+func (s *userService) resolveOIDCUser(claims *oidc.IDTokenClaims) (*User, error) {
+	email := normalizeEmail(claims.Email)
+	if email == "" {
+		return nil, ErrIdentityEmailMissing
+	}
+
+	existing, err := s.repo.FindByEmail(email)
+	if err == nil {
+		if !claims.EmailVerified || existing.EmailVerifiedAt == nil {
+			return nil, ErrIdentityEmailConflict
+		}
+		return existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	user := &User{
+		Name:  truncate(name, 100),
+		Email: email,
+		Role:  RoleUser,
+	}
+	if claims.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	if err := s.repo.Create(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// linkIdentity links an external identity to a user
+func (s *userService) linkIdentity(user *User, provider string, claims *oidc.IDTokenClaims, meta RequestMeta) (*LinkedIdentity, error) {
+	identity := &LinkedIdentity{
+		UserID:      user.ID,
+		Provider:    provider,
+		Subject:     claims.Subject,
+		Email:       normalizeEmail(claims.Email),
+		LastLoginAt: time.Now(),
+	}
+	if err := s.repo.CreateIdentity(identity); err != nil {
+		return nil, err
+	}
+
+	s.record((&audit.Event{
+		Action:     "auth.identity_linked",
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	}).WithMetadata(map[string]string{"provider": provider, "subject": s.auditor.Pseudonym(claims.Subject)}))
+	return identity, nil
+}
+
+// ListIdentities lists the external identities linked to a user
+func (s *userService) ListIdentities(userID uint) ([]IdentityResponse, error) {
+	identities, err := s.repo.FindIdentities(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]IdentityResponse, len(identities))
+	for i, identity := range identities {
+		responses[i] = identity.ToResponse()
+	}
+	return responses, nil
+}
+
+// UnlinkIdentity removes a linked identity unless it is the account's only
+// way to sign in
+func (s *userService) UnlinkIdentity(userID, identityID uint, meta RequestMeta) error {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if !user.HasPassword() {
+		count, err := s.repo.CountIdentities(userID)
+		if err != nil {
+			return err
+		}
+		if count <= 1 {
+			return ErrLastLoginMethod
+		}
+	}
+
+	deleted, err := s.repo.DeleteIdentity(userID, identityID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrIdentityNotFound
+	}
+
+	s.record(&audit.Event{
+		Action:     "auth.identity_unlinked",
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(user.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+	return nil
+}
+
+// CreateAPIKey issues a personal API key. The key itself is only returned
+// here; afterwards it is identified by its prefix.
+func (s *userService) CreateAPIKey(userID uint, req *CreateAPIKeyRequest, meta RequestMeta) (*APIKeyCreatedResponse, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return nil, ErrInvalidAPIKeyExpiry
+	}
+	active, err := s.repo.FindActiveAPIKeys(user.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(active) >= maxAPIKeysPerUser {
+		return nil, ErrTooManyAPIKeys
+	}
+
+	key, prefix, hash, err := newAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	scopes := slices.Clone(req.Scopes)
+	slices.Sort(scopes)
+	apiKey := &APIKey{
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    strings.Join(slices.Compact(scopes), " "),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.repo.CreateAPIKey(apiKey); err != nil {
+		return nil, err
+	}
+
+	s.record((&audit.Event{
+		Action:     "api_key.created",
+		ActorID:    &user.ID,
+		TargetType: "api_key",
+		TargetID:   strconv.FormatUint(uint64(apiKey.ID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	}).WithMetadata(map[string]interface{}{"prefix": prefix, "scopes": apiKey.ScopeList()}))
+
+	return &APIKeyCreatedResponse{APIKeyResponse: apiKey.ToResponse(), Key: key}, nil
+}
+
+// ListAPIKeys lists a user's active API keys
+func (s *userService) ListAPIKeys(userID uint) ([]APIKeyResponse, error) {
+	keys, err := s.repo.FindActiveAPIKeys(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]APIKeyResponse, len(keys))
+	for i, key := range keys {
+		responses[i] = key.ToResponse()
+	}
+	return responses, nil
+}
+
+// RevokeAPIKey revokes one of a user's API keys
+func (s *userService) RevokeAPIKey(userID, keyID uint, meta RequestMeta) error {
+	revoked, err := s.repo.RevokeAPIKey(userID, keyID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+
+	s.record(&audit.Event{
+		Action:     "api_key.revoked",
+		ActorID:    &userID,
+		TargetType: "api_key",
+		TargetID:   strconv.FormatUint(uint64(keyID), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+	return nil
+}
+
+// record writes an audit event once the changes it describes are
+// committed; failures are logged and never block the action
+func (s *userService) record(event *audit.Event) {
+	database.AfterCommit(s.ctx, func() { s.recordNow(event) })
+}
+
+// recordNow writes an audit event right away
+func (s *userService) recordNow(event *audit.Event) {
+	if err := s.auditor.Record(event); err != nil {
+		log.Printf("⚠️  Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// personalFields are user and profile attributes recorded in audit diffs
+// as pseudonyms, as the audit log outlives account erasure
+var personalFields = []string{"name", "email", "pending_email", "display_name", "avatar_url", "bio"}
+
+// notify sends an informational email once the request's changes are
+// committed; failures are logged and never block the action
+func (s *userService) notify(msg mailer.Message) {
+	database.AfterCommit(s.ctx, func() {
+		if err := s.mailer.Send(msg); err != nil {
+			log.Printf("⚠️  Failed to send %q email: %v", msg.Subject, err)
+		}
+	})
+}
+
+// send sends an email the action depends on. Within a transaction it is
+// sent once the transaction commits, when failures can only be logged.
+func (s *userService) send(msg mailer.Message) error {
+	if database.InTransaction(s.ctx) {
+		s.notify(msg)
+		return nil
+	}
+	return s.mailer.Send(msg)
+}
+
+// revokeCached marks sessions as revoked in the session cache once their
+// revocation is committed
+func (s *userService) revokeCached(ids ...string) {
+	database.AfterCommit(s.ctx, func() { s.sessions.Revoke(ids...) })
+}
+
+// Delete deletes a user. Unless versions is nil, the user must currently
+// have one of the given versions.
+func (s *userService) Delete(actorID, id uint, versions []uint, meta RequestMeta) error {
+	user, err := s.repo.FindByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if versions != nil && !slices.Contains(versions, user.Version) {
+		return ErrVersionMismatch
+	}
+	if err := s.repo.Delete(user); err != nil {
+		return err
+	}
+
+	s.record(&audit.Event{
+		Action:     "user.deleted",
+		ActorID:    &actorID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(id), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+	return s.revokeSessions(id, "")
+}
+
+// DeleteAccount deletes the current user's own account after re-checking
+// their password
+func (s *userService) DeleteAccount(userID uint, versions []uint, password string, meta RequestMeta) error {
+	if err := s.Reauthenticate(userID, password, meta); err != nil {
+		return err
+	}
+	return s.Delete(userID, userID, versions, meta)
+}
+
+// importRow is a valid import row waiting to be created with its batch
+type importRow struct {
+	row int
+	req *CreateUserRequest
+}
+
+// Import creates users from rows in batches, each batch in its own
+// transaction. Rows that are invalid, break the password policy or use an
+// email that is taken are reported and skipped. A dry run only validates.
+func (s *userService) Import(actorID uint, rows UserRows, dryRun bool, meta RequestMeta) (*ImportResult, error) {
+	result := &ImportResult{DryRun: dryRun, Errors: []ImportRowError{}}
+	seen := map[string]bool{}
+	batch := make([]importRow, 0, importBatchSize)
+
+	for {
+		row, req, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			result.Rows++
+			result.addError(rowErr)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Rows++
+
+		email := strings.ToLower(req.Email)
+		if seen[email] {
+			result.addError(&RowError{Row: row, Email: req.Email, Err: "email appears earlier in the import"})
+			continue
+		}
+		seen[email] = true
+		if err := s.passwords.validate(req.Password, 0, req.Email, req.Name); err != nil {
+			result.addError(&RowError{Row: row, Email: req.Email, Err: err.Error()})
+			continue
+		}
+
+		batch = append(batch, importRow{row: row, req: req})
+		if len(batch) == importBatchSize {
+			if err := s.importBatch(batch, dryRun, result); err != nil {
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := s.importBatch(batch, dryRun, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if !dryRun && result.Created > 0 {
+		s.record((&audit.Event{
+			Action:     "users.imported",
+			ActorID:    &actorID,
+			TargetType: "user",
+			IP:         meta.IP,
+			RequestID:  meta.RequestID,
+		}).WithMetadata(map[string]int{"created": result.Created, "failed": result.Failed}))
+	}
+	return result, nil
+}
+
+// importBatch creates a batch of valid import rows, skipping rows whose
+// email is already taken
+func (s *userService) importBatch(batch []importRow, dryRun bool, result *ImportResult) error {
+	emails := make([]string, len(batch))
+	for i, row := range batch {
+		emails[i] = row.req.Email
+	}
+	existing, err := s.repo.FindExistingEmails(emails)
+	if err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	for _, email := range existing {
+		taken[strings.ToLower(email)] = true
+	}
+
+	rows := make([]importRow, 0, len(batch))
+	for _, row := range batch {
+		if taken[strings.ToLower(row.req.Email)] {
+			result.addError(&RowError{Row: row.row, Email: row.req.Email, Err: ErrEmailAlreadyExists.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if dryRun || len(rows) == 0 {
+		result.Created += len(rows)
+		return nil
+	}
+
+	users, err := s.hashImportRows(rows)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.CreateBatch(users, s.passwords.historySize > 0); err != nil {
+		log.Printf("⚠️  Failed to import a batch of users: %v", err)
+		for _, row := range rows {
+			result.addError(&RowError{Row: row.row, Email: row.req.Email, Err: "failed to create user"})
+		}
+		return nil
+	}
+	result.Created += len(users)
+	return nil
+}
+
+// hashImportRows hashes the passwords of import rows with a few workers,
+// as password hashing dominates the cost of an import
+func (s *userService) hashImportRows(rows []importRow) ([]User, error) {
+	users := make([]User, len(rows))
+	errs := make([]error, len(rows))
+	work := make(chan int)
+
+	var wg sync.WaitGroup
+	for range importHashWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				hash, err := s.hasher.Hash(rows[i].req.Password)
+				users[i] = User{Name: rows[i].req.Name, Email: rows[i].req.Email, Password: hash, Role: RoleUser}
+				errs[i] = err
+			}
+		}()
+	}
+	for i := range rows {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	return users, errors.Join(errs...)
+}
+
+// Export passes every user matching q's filters to fn, ordered by ID
+func (s *userService) Export(q *query.Query, fn func(user *UserResponse) error) error {
+	return s.repo.FindEach(q, importBatchSize, func(users []User) error {
+		for i := range users {
+			if err := fn(users[i].ToResponse()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListDeleted retrieves soft-deleted users with the time they will be purged
+func (s *userService) ListDeleted(page, limit int) ([]DeletedUserResponse, int64, error) {
+	users, total, err := s.repo.FindDeleted(page, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	responses := make([]DeletedUserResponse, len(users))
+	for i, user := range users {
+		responses[i] = DeletedUserResponse{
+			UserResponse: *user.ToResponse(),
+			DeletedAt:    user.DeletedAt.Time,
+		}
+		if s.cfg.UserDeletedRetention > 0 {
+			purgeAt := user.DeletedAt.Time.Add(s.cfg.UserDeletedRetention)
+			responses[i].PurgeAt = &purgeAt
+		}
+	}
+
+	return responses, total, nil
+}
+
+// Restore undeletes a soft-deleted user. It fails when another account has
+// registered the user's email in the meantime.
+func (s *userService) Restore(actorID, id uint, meta RequestMeta) (*UserResponse, error) {
+	user, err := s.repo.FindDeletedByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	if _, err := s.repo.FindByEmail(user.Email); err == nil {
+		return nil, ErrEmailAlreadyExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	restored, err := s.repo.Restore(id)
+	if err != nil {
+		return nil, err
+	}
+	if !restored {
+		return nil, ErrUserNotFound
+	}
+
+	s.record(&audit.Event{
+		Action:     "user.restored",
+		ActorID:    &actorID,
+		TargetType: "user",
+		TargetID:   strconv.FormatUint(uint64(id), 10),
+		IP:         meta.IP,
+		RequestID:  meta.RequestID,
+	})
+
+	user.DeletedAt = gorm.DeletedAt{}
+	return user.ToResponse(), nil
 }
@@ -0,0 +1,137 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/savindaJ/backend-app/internal/auth"
+	"gorm.io/gorm"
+)
+
+// maxCachedSessions bounds the session cache before expired entries are swept
+const maxCachedSessions = 10000
+
+// SessionCache answers "is this session still active?" for the auth
+// middleware without a database round trip on every request. Revocations
+// made by this process take effect immediately; those made elsewhere are
+// picked up once the cached entry is older than ttl.
+type SessionCache struct {
+	repo UserRepository
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.RWMutex
+	entries map[string]sessionEntry
+}
+
+type sessionEntry struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// NewSessionCache creates a session cache backed by the sessions table
+func NewSessionCache(db *gorm.DB, ttl time.Duration) *SessionCache {
+	return &SessionCache{
+		repo:    NewUserRepository(db),
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]sessionEntry),
+	}
+}
+
+// IsSessionActive implements middleware.SessionValidator. A cache miss also
+// refreshes the session's last-seen time, so it is updated at most once per ttl.
+func (c *SessionCache) IsSessionActive(id string) bool {
+	now := c.now()
+
+	c.mu.RLock()
+	entry, ok := c.entries[id]
+	c.mu.RUnlock()
+	if ok && now.Sub(entry.checkedAt) < c.ttl {
+		return entry.active
+	}
+
+	session, err := c.repo.FindSession(id)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("⚠️  Failed to look up session %s: %v", id, err)
+		}
+		return false
+	}
+
+	active := session.Active(now)
+	if active {
+		if err := c.repo.TouchSession(id, now); err != nil {
+			log.Printf("⚠️  Failed to update last-seen time of session %s: %v", id, err)
+		}
+	}
+	c.store(id, active, now)
+	return active
+}
+
+// Revoke marks sessions as inactive in the cache
+func (c *SessionCache) Revoke(ids ...string) {
+	now := c.now()
+	for _, id := range ids {
+		c.store(id, false, now)
+	}
+}
+
+func (c *SessionCache) store(id string, active bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCachedSessions {
+		for key, entry := range c.entries {
+			if now.Sub(entry.checkedAt) >= c.ttl {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[id] = sessionEntry{active: active, checkedAt: now}
+}
+
+// newSessionID returns a random 128-bit hex session ID
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// newRefreshToken returns a refresh token of the form "<session ID>.<secret>"
+// together with the hash of the secret to store
+func newRefreshToken(sessionID string) (token, hash string, err error) {
+	secret, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	return sessionID + "." + secret, hash, nil
+}
+
+// parseRefreshToken splits a refresh token into its session ID and secret hash
+func parseRefreshToken(token string) (sessionID, hash string, ok bool) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", false
+	}
+	return sessionID, auth.HashOpaqueToken(secret), true
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
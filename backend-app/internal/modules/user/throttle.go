@@ -0,0 +1,182 @@
+package user
+
+import (
+	"errors"
+	"log"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/config"
+	"gorm.io/gorm"
+)
+
+// LoginThrottledError is returned when a login is rejected because of
+// earlier failures. RetryAfter tells the client when to try again.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return "account temporarily locked due to too many failed login attempts"
+	}
+	return "too many failed login attempts, try again later"
+}
+
+// throttleKey identifies one failed-login counter
+type throttleKey struct {
+	scope      string
+	identifier string
+}
+
+// loginThrottler applies exponential backoff and temporary lockout to
+// failed logins, tracked both per account and per client IP
+type loginThrottler struct {
+	repo    UserRepository
+	auditor audit.Recorder
+	cfg     *config.Config
+	now     func() time.Time
+}
+
+func newLoginThrottler(repo UserRepository, auditor audit.Recorder, cfg *config.Config) *loginThrottler {
+	return &loginThrottler{repo: repo, auditor: auditor, cfg: cfg, now: time.Now}
+}
+
+// keys returns the counters a login attempt is tracked under. Unknown
+// emails are tracked exactly like real ones so responses do not reveal
+// which accounts exist. meta.IP only reflects forwarding headers sent by
+// the configured trusted proxies, so clients cannot pick their own counter.
+func (t *loginThrottler) keys(email string, meta RequestMeta) []throttleKey {
+	keys := []throttleKey{{scope: ThrottleScopeAccount, identifier: normalizeEmail(email)}}
+	if meta.IP != "" {
+		keys = append(keys, throttleKey{scope: ThrottleScopeIP, identifier: throttleIP(meta.IP)})
+	}
+	return keys
+}
+
+// throttleIP returns the IP counter of a client. IPv6 clients usually hold
+// a whole /64, so they are counted per /64 rather than per address.
+func throttleIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix.String()
+}
+
+// check rejects an attempt while a counter is locked or still backing off
+func (t *loginThrottler) check(keys []throttleKey) error {
+	now := t.now()
+	var rejection *LoginThrottledError
+
+	for _, key := range keys {
+		throttle, err := t.repo.FindThrottle(key.scope, key.identifier)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return err
+		}
+
+		var candidate *LoginThrottledError
+		if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+			candidate = &LoginThrottledError{RetryAfter: throttle.LockedUntil.Sub(now), Locked: true}
+		} else if now.Sub(throttle.LastFailureAt) <= t.cfg.LoginLockoutDuration {
+			if next := throttle.LastFailureAt.Add(t.backoff(throttle.Failures)); now.Before(next) {
+				candidate = &LoginThrottledError{RetryAfter: next.Sub(now)}
+			}
+		}
+
+		if candidate != nil && (rejection == nil || candidate.RetryAfter > rejection.RetryAfter) {
+			rejection = candidate
+		}
+	}
+
+	if rejection != nil {
+		return rejection
+	}
+	return nil
+}
+
+// fail records a failed attempt and locks counters that reached their limit
+func (t *loginThrottler) fail(keys []throttleKey, meta RequestMeta) {
+	now := t.now()
+	for _, key := range keys {
+		throttle, err := t.repo.RecordLoginFailure(key.scope, key.identifier, now, t.cfg.LoginLockoutDuration)
+		if err != nil {
+			log.Printf("⚠️  Failed to record login failure for %s %s: %v", key.scope, key.identifier, err)
+			continue
+		}
+
+		if throttle.Failures < t.limit(key.scope) {
+			continue
+		}
+		if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+			continue
+		}
+
+		lockedUntil := now.Add(t.cfg.LoginLockoutDuration)
+		if err := t.repo.LockThrottle(throttle.ID, lockedUntil); err != nil {
+			log.Printf("⚠️  Failed to lock %s %s: %v", key.scope, key.identifier, err)
+			continue
+		}
+
+		// The identifier is an email address or IP, so only its pseudonym is recorded
+		event := &audit.Event{
+			Action:     "auth." + key.scope + "_locked",
+			TargetType: key.scope,
+			TargetID:   t.auditor.Pseudonym(key.identifier),
+			IP:         meta.IP,
+			RequestID:  meta.RequestID,
+		}
+		t.record(event.WithMetadata(map[string]interface{}{
+			"failures":     throttle.Failures,
+			"locked_until": lockedUntil,
+		}))
+	}
+}
+
+// reset clears a counter, e.g. the account counter after a successful login
+func (t *loginThrottler) reset(scope, identifier string) error {
+	return t.repo.ResetThrottle(scope, identifier)
+}
+
+// backoff returns base * 2^(failures-1), capped at the configured maximum
+func (t *loginThrottler) backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := t.cfg.LoginBackoffBase
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= t.cfg.LoginBackoffMax {
+			return t.cfg.LoginBackoffMax
+		}
+	}
+	return min(delay, t.cfg.LoginBackoffMax)
+}
+
+func (t *loginThrottler) limit(scope string) int {
+	if scope == ThrottleScopeIP {
+		return t.cfg.LoginIPMaxFailures
+	}
+	return t.cfg.LoginMaxFailures
+}
+
+func (t *loginThrottler) record(event *audit.Event) {
+	if err := t.auditor.Record(event); err != nil {
+		log.Printf("⚠️  Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,118 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by all authenticator apps)
+const (
+	totpDigits     = 6
+	totpPeriod     = 30 * time.Second
+	totpSkewSteps  = 1
+	totpSecretSize = 20
+
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret returns a random base32-encoded shared secret
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpURI builds the otpauth:// URI consumed by authenticator apps
+func totpURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(params.Encode(), "+", "%20")
+}
+
+// hotp computes the RFC 4226 HOTP value for a counter
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, code%mod)
+}
+
+// matchTOTP checks a code against the secret, allowing one step of clock
+// skew, and returns the time step it matched so callers can reject replays
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	counter := now.Unix() / int64(totpPeriod.Seconds())
+	var matched int64
+	found := false
+	for step := -totpSkewSteps; step <= totpSkewSteps; step++ {
+		candidate := counter + int64(step)
+		expected := hotp(key, uint64(candidate))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			matched = candidate
+			found = true
+		}
+	}
+	return matched, found
+}
+
+// generateRecoveryCodes returns plain-text one-time codes as two groups of ten hex digits
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	buf := make([]byte, recoveryCodeBytes)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		encoded := hex.EncodeToString(buf)
+		codes[i] = encoded[:10] + "-" + encoded[10:]
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code for storage. Codes carry 80 bits
+// of entropy, so a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,130 @@
+package user
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 4226 and RFC 6238 SHA-1 test key "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	key := []byte("12345678901234567890")
+	for counter, code := range want {
+		if got := hotp(key, uint64(counter)); got != code {
+			t.Errorf("hotp(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		now      int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"rfc vector 59", rfcSecret, "287082", 59, 1, true},
+		{"rfc vector 1111111109", rfcSecret, "081804", 1111111109, 37037036, true},
+		{"rfc vector 1234567890", rfcSecret, "005924", 1234567890, 41152263, true},
+		{"rfc vector 2000000000", rfcSecret, "279037", 2000000000, 66666666, true},
+		{"previous step within skew", rfcSecret, "081804", 1111111109 + 30, 37037036, true},
+		{"next step within skew", rfcSecret, "081804", 1111111109 - 30, 37037036, true},
+		{"two steps late", rfcSecret, "081804", 1111111109 + 60, 0, false},
+		{"two steps early", rfcSecret, "081804", 1111111109 - 60, 0, false},
+		{"surrounding spaces", rfcSecret, " 287082 ", 59, 1, true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287082", 59, 1, true},
+		{"wrong code", rfcSecret, "287083", 59, 0, false},
+		{"too short", rfcSecret, "28708", 59, 0, false},
+		{"too long", rfcSecret, "2870820", 59, 0, false},
+		{"empty", rfcSecret, "", 59, 0, false},
+		{"invalid secret", "not base32!", "287082", 59, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := matchTOTP(tt.secret, tt.code, time.Unix(tt.now, 0))
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("matchTOTP() = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not unpadded base32: %v", secret, err)
+	}
+	if len(key) != totpSecretSize {
+		t.Errorf("secret has %d bytes, want %d", len(key), totpSecretSize)
+	}
+
+	// A code computed from the generated secret must verify
+	now := time.Unix(1700000000, 0)
+	code := hotp(key, uint64(now.Unix()/int64(totpPeriod.Seconds())))
+	if _, ok := matchTOTP(secret, code, now); !ok {
+		t.Errorf("code %s of generated secret did not verify", code)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	got := totpURI("Backend App", "john@example.com", rfcSecret)
+	want := "otpauth://totp/Backend%20App:john@example.com?algorithm=SHA1&digits=6&issuer=Backend%20App&period=30&secret=" + rfcSecret
+	if got != want {
+		t.Errorf("totpURI() = %s\nwant %s", got, want)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	format := regexp.MustCompile(`^[0-9a-f]{10}-[0-9a-f]{10}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q does not match %s", code, format)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{"same code", "0123456789-abcdef0123", "0123456789-abcdef0123", true},
+		{"uppercase", "0123456789-abcdef0123", "0123456789-ABCDEF0123", true},
+		{"without dash", "0123456789-abcdef0123", "0123456789abcdef0123", true},
+		{"surrounding spaces", "0123456789-abcdef0123", "  0123456789-abcdef0123\n", true},
+		{"different code", "0123456789-abcdef0123", "0123456789-abcdef0124", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if equal := hashRecoveryCode(tt.a) == hashRecoveryCode(tt.b); equal != tt.equal {
+				t.Errorf("hashes equal = %v, want %v", equal, tt.equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Defaults for the mock provider's signed-in user
+const (
+	mockDefaultEmail = "mock.user@example.com"
+	mockDefaultName  = "Mock User"
+	mockCodeTTL      = time.Minute
+	mockIDTokenTTL   = 5 * time.Minute
+)
+
+// MockConfig configures the in-process mock identity provider
+type MockConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// mockGrant is an issued but not yet redeemed authorization code
+type mockGrant struct {
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	name          string
+	expiresAt     time.Time
+}
+
+// MockProvider is a minimal OpenID provider for development and offline
+// testing. It serves discovery, JWKS, an auto-approving authorization
+// endpoint and a token endpoint that enforces PKCE. The signed-in identity
+// is chosen with the login_hint parameter (an email address).
+type MockProvider struct {
+	cfg    MockConfig
+	key    *rsa.PrivateKey
+	keyID  string
+	mux    *http.ServeMux
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+// NewMockProvider creates a mock provider with a fresh RSA signing key
+func NewMockProvider(cfg MockConfig) (*MockProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MockProvider{
+		cfg:    cfg,
+		key:    key,
+		keyID:  keyID,
+		mux:    http.NewServeMux(),
+		grants: make(map[string]mockGrant),
+	}
+	m.mux.HandleFunc("GET /.well-known/openid-configuration", m.discovery)
+	m.mux.HandleFunc("GET /jwks", m.jwks)
+	m.mux.HandleFunc("GET /authorize", m.authorize)
+	m.mux.HandleFunc("POST /token", m.token)
+	return m, nil
+}
+
+// ServeHTTP serves the provider endpoints relative to the issuer path
+func (m *MockProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.ServeHTTP(w, r)
+}
+
+// HTTPClient returns a client that reaches the provider in-process, so the
+// back-channel calls (discovery, JWKS, token) need no network
+func (m *MockProvider) HTTPClient() *http.Client {
+	prefix := strings.TrimSuffix(mustParsePath(m.cfg.Issuer), "/")
+	return &http.Client{Transport: inProcessTransport{handler: http.StripPrefix(prefix, m)}}
+}
+
+func (m *MockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.cfg.Issuer,
+		"authorization_endpoint":                m.cfg.Issuer + "/authorize",
+		"token_endpoint":                        m.cfg.Issuer + "/token",
+		"jwks_uri":                              m.cfg.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.SigningMethodRS256.Alg()},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (m *MockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jwkSet{Keys: []jwk{newJWK(m.keyID, &m.key.PublicKey)}})
+}
+
+func (m *MockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != m.cfg.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	redirectError := func(code string) {
+		params := redirectURI.Query()
+		params.Set("error", code)
+		params.Set("state", q.Get("state"))
+		redirectURI.RawQuery = params.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	}
+	if q.Get("response_type") != "code" {
+		redirectError("unsupported_response_type")
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		redirectError("invalid_request")
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(q.Get("login_hint")))
+	if email == "" {
+		email = mockDefaultEmail
+	}
+	name := mockDefaultName
+	if local, _, ok := strings.Cut(email, "@"); ok && email != mockDefaultEmail {
+		name = local
+	}
+
+	code, err := randomHex(16)
+	if err != nil {
+		http.Error(w, "failed to issue code", http.StatusInternalServerError)
+		return
+	}
+	m.mu.Lock()
+	m.grants[code] = mockGrant{
+		redirectURI:   redirectURI.String(),
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		email:         email,
+		name:          name,
+		expiresAt:     time.Now().Add(mockCodeTTL),
+	}
+	m.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (m *MockProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != m.cfg.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(m.cfg.ClientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	// Codes are single use, so remove the grant before validating it
+	code := r.PostForm.Get("code")
+	m.mu.Lock()
+	grant, found := m.grants[code]
+	delete(m.grants, code)
+	m.mu.Unlock()
+
+	if !found || time.Now().After(grant.expiresAt) || grant.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	challenge := S256Challenge(r.PostForm.Get("code_verifier"))
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(grant.codeChallenge)) != 1 {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := IDTokenClaims{
+		Nonce:         grant.nonce,
+		Email:         grant.email,
+		EmailVerified: true,
+		Name:          grant.name,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.cfg.Issuer,
+			Subject:   mockSubject(grant.email),
+			Audience:  jwt.ClaimStrings{m.cfg.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mockIDTokenTTL)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.keyID
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	accessToken, err := randomHex(16)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(mockIDTokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+// mockSubject derives a stable subject identifier from the email address
+func mockSubject(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(sum[:12])
+}
+
+// inProcessTransport serves requests with an http.Handler instead of the network
+type inProcessTransport struct {
+	handler http.Handler
+}
+
+func (t inProcessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func mustParsePath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
@@ -0,0 +1,421 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to JSON documents. Resources patch a JSON view of
+// their editable attributes and decode and validate the result like a
+// regular request body, so patching follows the same rules as replacing.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Patch media types
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported patch media type; use " + MergePatchType + " or " + JSONPatchType)
+	ErrInvalidPatch    = errors.New("invalid patch document")
+	ErrCannotApply     = errors.New("patch cannot be applied to the document")
+	ErrTestFailed      = errors.New("patch test operation failed")
+)
+
+// Patch is a parsed patch document
+type Patch interface {
+	// Apply returns the patched copy of a JSON document
+	Apply(document []byte) ([]byte, error)
+}
+
+// Parse parses a patch document of the given Content-Type
+func Parse(contentType string, body []byte) (Patch, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	switch mediaType {
+	case MergePatchType:
+		value, err := decode(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		return mergePatch{value: value}, nil
+	case JSONPatchType:
+		return parseOperations(body)
+	}
+	return nil, ErrUnsupportedType
+}
+
+// mergePatch is a JSON Merge Patch: objects are merged recursively, null
+// removes members and any other value replaces the target
+type mergePatch struct {
+	value interface{}
+}
+
+// Apply implements Patch
+func (p mergePatch) Apply(document []byte) ([]byte, error) {
+	target, err := decode(document)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(merge(target, p.value))
+}
+
+func merge(target, patch interface{}) interface{} {
+	members, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+	}
+	for name, value := range members {
+		if value == nil {
+			delete(object, name)
+			continue
+		}
+		object[name] = merge(object[name], value)
+	}
+	return object
+}
+
+// operation is a single JSON Patch operation
+type operation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"` // Nil when absent, "null" for null
+
+	path, from []string
+	value      interface{}
+}
+
+// jsonPatch is a JSON Patch: a sequence of operations applied atomically
+type jsonPatch []operation
+
+// parseOperations parses and validates a JSON Patch
+func parseOperations(body []byte) (jsonPatch, error) {
+	var ops jsonPatch
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	for i := range ops {
+		op := &ops[i]
+		invalid := func(reason string) error {
+			return fmt.Errorf("%w: operation %d: %s", ErrInvalidPatch, i, reason)
+		}
+
+		switch op.Op {
+		case "add", "remove", "replace", "move", "copy", "test":
+		default:
+			return nil, invalid(fmt.Sprintf("unknown op %q", op.Op))
+		}
+		if op.Path == nil {
+			return nil, invalid("path is required")
+		}
+		path, err := parsePointer(*op.Path)
+		if err != nil {
+			return nil, invalid(err.Error())
+		}
+		op.path = path
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, invalid("value is required")
+			}
+			if op.value, err = decode(op.Value); err != nil {
+				return nil, invalid(err.Error())
+			}
+		case "move", "copy":
+			if op.From == nil {
+				return nil, invalid("from is required")
+			}
+			if op.from, err = parsePointer(*op.From); err != nil {
+				return nil, invalid(err.Error())
+			}
+			if op.Op == "move" && len(op.from) < len(op.path) && reflect.DeepEqual(op.from, op.path[:len(op.from)]) {
+				return nil, invalid("cannot move a value into one of its children")
+			}
+		}
+	}
+	return ops, nil
+}
+
+// Apply implements Patch. Either every operation applies or the document
+// is left unchanged.
+func (p jsonPatch) Apply(document []byte) ([]byte, error) {
+	doc, err := decode(document)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range p {
+		doc, err = op.apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d (%s %s)", err, i, op.Op, *op.Path)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+func (op operation) apply(doc interface{}) (interface{}, error) {
+	switch op.Op {
+	case "add":
+		return add(doc, op.path, op.value)
+	case "remove":
+		doc, _, err := remove(doc, op.path)
+		return doc, err
+	case "replace":
+		doc, _, err := remove(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, op.value)
+	case "move":
+		doc, value, err := remove(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, value)
+	case "copy":
+		value, err := get(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, op.path, deepCopy(value))
+	case "test":
+		value, err := get(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(value, op.value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	}
+	return nil, ErrInvalidPatch
+}
+
+// get returns the value at path
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, ErrCannotApply
+			}
+			node = child
+		case []interface{}:
+			index, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[index]
+		default:
+			return nil, ErrCannotApply
+		}
+	}
+	return node, nil
+}
+
+// add sets an object member or inserts an array element at path. The
+// parent must exist; "-" appends to an array.
+func add(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, ErrCannotApply
+		}
+		child, err := add(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+	case []interface{}:
+		if len(rest) == 0 {
+			index := len(n)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(n)); err != nil {
+					return nil, err
+				}
+			}
+			n = append(n, nil)
+			copy(n[index+1:], n[index:])
+			n[index] = value
+			return n, nil
+		}
+		index, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		if n[index], err = add(n[index], rest, value); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	return nil, ErrCannotApply
+}
+
+// remove deletes the value at path, which must exist, and returns it
+func remove(node interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, node, nil
+	}
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, ErrCannotApply
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+		child, removed, err := remove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = child
+		return n, removed, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[index]
+			return append(n[:index], n[index+1:]...), removed, nil
+		}
+		child, removed, err := remove(n[index], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[index] = child
+		return n, removed, nil
+	}
+	return nil, nil, ErrCannotApply
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("pointer %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token between 0 and max
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrCannotApply
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > max {
+		return 0, ErrCannotApply
+	}
+	return index, nil
+}
+
+// equal compares JSON values, treating numbers as equal when numerically equal
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, value := range x {
+			other, ok := y[name]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// deepCopy copies a decoded JSON value so copies do not share containers
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			copied[name] = deepCopy(member)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, element := range v {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	}
+	return value
+}
+
+// decode parses a single JSON value, keeping numbers exact
+func decode(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
@@ -0,0 +1,284 @@
+package patch
+
+import (
+	"errors"
+	"testing"
+)
+
+// assertJSON compares JSON documents by value
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	gotValue, err := decode(got)
+	if err != nil {
+		t.Fatalf("result %s is not JSON: %v", got, err)
+	}
+	wantValue, err := decode([]byte(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(gotValue, wantValue) {
+		t.Errorf("result = %s, want %s", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		wantErr     error
+	}{
+		{MergePatchType, `{"a":1}`, nil},
+		{MergePatchType + "; charset=utf-8", `{"a":1}`, nil},
+		{JSONPatchType, `[]`, nil},
+		{"application/json", `{"a":1}`, ErrUnsupportedType},
+		{"", `{"a":1}`, ErrUnsupportedType},
+		{"application/merge-patch+json; =", `{"a":1}`, ErrUnsupportedType},
+		{MergePatchType, `{"a":`, ErrInvalidPatch},
+		{MergePatchType, `{"a":1} {"b":2}`, ErrInvalidPatch},
+		{JSONPatchType, `{"op":"add","path":"/a","value":1}`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"merge","path":"/a","value":1}]`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"add","value":1}]`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"add","path":"a","value":1}]`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"add","path":"/a"}]`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"replace","path":"/a"}]`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"test","path":"/a"}]`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"move","path":"/a"}]`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"copy","path":"/a","from":"b"}]`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"move","from":"/a","path":"/a/b"}]`, ErrInvalidPatch},
+		{JSONPatchType, `[{"op":"move","from":"/a","path":"/ab"}]`, nil},
+		{JSONPatchType, `[{"op":"remove","path":"/a"}]`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType+" "+tt.body, func(t *testing.T) {
+			_, err := Parse(tt.contentType, []byte(tt.body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		// RFC 7396 appendix A
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"remove member", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"remove one of two members", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"replace array with string", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"replace string with array", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"merge nested object", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"replace array of objects", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"replace array", `["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{"replace object with array", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"replace with null", `{"a":"foo"}`, `null`, `null`},
+		{"replace with string", `{"a":"foo"}`, `"bar"`, `"bar"`},
+		{"keep existing null", `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{"replace array with object", `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{"drop nulls from new objects", `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		// Beyond the appendix
+		{"keep numbers exact", `{"n":1}`, `{"n":12345678901234567890}`, `{"n":12345678901234567890}`},
+		{"empty patch", `{"a":"b"}`, `{}`, `{"a":"b"}`},
+		{"array elements are not merged", `{"a":[{"b":"c","d":"e"}]}`, `{"a":[{"b":null}]}`, `{"a":[{"b":null}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(MergePatchType, []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got, err := p.Apply([]byte(tt.target))
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		// RFC 6902 appendix A
+		{name: "A.1 add an object member", target: `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`},
+		{name: "A.2 add an array element", target: `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`},
+		{name: "A.3 remove an object member", target: `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`},
+		{name: "A.4 remove an array element", target: `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`},
+		{name: "A.5 replace a value", target: `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`},
+		{name: "A.6 move a value", target: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{name: "A.7 move an array element", target: `{"foo":["all","grass","cows","eat"]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:  `{"foo":["all","cows","eat","grass"]}`},
+		{name: "A.8 test a value: success", target: `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			want:  `{"baz":"qux","foo":["a",2,"c"]}`},
+		{name: "A.9 test a value: error", target: `{"baz":"qux"}`,
+			patch:   `[{"op":"test","path":"/baz","value":"bar"}]`,
+			wantErr: ErrTestFailed},
+		{name: "A.10 add a nested member object", target: `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want:  `{"foo":"bar","child":{"grandchild":{}}}`},
+		{name: "A.11 ignore unrecognized elements", target: `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want:  `{"foo":"bar","baz":"qux"}`},
+		{name: "A.12 add to a nonexistent target", target: `{"foo":"bar"}`,
+			patch:   `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			wantErr: ErrCannotApply},
+		{name: "A.14 escape ordering", target: `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10}]`,
+			want:  `{"/":9,"~1":10}`},
+		{name: "A.15 compare strings and numbers", target: `{"/":9,"~1":10}`,
+			patch:   `[{"op":"test","path":"/~01","value":"10"}]`,
+			wantErr: ErrTestFailed},
+		{name: "A.16 add an array value", target: `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`},
+
+		// Values and pointers
+		{name: "add null", target: `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":null}]`,
+			want:  `{"foo":"bar","baz":null}`},
+		{name: "replace with null", target: `{"foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/foo","value":null}]`,
+			want:  `{"foo":null}`},
+		{name: "test null", target: `{"foo":null}`,
+			patch: `[{"op":"test","path":"/foo","value":null}]`,
+			want:  `{"foo":null}`},
+		{name: "add replaces an existing member", target: `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/foo","value":"baz"}]`,
+			want:  `{"foo":"baz"}`},
+		{name: "add at the end of an array by index", target: `{"foo":[1,2]}`,
+			patch: `[{"op":"add","path":"/foo/2","value":3}]`,
+			want:  `{"foo":[1,2,3]}`},
+		{name: "add past the end of an array", target: `{"foo":[1,2]}`,
+			patch:   `[{"op":"add","path":"/foo/3","value":3}]`,
+			wantErr: ErrCannotApply},
+		{name: "index with a leading zero", target: `{"foo":[1,2]}`,
+			patch:   `[{"op":"remove","path":"/foo/01"}]`,
+			wantErr: ErrCannotApply},
+		{name: "negative index", target: `{"foo":[1,2]}`,
+			patch:   `[{"op":"remove","path":"/foo/-1"}]`,
+			wantErr: ErrCannotApply},
+		{name: "remove past the end", target: `{"foo":[1,2]}`,
+			patch:   `[{"op":"remove","path":"/foo/-"}]`,
+			wantErr: ErrCannotApply},
+		{name: "remove a missing member", target: `{"foo":"bar"}`,
+			patch:   `[{"op":"remove","path":"/baz"}]`,
+			wantErr: ErrCannotApply},
+		{name: "replace a missing member", target: `{"foo":"bar"}`,
+			patch:   `[{"op":"replace","path":"/baz","value":1}]`,
+			wantErr: ErrCannotApply},
+		{name: "replace the document", target: `{"foo":"bar"}`,
+			patch: `[{"op":"replace","path":"","value":[1]}]`,
+			want:  `[1]`},
+		{name: "index into a scalar", target: `{"foo":"bar"}`,
+			patch:   `[{"op":"add","path":"/foo/0","value":1}]`,
+			wantErr: ErrCannotApply},
+		{name: "escaped slash", target: `{"a/b":1}`,
+			patch: `[{"op":"replace","path":"/a~1b","value":2}]`,
+			want:  `{"a/b":2}`},
+		{name: "empty member name", target: `{"":1}`,
+			patch: `[{"op":"remove","path":"/"}]`,
+			want:  `{}`},
+
+		// Comparison
+		{name: "test numbers numerically", target: `{"n":1.0}`,
+			patch: `[{"op":"test","path":"/n","value":1}]`,
+			want:  `{"n":1.0}`},
+		{name: "test objects regardless of member order", target: `{"o":{"a":1,"b":[1,{"c":2}]}}`,
+			patch: `[{"op":"test","path":"/o","value":{"b":[1,{"c":2}],"a":1}}]`,
+			want:  `{"o":{"a":1,"b":[1,{"c":2}]}}`},
+		{name: "test arrays in order", target: `{"a":[1,2]}`,
+			patch:   `[{"op":"test","path":"/a","value":[2,1]}]`,
+			wantErr: ErrTestFailed},
+		{name: "test a missing member", target: `{}`,
+			patch:   `[{"op":"test","path":"/a","value":null}]`,
+			wantErr: ErrCannotApply},
+
+		// Copies and moves
+		{name: "copy is independent of its source", target: `{"a":{"b":1}}`,
+			patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			want:  `{"a":{"b":1},"c":{"b":2}}`},
+		{name: "copy a missing member", target: `{}`,
+			patch:   `[{"op":"copy","from":"/a","path":"/b"}]`,
+			wantErr: ErrCannotApply},
+		{name: "move to the same location", target: `{"a":1}`,
+			patch: `[{"op":"move","from":"/a","path":"/a"}]`,
+			want:  `{"a":1}`},
+		{name: "move into a missing parent", target: `{"a":1}`,
+			patch:   `[{"op":"move","from":"/a","path":"/b/c"}]`,
+			wantErr: ErrCannotApply},
+
+		// Operations apply in sequence and a failure discards earlier ones
+		{name: "later operations see earlier ones", target: `{}`,
+			patch: `[{"op":"add","path":"/a","value":[]},{"op":"add","path":"/a/-","value":1},{"op":"test","path":"/a/0","value":1}]`,
+			want:  `{"a":[1]}`},
+		{name: "failed test discards earlier operations", target: `{"version":1}`,
+			patch:   `[{"op":"replace","path":"/version","value":2},{"op":"test","path":"/version","value":1}]`,
+			wantErr: ErrTestFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(JSONPatchType, []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			target := []byte(tt.target)
+			got, err := p.Apply(target)
+			if string(target) != tt.target {
+				t.Errorf("Apply() modified its input to %s", target)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Apply() = %s, %v, want error %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestApplyInvalidDocument(t *testing.T) {
+	for _, contentType := range []string{MergePatchType, JSONPatchType} {
+		body := `{"a":1}`
+		if contentType == JSONPatchType {
+			body = `[{"op":"add","path":"/a","value":1}]`
+		}
+		p, err := Parse(contentType, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Apply([]byte(`{"a":`)); err == nil {
+			t.Errorf("%s: Apply() of an invalid document succeeded", contentType)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package query
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CursorParam is the query parameter carrying a pagination cursor. Its
+// presence, even empty for the first page, selects keyset pagination.
+const CursorParam = "cursor"
+
+// ErrInvalidCursor is returned for cursors that are malformed, were not
+// issued by this server or belong to a different sort order
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// Cursor marks a position in a keyset-paginated listing: the sort key of
+// the row next to the page boundary
+type Cursor struct {
+	Sort     string   `json:"s"`           // Sort order the cursor was issued for
+	Values   []string `json:"v"`           // Sort key values, encoded with their type
+	Backward bool     `json:"b,omitempty"` // Pages towards the start of the listing
+}
+
+// Page describes where a keyset page sits within the listing
+type Page struct {
+	Next  *Cursor // Nil on the last page
+	Prev  *Cursor // Nil on the first page
+	Total *int64  // Set only when counting was requested
+}
+
+// Codec signs cursors so clients cannot forge positions or sort keys
+type Codec struct {
+	key []byte
+}
+
+// NewCodec creates a cursor codec. The signing key is derived from secret
+// so it can be shared with other uses of the same secret.
+func NewCodec(secret string) *Codec {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("pagination-cursor"))
+	return &Codec{key: mac.Sum(nil)}
+}
+
+// Encode returns the opaque, signed form of a cursor
+func (c *Codec) Encode(cursor *Cursor) string {
+	payload, _ := json.Marshal(cursor)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(c.sign(encoded))
+}
+
+// Decode verifies and decodes a cursor. An empty token decodes to nil,
+// which requests the first page.
+func (c *Codec) Decode(token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, c.sign(encoded)) {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func (c *Codec) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// Links returns the URLs of the next and previous pages, based on the
+// current request URL. Links for missing pages are empty.
+func (c *Codec) Links(current *url.URL, page *Page) (next, prev string) {
+	link := func(cursor *Cursor) string {
+		if cursor == nil {
+			return ""
+		}
+		values := current.Query()
+		values.Set(CursorParam, c.Encode(cursor))
+		u := url.URL{Path: current.Path, RawQuery: values.Encode()}
+		return u.String()
+	}
+	return link(page.Next), link(page.Prev)
+}
+
+// Seek loads up to limit rows after cursor into dest, a pointer to a slice
+// of models, using the query's filters and sort order. Rows are located by
+// comparing sort keys instead of skipping an OFFSET, so deep pages stay
+// fast and rows inserted meanwhile do not shift the page. A nil cursor
+// loads the first page.
+func (q *Query) Seek(db *gorm.DB, cursor *Cursor, limit int, dest interface{}) (*Page, error) {
+	signature := q.signature()
+	backward := false
+	tx := db.Scopes(q.Filter)
+	if cursor != nil {
+		if cursor.Sort != signature || len(cursor.Values) != len(q.order) {
+			return nil, ErrInvalidCursor
+		}
+		condition, err := q.after(cursor)
+		if err != nil {
+			return nil, err
+		}
+		tx = tx.Where(condition)
+		backward = cursor.Backward
+	}
+
+	order := q.order
+	if backward {
+		order = make([]clause.OrderByColumn, len(q.order))
+		for i, column := range q.order {
+			column.Desc = !column.Desc
+			order[i] = column
+		}
+	}
+	if len(order) > 0 {
+		tx = tx.Order(clause.OrderBy{Columns: order})
+	}
+	if err := tx.Limit(limit + 1).Find(dest).Error; err != nil {
+		return nil, err
+	}
+
+	rows := reflect.ValueOf(dest).Elem()
+	more := rows.Len() > limit
+	if more {
+		rows.Set(rows.Slice(0, limit))
+	}
+	if backward {
+		swap := reflect.Swapper(rows.Interface())
+		for i, j := 0, rows.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	page := &Page{}
+	if rows.Len() == 0 {
+		return page, nil
+	}
+	hasNext, hasPrev := more, cursor != nil
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(dest); err != nil {
+		return nil, err
+	}
+	if hasNext {
+		values, err := q.keyValues(stmt, rows.Index(rows.Len()-1))
+		if err != nil {
+			return nil, err
+		}
+		page.Next = &Cursor{Sort: signature, Values: values}
+	}
+	if hasPrev {
+		values, err := q.keyValues(stmt, rows.Index(0))
+		if err != nil {
+			return nil, err
+		}
+		page.Prev = &Cursor{Sort: signature, Values: values, Backward: true}
+	}
+	return page, nil
+}
+
+// signature identifies the sort order, e.g. "created_at:desc,id:desc"
+func (q *Query) signature() string {
+	parts := make([]string, len(q.order))
+	for i, column := range q.order {
+		parts[i] = column.Column.Name
+		if column.Desc {
+			parts[i] += ":desc"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// after builds the condition selecting rows past the cursor in its
+// direction: (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., with < for
+// descending keys
+func (q *Query) after(cursor *Cursor) (clause.Expression, error) {
+	values := make([]interface{}, len(cursor.Values))
+	for i, encoded := range cursor.Values {
+		value, err := decodeValue(encoded)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = value
+	}
+
+	branches := make([]clause.Expression, len(q.order))
+	for i, column := range q.order {
+		var exprs []clause.Expression
+		for j := 0; j < i; j++ {
+			exprs = append(exprs, clause.Eq{Column: q.order[j].Column, Value: values[j]})
+		}
+		if column.Desc != cursor.Backward {
+			exprs = append(exprs, clause.Lt{Column: column.Column, Value: values[i]})
+		} else {
+			exprs = append(exprs, clause.Gt{Column: column.Column, Value: values[i]})
+		}
+		branches[i] = clause.And(exprs...)
+	}
+	return clause.Or(branches...), nil
+}
+
+// keyValues reads and encodes the sort key of a row
+func (q *Query) keyValues(stmt *gorm.Statement, row reflect.Value) ([]string, error) {
+	values := make([]string, len(q.order))
+	for i, column := range q.order {
+		field := stmt.Schema.LookUpField(column.Column.Name)
+		if field == nil {
+			return nil, fmt.Errorf("sort column %s is not a field of %s", column.Column.Name, stmt.Schema.Name)
+		}
+		value, _ := field.ValueOf(context.Background(), reflect.Indirect(row))
+		encoded, err := encodeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("sort column %s: %w", column.Column.Name, err)
+		}
+		values[i] = encoded
+	}
+	return values, nil
+}
+
+// encodeValue encodes a sort key value with its type, so it is compared
+// as the same type when the cursor is used
+func encodeValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return "t:" + v.UTC().Format(time.RFC3339Nano), nil
+	case string:
+		return "s:" + v, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "i:" + strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "u:" + strconv.FormatUint(rv.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("unsupported sort key type %T", value)
+}
+
+// decodeValue reverses encodeValue
+func decodeValue(encoded string) (interface{}, error) {
+	kind, value, ok := strings.Cut(encoded, ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	switch kind {
+	case "t":
+		return time.Parse(time.RFC3339Nano, value)
+	case "s":
+		return value, nil
+	case "i":
+		return strconv.ParseInt(value, 10, 64)
+	case "u":
+		return strconv.ParseUint(value, 10, 64)
+	}
+	return nil, ErrInvalidCursor
+}
@@ -0,0 +1,332 @@
+package query
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/testdb"
+	"gorm.io/gorm"
+)
+
+// item is the model listed in the tests
+type item struct {
+	ID        uint
+	Name      string
+	CreatedAt time.Time
+}
+
+var itemSpec = &Spec{
+	Sorts:       map[string]string{"name": "name", "created_at": "created_at"},
+	DefaultSort: "-created_at",
+	TieBreaker:  "id",
+}
+
+// parseItems parses a listing query for items
+func parseItems(t *testing.T, sort string) *Query {
+	t.Helper()
+	q, err := itemSpec.Parse(url.Values{SortParam: {sort}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// itemConn answers every query with rows
+func itemConn(rows []item) *testdb.Conn {
+	return &testdb.Conn{Query: func(string, []driver.Value) (driver.Rows, error) {
+		result := testdb.NewRows("id", "name", "created_at")
+		for _, row := range rows {
+			result.Add(int64(row.ID), row.Name, row.CreatedAt)
+		}
+		return result, nil
+	}}
+}
+
+// openItems returns the items table of a database answering with conn
+func openItems(t *testing.T, conn *testdb.Conn) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, conn).Table("items")
+}
+
+// items returns n items, newest first as the default sort lists them
+func items(n int) []item {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	list := make([]item, n)
+	for i := range list {
+		list[i] = item{ID: uint(n - i), Name: "item", CreatedAt: start.Add(time.Duration(n-i) * time.Minute)}
+	}
+	return list
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codec := NewCodec("secret")
+	cursor := &Cursor{Sort: "created_at:desc,id:desc", Values: []string{"t:2024-01-01T00:00:00Z", "u:42"}, Backward: true}
+
+	got, err := codec.Decode(codec.Encode(cursor))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if got.Sort != cursor.Sort || strings.Join(got.Values, "|") != strings.Join(cursor.Values, "|") || got.Backward != cursor.Backward {
+		t.Errorf("Decode() = %+v, want %+v", got, cursor)
+	}
+
+	if got, err := codec.Decode(""); got != nil || err != nil {
+		t.Errorf("Decode(\"\") = %v, %v, want the first page", got, err)
+	}
+}
+
+func TestCodecDecodeTampered(t *testing.T) {
+	codec := NewCodec("secret")
+	token := codec.Encode(&Cursor{Sort: "id", Values: []string{"u:42"}})
+	payload, signature, _ := strings.Cut(token, ".")
+
+	// A payload the attacker chose, signed with a key they do not have
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"id","v":["u:1"]}`))
+	// A payload this server signed that is not a cursor
+	notJSON := base64.RawURLEncoding.EncodeToString([]byte("not json"))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no signature", payload},
+		{"empty signature", payload + "."},
+		{"signature not base64", payload + ".!!!"},
+		{"edited payload", forged + "." + signature},
+		{"truncated signature", payload + "." + signature[:len(signature)-2]},
+		{"signed with another secret", NewCodec("other").Encode(&Cursor{Sort: "id", Values: []string{"u:42"}})},
+		{"payload not base64", "!!!." + base64.RawURLEncoding.EncodeToString(codec.sign("!!!"))},
+		{"payload not a cursor", notJSON + "." + base64.RawURLEncoding.EncodeToString(codec.sign(notJSON))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cursor, err := codec.Decode(tt.token); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Decode() = %+v, %v, want ErrInvalidCursor", cursor, err)
+			}
+		})
+	}
+}
+
+func TestSeekRejectsCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor *Cursor
+	}{
+		{"other sort order", &Cursor{Sort: "name,id", Values: []string{"s:a", "u:1"}}},
+		{"other direction", &Cursor{Sort: "created_at,id", Values: []string{"t:2024-01-01T00:00:00Z", "u:1"}}},
+		{"missing value", &Cursor{Sort: "created_at:desc,id:desc", Values: []string{"t:2024-01-01T00:00:00Z"}}},
+		{"extra value", &Cursor{Sort: "created_at:desc,id:desc", Values: []string{"t:2024-01-01T00:00:00Z", "u:1", "u:2"}}},
+		{"untyped value", &Cursor{Sort: "created_at:desc,id:desc", Values: []string{"2024-01-01T00:00:00Z", "u:1"}}},
+		{"unknown type", &Cursor{Sort: "created_at:desc,id:desc", Values: []string{"x:2024", "u:1"}}},
+		{"malformed time", &Cursor{Sort: "created_at:desc,id:desc", Values: []string{"t:yesterday", "u:1"}}},
+		{"malformed number", &Cursor{Sort: "created_at:desc,id:desc", Values: []string{"t:2024-01-01T00:00:00Z", "u:-1"}}},
+	}
+
+	q := parseItems(t, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := itemConn(nil)
+			var dest []item
+			if _, err := q.Seek(openItems(t, conn), tt.cursor, 10, &dest); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Seek() error = %v, want ErrInvalidCursor", err)
+			}
+			if len(conn.Statements) != 0 {
+				t.Errorf("Seek() ran %v for an invalid cursor", conn.SQL())
+			}
+		})
+	}
+}
+
+func TestSeek(t *testing.T) {
+	at := "t:2024-01-01T00:05:00Z"
+	atTime := time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		sort     string
+		cursor   *Cursor
+		rows     []item // As returned by the database
+		wantSQL  string
+		wantArgs []driver.Value
+		wantIDs  []uint
+		wantNext []string // Nil when there is no next page
+		wantPrev []string // Nil when there is no previous page
+	}{
+		{
+			name:     "first page",
+			rows:     items(4),
+			wantSQL:  "SELECT * FROM `items` ORDER BY `created_at` DESC,`id` DESC LIMIT ?",
+			wantArgs: []driver.Value{int64(4)},
+			wantIDs:  []uint{4, 3, 2},
+			wantNext: []string{"t:2024-01-01T00:02:00Z", "u:2"},
+		},
+		{
+			name:     "only page",
+			rows:     items(2),
+			wantSQL:  "SELECT * FROM `items` ORDER BY `created_at` DESC,`id` DESC LIMIT ?",
+			wantArgs: []driver.Value{int64(4)},
+			wantIDs:  []uint{2, 1},
+		},
+		{
+			name:     "next page",
+			cursor:   &Cursor{Sort: "created_at:desc,id:desc", Values: []string{at, "u:5"}},
+			rows:     items(4),
+			wantSQL:  "SELECT * FROM `items` WHERE (`created_at` < ? OR (`created_at` = ? AND `id` < ?)) ORDER BY `created_at` DESC,`id` DESC LIMIT ?",
+			wantArgs: []driver.Value{atTime, atTime, int64(5), int64(4)},
+			wantIDs:  []uint{4, 3, 2},
+			wantNext: []string{"t:2024-01-01T00:02:00Z", "u:2"},
+			wantPrev: []string{"t:2024-01-01T00:04:00Z", "u:4"},
+		},
+		{
+			name:     "last page",
+			cursor:   &Cursor{Sort: "created_at:desc,id:desc", Values: []string{at, "u:5"}},
+			rows:     items(3),
+			wantSQL:  "SELECT * FROM `items` WHERE (`created_at` < ? OR (`created_at` = ? AND `id` < ?)) ORDER BY `created_at` DESC,`id` DESC LIMIT ?",
+			wantArgs: []driver.Value{atTime, atTime, int64(5), int64(4)},
+			wantIDs:  []uint{3, 2, 1},
+			wantPrev: []string{"t:2024-01-01T00:03:00Z", "u:3"},
+		},
+		{
+			name:   "previous page",
+			cursor: &Cursor{Sort: "created_at:desc,id:desc", Values: []string{at, "u:5"}, Backward: true},
+			// Nearest first, in the reversed order
+			rows:     []item{items(10)[4], items(10)[3], items(10)[2], items(10)[1]},
+			wantSQL:  "SELECT * FROM `items` WHERE (`created_at` > ? OR (`created_at` = ? AND `id` > ?)) ORDER BY `created_at`,`id` LIMIT ?",
+			wantArgs: []driver.Value{atTime, atTime, int64(5), int64(4)},
+			wantIDs:  []uint{8, 7, 6},
+			wantNext: []string{"t:2024-01-01T00:06:00Z", "u:6"},
+			wantPrev: []string{"t:2024-01-01T00:08:00Z", "u:8"},
+		},
+		{
+			name:     "previous page reaching the start",
+			cursor:   &Cursor{Sort: "created_at:desc,id:desc", Values: []string{at, "u:5"}, Backward: true},
+			rows:     []item{items(7)[1], items(7)[0]},
+			wantSQL:  "SELECT * FROM `items` WHERE (`created_at` > ? OR (`created_at` = ? AND `id` > ?)) ORDER BY `created_at`,`id` LIMIT ?",
+			wantArgs: []driver.Value{atTime, atTime, int64(5), int64(4)},
+			wantIDs:  []uint{7, 6},
+			wantNext: []string{"t:2024-01-01T00:06:00Z", "u:6"},
+		},
+		{
+			name:     "mixed directions",
+			sort:     "name,-created_at",
+			cursor:   &Cursor{Sort: "name,created_at:desc,id:desc", Values: []string{"s:item", at, "u:5"}},
+			rows:     items(1),
+			wantSQL:  "SELECT * FROM `items` WHERE (`name` > ? OR (`name` = ? AND `created_at` < ?) OR (`name` = ? AND `created_at` = ? AND `id` < ?)) ORDER BY `name`,`created_at` DESC,`id` DESC LIMIT ?",
+			wantArgs: []driver.Value{"item", "item", atTime, "item", atTime, int64(5), int64(4)},
+			wantIDs:  []uint{1},
+			wantPrev: []string{"s:item", "t:2024-01-01T00:01:00Z", "u:1"},
+		},
+		{
+			name:     "empty page",
+			cursor:   &Cursor{Sort: "created_at:desc,id:desc", Values: []string{at, "u:5"}},
+			wantSQL:  "SELECT * FROM `items` WHERE (`created_at` < ? OR (`created_at` = ? AND `id` < ?)) ORDER BY `created_at` DESC,`id` DESC LIMIT ?",
+			wantArgs: []driver.Value{atTime, atTime, int64(5), int64(4)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := itemConn(tt.rows)
+			var dest []item
+			page, err := parseItems(t, tt.sort).Seek(openItems(t, conn), tt.cursor, 3, &dest)
+			if err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+
+			if len(conn.Statements) != 1 {
+				t.Fatalf("Seek() ran %v, want one query", conn.SQL())
+			}
+			if query := conn.Statements[0]; query.SQL != tt.wantSQL {
+				t.Errorf("query = %s\nwant %s", query.SQL, tt.wantSQL)
+			}
+			if args := conn.Statements[0].Args; !equalValues(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+			var ids []uint
+			for _, row := range dest {
+				ids = append(ids, row.ID)
+			}
+			if !equalValues(ids, tt.wantIDs) {
+				t.Errorf("rows = %v, want %v", ids, tt.wantIDs)
+			}
+			checkCursor(t, "next", page.Next, tt.wantNext, false)
+			checkCursor(t, "prev", page.Prev, tt.wantPrev, true)
+		})
+	}
+}
+
+// checkCursor compares a page cursor with the expected sort key values
+func checkCursor(t *testing.T, name string, got *Cursor, want []string, backward bool) {
+	t.Helper()
+	if want == nil {
+		if got != nil {
+			t.Errorf("%s = %+v, want none", name, got)
+		}
+		return
+	}
+	if got == nil {
+		t.Errorf("%s = nil, want %v", name, want)
+		return
+	}
+	if !equalValues(got.Values, want) || got.Backward != backward {
+		t.Errorf("%s = %+v, want values %v with backward %v", name, got, want, backward)
+	}
+}
+
+// equalValues reports whether two slices hold equal values; times are
+// compared as instants
+func equalValues[T any](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := any(a[i]), any(b[i])
+		if tx, ok := x.(time.Time); ok {
+			if ty, ok := y.(time.Time); !ok || !tx.Equal(ty) {
+				return false
+			}
+			continue
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEncodeValue(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		encoded string
+		decoded interface{}
+	}{
+		{time.Date(2024, 1, 2, 3, 4, 5, 6000, time.FixedZone("", 3600)), "t:2024-01-02T02:04:05.000006Z", time.Date(2024, 1, 2, 2, 4, 5, 6000, time.UTC)},
+		{"a:b", "s:a:b", "a:b"},
+		{"", "s:", ""},
+		{-7, "i:-7", int64(-7)},
+		{int32(7), "i:7", int64(7)},
+		{uint(42), "u:42", uint64(42)},
+	}
+
+	for _, tt := range tests {
+		encoded, err := encodeValue(tt.value)
+		if err != nil || encoded != tt.encoded {
+			t.Errorf("encodeValue(%v) = %q, %v, want %q", tt.value, encoded, err, tt.encoded)
+			continue
+		}
+		decoded, err := decodeValue(encoded)
+		if err != nil || !equalValues([]interface{}{decoded}, []interface{}{tt.decoded}) {
+			t.Errorf("decodeValue(%q) = %v, %v, want %v", encoded, decoded, err, tt.decoded)
+		}
+	}
+
+	if _, err := encodeValue(1.5); err == nil {
+		t.Error("encodeValue(1.5) succeeded, want an unsupported type error")
+	}
+}
@@ -0,0 +1,231 @@
+// Package query turns listing query parameters into GORM conditions and
+// ordering. Each listing declares a Spec naming the parameters it accepts
+// and the columns they map to; columns only ever come from the Spec and
+// values are always bound, so clients cannot inject SQL.
+package query
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reserved query parameters
+const (
+	SortParam   = "sort"
+	SearchParam = "q"
+)
+
+// maxValueLength bounds filter and search values
+const maxValueLength = 100
+
+// Operator is how a filter compares a column with the parameter value
+type Operator int
+
+const (
+	// Equal matches one of a comma-separated list of values
+	Equal Operator = iota
+	// Contains matches values that contain the parameter as a substring
+	Contains
+	// From matches timestamps at or after the parameter
+	From
+	// Before matches timestamps strictly before the parameter
+	Before
+	// Present matches non-NULL columns for "true" and NULL columns for "false"
+	Present
+)
+
+// Filter maps a query parameter to a column
+type Filter struct {
+	Column  string
+	Op      Operator
+	Allowed []string // Accepted values for Equal; empty accepts any
+}
+
+// Spec declares the filters, sort fields and search columns a listing accepts
+type Spec struct {
+	Filters     map[string]Filter // Keyed by query parameter
+	Sorts       map[string]string // Sort field to column
+	Search      []string          // Columns matched by the q parameter
+	DefaultSort string            // e.g. "-created_at"
+	TieBreaker  string            // Unique column appended to every sort, usually "id"
+	MaxSorts    int               // Maximum number of sort fields; zero means 3
+}
+
+// Query is a parsed, validated listing query
+type Query struct {
+	conditions []clause.Expression
+	order      []clause.OrderByColumn
+}
+
+// Parse validates values against the spec. Parameters the spec does not
+// declare, such as page and limit, are ignored.
+func (s *Spec) Parse(values url.Values) (*Query, error) {
+	q := &Query{}
+
+	params := make([]string, 0, len(s.Filters))
+	for param := range s.Filters {
+		params = append(params, param)
+	}
+	slices.Sort(params)
+	for _, param := range params {
+		value := strings.TrimSpace(values.Get(param))
+		if value == "" {
+			continue
+		}
+		condition, err := s.Filters[param].condition(param, value)
+		if err != nil {
+			return nil, err
+		}
+		q.conditions = append(q.conditions, condition)
+	}
+
+	if search := strings.TrimSpace(values.Get(SearchParam)); search != "" && len(s.Search) > 0 {
+		if len(search) > maxValueLength {
+			return nil, fmt.Errorf("%s must be at most %d characters", SearchParam, maxValueLength)
+		}
+		matches := make([]clause.Expression, len(s.Search))
+		for i, column := range s.Search {
+			matches[i] = clause.Like{Column: clause.Column{Name: column}, Value: "%" + EscapeLike(search) + "%"}
+		}
+		q.conditions = append(q.conditions, clause.Or(matches...))
+	}
+
+	sort := values.Get(SortParam)
+	if sort == "" {
+		sort = s.DefaultSort
+	}
+	order, err := s.parseSort(sort)
+	if err != nil {
+		return nil, err
+	}
+	q.order = order
+
+	return q, nil
+}
+
+// parseSort parses a comma-separated list of sort fields, each optionally
+// prefixed with "-" for descending order
+func (s *Spec) parseSort(sort string) ([]clause.OrderByColumn, error) {
+	maxSorts := s.MaxSorts
+	if maxSorts == 0 {
+		maxSorts = 3
+	}
+
+	var order []clause.OrderByColumn
+	seen := map[string]bool{}
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, desc := strings.CutPrefix(field, "-")
+		column, ok := s.Sorts[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q; allowed fields are %s", name, strings.Join(s.sortFields(), ", "))
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("sort field %q is repeated", name)
+		}
+		seen[column] = true
+		order = append(order, clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: desc})
+	}
+	if len(order) > maxSorts {
+		return nil, fmt.Errorf("at most %d sort fields are allowed", maxSorts)
+	}
+
+	// A unique last sort key keeps pages stable when other keys tie
+	if s.TieBreaker != "" && !seen[s.TieBreaker] {
+		desc := len(order) > 0 && order[len(order)-1].Desc
+		order = append(order, clause.OrderByColumn{Column: clause.Column{Name: s.TieBreaker}, Desc: desc})
+	}
+	return order, nil
+}
+
+// sortFields returns the accepted sort fields for error messages
+func (s *Spec) sortFields() []string {
+	fields := make([]string, 0, len(s.Sorts))
+	for field := range s.Sorts {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// condition builds the condition for a filter parameter
+func (f Filter) condition(param, value string) (clause.Expression, error) {
+	if len(value) > maxValueLength {
+		return nil, fmt.Errorf("%s must be at most %d characters", param, maxValueLength)
+	}
+	column := clause.Column{Name: f.Column}
+
+	switch f.Op {
+	case Equal:
+		values := strings.Split(value, ",")
+		in := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			v = strings.TrimSpace(v)
+			if len(f.Allowed) > 0 && !slices.Contains(f.Allowed, v) {
+				return nil, fmt.Errorf("%s must be one of %s", param, strings.Join(f.Allowed, ", "))
+			}
+			in = append(in, v)
+		}
+		return clause.IN{Column: column, Values: in}, nil
+	case Contains:
+		return clause.Like{Column: column, Value: "%" + EscapeLike(value) + "%"}, nil
+	case From, Before:
+		t, err := parseTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 timestamp or a date such as 2024-01-31", param)
+		}
+		if f.Op == From {
+			return clause.Gte{Column: column, Value: t}, nil
+		}
+		return clause.Lt{Column: column, Value: t}, nil
+	case Present:
+		present, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", param)
+		}
+		if present {
+			return clause.Neq{Column: column, Value: nil}, nil
+		}
+		return clause.Eq{Column: column, Value: nil}, nil
+	}
+	return nil, fmt.Errorf("%s has an unsupported filter operator", param)
+}
+
+// Filter applies the query's conditions, e.g. before counting
+func (q *Query) Filter(db *gorm.DB) *gorm.DB {
+	if len(q.conditions) == 0 {
+		return db
+	}
+	return db.Clauses(clause.Where{Exprs: q.conditions})
+}
+
+// Sort applies the query's ordering
+func (q *Query) Sort(db *gorm.DB) *gorm.DB {
+	if len(q.order) == 0 {
+		return db
+	}
+	return db.Order(clause.OrderBy{Columns: q.order})
+}
+
+// EscapeLike escapes LIKE wildcards so values match literally
+func EscapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// parseTime accepts RFC 3339 timestamps and plain dates, read as UTC midnight
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
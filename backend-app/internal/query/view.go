@@ -0,0 +1,202 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Reserved query parameters for response shaping
+const (
+	FieldsParam  = "fields"
+	IncludeParam = "include"
+)
+
+// ViewSpec declares how clients may shape a resource's responses: which
+// attributes they may select with fields= and which related resources they
+// may embed with include=
+type ViewSpec struct {
+	fields   []string
+	includes map[string]string
+}
+
+// NewViewSpec creates a view spec for response, a struct whose JSON
+// attributes may be selected. includes maps each include name to the GORM
+// association preloaded for it; the include name must also be the JSON
+// attribute the relation is rendered under.
+func NewViewSpec(response interface{}, includes map[string]string) *ViewSpec {
+	fields := jsonFields(reflect.TypeOf(response))
+	fields = slices.DeleteFunc(fields, func(field string) bool {
+		_, isInclude := includes[field]
+		return isInclude
+	})
+	return &ViewSpec{fields: fields, includes: includes}
+}
+
+// View is a parsed, validated response shape
+type View struct {
+	fields   []string // Empty renders every attribute
+	includes []string
+	preloads []string
+}
+
+// Parse validates the fields and include parameters. Without them the view
+// renders responses unchanged.
+func (s *ViewSpec) Parse(values url.Values) (*View, error) {
+	v := &View{}
+
+	for _, field := range splitList(values.Get(FieldsParam)) {
+		if !slices.Contains(s.fields, field) {
+			return nil, fmt.Errorf("unknown field %q; allowed fields are %s", field, strings.Join(s.fields, ", "))
+		}
+		if !slices.Contains(v.fields, field) {
+			v.fields = append(v.fields, field)
+		}
+	}
+	// The ID always identifies the rendered resource
+	if len(v.fields) > 0 && slices.Contains(s.fields, "id") && !slices.Contains(v.fields, "id") {
+		v.fields = append(v.fields, "id")
+	}
+
+	for _, include := range splitList(values.Get(IncludeParam)) {
+		association, ok := s.includes[include]
+		if !ok {
+			return nil, fmt.Errorf("cannot include %q; allowed includes are %s", include, strings.Join(s.includeNames(), ", "))
+		}
+		if !slices.Contains(v.includes, include) {
+			v.includes = append(v.includes, include)
+			v.preloads = append(v.preloads, association)
+		}
+	}
+
+	return v, nil
+}
+
+// includeNames returns the accepted includes for error messages
+func (s *ViewSpec) includeNames() []string {
+	names := make([]string, 0, len(s.includes))
+	for name := range s.includes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Includes reports whether the view embeds the named relation
+func (v *View) Includes(name string) bool {
+	return v != nil && slices.Contains(v.includes, name)
+}
+
+// LoadsRelations reports whether the view preloads any relation
+func (v *View) LoadsRelations() bool {
+	return v != nil && len(v.preloads) > 0
+}
+
+// Preload loads the included relations. GORM loads each relation for all
+// rows with a single IN query, so listings do not issue a query per row.
+func (v *View) Preload(db *gorm.DB) *gorm.DB {
+	if v == nil {
+		return db
+	}
+	for _, association := range v.preloads {
+		db = db.Preload(association)
+	}
+	return db
+}
+
+// Render shapes a response. It is returned unchanged unless attributes
+// were selected or relations included; included relations that are
+// missing are rendered as null.
+func (v *View) Render(response interface{}) (interface{}, error) {
+	if v == nil || (len(v.fields) == 0 && len(v.includes) == 0) {
+		return response, nil
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &attributes); err != nil {
+		return nil, err
+	}
+
+	if len(v.fields) > 0 {
+		selected := make(map[string]json.RawMessage, len(v.fields)+len(v.includes))
+		for _, field := range v.fields {
+			if value, ok := attributes[field]; ok {
+				selected[field] = value
+			}
+		}
+		for _, include := range v.includes {
+			selected[include] = attributes[include]
+		}
+		attributes = selected
+	}
+	for _, include := range v.includes {
+		if attributes[include] == nil {
+			attributes[include] = json.RawMessage("null")
+		}
+	}
+	return attributes, nil
+}
+
+// RenderAll shapes every response in a slice
+func (v *View) RenderAll(responses interface{}) (interface{}, error) {
+	if v == nil || (len(v.fields) == 0 && len(v.includes) == 0) {
+		return responses, nil
+	}
+
+	items := reflect.ValueOf(responses)
+	rendered := make([]interface{}, items.Len())
+	for i := range rendered {
+		item, err := v.Render(items.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		rendered[i] = item
+	}
+	return rendered, nil
+}
+
+// jsonFields lists the JSON attribute names of a struct type, including
+// those of embedded structs
+func jsonFields(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// splitList splits a comma-separated parameter, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Callers a policy counts requests for. Requests without the credential a
+// policy keys on are counted per client IP.
+const (
+	KeyIP     = "ip"      // Client IP address
+	KeyUser   = "user"    // User of the bearer access token
+	KeyAPIKey = "api_key" // API key sent in X-API-Key
+)
+
+// AnyRoute matches every route, including unknown paths
+const AnyRoute = "*"
+
+var ErrInvalidPolicy = errors.New("invalid rate limit policy")
+
+// Policy allows Limit requests per Window to a route for each caller
+type Policy struct {
+	Name   string
+	Method string // HTTP method, or * for any
+	Path   string // Route pattern as registered, e.g. /api/v1/users/:id, or * for any
+	Limit  int
+	Window time.Duration
+	Key    string // KeyIP, KeyUser or KeyAPIKey
+}
+
+// NewPolicy validates a policy. route is a method and route pattern, e.g.
+// "POST /api/v1/users/login", with * matching any method or route; a lone
+// * matches every request.
+func NewPolicy(name, route string, limit int, window time.Duration, key string) (Policy, error) {
+	method, path, ok := strings.Cut(strings.TrimSpace(route), " ")
+	if !ok {
+		method, path = AnyRoute, method
+	}
+	policy := Policy{
+		Name:   name,
+		Method: strings.ToUpper(method),
+		Path:   strings.TrimSpace(path),
+		Limit:  limit,
+		Window: window,
+		Key:    strings.ToLower(key),
+	}
+
+	switch {
+	case name == "" || strings.ContainsAny(name, ":; ,"):
+		return Policy{}, fmt.Errorf("%w: name %q must be non-empty without spaces, colons, semicolons or commas", ErrInvalidPolicy, name)
+	case policy.Path != AnyRoute && !strings.HasPrefix(policy.Path, "/"):
+		return Policy{}, fmt.Errorf("%w %s: route %q must be a method and path, e.g. \"POST /api/v1/users/login\"", ErrInvalidPolicy, name, route)
+	case limit <= 0:
+		return Policy{}, fmt.Errorf("%w %s: limit must be positive", ErrInvalidPolicy, name)
+	case window < time.Second:
+		return Policy{}, fmt.Errorf("%w %s: window must be at least 1s", ErrInvalidPolicy, name)
+	case policy.Key != KeyIP && policy.Key != KeyUser && policy.Key != KeyAPIKey:
+		return Policy{}, fmt.Errorf("%w %s: key must be %s, %s or %s", ErrInvalidPolicy, name, KeyIP, KeyUser, KeyAPIKey)
+	}
+	return policy, nil
+}
+
+// Matches reports whether the policy applies to a request with method for
+// route, the pattern the router matched or "" when none did
+func (p Policy) Matches(method, route string) bool {
+	return (p.Method == AnyRoute || p.Method == method) && (p.Path == AnyRoute || p.Path == route)
+}
+
+// String describes the policy in the RateLimit-Policy header format
+func (p Policy) String() string {
+	return strconv.Itoa(p.Limit) + ";w=" + strconv.Itoa(int(p.Window/time.Second))
+}
+
+// Decision is the outcome of counting a request against a policy
+type Decision struct {
+	Policy     Policy
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // Until the current window ends
+	RetryAfter time.Duration // Until a request would be allowed again; zero when allowed
+}
+
+// Limiter counts requests with a sliding window: the count is the requests
+// in the current fixed window plus those in the previous one, weighted by
+// how much of it still overlaps the sliding window. Rejected requests are
+// not counted, so a client retrying after Retry-After gets through.
+type Limiter struct {
+	store Store
+}
+
+// NewLimiter creates a limiter keeping its counters in store
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store}
+}
+
+// Allow counts a request by caller, e.g. "ip:203.0.113.7", against policy
+func (l *Limiter) Allow(policy Policy, caller string, now time.Time) (Decision, error) {
+	window := policy.Window.Nanoseconds()
+	index := now.UnixNano() / window
+	elapsed := time.Duration(now.UnixNano() - index*window)
+	key := "ratelimit:" + policy.Name + ":" + caller + ":"
+
+	// Counters outlive their window so the next one can weigh them
+	current, err := l.store.Add(key+strconv.FormatInt(index, 10), 1, 2*policy.Window)
+	if err != nil {
+		return Decision{}, err
+	}
+	previous, err := l.store.Get(key + strconv.FormatInt(index-1, 10))
+	if err != nil {
+		return Decision{}, err
+	}
+
+	weight := 1 - float64(elapsed)/float64(policy.Window)
+	limit := float64(policy.Limit)
+	count := float64(previous)*weight + float64(current)
+	decision := Decision{
+		Policy:    policy,
+		Allowed:   count <= limit,
+		Remaining: max(0, policy.Limit-int(math.Ceil(count))),
+		Reset:     policy.Window - elapsed,
+	}
+	if decision.Allowed {
+		return decision, nil
+	}
+
+	if _, err := l.store.Add(key+strconv.FormatInt(index, 10), -1, 2*policy.Window); err != nil {
+		return Decision{}, err
+	}
+	decision.RetryAfter = retryAfter(policy, float64(previous), float64(current-1), elapsed)
+	return decision, nil
+}
+
+// retryAfter estimates when one more request fits: once enough of the
+// previous window slides out, or, when the current window alone is full,
+// once enough of it slides out in the next one. It is rounded up to whole
+// seconds, as sent in Retry-After, so retrying then is not early.
+func retryAfter(policy Policy, previous, current float64, elapsed time.Duration) time.Duration {
+	limit := float64(policy.Limit)
+	window := float64(policy.Window)
+	var wait time.Duration
+	if current+1 <= limit && previous > 0 {
+		// previous * (1 - t/window) + current + 1 <= limit
+		t := window * (1 - (limit-current-1)/previous)
+		wait = time.Duration(t) - elapsed
+	} else {
+		// In the next window: current * (1 - t/window) + 1 <= limit
+		t := window * (1 - (limit-1)/current)
+		wait = policy.Window - elapsed + time.Duration(t)
+	}
+	return time.Duration(max(seconds(wait), 1)) * time.Second
+}
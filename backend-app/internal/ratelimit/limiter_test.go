@@ -0,0 +1,264 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/redis"
+)
+
+// stores returns the stores to run limiter tests against: memory, and
+// Redis as served by the stub
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	stub, err := redis.NewStub("127.0.0.1:0", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(stub.Addr(), "secret")
+	t.Cleanup(func() {
+		client.Close()
+		stub.Close()
+	})
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"redis":  NewRedisStore(client),
+	}
+}
+
+// windowStart returns the start of a fixed window of policy
+func windowStart(policy Policy) time.Time {
+	return time.Unix(0, 0).Add(1000 * policy.Window)
+}
+
+func mustPolicy(t *testing.T, limit int, window time.Duration) Policy {
+	t.Helper()
+	policy, err := NewPolicy("test", "POST /api/v1/users/login", limit, window, KeyIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		route      string
+		limit      int
+		window     time.Duration
+		key        string
+		wantMethod string
+		wantPath   string
+		wantErr    bool
+	}{
+		{name: "login", policy: "login", route: "POST /api/v1/users/login", limit: 5, window: time.Minute, key: KeyIP, wantMethod: "POST", wantPath: "/api/v1/users/login"},
+		{name: "lowercase method", policy: "login", route: " post  /api/v1/users/login ", limit: 5, window: time.Minute, key: "IP", wantMethod: "POST", wantPath: "/api/v1/users/login"},
+		{name: "path only", policy: "users", route: "/api/v1/users/:id", limit: 5, window: time.Minute, key: KeyUser, wantMethod: AnyRoute, wantPath: "/api/v1/users/:id"},
+		{name: "everything", policy: "global", route: "*", limit: 5, window: time.Minute, key: KeyAPIKey, wantMethod: AnyRoute, wantPath: AnyRoute},
+		{name: "empty name", policy: "", route: "*", limit: 5, window: time.Minute, key: KeyIP, wantErr: true},
+		{name: "colon in name", policy: "a:b", route: "*", limit: 5, window: time.Minute, key: KeyIP, wantErr: true},
+		{name: "relative path", policy: "users", route: "GET users", limit: 5, window: time.Minute, key: KeyIP, wantErr: true},
+		{name: "zero limit", policy: "users", route: "*", limit: 0, window: time.Minute, key: KeyIP, wantErr: true},
+		{name: "short window", policy: "users", route: "*", limit: 5, window: 500 * time.Millisecond, key: KeyIP, wantErr: true},
+		{name: "unknown key", policy: "users", route: "*", limit: 5, window: time.Minute, key: "session", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy(tt.policy, tt.route, tt.limit, tt.window, tt.key)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPolicy) {
+					t.Errorf("NewPolicy() = %+v, %v, want ErrInvalidPolicy", policy, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewPolicy() error = %v", err)
+			}
+			if policy.Method != tt.wantMethod || policy.Path != tt.wantPath {
+				t.Errorf("NewPolicy() matches %s %s, want %s %s", policy.Method, policy.Path, tt.wantMethod, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestPolicyMatches(t *testing.T) {
+	tests := []struct {
+		route  string
+		method string
+		path   string
+		want   bool
+	}{
+		{"POST /api/v1/users/login", "POST", "/api/v1/users/login", true},
+		{"POST /api/v1/users/login", "GET", "/api/v1/users/login", false},
+		{"POST /api/v1/users/login", "POST", "/api/v1/users/register", false},
+		{"/api/v1/users/:id", "DELETE", "/api/v1/users/:id", true},
+		{"/api/v1/users/:id", "GET", "/api/v1/users/42", false},
+		{"GET *", "GET", "", true},
+		{"*", "PATCH", "/api/v1/users/:id", true},
+	}
+
+	for _, tt := range tests {
+		policy, err := NewPolicy("test", tt.route, 1, time.Minute, KeyIP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := policy.Matches(tt.method, tt.path); got != tt.want {
+			t.Errorf("%q.Matches(%s, %q) = %v, want %v", tt.route, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyString(t *testing.T) {
+	if got := mustPolicy(t, 100, time.Hour).String(); got != "100;w=3600" {
+		t.Errorf("String() = %s, want 100;w=3600", got)
+	}
+}
+
+func TestLimiterWindow(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			limiter := NewLimiter(store)
+			policy := mustPolicy(t, 10, time.Minute)
+			start := windowStart(policy)
+			now := start.Add(10 * time.Second)
+
+			for i := 1; i <= 10; i++ {
+				decision, err := limiter.Allow(policy, "ip:203.0.113.7", now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !decision.Allowed || decision.Remaining != 10-i || decision.Reset != 50*time.Second || decision.RetryAfter != 0 {
+					t.Fatalf("request %d: %+v, want allowed with %d remaining", i, decision, 10-i)
+				}
+			}
+
+			// Rejected requests are not counted
+			for i := 0; i < 3; i++ {
+				decision, err := limiter.Allow(policy, "ip:203.0.113.7", now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Enough of the full window slides out 6s into the next one
+				if decision.Allowed || decision.Remaining != 0 || decision.RetryAfter != 56*time.Second {
+					t.Fatalf("request over the limit: %+v, want rejected for 56s", decision)
+				}
+			}
+
+			// Other callers and policies have their own counters
+			if decision, _ := limiter.Allow(policy, "ip:203.0.113.8", now); !decision.Allowed {
+				t.Error("another caller was rejected")
+			}
+			other := policy
+			other.Name = "other"
+			if decision, _ := limiter.Allow(other, "ip:203.0.113.7", now); !decision.Allowed {
+				t.Error("another policy rejected the caller")
+			}
+
+			// The previous window weighs 55/60 at 65s: 9.17 + 1 > 10
+			if decision, _ := limiter.Allow(policy, "ip:203.0.113.7", start.Add(65*time.Second)); decision.Allowed {
+				t.Errorf("allowed before Retry-After: %+v", decision)
+			}
+			// and 54/60 at 66s: 9 + 1 <= 10
+			decision, err := limiter.Allow(policy, "ip:203.0.113.7", start.Add(66*time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !decision.Allowed || decision.Remaining != 0 {
+				t.Errorf("at Retry-After: %+v, want allowed with none remaining", decision)
+			}
+
+			// Two windows later nothing is left to weigh
+			for i := 0; i < 10; i++ {
+				if decision, _ := limiter.Allow(policy, "ip:203.0.113.7", start.Add(3*time.Minute)); !decision.Allowed {
+					t.Fatalf("request %d two windows later was rejected", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterRetryAfter(t *testing.T) {
+	// Requests spread over the previous window, then the current one filled
+	// at different points; retrying after Retry-After must be allowed
+	tests := []struct {
+		limit    int
+		previous int           // Requests in the previous window
+		at       time.Duration // Into the current window when it fills
+	}{
+		{10, 0, 0},
+		{10, 0, 30 * time.Second},
+		{10, 5, 10 * time.Second},
+		{10, 10, 30 * time.Second},
+		{10, 10, 59 * time.Second},
+		{3, 2, 45 * time.Second},
+		{1, 1, 0},
+		{100, 60, 20 * time.Second},
+	}
+
+	for name, store := range stores(t) {
+		for i, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%d", name, i), func(t *testing.T) {
+				limiter := NewLimiter(store)
+				policy := mustPolicy(t, tt.limit, time.Minute)
+				caller := fmt.Sprintf("ip:%s-%d", name, i)
+				start := windowStart(policy)
+
+				for j := 0; j < tt.previous; j++ {
+					limiter.Allow(policy, caller, start.Add(-time.Minute))
+				}
+				now := start.Add(tt.at)
+				var decision Decision
+				for {
+					var err error
+					if decision, err = limiter.Allow(policy, caller, now); err != nil {
+						t.Fatal(err)
+					}
+					if !decision.Allowed {
+						break
+					}
+				}
+				if decision.RetryAfter < time.Second {
+					t.Fatalf("Retry-After = %v, want at least 1s", decision.RetryAfter)
+				}
+
+				retry, err := limiter.Allow(policy, caller, now.Add(decision.RetryAfter))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !retry.Allowed {
+					t.Errorf("retry after %v was rejected: %+v", decision.RetryAfter, retry)
+				}
+			})
+		}
+	}
+}
+
+func TestRedisStoreExpiry(t *testing.T) {
+	store := stores(t)["redis"].(*RedisStore)
+
+	if value, err := store.Get("missing"); value != 0 || err != nil {
+		t.Errorf("Get(missing) = %d, %v, want 0", value, err)
+	}
+	if value, err := store.Add("counter", 1, time.Minute); value != 1 || err != nil {
+		t.Fatalf("Add() = %d, %v, want 1", value, err)
+	}
+	if value, err := store.Add("counter", 2, time.Minute); value != 3 || err != nil {
+		t.Fatalf("Add() = %d, %v, want 3", value, err)
+	}
+	ttl, err := redis.Int(store.client.Do("PTTL", "counter"))
+	if err != nil || ttl <= 0 || ttl > time.Minute.Milliseconds() {
+		t.Errorf("counter TTL = %dms, %v, want up to a minute", ttl, err)
+	}
+
+	if _, err := store.Add("short", 1, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if value, err := store.Get("short"); value != 0 || err != nil {
+		t.Errorf("Get() of an expired counter = %d, %v, want 0", value, err)
+	}
+}
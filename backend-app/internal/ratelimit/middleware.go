@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/middleware"
+)
+
+// Response headers, as in the IETF RateLimit header fields draft
+const (
+	LimitHeader      = "RateLimit-Limit"
+	RemainingHeader  = "RateLimit-Remaining"
+	ResetHeader      = "RateLimit-Reset"
+	PolicyHeader     = "RateLimit-Policy"
+	RetryAfterHeader = "Retry-After"
+)
+
+// errorResponse mirrors the modules' error body
+type errorResponse struct {
+	Error   string `json:"error"`
+	Details string `json:"details,omitempty"`
+}
+
+// Middleware counts each request against every policy matching its route
+// and rejects it with 429 once any is exhausted. It runs on the engine,
+// ahead of authentication, so it identifies callers itself by a verified
+// access token or API key. The most restrictive policy is reported in the
+// RateLimit headers. When the store fails, requests are let through.
+func Middleware(limiter *Limiter, policies []Policy, tokens *auth.TokenManager, apiKeys middleware.APIKeyValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method, route := c.Request.Method, c.FullPath()
+		var tightest *Decision
+		var applied []string
+		callers := map[string]string{} // By policy key, so each credential is checked once
+		for _, policy := range policies {
+			if !policy.Matches(method, route) {
+				continue
+			}
+
+			id, ok := callers[policy.Key]
+			if !ok {
+				id = caller(c, policy.Key, tokens, apiKeys)
+				callers[policy.Key] = id
+			}
+			decision, err := limiter.Allow(policy, id, time.Now())
+			if err != nil {
+				log.Printf("⚠️  Rate limit policy %s skipped: %v", policy.Name, err)
+				continue
+			}
+			applied = append(applied, policy.String())
+			if tightest == nil || tighter(decision, *tightest) {
+				tightest = &decision
+			}
+		}
+		if tightest == nil {
+			c.Next()
+			return
+		}
+
+		c.Header(LimitHeader, strconv.Itoa(tightest.Policy.Limit))
+		c.Header(RemainingHeader, strconv.Itoa(tightest.Remaining))
+		c.Header(ResetHeader, strconv.Itoa(seconds(tightest.Reset)))
+		c.Header(PolicyHeader, strings.Join(applied, ", "))
+		if !tightest.Allowed {
+			retryAfter := seconds(tightest.RetryAfter)
+			c.Header(RetryAfterHeader, strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errorResponse{
+				Error: "Too many requests",
+				Details: "limit of " + strconv.Itoa(tightest.Policy.Limit) + " requests per " + tightest.Policy.Window.String() +
+					" exceeded; retry in " + strconv.Itoa(retryAfter) + "s",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// tighter reports whether a leaves the caller less room than b; a rejection
+// is tighter than any allowance
+func tighter(a, b Decision) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+// caller identifies whom a request is counted for under key, falling back
+// to the client IP when the request lacks that credential. Invalid access
+// tokens and unknown API keys fall back too, so made-up ones cannot spread
+// requests over many counters.
+func caller(c *gin.Context, key string, tokens *auth.TokenManager, apiKeys middleware.APIKeyValidator) string {
+	switch key {
+	case KeyUser:
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && token != "" {
+			if claims, err := tokens.Parse(token, auth.PurposeAccess); err == nil {
+				if userID, err := claims.UserID(); err == nil {
+					return "user:" + strconv.FormatUint(uint64(userID), 10)
+				}
+			}
+		}
+	case KeyAPIKey:
+		if apiKey := c.GetHeader(middleware.APIKeyHeader); apiKey != "" {
+			if principal, err := apiKeys.ValidateAPIKey(apiKey); err == nil {
+				return "key:" + strconv.FormatUint(uint64(principal.KeyID), 10)
+			}
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// seconds rounds d up to whole seconds
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
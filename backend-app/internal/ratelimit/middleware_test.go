@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/middleware"
+)
+
+// apiKeys accepts the API keys it maps to key IDs, counting the lookups
+type apiKeys struct {
+	ids     map[string]uint
+	lookups int
+}
+
+func (k *apiKeys) ValidateAPIKey(key string) (*middleware.APIKeyPrincipal, error) {
+	k.lookups++
+	id, ok := k.ids[key]
+	if !ok {
+		return nil, errors.New("invalid API key")
+	}
+	return &middleware.APIKeyPrincipal{KeyID: id, UserID: 1, Role: "user"}, nil
+}
+
+func TestMiddlewareAPIKeys(t *testing.T) {
+	policies := make([]Policy, 2)
+	for i, name := range []string{"burst", "reports"} {
+		policy, err := NewPolicy(name, "GET /reports", 2, time.Minute, KeyAPIKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies[i] = policy
+	}
+	keys := &apiKeys{ids: map[string]uint{"valid-1": 1, "valid-2": 2}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(NewLimiter(NewMemoryStore()), policies, auth.NewTokenManager("test-secret", "test"), keys))
+	r.GET("/reports", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	get := func(apiKey, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/reports", nil)
+		req.Header.Set(middleware.APIKeyHeader, apiKey)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	steps := []struct {
+		name       string
+		apiKey     string
+		ip         string
+		wantStatus int
+	}{
+		{"first unknown key", "forged-1", "192.0.2.1", http.StatusNoContent},
+		{"second unknown key from the same IP", "forged-2", "192.0.2.1", http.StatusNoContent},
+		{"third unknown key from the same IP", "forged-3", "192.0.2.1", http.StatusTooManyRequests},
+		{"unknown key from another IP", "forged-4", "192.0.2.2", http.StatusNoContent},
+		{"valid key from the limited IP", "valid-1", "192.0.2.1", http.StatusNoContent},
+		{"valid key again", "valid-1", "192.0.2.3", http.StatusNoContent},
+		{"valid key over its limit", "valid-1", "192.0.2.4", http.StatusTooManyRequests},
+		{"another valid key", "valid-2", "192.0.2.4", http.StatusNoContent},
+	}
+	for _, step := range steps {
+		if got := get(step.apiKey, step.ip); got != step.wantStatus {
+			t.Errorf("%s: status = %d, want %d", step.name, got, step.wantStatus)
+		}
+	}
+	if keys.lookups != len(steps) {
+		t.Errorf("looked up API keys %d times for %d requests, want once per request", keys.lookups, len(steps))
+	}
+}
@@ -0,0 +1,108 @@
+// Package ratelimit throttles requests per route and caller with a
+// sliding-window counter. Counters live in a Store, in memory for a single
+// instance or in Redis when several instances share the limits.
+package ratelimit
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/savindaJ/backend-app/internal/redis"
+)
+
+// Store keeps expiring counters
+type Store interface {
+	// Add adds delta to the counter at key and returns its new value. A new
+	// counter starts at zero and expires after ttl.
+	Add(key string, delta int64, ttl time.Duration) (int64, error)
+	// Get returns the counter at key, or zero when it does not exist
+	Get(key string) (int64, error)
+}
+
+// memorySweepInterval is how often the memory store drops expired counters
+const memorySweepInterval = time.Minute
+
+// MemoryStore keeps counters in process memory, so limits apply per instance
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]memoryCounter
+	lastSweep time.Time
+}
+
+type memoryCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: map[string]memoryCounter{}, lastSweep: time.Now()}
+}
+
+// Add implements Store
+func (s *MemoryStore) Add(key string, delta int64, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= memorySweepInterval {
+		for k, counter := range s.counters {
+			if !now.Before(counter.expiresAt) {
+				delete(s.counters, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	counter, ok := s.counters[key]
+	if !ok || !now.Before(counter.expiresAt) {
+		counter = memoryCounter{expiresAt: now.Add(ttl)}
+	}
+	counter.value += delta
+	s.counters[key] = counter
+	return counter.value, nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok || !time.Now().Before(counter.expiresAt) {
+		return 0, nil
+	}
+	return counter.value, nil
+}
+
+// RedisStore keeps counters in Redis, so instances sharing it share limits
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a store backed by client
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Add implements Store. The expiry is set when the counter is created; if
+// that fails the counter is removed so it cannot outlive its window.
+func (s *RedisStore) Add(key string, delta int64, ttl time.Duration) (int64, error) {
+	value, err := redis.Int(s.client.Do("INCRBY", key, strconv.FormatInt(delta, 10)))
+	if err != nil {
+		return 0, err
+	}
+	if value == delta {
+		if _, err := s.client.Do("PEXPIRE", key, strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+			s.client.Do("DEL", key)
+			return 0, err
+		}
+	}
+	return value, nil
+}
+
+// Get implements Store
+func (s *RedisStore) Get(key string) (int64, error) {
+	return redis.Int(s.client.Do("GET", key))
+}
@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client defaults
+const (
+	defaultTimeout = 2 * time.Second
+	maxIdleConns   = 16
+)
+
+// Client sends commands to a Redis server over a small pool of
+// connections. It is safe for concurrent use.
+type Client struct {
+	addr     string
+	password string
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+// conn is a connection with its buffered reader and writer
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// NewClient creates a client for the server at addr. Connections
+// authenticate with password when it is set. Each command, including
+// dialing, must complete within a short timeout.
+func NewClient(addr, password string) *Client {
+	return &Client{addr: addr, password: password, timeout: defaultTimeout}
+}
+
+// Do sends a command, e.g. Do("INCRBY", key, "1"), and returns its reply as
+// read by readReply. Error replies are returned as an Error.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(time.Now().Add(c.timeout), args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection's state is unknown after an I/O or protocol error
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Ping checks that the server is reachable
+func (c *Client) Ping() error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// Close closes the idle connections
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// get takes an idle connection or dials a new one
+func (c *Client) get() (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	netConn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: netConn, reader: bufio.NewReader(netConn), writer: bufio.NewWriter(netConn)}
+	if c.password != "" {
+		if _, err := cn.do(time.Now().Add(c.timeout), []string{"AUTH", c.password}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// put returns a healthy connection to the pool
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdleConns {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// do sends one command and reads its reply before deadline
+func (cn *conn) do(deadline time.Time, args []string) (interface{}, error) {
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := writeCommand(cn.writer, args); err != nil {
+		return nil, err
+	}
+	return readReply(cn.reader)
+}
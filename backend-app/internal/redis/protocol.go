@@ -0,0 +1,155 @@
+// Package redis speaks the Redis serialization protocol (RESP2). It holds a
+// minimal client for the commands this service sends and an in-process
+// stub server implementing them, for development and tests without Redis.
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxBulkLength bounds bulk strings and arrays read from the wire
+const maxBulkLength = 64 * 1024 * 1024
+
+// ErrProtocol reports a malformed message
+var ErrProtocol = errors.New("redis: protocol error")
+
+// Error is an error reply, e.g. for a command against a key of the wrong type
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// readReply reads one value. Simple and bulk strings are returned as
+// string, integers as int64, arrays as []interface{} and null bulk strings
+// and arrays as nil. Error replies are returned as an Error.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, fmt.Errorf("%w: empty line", ErrProtocol)
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid integer %q", ErrProtocol, line[1:])
+		}
+		return n, nil
+	case '$':
+		n, err := readLength(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if string(buf[n:]) != "\r\n" {
+			return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := readLength(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected type %q", ErrProtocol, line[0])
+}
+
+// readLine reads a CRLF-terminated line without its terminator
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line, ok := strings.CutSuffix(line, "\r\n")
+	if !ok {
+		return "", fmt.Errorf("%w: line not terminated by CRLF", ErrProtocol)
+	}
+	return line, nil
+}
+
+// readLength parses a bulk string or array length; -1 denotes null
+func readLength(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < -1 || n > maxBulkLength {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, s)
+	}
+	return n, nil
+}
+
+// writeCommand writes a command as an array of bulk strings
+func writeCommand(w *bufio.Writer, args []string) error {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		writeBulk(w, &arg)
+	}
+	return w.Flush()
+}
+
+// writeBulk writes a bulk string, or a null bulk string for nil
+func writeBulk(w *bufio.Writer, s *string) {
+	if s == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteString("$" + strconv.Itoa(len(*s)) + "\r\n")
+	w.WriteString(*s)
+	w.WriteString("\r\n")
+}
+
+// Int converts an integer reply, or a bulk string holding one, to int64.
+// A null reply is zero, as Redis treats missing counters.
+func Int(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("redis: reply %q is not an integer", v)
+		}
+		return n, nil
+	case nil:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("redis: unexpected reply type %T", reply)
+}
+
+// String converts a string reply, reporting false for a null reply
+func String(reply interface{}, err error) (string, bool, error) {
+	if err != nil {
+		return "", false, err
+	}
+	switch v := reply.(type) {
+	case string:
+		return v, true, nil
+	case nil:
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("redis: unexpected reply type %T", reply)
+}
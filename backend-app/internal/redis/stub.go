@@ -0,0 +1,388 @@
+package redis
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stub is an in-process server speaking the Redis protocol for the string,
+// counter and expiry commands the client needs, with keys held in memory.
+// It stands in for Redis in development and tests; nothing is persisted.
+type Stub struct {
+	listener net.Listener
+	password string
+
+	mu    sync.Mutex
+	data  map[string]stubEntry
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// stubEntry is a stored string and its expiry; zero means it never expires
+type stubEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// stubHandler runs a command whose arity was checked
+type stubHandler struct {
+	minArgs int // Including the command name
+	maxArgs int // Zero for no limit
+	run     func(s *Stub, args []string, w *bufio.Writer)
+}
+
+// stubCommands are the commands the stub implements besides AUTH and QUIT
+var stubCommands = map[string]stubHandler{
+	"PING":     {1, 2, (*Stub).ping},
+	"GET":      {2, 2, (*Stub).get},
+	"SET":      {3, 0, (*Stub).set},
+	"DEL":      {2, 0, (*Stub).del},
+	"EXISTS":   {2, 0, (*Stub).exists},
+	"INCR":     {2, 2, (*Stub).incrBy},
+	"INCRBY":   {3, 3, (*Stub).incrBy},
+	"DECRBY":   {3, 3, (*Stub).incrBy},
+	"EXPIRE":   {3, 3, (*Stub).expire},
+	"PEXPIRE":  {3, 3, (*Stub).expire},
+	"TTL":      {2, 2, (*Stub).ttl},
+	"PTTL":     {2, 2, (*Stub).ttl},
+	"FLUSHALL": {1, 2, (*Stub).flushAll},
+}
+
+// NewStub starts a stub listening on addr, e.g. "127.0.0.1:0" for a free
+// port. When password is set, clients must AUTH before other commands.
+func NewStub(addr, password string) (*Stub, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stub{
+		listener: listener,
+		password: password,
+		data:     map[string]stubEntry{},
+		conns:    map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address the stub listens on
+func (s *Stub) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the stub and closes its connections
+func (s *Stub) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for cn := range s.conns {
+		cn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Stub) serve() {
+	defer s.wg.Done()
+	for {
+		cn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[cn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(cn)
+	}
+}
+
+// handle runs the commands of one connection until it closes
+func (s *Stub) handle(cn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, cn)
+		s.mu.Unlock()
+		cn.Close()
+	}()
+
+	reader := bufio.NewReader(cn)
+	writer := bufio.NewWriter(cn)
+	authenticated := s.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				writeError(writer, "ERR Protocol error: "+err.Error())
+				writer.Flush()
+			}
+			return
+		}
+
+		name := strings.ToUpper(args[0])
+		switch {
+		case name == "AUTH":
+			authenticated = s.auth(args, writer) || authenticated
+		case name == "QUIT":
+			writer.WriteString("+OK\r\n")
+			writer.Flush()
+			return
+		case !authenticated:
+			writeError(writer, "NOAUTH Authentication required.")
+		default:
+			s.run(name, args, writer)
+		}
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	reply, err := readReply(r)
+	if err != nil {
+		if _, ok := err.(Error); ok {
+			return nil, ErrProtocol
+		}
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, ErrProtocol
+	}
+	args := make([]string, len(items))
+	for i, item := range items {
+		if args[i], ok = item.(string); !ok {
+			return nil, ErrProtocol
+		}
+	}
+	return args, nil
+}
+
+func (s *Stub) auth(args []string, w *bufio.Writer) bool {
+	if len(args) != 2 {
+		writeError(w, "ERR wrong number of arguments for 'auth' command")
+		return false
+	}
+	if s.password == "" {
+		writeError(w, "ERR AUTH called without any password configured")
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(args[1]), []byte(s.password)) != 1 {
+		writeError(w, "WRONGPASS invalid password")
+		return false
+	}
+	w.WriteString("+OK\r\n")
+	return true
+}
+
+// run checks a command's arity and runs it with the data locked
+func (s *Stub) run(name string, args []string, w *bufio.Writer) {
+	handler, ok := stubCommands[name]
+	if !ok {
+		writeError(w, "ERR unknown command '"+args[0]+"'")
+		return
+	}
+	if len(args) < handler.minArgs || handler.maxArgs > 0 && len(args) > handler.maxArgs {
+		writeError(w, "ERR wrong number of arguments for '"+strings.ToLower(name)+"' command")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handler.run(s, args, w)
+}
+
+// lookup returns a key's entry, removing it once expired. Callers hold mu.
+func (s *Stub) lookup(key string) (stubEntry, bool) {
+	entry, ok := s.data[key]
+	if ok && !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		delete(s.data, key)
+		return stubEntry{}, false
+	}
+	return entry, ok
+}
+
+func (s *Stub) ping(args []string, w *bufio.Writer) {
+	if len(args) == 2 {
+		writeBulk(w, &args[1])
+		return
+	}
+	w.WriteString("+PONG\r\n")
+}
+
+func (s *Stub) get(args []string, w *bufio.Writer) {
+	entry, ok := s.lookup(args[1])
+	if !ok {
+		writeBulk(w, nil)
+		return
+	}
+	writeBulk(w, &entry.value)
+}
+
+// set supports the EX, PX, NX, XX and KEEPTTL options
+func (s *Stub) set(args []string, w *bufio.Writer) {
+	key, entry := args[1], stubEntry{value: args[2]}
+	var nx, xx, keepTTL bool
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX":
+			if i+1 == len(args) {
+				writeError(w, "ERR syntax error")
+				return
+			}
+			i++
+			ttl, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || ttl <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			unit := time.Millisecond
+			if option == "EX" {
+				unit = time.Second
+			}
+			entry.expiresAt = time.Now().Add(time.Duration(ttl) * unit)
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+	if nx && xx || keepTTL && !entry.expiresAt.IsZero() {
+		writeError(w, "ERR syntax error")
+		return
+	}
+
+	existing, exists := s.lookup(key)
+	if nx && exists || xx && !exists {
+		writeBulk(w, nil)
+		return
+	}
+	if keepTTL {
+		entry.expiresAt = existing.expiresAt
+	}
+	s.data[key] = entry
+	w.WriteString("+OK\r\n")
+}
+
+func (s *Stub) del(args []string, w *bufio.Writer) {
+	deleted := 0
+	for _, key := range args[1:] {
+		if _, ok := s.lookup(key); ok {
+			delete(s.data, key)
+			deleted++
+		}
+	}
+	writeInt(w, int64(deleted))
+}
+
+func (s *Stub) exists(args []string, w *bufio.Writer) {
+	found := 0
+	for _, key := range args[1:] {
+		if _, ok := s.lookup(key); ok {
+			found++
+		}
+	}
+	writeInt(w, int64(found))
+}
+
+// incrBy implements INCR, INCRBY and DECRBY, keeping the key's expiry
+func (s *Stub) incrBy(args []string, w *bufio.Writer) {
+	delta := int64(1)
+	if len(args) == 3 {
+		var err error
+		if delta, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			writeError(w, "ERR value is not an integer or out of range")
+			return
+		}
+		if strings.EqualFold(args[0], "DECRBY") {
+			delta = -delta
+		}
+	}
+
+	entry, _ := s.lookup(args[1])
+	value := int64(0)
+	if entry.value != "" {
+		var err error
+		if value, err = strconv.ParseInt(entry.value, 10, 64); err != nil {
+			writeError(w, "ERR value is not an integer or out of range")
+			return
+		}
+	}
+	value += delta
+	entry.value = strconv.FormatInt(value, 10)
+	s.data[args[1]] = entry
+	writeInt(w, value)
+}
+
+// expire implements EXPIRE and PEXPIRE without options
+func (s *Stub) expire(args []string, w *bufio.Writer) {
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		writeError(w, "ERR value is not an integer or out of range")
+		return
+	}
+	entry, ok := s.lookup(args[1])
+	if !ok {
+		writeInt(w, 0)
+		return
+	}
+	unit := time.Millisecond
+	if strings.EqualFold(args[0], "EXPIRE") {
+		unit = time.Second
+	}
+	if ttl <= 0 {
+		delete(s.data, args[1])
+		writeInt(w, 1)
+		return
+	}
+	entry.expiresAt = time.Now().Add(time.Duration(ttl) * unit)
+	s.data[args[1]] = entry
+	writeInt(w, 1)
+}
+
+// ttl implements TTL and PTTL: -2 for a missing key, -1 for no expiry
+func (s *Stub) ttl(args []string, w *bufio.Writer) {
+	entry, ok := s.lookup(args[1])
+	switch {
+	case !ok:
+		writeInt(w, -2)
+	case entry.expiresAt.IsZero():
+		writeInt(w, -1)
+	case strings.EqualFold(args[0], "TTL"):
+		writeInt(w, int64((time.Until(entry.expiresAt)+time.Second-1)/time.Second))
+	default:
+		writeInt(w, int64((time.Until(entry.expiresAt)+time.Millisecond-1)/time.Millisecond))
+	}
+}
+
+func (s *Stub) flushAll(args []string, w *bufio.Writer) {
+	s.data = map[string]stubEntry{}
+	w.WriteString("+OK\r\n")
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// writeError writes an error reply; newlines would break the framing
+func writeError(w io.StringWriter, message string) {
+	w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(message) + "\r\n")
+}
@@ -1,25 +1,76 @@
 package server
 
 import (
+	"expvar"
 	"fmt"
 	"log"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/savindaJ/backend-app/internal/audit"
+	"github.com/savindaJ/backend-app/internal/auth"
+	"github.com/savindaJ/backend-app/internal/batch"
+	"github.com/savindaJ/backend-app/internal/cache"
 	"github.com/savindaJ/backend-app/internal/config"
 	"github.com/savindaJ/backend-app/internal/database"
+	"github.com/savindaJ/backend-app/internal/idempotency"
+	"github.com/savindaJ/backend-app/internal/middleware"
+	"github.com/savindaJ/backend-app/internal/modules/oauth"
+	"github.com/savindaJ/backend-app/internal/modules/privacy"
 	"github.com/savindaJ/backend-app/internal/modules/user"
+	"github.com/savindaJ/backend-app/internal/oidc"
+	"github.com/savindaJ/backend-app/internal/ratelimit"
+	"github.com/savindaJ/backend-app/internal/redis"
 )
 
 func Start() {
 	cfg := config.Load()
 
+	if cfg.JWTSecret == "" {
+		log.Fatal("❌ JWT_SECRET must be set")
+	}
+	tokens := auth.NewTokenManager(cfg.JWTSecret, cfg.AppName)
+
 	// Connect to database
 	db := database.Connect(cfg)
 
 	// Auto migrate models
-	database.AutoMigrate(db, &user.User{})
+	if err := user.PrepareMigration(db); err != nil {
+		log.Fatalf("❌ Failed to prepare migrations: %v", err)
+	}
+	database.AutoMigrate(db, &user.User{}, &user.RecoveryCode{}, &user.PasswordHistory{}, &user.LoginThrottle{}, &user.Session{}, &user.LinkedIdentity{}, &user.OIDCLoginState{}, &user.APIKey{}, &user.UserProfile{}, &oauth.Client{}, &oauth.AuthorizationCode{}, &oauth.Grant{}, &privacy.DataExport{}, &privacy.ErasureRequest{}, &audit.Event{}, &audit.ChainHead{}, &idempotency.Record{})
+
+	// Access tokens are bound to a login session or, for OAuth clients, a grant
+	sessions := user.NewSessionCache(db, cfg.SessionCacheTTL)
+	grants := oauth.NewGrantCache(db, cfg.SessionCacheTTL)
+	apiKeys := user.NewAPIKeyAuthenticator(db)
+	requireAuth := middleware.Auth(tokens, middleware.SessionRouter{
+		Default:  sessions,
+		Prefixes: map[string]middleware.SessionValidator{oauth.GrantIDPrefix: grants},
+	}, apiKeys)
+
+	// Retries of creating requests with an Idempotency-Key replay the first response
+	idempotencyKeys := idempotency.NewStore(db)
+	idempotencyKeys.StartCleanup(cfg.IdempotencyCleanupInterval)
+	idempotent := idempotency.Middleware(idempotencyKeys, cfg.IdempotencyKeyTTL, cfg.IdempotencyLockTTL)
+
+	// Redis is connected to only when a store is configured to use it
+	var redisConn *redis.Client
+	connectRedis := func() *redis.Client {
+		if redisConn == nil {
+			redisConn = redisClient(cfg)
+		}
+		return redisConn
+	}
+
+	// Users read by ID are cached; purges and erasures drop them once done
+	usersCache := userCache(cfg, connectRedis)
+	invalidateUsers := user.NewCacheInvalidator(usersCache)
+
+	// Permanently remove users once their deletion retention period ends
+	user.NewPurger(db, cfg, invalidateUsers, oauth.PurgeUserData, privacy.PurgeUserData).Start()
 
 	// Set Gin mode
 	if cfg.AppEnv == "production" {
@@ -28,6 +79,22 @@ func Start() {
 
 	r := gin.Default()
 
+	// Client IPs key rate limits and login throttling, so forwarding
+	// headers are only believed from the configured proxies
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("❌ Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if cfg.CompressEnabled {
+		r.Use(compression(cfg))
+	}
+	r.Use(middleware.AssignRequestID())
+	for _, protection := range browserProtections(cfg) {
+		r.Use(protection)
+	}
+	if cfg.RateLimitEnabled {
+		r.Use(rateLimiter(cfg, tokens, apiKeys, connectRedis))
+	}
+
 	// Health check endpoint
 	// @Summary      Health check
 	// @Description  Check if the server is running
@@ -40,13 +107,33 @@ func Start() {
 	})
 
 	// Swagger documentation route
-	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	r.GET("/swagger/*any", middleware.CacheControl(cfg.CacheControlSwagger), middleware.ContentSecurityPolicy(swaggerCSP), ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	// API v1 routes
-	v1 := r.Group("/api/v1")
+	// Runtime metrics, including cache hit and miss counts, for admins
+	r.GET("/debug/vars", middleware.CacheControl("no-store"), requireAuth, middleware.RequireRole(user.RoleAdmin), middleware.RequireFirstParty(), gin.WrapH(expvar.Handler()))
+
+	providers := identityProviders(cfg, r)
+
+	// API v1 routes. By default clients may store responses but must
+	// revalidate them, e.g. with the ETag; handlers returning credentials
+	// send no-store instead.
+	v1 := r.Group("/api/v1", middleware.CacheControl(cfg.CacheControlAPI))
 	{
 		// Register module routes
-		user.RegisterRoutes(v1, db)
+		users := user.RegisterRoutes(v1, db, cfg, tokens, sessions, providers, usersCache, requireAuth, idempotent)
+		oauth.RegisterRoutes(v1, db, cfg, tokens, users, grants, requireAuth)
+		audit.RegisterRoutes(v1, db, cfg.JWTSecret, requireAuth, middleware.RequireRole(user.RoleAdmin), middleware.RequireFirstParty())
+
+		// Exports gather, and erasures remove, what every module keeps about
+		// a user. The audit log is append-only and kept on erasure; it holds
+		// pseudonyms rather than personal data.
+		privacy.RegisterRoutes(v1, db, cfg, users, requireAuth,
+			[]user.ExportSource{user.ExportUserData(db), oauth.ExportUserData(db), audit.NewStore(db, cfg.JWTSecret).ExportUser},
+			[]user.PurgeHook{user.EraseUserData, oauth.PurgeUserData}, invalidateUsers)
+
+		// Batched calls are dispatched through the router like separate
+		// requests; atomic batches of user calls share a transaction
+		batch.RegisterRoutes(v1, r, db, user.TransactionalPath, requireAuth)
 	}
 
 	log.Printf("🚀 Server starting on port %s", cfg.AppPort)
@@ -54,3 +141,191 @@ func Start() {
 
 	r.Run(fmt.Sprintf(":%s", cfg.AppPort))
 }
+
+// swaggerCSP lets the Swagger UI load its own scripts, styles and images
+const swaggerCSP = "default-src 'self'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; frame-ancestors 'none'"
+
+// browserProtections builds the enabled CORS, security header and CSRF
+// middleware. CORS runs first so preflights are answered before anything
+// else and errors stay readable by allowed origins.
+func browserProtections(cfg *config.Config) []gin.HandlerFunc {
+	var protections []gin.HandlerFunc
+	if cfg.CORSEnabled && len(cfg.CORSAllowedOrigins) > 0 {
+		cors, err := middleware.CORS(middleware.CORSOptions{
+			AllowedOrigins:   cfg.CORSAllowedOrigins,
+			AllowedMethods:   cfg.CORSAllowedMethods,
+			AllowedHeaders:   cfg.CORSAllowedHeaders,
+			ExposedHeaders:   cfg.CORSExposedHeaders,
+			AllowCredentials: cfg.CORSAllowCredentials,
+			MaxAge:           cfg.CORSMaxAge,
+		})
+		if err != nil {
+			log.Fatalf("❌ Invalid CORS config: %v", err)
+		}
+		protections = append(protections, cors)
+	}
+	if cfg.SecurityHeadersEnabled {
+		protections = append(protections, middleware.SecurityHeaders(middleware.SecurityHeadersOptions{
+			HSTSMaxAge:            cfg.HSTSMaxAge,
+			ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+			ReferrerPolicy:        cfg.ReferrerPolicy,
+		}))
+	}
+	if cfg.CSRFEnabled {
+		protections = append(protections, middleware.CSRF(middleware.CSRFOptions{
+			CookieName:     cfg.CSRFCookieName,
+			HeaderName:     "X-CSRF-Token",
+			FormField:      "csrf_token",
+			SessionCookies: cfg.CSRFSessionCookies,
+			Secure:         cfg.CSRFCookieSecure,
+		}))
+	}
+	return protections
+}
+
+// compression builds the response compression middleware
+func compression(cfg *config.Config) gin.HandlerFunc {
+	var encodings []middleware.Encoding
+	for _, name := range cfg.CompressEncodings {
+		switch name {
+		case "gzip":
+			gzip, err := middleware.NewGzip(cfg.CompressLevel)
+			if err != nil {
+				log.Fatalf("❌ Invalid COMPRESS_LEVEL: %v", err)
+			}
+			encodings = append(encodings, gzip)
+		case "br":
+			encodings = append(encodings, middleware.NewBrotli())
+		case "zstd":
+			encodings = append(encodings, middleware.NewZstd())
+		default:
+			log.Fatalf("❌ Invalid COMPRESS_ENCODINGS: unknown encoding %q", name)
+		}
+	}
+	return middleware.Compress(middleware.CompressOptions{
+		Encodings:    encodings,
+		MinSize:      cfg.CompressMinSize,
+		ContentTypes: cfg.CompressContentTypes,
+	})
+}
+
+// rateLimiter builds the rate limiting middleware from the configured
+// policies, skipping invalid ones, with counters in memory or in Redis
+func rateLimiter(cfg *config.Config, tokens *auth.TokenManager, apiKeys middleware.APIKeyValidator, connectRedis func() *redis.Client) gin.HandlerFunc {
+	var policies []ratelimit.Policy
+	for _, p := range cfg.RateLimitPolicies {
+		policy, err := ratelimit.NewPolicy(p.Name, p.Route, p.Limit, p.Window, p.Key)
+		if err != nil {
+			log.Printf("⚠️  Skipping rate limit policy: %v", err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+
+	var store ratelimit.Store
+	switch cfg.RateLimitStore {
+	case "memory":
+		store = ratelimit.NewMemoryStore()
+	case "redis":
+		store = ratelimit.NewRedisStore(connectRedis())
+	default:
+		log.Fatalf("❌ Invalid RATE_LIMIT_STORE %q: use memory or redis", cfg.RateLimitStore)
+	}
+
+	return ratelimit.Middleware(ratelimit.NewLimiter(store), policies, tokens, apiKeys)
+}
+
+// userCache creates the cache of users read by ID, or nil when disabled
+func userCache(cfg *config.Config, connectRedis func() *redis.Client) *cache.Cache {
+	var store cache.Store
+	switch cfg.UserCacheStore {
+	case "none":
+		return nil
+	case "memory":
+		store = cache.NewLRU(cfg.UserCacheSize)
+	case "redis":
+		store = cache.NewRedisStore(connectRedis())
+	default:
+		log.Fatalf("❌ Invalid USER_CACHE_STORE %q: use memory, redis or none", cfg.UserCacheStore)
+	}
+	return cache.New("users", store, cfg.UserCacheTTL)
+}
+
+// redisClient connects to the configured Redis server or, outside
+// production, starts the in-process stub at its address
+func redisClient(cfg *config.Config) *redis.Client {
+	addr := cfg.RedisAddr
+	if cfg.RedisStubEnabled {
+		if cfg.AppEnv == "production" {
+			log.Printf("⚠️  REDIS_STUB_ENABLED is ignored in production")
+		} else {
+			stub, err := redis.NewStub(addr, cfg.RedisPassword)
+			if err != nil {
+				log.Fatalf("❌ Failed to start Redis stub: %v", err)
+			}
+			addr = stub.Addr()
+			log.Printf("🧪 Redis stub: %s", addr)
+		}
+	}
+
+	client := redis.NewClient(addr, cfg.RedisPassword)
+	if err := client.Ping(); err != nil {
+		log.Printf("⚠️  Redis at %s is unreachable: %v", addr, err)
+	}
+	return client
+}
+
+// mockProviderPath is where the development mock OpenID provider is served
+const mockProviderPath = "/mock-oidc"
+
+// identityProviders registers the configured OpenID providers and, outside
+// production, the in-process mock provider
+func identityProviders(cfg *config.Config, r *gin.Engine) *oidc.Registry {
+	providers := oidc.NewRegistry()
+	callbackURL := func(name string) string {
+		return cfg.AppBaseURL + "/api/v1/users/oidc/" + name + "/callback"
+	}
+
+	for _, p := range cfg.OIDCProviders {
+		if p.Issuer == "" || p.ClientID == "" {
+			log.Printf("⚠️  Skipping OIDC provider %s: issuer and client ID are required", p.Name)
+			continue
+		}
+		providers.Register(oidc.NewClient(oidc.Config{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  callbackURL(p.Name),
+		}, http.DefaultClient))
+	}
+
+	if !cfg.OIDCMockEnabled {
+		return providers
+	}
+	if cfg.AppEnv == "production" {
+		log.Printf("⚠️  OIDC_MOCK_ENABLED is ignored in production")
+		return providers
+	}
+
+	mockCfg := oidc.MockConfig{
+		Issuer:       cfg.AppBaseURL + mockProviderPath,
+		ClientID:     "mock-client",
+		ClientSecret: "mock-secret",
+	}
+	mock, err := oidc.NewMockProvider(mockCfg)
+	if err != nil {
+		log.Fatalf("❌ Failed to start mock OIDC provider: %v", err)
+	}
+	r.Any(mockProviderPath+"/*path", gin.WrapH(http.StripPrefix(mockProviderPath, mock)))
+	providers.Register(oidc.NewClient(oidc.Config{
+		Name:         "mock",
+		Issuer:       mockCfg.Issuer,
+		ClientID:     mockCfg.ClientID,
+		ClientSecret: mockCfg.ClientSecret,
+		RedirectURL:  callbackURL("mock"),
+	}, mock.HTTPClient()))
+	log.Printf("🧪 Mock OIDC provider: %s/api/v1/users/oidc/mock/login", cfg.AppBaseURL)
+
+	return providers
+}
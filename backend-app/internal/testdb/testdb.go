@@ -0,0 +1,143 @@
+// Package testdb stands in for a MySQL connection in tests. GORM queries
+// run against a Conn reach no database: the conn answers them with rows the
+// test supplies and records every statement, so tests can check the SQL a
+// query builds and the transactions it runs.
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Transaction boundaries, as recorded in Conn.Statements
+const (
+	Begin    = "BEGIN"
+	Commit   = "COMMIT"
+	Rollback = "ROLLBACK"
+)
+
+// Statement is a statement sent to a Conn. Bound integers arrive as int64.
+type Statement struct {
+	SQL  string
+	Args []driver.Value
+}
+
+// Conn is a driver connection answering queries with Query and other
+// statements with Exec, recording them all in order
+type Conn struct {
+	Query      func(query string, args []driver.Value) (driver.Rows, error)   // Nil answers with no rows
+	Exec       func(query string, args []driver.Value) (driver.Result, error) // Nil reports one row affected
+	Statements []Statement
+}
+
+// Open returns a database sending its statements to conn
+func Open(t testing.TB, conn *Conn) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      sql.OpenDB(conn),
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// SQL returns the recorded statements without their arguments
+func (c *Conn) SQL() []string {
+	statements := make([]string, len(c.Statements))
+	for i, s := range c.Statements {
+		statements[i] = s.SQL
+	}
+	return statements
+}
+
+func (c *Conn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *Conn) Driver() driver.Driver                        { return nil }
+func (c *Conn) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (c *Conn) Close() error                                 { return nil }
+
+func (c *Conn) Begin() (driver.Tx, error) {
+	c.record(Begin, nil)
+	return tx{c}, nil
+}
+
+func (c *Conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := c.record(query, args)
+	if c.Query == nil {
+		return NewRows(), nil
+	}
+	return c.Query(query, values)
+}
+
+func (c *Conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := c.record(query, args)
+	if c.Exec == nil {
+		return driver.RowsAffected(1), nil
+	}
+	return c.Exec(query, values)
+}
+
+// record appends a statement, returning its argument values
+func (c *Conn) record(query string, args []driver.NamedValue) []driver.Value {
+	var values []driver.Value
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	c.Statements = append(c.Statements, Statement{SQL: query, Args: values})
+	return values
+}
+
+// tx records the end of a transaction
+type tx struct {
+	conn *Conn
+}
+
+func (t tx) Commit() error {
+	t.conn.record(Commit, nil)
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.conn.record(Rollback, nil)
+	return nil
+}
+
+// Rows is a driver.Rows over fixed values
+type Rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+// NewRows returns rows with the given columns and no values yet
+func NewRows(columns ...string) *Rows {
+	return &Rows{columns: columns}
+}
+
+// Add appends a row, with a value for each column
+func (r *Rows) Add(values ...driver.Value) *Rows {
+	r.values = append(r.values, values)
+	return r
+}
+
+// Len returns the number of rows not read yet
+func (r *Rows) Len() int { return len(r.values) }
+
+func (r *Rows) Columns() []string { return r.columns }
+func (r *Rows) Close() error      { return nil }
+
+func (r *Rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}